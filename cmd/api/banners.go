@@ -239,15 +239,17 @@ func (app *application) getBannersHandler(w http.ResponseWriter, r *http.Request
 // ====================================================================================
 
 func (app *application) gormListBannerHandler(w http.ResponseWriter, r *http.Request) {
-	var pagination data.Pagination
-
 	v := validator.New()
 	qs := r.URL.Query()
 
-	pagination.Page = app.readInt(qs, "page", 1, v)
-	pagination.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters := app.readFilters(qs, "id", data.BannerSortSafeList, data.BannerFilterSafeList, v)
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 
-	banners, metadata, err := app.gorm.Banners.GetAll(pagination)
+	banners, metadata, err := app.gorm.Banners.GetAll(filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -309,6 +311,9 @@ func (app *application) gormCreateBannerHandler(w http.ResponseWriter, r *http.R
 
 	v := validator.New()
 
+	banner.PublishAt = app.readTime(r.Form, "publish_at", v)
+	banner.UnpublishAt = app.readTime(r.Form, "unpublish_at", v)
+
 	if data.ValidateBanner(v, banner); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -320,6 +325,9 @@ func (app *application) gormCreateBannerHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	app.invalidateCatalogueCache("GET_BANNERS_API")
+	app.reschedulePublishing()
+
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/banners/%d", banner.ID))
 
@@ -370,6 +378,9 @@ func (app *application) gormFullUpdateBannerHandler(w http.ResponseWriter, r *ht
 
 	v := validator.New()
 
+	banner.PublishAt = app.readTime(r.Form, "publish_at", v)
+	banner.UnpublishAt = app.readTime(r.Form, "unpublish_at", v)
+
 	if data.ValidateBanner(v, banner); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -387,6 +398,9 @@ func (app *application) gormFullUpdateBannerHandler(w http.ResponseWriter, r *ht
 		return
 	}
 
+	app.invalidateCatalogueCache("GET_BANNERS_API")
+	app.reschedulePublishing()
+
 	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), banner, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -411,6 +425,8 @@ func (app *application) gormDeleteBannerHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	app.invalidateCatalogueCache("GET_BANNERS_API")
+
 	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), "banner successfully deleted", nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
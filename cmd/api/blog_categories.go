@@ -16,15 +16,17 @@ import (
 // ====================================================================================
 
 func (app *application) listBlogCategoriesHandler(w http.ResponseWriter, r *http.Request) {
-	var pagination data.Pagination
-
 	v := validator.New()
 	qs := r.URL.Query()
 
-	pagination.Page = app.readInt(qs, "page", 1, v)
-	pagination.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters := app.readFilters(qs, "id", data.BlogCategorySortSafeList, data.BlogCategoryFilterSafeList, v)
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 
-	blogCategories, metadata, err := app.gorm.BlogCategories.GetAll(pagination)
+	blogCategories, metadata, err := app.gorm.BlogCategories.GetAll(filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
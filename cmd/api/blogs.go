@@ -17,15 +17,17 @@ import (
 // ====================================================================================
 
 func (app *application) listBlogsHandler(w http.ResponseWriter, r *http.Request) {
-	var pagination data.Pagination
-
 	v := validator.New()
 	qs := r.URL.Query()
 
-	pagination.Page = app.readInt(qs, "page", 1, v)
-	pagination.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters := app.readFilters(qs, "status", data.BlogSortSafeList, data.BlogFilterSafeList, v)
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 
-	blogs, metadata, err := app.gorm.Blogs.GetAll(pagination)
+	blogs, metadata, err := app.gorm.Blogs.GetAll(filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -103,6 +105,9 @@ func (app *application) createBlogHandler(w http.ResponseWriter, r *http.Request
 
 	v := validator.New()
 
+	blog.PublishAt = app.readTime(r.Form, "publish_at", v)
+	blog.UnpublishAt = app.readTime(r.Form, "unpublish_at", v)
+
 	if data.ValidateBlog(v, blog); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -180,6 +185,9 @@ func (app *application) updateBlogHandler(w http.ResponseWriter, r *http.Request
 
 	v := validator.New()
 
+	blog.PublishAt = app.readTime(r.Form, "publish_at", v)
+	blog.UnpublishAt = app.readTime(r.Form, "unpublish_at", v)
+
 	if data.ValidateBlog(v, blog); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -243,6 +251,12 @@ func (app *application) getBlogsHandler(w http.ResponseWriter, r *http.Request)
 
 	pagination.Page = app.readInt(qs, "page", 1, v)
 	pagination.PageSize = app.readInt(qs, "page_size", 20, v)
+	pagination.After = app.readStrings(qs, "after", "")
+
+	if data.ValidateCursor(v, pagination.After, data.NewestFirst); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 
 	blogs, metadata, err := app.gorm.Blogs.GetAPI(pagination)
 
@@ -278,8 +292,24 @@ func (app *application) getBlogBySlugHandler(w http.ResponseWriter, r *http.Requ
 }
 
 func (app *application) getBlogsRecommendationHandler(w http.ResponseWriter, r *http.Request) {
-	blogs, err := app.gorm.Blogs.GetRecommendations()
+	var blog *data.Blog
+	var err error
+
+	// The blog being read, if any, to recommend the ones related to it.
+	if slug := r.URL.Query().Get("slug"); slug != "" {
+		blog, err = app.gorm.Blogs.GetBySlug(slug)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
 
+	blogs, err := app.gorm.Blogs.GetRecommendations(blog)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -17,15 +17,17 @@ import (
 // ====================================================================================
 
 func (app *application) listBrandsHandler(w http.ResponseWriter, r *http.Request) {
-	var pagination data.Pagination
-
 	v := validator.New()
 	qs := r.URL.Query()
 
-	pagination.Page = app.readInt(qs, "page", 1, v)
-	pagination.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters := app.readFilters(qs, "id", data.BrandSortSafeList, data.BrandFilterSafeList, v)
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 
-	brands, metadata, err := app.gorm.Brands.GetAll(pagination)
+	brands, metadata, err := app.gorm.Brands.GetAll(filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -113,6 +115,8 @@ func (app *application) createBrandHandler(w http.ResponseWriter, r *http.Reques
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/brands/%d", brand.ID))
 
+	app.invalidateCatalogueCache("GET_BRANDS_API")
+
 	err = app.writeJSON(w, http.StatusCreated, http.StatusText(http.StatusCreated), brand, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -187,6 +191,8 @@ func (app *application) updateBrandHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	app.invalidateCatalogueCache("GET_BRANDS_API")
+
 	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), brand, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -211,6 +217,8 @@ func (app *application) deleteBrandHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	app.invalidateCatalogueCache("GET_BRANDS_API")
+
 	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), "brand successfully deleted", nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
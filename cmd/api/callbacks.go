@@ -94,6 +94,19 @@ func (app *application) invoiceCallbackHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	// Failing here makes Xendit retry the callback, settling an order twice is a no-op.
+	if order.Status == "paid" || order.Status == "expired" {
+		err = app.gorm.InventoryMovements.SettleOrder(orderID, order.Status == "paid")
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if order.Status == "paid" {
+		app.issueGiftCards(orderID, order.GormUser.Email)
+	}
+
 	app.background(func() {
 		data := map[string]interface{}{
 			"orderID": orderID,
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/kervinch/internal/data"
+	"github.com/kervinch/internal/validator"
+)
+
+// campaignInput is the request body of a campaign. The items replace the items of the
+// campaign on update.
+type campaignInput struct {
+	Name          string    `json:"name"`
+	StartsAt      time.Time `json:"starts_at"`
+	EndsAt        time.Time `json:"ends_at"`
+	IsActive      *bool     `json:"is_active"`
+	CampaignItems []struct {
+		ProductDetailID int64  `json:"product_detail_id"`
+		SalePrice       *int64 `json:"sale_price"`
+		DiscountPercent *int   `json:"discount_percent"`
+		PerUserLimit    int    `json:"per_user_limit"`
+		Quota           int    `json:"quota"`
+	} `json:"campaign_items"`
+}
+
+func (input *campaignInput) apply(campaign *data.Campaign) {
+	campaign.Name = input.Name
+	campaign.StartsAt = input.StartsAt
+	campaign.EndsAt = input.EndsAt
+
+	if input.IsActive != nil {
+		campaign.IsActive = *input.IsActive
+	}
+
+	campaign.CampaignItem = make([]data.CampaignItem, len(input.CampaignItems))
+
+	for i, item := range input.CampaignItems {
+		campaign.CampaignItem[i] = data.CampaignItem{
+			ProductDetailID: item.ProductDetailID,
+			SalePrice:       item.SalePrice,
+			DiscountPercent: item.DiscountPercent,
+			PerUserLimit:    item.PerUserLimit,
+			Quota:           item.Quota,
+		}
+	}
+}
+
+// ====================================================================================
+// Backoffice Handlers
+// ====================================================================================
+
+func (app *application) listCampaignsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters := app.readFilters(qs, "-starts_at", data.CampaignSortSafeList, data.CampaignFilterSafeList, v)
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	campaigns, metadata, err := app.gorm.Campaigns.GetAll(filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSONWithMeta(w, http.StatusOK, http.StatusText(http.StatusOK), campaigns, nil, metadata)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showCampaignHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	campaign, err := app.gorm.Campaigns.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), campaign, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createCampaignHandler(w http.ResponseWriter, r *http.Request) {
+	var input campaignInput
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	campaign := &data.Campaign{
+		IsActive:  true,
+		CreatedBy: user.ID,
+		UpdatedBy: user.ID,
+	}
+
+	input.apply(campaign)
+
+	v := validator.New()
+
+	if data.ValidateCampaign(v, campaign); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.gorm.Campaigns.Insert(campaign)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownProductDetail):
+			v.AddError("campaign_items", "must only contain existing variants")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.invalidateCatalogueCache()
+	app.reschedulePublishing()
+
+	err = app.writeJSON(w, http.StatusCreated, http.StatusText(http.StatusCreated), campaign, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateCampaignHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	campaign, err := app.gorm.Campaigns.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input campaignInput
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	input.apply(campaign)
+	campaign.UpdatedBy = app.contextGetUser(r).ID
+
+	v := validator.New()
+
+	if data.ValidateCampaign(v, campaign); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.gorm.Campaigns.Update(campaign)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownProductDetail):
+			v.AddError("campaign_items", "must only contain existing variants")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrQuotaBelowSold):
+			v.AddError("campaign_items", "must not have a quota below the quantity already sold")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.invalidateCatalogueCache()
+	app.reschedulePublishing()
+
+	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), campaign, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteCampaignHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.gorm.Campaigns.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.invalidateCatalogueCache()
+
+	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), "campaign successfully deleted", nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
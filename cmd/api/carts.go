@@ -22,8 +22,16 @@ func (app *application) getCartsHandler(w http.ResponseWriter, r *http.Request)
 
 	pagination.Page = app.readInt(qs, "page", 1, v)
 	pagination.PageSize = app.readInt(qs, "page_size", 20, v)
+	savedForLater := app.readStrings(qs, "saved_for_later", "false")
 
-	carts, metadata, err := app.gorm.Carts.GetAll(pagination, user)
+	v.Check(validator.In(savedForLater, "true", "false"), "saved_for_later", "must be either true or false")
+
+	if data.ValidatePagination(v, pagination); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	carts, metadata, err := app.gorm.Carts.GetAll(pagination, user, savedForLater == "true")
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -35,6 +43,71 @@ func (app *application) getCartsHandler(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// getCartPromotionsHandler evaluates the running promotions over the whole cart the way
+// checkout does, so the discounts can be shown before checking out.
+func (app *application) getCartPromotionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	carts, err := app.gorm.Carts.GetAllWithProducts(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	promotions, err := app.gorm.Promotions.GetActive()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	type brandDiscounts struct {
+		BrandID   int64           `json:"brand_id"`
+		Subtotal  int64           `json:"subtotal"`
+		Discounts []data.Discount `json:"discounts"`
+		Total     int64           `json:"total"`
+	}
+
+	var brandID []int64
+	lines := make(map[int64][]data.PromotionLine)
+
+	for _, cart := range carts {
+		if len(cart.Issues) > 0 && cart.Issues[0].Code == data.CartUnavailable {
+			continue
+		}
+
+		line := data.NewPromotionLine(&cart.ProductDetail, cart.Quantity)
+
+		brandID = app.appendIfMissing(brandID, line.BrandID)
+		lines[line.BrandID] = append(lines[line.BrandID], line)
+	}
+
+	brands := []brandDiscounts{}
+
+	for _, bid := range brandID {
+		brand := brandDiscounts{
+			BrandID:   bid,
+			Discounts: data.ApplyPromotions(promotions, lines[bid]),
+		}
+
+		for _, line := range lines[bid] {
+			brand.Subtotal += int64(line.Quantity) * line.Price
+		}
+
+		brand.Total = brand.Subtotal
+
+		for _, discount := range brand.Discounts {
+			brand.Total -= discount.Value
+		}
+
+		brands = append(brands, brand)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), brands, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 func (app *application) createCartHandler(w http.ResponseWriter, r *http.Request) {
 	user := app.contextGetUser(r)
 
@@ -63,7 +136,13 @@ func (app *application) createCartHandler(w http.ResponseWriter, r *http.Request
 
 	err = app.gorm.Carts.Insert(cart)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrUnknownProductDetail):
+			v.AddError("product_detail_id", "does not match any product")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -94,7 +173,8 @@ func (app *application) updateCartHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	var input struct {
-		Quantity int `json:"quantity"`
+		Quantity      *int  `json:"quantity"`
+		SavedForLater *bool `json:"saved_for_later"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -103,7 +183,13 @@ func (app *application) updateCartHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	cart.Quantity = input.Quantity
+	if input.Quantity != nil {
+		cart.Quantity = *input.Quantity
+	}
+
+	if input.SavedForLater != nil {
+		cart.SavedForLater = *input.SavedForLater
+	}
 
 	v := validator.New()
 
@@ -115,6 +201,9 @@ func (app *application) updateCartHandler(w http.ResponseWriter, r *http.Request
 	err = app.gorm.Carts.Update(cart)
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrUnknownProductDetail):
+			v.AddError("product_detail_id", "does not match any product")
+			app.failedValidationResponse(w, r, v.Errors)
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
 		case errors.Is(err, data.ErrEditConflict):
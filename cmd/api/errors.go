@@ -82,6 +82,16 @@ func (app *application) invactiveAccountResponse(w http.ResponseWriter, r *http.
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
 
+func (app *application) unverifiedPhoneNumberResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your phone number must be verified to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) twoFactorRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you must enable two-factor authentication to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	messsage := "your do not have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, messsage)
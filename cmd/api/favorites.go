@@ -1,6 +1,7 @@
 package main
 
 import (
+	"database/sql"
 	"errors"
 	"net/http"
 
@@ -39,7 +40,8 @@ func (app *application) createFavoriteHandler(w http.ResponseWriter, r *http.Req
 	user := app.contextGetUser(r)
 
 	var input struct {
-		ProductDetailID int64 `json:"product_detail_id"`
+		ProductDetailID int64  `json:"product_detail_id"`
+		CollectionID    *int64 `json:"collection_id"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -60,6 +62,18 @@ func (app *application) createFavoriteHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	// Without a collection the favorite goes to the default one.
+	favorite.CollectionID, err = app.readWishlistID(user, input.CollectionID, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	err = app.gorm.Favorites.Insert(favorite)
 	if err != nil {
 		switch {
@@ -102,3 +116,159 @@ func (app *application) deleteFavoriteHandler(w http.ResponseWriter, r *http.Req
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// moveFavoriteHandler moves a favorite to another collection, or back to the default one
+// when collection_id is null.
+func (app *application) moveFavoriteHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	favorite, err := app.gorm.Favorites.Get(id, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		CollectionID *int64 `json:"collection_id"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	collectionID, err := app.readWishlistID(user, input.CollectionID, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.gorm.Favorites.Move(favorite, collectionID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateKeyValue):
+			app.violateUniqueConstraint(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), favorite, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// moveFavoriteToCartHandler adds a favorite to the cart and takes it off the wishlist.
+// The variant added defaults to the one favorited, but can be any variant of the same
+// product, e.g. another size.
+func (app *application) moveFavoriteToCartHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	favorite, err := app.gorm.Favorites.Get(id, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		ProductDetailID *int64 `json:"product_detail_id"`
+		Quantity        *int   `json:"quantity"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	cart := &data.Cart{
+		UserID:          user.ID,
+		ProductDetailID: favorite.ProductDetailID,
+		Quantity:        1,
+	}
+
+	if input.ProductDetailID != nil {
+		cart.ProductDetailID = *input.ProductDetailID
+	}
+
+	if input.Quantity != nil {
+		cart.Quantity = *input.Quantity
+	}
+
+	v := validator.New()
+
+	if data.ValidateCart(v, cart); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	cart, err = app.gorm.Favorites.MoveToCart(favorite, cart.ProductDetailID, cart.Quantity)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownProductDetail):
+			v.AddError("product_detail_id", "must be a variant of the favorited product")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, http.StatusText(http.StatusCreated), cart, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readWishlistID checks that a collection picked by a user is theirs. No collection
+// stands for the default one.
+func (app *application) readWishlistID(user *data.User, id *int64, v *validator.Validator) (sql.NullInt64, error) {
+	if id == nil {
+		return sql.NullInt64{}, nil
+	}
+
+	_, err := app.gorm.Wishlists.Get(*id, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("collection_id", "does not match any of your wishlists")
+			return sql.NullInt64{}, nil
+		default:
+			return sql.NullInt64{}, err
+		}
+	}
+
+	return sql.NullInt64{Int64: *id, Valid: true}, nil
+}
@@ -1,19 +1,24 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gosimple/slug"
 	"github.com/julienschmidt/httprouter"
 	"github.com/kervinch/internal/data"
+	"github.com/kervinch/internal/spreadsheet"
+	"github.com/kervinch/internal/totp"
 	"github.com/kervinch/internal/validator"
 	"gorm.io/gorm"
 )
@@ -239,6 +244,139 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// readTime reads an optional RFC 3339 time, so a blank value clears a schedule. Values
+// that cannot be parsed are recorded in the validator.
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) *time.Time {
+	s := qs.Get(key)
+
+	if s == "" {
+		return nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 time")
+		return nil
+	}
+
+	return &t
+}
+
+// readConditions reads every filter[field] and filter[field][operator] parameter. The
+// operator defaults to eq. Fields and operators are checked later by ValidateFilters.
+func (app *application) readConditions(qs url.Values, v *validator.Validator) []data.Condition {
+	var conditions []data.Condition
+
+	keys := make([]string, 0, len(qs))
+	for key := range qs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if !strings.HasPrefix(key, "filter[") {
+			continue
+		}
+
+		parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(key, "filter["), "]"), "][")
+		if !strings.HasSuffix(key, "]") || len(parts) > 2 || parts[0] == "" {
+			v.AddError(key, "must be filter[field] or filter[field][operator]")
+			continue
+		}
+
+		condition := data.Condition{Field: parts[0], Operator: "eq", Value: qs.Get(key)}
+		if len(parts) == 2 {
+			condition.Operator = parts[1]
+		}
+
+		conditions = append(conditions, condition)
+	}
+
+	return conditions
+}
+
+// readFilters reads the pagination, sort and filter parameters of a backoffice list.
+func (app *application) readFilters(qs url.Values, defaultSort string, sortSafeList []string, filterSafeList map[string]data.FilterField, v *validator.Validator) data.Filters {
+	return data.Filters{
+		Page:           app.readInt(qs, "page", 1, v),
+		PageSize:       app.readInt(qs, "page_size", 20, v),
+		Sort:           app.readStrings(qs, "sort", defaultSort),
+		SortSafeList:   sortSafeList,
+		Conditions:     app.readConditions(qs, v),
+		FilterSafeList: filterSafeList,
+	}
+}
+
+// streamExport sends the rows produced by run as a CSV or XLSX attachment. The file is
+// only started on the first row, so a failing query can still be answered with a JSON
+// error. Once rows have been sent, later errors can only be logged.
+func (app *application) streamExport(w http.ResponseWriter, r *http.Request, name string, format string, header []interface{}, run func(write func(row []interface{}) error) error) {
+	var ew spreadsheet.Writer
+
+	start := func() error {
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.%s"`, name, time.Now().Format("20060102-150405"), format))
+
+		writer, contentType, err := spreadsheet.New(w, format, name)
+		if err != nil {
+			return err
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		ew = writer
+
+		return ew.Write(header)
+	}
+
+	err := run(func(row []interface{}) error {
+		if ew == nil {
+			err := start()
+			if err != nil {
+				return err
+			}
+		}
+
+		return ew.Write(row)
+	})
+	if err != nil {
+		if ew == nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// The status line is gone already, so the connection is dropped for the client to
+		// see a failed download instead of a complete looking file.
+		app.logger.PrintError(err, map[string]string{"export": name})
+		panic(http.ErrAbortHandler)
+	}
+
+	if ew == nil {
+		err = start()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = ew.Close()
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"export": name})
+	}
+}
+
+// routeSegment serves static when the :id parameter equals segment. httprouter cannot
+// register a static path next to a parameter, so e.g. /cms/orders/export shares the
+// route of /cms/orders/:id.
+func (app *application) routeSegment(segment string, static http.HandlerFunc, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if httprouter.ParamsFromContext(r.Context()).ByName("id") == segment {
+			static(w, r)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
 func (app *application) background(fn func()) {
 	app.wg.Add(1)
 
@@ -255,12 +393,40 @@ func (app *application) background(fn func()) {
 	}()
 }
 
+// validateTOTP checks a TOTP code of a user and marks it used, so it cannot be replayed
+// while it is still valid.
+func (app *application) validateTOTP(user *data.User, code string) (bool, error) {
+	step, ok := totp.Match(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	return app.models.Users.UseTOTPStep(user.ID, step)
+}
+
+func (app *application) sendPhoneOTP(user *data.User) error {
+	token, err := app.models.Tokens.NewOTP(user.ID, user.PhoneNumber, data.ScopePhoneOTP)
+	if err != nil {
+		return err
+	}
+
+	phoneNumber := user.PhoneNumber
+
+	app.background(func() {
+		message := fmt.Sprintf("Your KIN verification code is %s. It expires in %d minutes.", token.Plaintext, int(data.OTPTTL.Minutes()))
+
+		err := app.sms.Send(phoneNumber, message)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	return nil
+}
+
 func (app *application) Paginate(w http.ResponseWriter, r *http.Request) func(db *gorm.DB) *gorm.DB {
 	return func(db *gorm.DB) *gorm.DB {
-		var input struct {
-			Page     int
-			PageSize int
-		}
+		var input data.Pagination
 
 		v := validator.New()
 		qs := r.URL.Query()
@@ -330,3 +496,70 @@ func (app *application) generateInvoiceNumber(userID int64, orderID int64, brand
 
 	return invoiceNumber
 }
+
+// invalidateCatalogueCache removes cached catalogue responses after an edit. Search
+// suggestions are cached per query, so they are invalidated by moving to a new key
+// version instead, and the stale entries expire on their own.
+func (app *application) invalidateCatalogueCache(keys ...string) {
+	for _, key := range keys {
+		app.cache.Delete(key)
+	}
+
+	atomic.AddInt64(&app.searchCacheVersion, 1)
+}
+
+func (app *application) searchSuggestionsCacheKey(query string) string {
+	return fmt.Sprintf("GET_SEARCH_SUGGESTIONS_API:%d:%s", atomic.LoadInt64(&app.searchCacheVersion), strings.ToLower(query))
+}
+
+// readDeviceID returns the identifier that anonymous clients send to tie their activity
+// together before they sign in.
+func (app *application) readDeviceID(r *http.Request) string {
+	deviceID := strings.TrimSpace(r.Header.Get("X-Device-ID"))
+
+	if len(deviceID) > 100 {
+		return ""
+	}
+
+	return deviceID
+}
+
+// logSearchEvent records a product search and returns the search ID
+// that clients send back when one of the results is clicked.
+func (app *application) logSearchEvent(r *http.Request, search data.ProductSearch, sort data.Sort, resultCount int) (string, error) {
+	searchID, err := data.NewSearchID()
+	if err != nil {
+		return "", err
+	}
+
+	filters, err := json.Marshal(struct {
+		data.ProductSearch
+		Sort []string `json:"sort"`
+	}{search, sort.List})
+	if err != nil {
+		return "", err
+	}
+
+	event := &data.SearchEvent{
+		SearchID:    searchID,
+		Query:       search.Query,
+		Filters:     string(filters),
+		ResultCount: resultCount,
+		DeviceID:    app.readDeviceID(r),
+	}
+
+	user := app.contextGetUser(r)
+	if !user.IsAnonymous() {
+		event.UserID = sql.NullInt64{Int64: user.ID, Valid: true}
+	}
+
+	// The event is stored before the search ID is handed out, so a click reported right
+	// away finds it. A failure only costs the analytics, not the search.
+	err = app.gorm.SearchEvents.Insert(event)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return "", nil
+	}
+
+	return searchID, nil
+}
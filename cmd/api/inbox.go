@@ -15,15 +15,17 @@ import (
 // ====================================================================================
 
 func (app *application) listInboxHandler(w http.ResponseWriter, r *http.Request) {
-	var pagination data.Pagination
-
 	v := validator.New()
 	qs := r.URL.Query()
 
-	pagination.Page = app.readInt(qs, "page", 1, v)
-	pagination.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters := app.readFilters(qs, "-created_at", data.InboxSortSafeList, data.InboxFilterSafeList, v)
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 
-	favorites, metadata, err := app.gorm.Inbox.GetAll(pagination)
+	favorites, metadata, err := app.gorm.Inbox.GetAll(filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -239,6 +241,12 @@ func (app *application) getInboxHandler(w http.ResponseWriter, r *http.Request)
 
 	pagination.Page = app.readInt(qs, "page", 1, v)
 	pagination.PageSize = app.readInt(qs, "page_size", 20, v)
+	pagination.After = app.readStrings(qs, "after", "")
+
+	if data.ValidateCursor(v, pagination.After, data.NewestFirst); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 
 	inbox, metadata, err := app.gorm.Inbox.GetAPI(pagination, user)
 	if err != nil {
@@ -257,7 +265,7 @@ func (app *application) getInboxBySlugHandler(w http.ResponseWriter, r *http.Req
 
 	slug := app.readSlugParam(r)
 
-	inbox, err := app.gorm.Inbox.GetBySlug(slug)
+	inbox, err := app.gorm.Inbox.GetBySlug(slug, user)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/kervinch/internal/data"
+	"github.com/kervinch/internal/validator"
+)
+
+// ====================================================================================
+// Backoffice Handlers
+// ====================================================================================
+
+func (app *application) adjustInventoryHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Adjustments []data.InventoryAdjustment `json:"adjustments"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	for i := range input.Adjustments {
+		if input.Adjustments[i].Type == "" {
+			input.Adjustments[i].Type = data.MovementAdjustment
+		}
+	}
+
+	v := validator.New()
+
+	if data.ValidateInventoryAdjustments(v, input.Adjustments); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movements, alerts, err := app.gorm.InventoryMovements.Adjust(input.Adjustments, app.contextGetUser(r).ID)
+	if err != nil {
+		var adjustmentErr *data.AdjustmentError
+
+		switch {
+		case errors.As(err, &adjustmentErr):
+			key := fmt.Sprintf("adjustments[%d].", adjustmentErr.Index)
+
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError(key+"sku", "does not match any variant")
+			case errors.Is(err, data.ErrAmbiguousSKU):
+				v.AddError(key+"sku", "matches more than one variant")
+			default:
+				v.AddError(key+"quantity", "must not take the stock below zero")
+			}
+
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.notifyLowStock(alerts)
+	app.invalidateCatalogueCache()
+
+	err = app.writeJSON(w, http.StatusCreated, http.StatusText(http.StatusCreated), envelope{"movements": movements, "low_stock": alerts}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listInventoryMovementsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	sku := app.readStrings(qs, "sku", "")
+	filters := app.readFilters(qs, "-id", data.InventoryMovementSortSafeList, data.InventoryMovementFilterSafeList, v)
+
+	v.Check(sku != "", "sku", "must be provided")
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movements, metadata, err := app.gorm.InventoryMovements.GetAllForSKU(sku, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSONWithMeta(w, http.StatusOK, http.StatusText(http.StatusOK), movements, nil, metadata)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listInventoryDiscrepanciesHandler(w http.ResponseWriter, r *http.Request) {
+	discrepancies, err := app.gorm.InventoryMovements.GetDiscrepancies()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), discrepancies, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// notifyLowStock emails every admin about the variants that just reached their low stock
+// threshold.
+func (app *application) notifyLowStock(alerts []*data.LowStockAlert) {
+	if len(alerts) == 0 {
+		return
+	}
+
+	app.background(func() {
+		admins, err := app.gorm.GormUsers.GetAdmins()
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			return
+		}
+
+		data := map[string]interface{}{
+			"alerts": alerts,
+		}
+
+		for _, admin := range admins {
+			err = app.mailer.Send(admin.Email, "Low stock", "low_stock.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{
+					"user_id": fmt.Sprint(admin.ID),
+				})
+			}
+		}
+	})
+}
+
+// verifyInventory logs the variants whose stock no longer matches the ledger, e.g. after
+// a manual database fix.
+func (app *application) verifyInventory() {
+	discrepancies, err := app.gorm.InventoryMovements.GetDiscrepancies()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	for _, discrepancy := range discrepancies {
+		app.logger.PrintInfo("inventory discrepancy", map[string]string{
+			"product_detail_id": fmt.Sprint(discrepancy.ProductDetailID),
+			"sku":               discrepancy.SKU,
+			"stock":             fmt.Sprint(discrepancy.Stock),
+			"ledger_stock":      fmt.Sprint(discrepancy.LedgerStock),
+		})
+	}
+}
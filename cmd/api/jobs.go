@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// startJobs launches the periodic jobs that run alongside the HTTP server.
+func (app *application) startJobs() {
+	app.runPeriodically(time.Hour, app.anonymiseDeletedUsers)
+	app.runPeriodically(time.Minute, app.sendProductAlerts)
+	app.runPeriodically(24*time.Hour, app.verifyInventory)
+	app.runPeriodically(6*time.Hour, app.refreshRecommendations)
+	app.runPeriodically(24*time.Hour, app.deleteExpiredProductViews)
+	app.runPeriodically(time.Hour, app.expireStoreCredit)
+	app.runPeriodically(time.Hour, app.expireLoyaltyPoints)
+	app.schedulePublishing()
+}
+
+// runPeriodically calls fn every interval until the process exits. A panic in fn is
+// logged and does not stop later runs.
+func (app *application) runPeriodically(interval time.Duration, fn func()) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			func() {
+				defer func() {
+					if err := recover(); err != nil {
+						app.logger.PrintError(fmt.Errorf("%s", err), nil)
+					}
+				}()
+
+				fn()
+			}()
+		}
+	}()
+}
+
+func (app *application) anonymiseDeletedUsers() {
+	ids, err := app.models.Users.GetAllDueForDeletion()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	for _, id := range ids {
+		// Orders placed during the grace period must be settled before their owner is wiped,
+		// so the user is left for a later run until then.
+		openOrders, err := app.gorm.Orders.CountOpenForUser(id)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{
+				"user_id": fmt.Sprint(id),
+			})
+			continue
+		}
+
+		if openOrders > 0 {
+			app.logger.PrintInfo("user anonymisation postponed for open orders", map[string]string{
+				"user_id": fmt.Sprint(id),
+			})
+			continue
+		}
+
+		err = app.models.Users.Anonymise(id)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{
+				"user_id": fmt.Sprint(id),
+			})
+			continue
+		}
+
+		app.logger.PrintInfo("user anonymised", map[string]string{
+			"user_id": fmt.Sprint(id),
+		})
+	}
+}
+
+func (app *application) refreshRecommendations() {
+	start := time.Now()
+
+	err := app.gorm.Recommendations.Refresh()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	app.logger.PrintInfo("recommendations refreshed", map[string]string{
+		"duration": time.Since(start).String(),
+	})
+}
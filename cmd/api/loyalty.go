@@ -0,0 +1,124 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/kervinch/internal/data"
+	"github.com/kervinch/internal/validator"
+)
+
+// ====================================================================================
+// Backoffice Handlers
+// ====================================================================================
+
+func (app *application) showLoyaltySettingsHandler(w http.ResponseWriter, r *http.Request) {
+	settings, err := app.gorm.Loyalty.GetSettings()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	tiers, err := app.gorm.Loyalty.GetTiers()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), envelope{"settings": settings, "tiers": tiers}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateLoyaltySettingsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		EarnPercent      float64            `json:"earn_percent"`
+		PointValue       int64              `json:"point_value"`
+		MaxRedeemPercent int                `json:"max_redeem_percent"`
+		ValidityDays     int                `json:"validity_days"`
+		Tiers            []data.LoyaltyTier `json:"tiers"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	settings := &data.LoyaltySettings{
+		EarnPercent:      input.EarnPercent,
+		PointValue:       input.PointValue,
+		MaxRedeemPercent: input.MaxRedeemPercent,
+		ValidityDays:     input.ValidityDays,
+		UpdatedBy:        sql.NullInt64{Int64: app.contextGetUser(r).ID, Valid: true},
+	}
+
+	v := validator.New()
+
+	if data.ValidateLoyaltySettings(v, settings, input.Tiers); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.gorm.Loyalty.UpdateSettings(settings, input.Tiers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.showLoyaltySettingsHandler(w, r)
+}
+
+// ====================================================================================
+// Business Handlers
+// ====================================================================================
+
+func (app *application) getLoyaltyHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var pagination data.Pagination
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	pagination.Page = app.readInt(qs, "page", 1, v)
+	pagination.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	if data.ValidatePagination(v, pagination); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	summary, err := app.gorm.Loyalty.GetSummary(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	entries, metadata, err := app.gorm.Loyalty.GetAllForUser(user, pagination)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSONWithMeta(w, http.StatusOK, http.StatusText(http.StatusOK), envelope{"summary": summary, "entries": entries}, nil, metadata)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// expireLoyaltyPoints writes off the loyalty points that reached their expiry unspent.
+func (app *application) expireLoyaltyPoints() {
+	expired, err := app.gorm.Loyalty.Expire()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+	}
+
+	if expired > 0 {
+		app.logger.PrintInfo("loyalty points expired", map[string]string{
+			"entries": fmt.Sprint(expired),
+		})
+	}
+}
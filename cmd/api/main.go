@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
@@ -19,7 +20,9 @@ import (
 	"github.com/kervinch/internal/data"
 	"github.com/kervinch/internal/jsonlog"
 	"github.com/kervinch/internal/mailer"
+	"github.com/kervinch/internal/oauth"
 	"github.com/kervinch/internal/s3"
+	"github.com/kervinch/internal/sms"
 	"github.com/kervinch/internal/xendit"
 
 	_ "github.com/lib/pq"
@@ -56,6 +59,25 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	accountDeletion struct {
+		gracePeriod time.Duration
+	}
+	oauth struct {
+		google struct {
+			jwksURL  string
+			issuers  []string
+			clientID string
+		}
+		apple struct {
+			jwksURL  string
+			issuers  []string
+			clientID string
+		}
+	}
+	sms struct {
+		gatewayURL string
+		apiKey     string
+	}
 }
 
 // Define an application struct to hold the dependencies for our HTTP handlers, helpers,
@@ -71,6 +93,13 @@ type application struct {
 	s3     s3.S3
 	xendit xendit.Xendit
 	cache  bigcache.BigCache
+	oauth  oauth.OAuth
+	sms    sms.Sender
+	// searchCacheVersion is part of every search suggestion cache key, so bumping it
+	// invalidates all cached suggestions at once.
+	searchCacheVersion int64
+	// publishing wakes the publishing scheduler when a publishing window changes.
+	publishing chan struct{}
 }
 
 func main() {
@@ -98,6 +127,27 @@ func main() {
 		return nil
 	})
 
+	flag.DurationVar(&cfg.accountDeletion.gracePeriod, "account-deletion-grace-period", 30*24*time.Hour, "Time before a deleted account is anonymised")
+
+	flag.StringVar(&cfg.oauth.google.jwksURL, "oauth-google-jwks-url", "https://www.googleapis.com/oauth2/v3/certs", "Google JWKS URL")
+	flag.StringVar(&cfg.oauth.google.clientID, "oauth-google-client-id", os.Getenv("OAUTH_GOOGLE_CLIENT_ID"), "Google OAuth client ID")
+	cfg.oauth.google.issuers = []string{"https://accounts.google.com", "accounts.google.com"}
+	flag.Func("oauth-google-issuers", "Google ID token issuers (space separated)", func(val string) error {
+		cfg.oauth.google.issuers = strings.Fields(val)
+		return nil
+	})
+
+	flag.StringVar(&cfg.oauth.apple.jwksURL, "oauth-apple-jwks-url", "https://appleid.apple.com/auth/keys", "Apple JWKS URL")
+	flag.StringVar(&cfg.oauth.apple.clientID, "oauth-apple-client-id", os.Getenv("OAUTH_APPLE_CLIENT_ID"), "Apple OAuth client ID")
+	cfg.oauth.apple.issuers = []string{"https://appleid.apple.com"}
+	flag.Func("oauth-apple-issuers", "Apple ID token issuers (space separated)", func(val string) error {
+		cfg.oauth.apple.issuers = strings.Fields(val)
+		return nil
+	})
+
+	flag.StringVar(&cfg.sms.gatewayURL, "sms-gateway-url", os.Getenv("SMS_GATEWAY_URL"), "SMS gateway URL (messages are only logged when empty)")
+	flag.StringVar(&cfg.sms.apiKey, "sms-gateway-api-key", os.Getenv("SMS_GATEWAY_API_KEY"), "SMS gateway API key")
+
 	displayVersion := flag.Bool("version", false, "Display versions and exit")
 
 	flag.Parse()
@@ -112,6 +162,16 @@ func main() {
 	// logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
+	// One-time passwords must reach the phone in production, where checkout requires a
+	// verified phone number, so only development may log them instead.
+	var smsSender sms.Sender = sms.NewLogSender(logger)
+
+	if cfg.sms.gatewayURL != "" {
+		smsSender = sms.NewHTTPSender(cfg.sms.gatewayURL, cfg.sms.apiKey)
+	} else if cfg.env == "production" {
+		logger.PrintFatal(errors.New("an SMS gateway must be configured in production"), nil)
+	}
+
 	db, gorm, err := openDB(cfg)
 	if err != nil {
 		logger.PrintFatal(err, nil)
@@ -145,8 +205,16 @@ func main() {
 		s3:     s3.New("kin-public"),
 		xendit: xendit.New(os.Getenv("XENDIT_SECRET_KEY")),
 		cache:  *bigcache,
+		oauth: oauth.New(
+			oauth.Provider{Name: oauth.GOOGLE, JWKSURL: cfg.oauth.google.jwksURL, Issuers: cfg.oauth.google.issuers, ClientID: cfg.oauth.google.clientID},
+			oauth.Provider{Name: oauth.APPLE, JWKSURL: cfg.oauth.apple.jwksURL, Issuers: cfg.oauth.apple.issuers, ClientID: cfg.oauth.apple.clientID},
+		),
+		sms:        smsSender,
+		publishing: make(chan struct{}, 1),
 	}
 
+	app.startJobs()
+
 	err = app.serve()
 	if err != nil {
 		logger.PrintFatal(err, nil)
@@ -22,6 +22,11 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 
 		defer func() {
 			if err := recover(); err != nil {
+				// An aborted response is left for net/http to drop the connection.
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+
 				w.Header().Set("Connection", "close")
 				app.serverErrorResponse(w, r, fmt.Errorf("%s", err))
 			}
@@ -218,10 +223,16 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 			return
 		}
 
+		if data.SensitivePermissions.Include(code) && !user.TOTPEnabled {
+			app.twoFactorRequiredResponse(w, r)
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	}
 
-	return app.requireActivatedUser(fn)
+	// Permissions are only granted to admins, so check the role before the database is hit.
+	return app.requireAuthenticatedAdmin(fn)
 }
 
 func (app *application) enableCORS(next http.Handler) http.Handler {
@@ -7,6 +7,7 @@ import (
 
 	"github.com/kervinch/internal/data"
 	"github.com/kervinch/internal/s3"
+	"github.com/kervinch/internal/spreadsheet"
 	"github.com/kervinch/internal/validator"
 )
 
@@ -15,15 +16,17 @@ import (
 // ====================================================================================
 
 func (app *application) listOrderRefundsHandler(w http.ResponseWriter, r *http.Request) {
-	var pagination data.Pagination
-
 	v := validator.New()
 	qs := r.URL.Query()
 
-	pagination.Page = app.readInt(qs, "page", 1, v)
-	pagination.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters := app.readFilters(qs, "-created_at", data.OrderRefundSortSafeList, data.OrderRefundFilterSafeList, v)
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 
-	orderRefunds, metadata, err := app.gorm.OrderRefunds.GetAll(pagination)
+	orderRefunds, metadata, err := app.gorm.OrderRefunds.GetAll(filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -35,6 +38,27 @@ func (app *application) listOrderRefundsHandler(w http.ResponseWriter, r *http.R
 	}
 }
 
+func (app *application) exportOrderRefundsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters := app.readFilters(qs, "-created_at", data.OrderRefundSortSafeList, data.OrderRefundFilterSafeList, v)
+	format := app.readStrings(qs, "format", spreadsheet.FormatCSV)
+
+	v.Check(validator.In(format, spreadsheet.FormatCSV, spreadsheet.FormatXLSX), "format", "must be csv or xlsx")
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	app.streamExport(w, r, "order-refunds", format, data.OrderRefundExportHeader, func(write func(row []interface{}) error) error {
+		return app.gorm.OrderRefunds.Export(filters, func(row *data.OrderRefundExportRow) error {
+			return write(row.Values())
+		})
+	})
+}
+
 func (app *application) showOrderRefundHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
@@ -78,7 +102,8 @@ func (app *application) updateOrderRefundStatusHandler(w http.ResponseWriter, r
 	}
 
 	var input struct {
-		Status string `json:"status"`
+		Status   string `json:"status"`
+		RefundTo string `json:"refund_to"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -91,17 +116,57 @@ func (app *application) updateOrderRefundStatusHandler(w http.ResponseWriter, r
 
 	v := validator.New()
 
+	// An approved refund is paid through the payment gateway unless it goes to store credit.
+	refunded := input.Status == "refund_immediately" || input.Status == "refund"
+
+	v.Check(input.RefundTo == "" || validator.In(input.RefundTo, "gateway", "store_credit"), "refund_to", "must be either gateway or store_credit")
+	v.Check(input.RefundTo != "store_credit" || refunded, "refund_to", "must only be store_credit when the refund is approved")
+	v.Check(input.RefundTo != "store_credit" || orderRefund.RefundValue > 0, "refund_value", "must be set before refunding to store credit")
+
 	if data.ValidateOrderRefund(v, orderRefund); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.gorm.OrderRefunds.UpdateStatus(orderRefund, input.Status)
+	// The refund only changes status together with its store credit, so a failed credit
+	// leaves the refund open to be approved again.
+	tx := app.gorm.Transaction.DB.Begin()
+
+	err = app.gorm.OrderRefunds.UpdateStatusWithTx(orderRefund, input.Status, tx)
 	if err != nil {
+		tx.Rollback()
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	if input.RefundTo == "store_credit" {
+		err = app.gorm.Wallet.CreditRefundWithTx(orderRefund, app.contextGetUser(r).ID, tx)
+		if err != nil {
+			tx.Rollback()
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		orderRefund.RefundTo = input.RefundTo
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Returned items go back in stock.
+	if input.Status == "return" {
+		err = app.gorm.InventoryMovements.ReturnOrderDetail(orderRefund.OrderDetailID, app.contextGetUser(r).ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.invalidateCatalogueCache()
+	}
+
 	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), orderRefund, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -187,8 +252,16 @@ func (app *application) updateOrderRefundRefundValueHandler(w http.ResponseWrite
 
 	orderRefund.RefundValue = input.RefundValue
 
+	refundable, err := app.gorm.OrderDetails.GetRefundableTotal(orderRefund.OrderDetailID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	v := validator.New()
 
+	v.Check(orderRefund.RefundValue <= refundable, "refund_value", "must not be more than the total of the invoice without its gift cards")
+
 	if data.ValidateOrderRefund(v, orderRefund); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -332,6 +405,24 @@ func (app *application) createOrderRefundHandler(w http.ResponseWriter, r *http.
 		return
 	}
 
+	refundable, err := app.gorm.OrderDetails.GetRefundableTotal(orderRefund.OrderDetailID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("order_detail_id", "does not match any invoice")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if refundable == 0 {
+		v.AddError("order_detail_id", "gift cards cannot be refunded")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	err = app.gorm.OrderRefunds.Insert(orderRefund)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -354,6 +445,12 @@ func (app *application) getOrderRefundHandler(w http.ResponseWriter, r *http.Req
 
 	pagination.Page = app.readInt(qs, "page", 1, v)
 	pagination.PageSize = app.readInt(qs, "page_size", 20, v)
+	pagination.After = app.readStrings(qs, "after", "")
+
+	if data.ValidateCursor(v, pagination.After, data.NewestFirst); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 
 	orderRefunds, metadata, err := app.gorm.OrderRefunds.GetAPI(pagination, user)
 	if err != nil {
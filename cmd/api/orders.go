@@ -6,6 +6,7 @@ import (
 	"strconv"
 
 	"github.com/kervinch/internal/data"
+	"github.com/kervinch/internal/spreadsheet"
 	"github.com/kervinch/internal/validator"
 	"github.com/xendit/xendit-go"
 )
@@ -15,15 +16,17 @@ import (
 // ====================================================================================
 
 func (app *application) listOrdersHandler(w http.ResponseWriter, r *http.Request) {
-	var pagination data.Pagination
-
 	v := validator.New()
 	qs := r.URL.Query()
 
-	pagination.Page = app.readInt(qs, "page", 1, v)
-	pagination.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters := app.readFilters(qs, "-created_at", data.OrderSortSafeList, data.OrderFilterSafeList, v)
 
-	orders, metadata, err := app.gorm.Orders.GetAll(pagination)
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	orders, metadata, err := app.gorm.Orders.GetAll(filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -35,6 +38,27 @@ func (app *application) listOrdersHandler(w http.ResponseWriter, r *http.Request
 	}
 }
 
+func (app *application) exportOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters := app.readFilters(qs, "-created_at", data.OrderSortSafeList, data.OrderFilterSafeList, v)
+	format := app.readStrings(qs, "format", spreadsheet.FormatCSV)
+
+	v.Check(validator.In(format, spreadsheet.FormatCSV, spreadsheet.FormatXLSX), "format", "must be csv or xlsx")
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	app.streamExport(w, r, "orders", format, data.OrderExportHeader, func(write func(row []interface{}) error) error {
+		return app.gorm.Orders.Export(filters, func(row *data.OrderExportRow) error {
+			return write(row.Values())
+		})
+	})
+}
+
 func (app *application) showOrderHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
@@ -78,12 +102,18 @@ func (app *application) getOrdersHandler(w http.ResponseWriter, r *http.Request)
 	input.Status = app.readStrings(qs, "status", "")
 	input.Page = app.readInt(qs, "page", 1, v)
 	input.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.After = app.readStrings(qs, "after", "")
 
 	if data.ValidatePagination(v, input.Pagination); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
+	if data.ValidateCursor(v, input.After, data.NewestFirst); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	orders, metadata, err := app.gorm.Orders.GetAPI(input.Pagination, user.ID, input.Status)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -101,6 +131,12 @@ func (app *application) createOrdersHandler(w http.ResponseWriter, r *http.Reque
 
 	user := app.contextGetUser(r)
 
+	// Xendit sends payment notifications by SMS, so the number must belong to the user.
+	if !user.PhoneVerified {
+		app.unverifiedPhoneNumberResponse(w, r)
+		return
+	}
+
 	var x struct {
 		Customer        xendit.InvoiceCustomer
 		CustomerAddress xendit.CustomerAddress
@@ -129,6 +165,48 @@ func (app *application) createOrdersHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// The store credit is checked before anything is reserved, and again when it is spent.
+	storeCredit, _ := strconv.ParseInt(r.FormValue("store_credit"), 10, 64)
+
+	if storeCredit != 0 {
+		balance, err := app.gorm.Wallet.GetBalance(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		v.Check(storeCredit > 0, "store_credit", "must be a positive integer")
+		v.Check(storeCredit <= balance, "store_credit", "exceeds the available store credit")
+
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+
+	// Loyalty points are checked the same way, their value is known once the order is.
+	loyaltyPoints, _ := strconv.ParseInt(r.FormValue("loyalty_points"), 10, 64)
+
+	var loyalty *data.LoyaltySettings
+
+	if loyaltyPoints != 0 {
+		summary, err := app.gorm.Loyalty.GetSummary(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		v.Check(loyaltyPoints > 0, "loyalty_points", "must be a positive integer")
+		v.Check(loyaltyPoints <= summary.Points, "loyalty_points", "exceeds the available points")
+
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		loyalty = summary.Settings
+	}
+
 	db := app.gorm.Transaction.DB
 	tx := db.Begin()
 
@@ -214,6 +292,13 @@ func (app *application) createOrdersHandler(w http.ResponseWriter, r *http.Reque
 	// ===================================
 
 	var odids []int64
+	var alerts []*data.LowStockAlert
+
+	lines := make(map[int64][]data.PromotionLine)
+
+	// Gift cards are worth their price in store credit, so no discount applies to them.
+	giftCards := make(map[int64]int)
+	giftCardTotal := 0
 
 	for _, bid := range brandID {
 		orderDetail := &data.OrderDetail{
@@ -242,38 +327,103 @@ func (app *application) createOrdersHandler(w http.ResponseWriter, r *http.Reque
 
 		for i := 0; i < count; i++ {
 			if input.ProductDetail[i].Product.BrandID == bid {
-				invoiceDetail := &data.InvoiceDetail{
-					OrderDetailID:   orderDetailID,
-					ProductDetailID: input.ProductDetail[i].ID,
-					ProductName:     input.ProductDetail[i].Product.Name,
-					Quantity:        input.Quantity[i],
-					Price:           input.ProductDetail[i].Price,
-					Total:           int64(input.Quantity[i]) * input.ProductDetail[i].Price,
+				// The units a sale has no quota left for are sold at the regular price, on a
+				// line of their own.
+				productDetails := []*data.ProductDetail{input.ProductDetail[i]}
+				quantities := []int{input.Quantity[i]}
+
+				if input.ProductDetail[i].CampaignItemID != nil {
+					reserved, err := app.gorm.Campaigns.ReserveWithTx(*input.ProductDetail[i].CampaignItemID, user.ID, input.Quantity[i], tx)
+					if err != nil {
+						tx.Rollback()
+						switch {
+						case errors.Is(err, data.ErrCampaignUnavailable):
+							v.AddError("product_detail_id_"+strconv.Itoa(i), "is no longer available at the sale price")
+							app.failedValidationResponse(w, r, v.Errors)
+						case errors.Is(err, data.ErrCampaignLimitExceeded):
+							v.AddError("quantity_"+strconv.Itoa(i), "exceeds the limit per customer of the sale")
+							app.failedValidationResponse(w, r, v.Errors)
+						default:
+							app.serverErrorResponse(w, r, err)
+						}
+						return
+					}
+
+					if reserved < input.Quantity[i] {
+						regular := *input.ProductDetail[i]
+						regular.SalePrice, regular.SaleEndsAt, regular.CampaignID, regular.CampaignItemID = nil, nil, nil, nil
+
+						productDetails = append(productDetails, &regular)
+						quantities = []int{reserved, input.Quantity[i] - reserved}
+					}
 				}
 
-				v = validator.New()
-
-				if data.ValidateInvoiceDetail(v, invoiceDetail); !v.Valid() {
-					tx.Rollback()
-					app.failedValidationResponse(w, r, v.Errors)
-					return
+				for j, productDetail := range productDetails {
+					if quantities[j] == 0 {
+						continue
+					}
+
+					invoiceDetail := &data.InvoiceDetail{
+						OrderDetailID:   orderDetailID,
+						ProductDetailID: productDetail.ID,
+						ProductName:     productDetail.Product.Name,
+						Quantity:        quantities[j],
+						Price:           productDetail.EffectivePrice(),
+						Total:           int64(quantities[j]) * productDetail.EffectivePrice(),
+						CampaignID:      productDetail.CampaignID,
+						CampaignItemID:  productDetail.CampaignItemID,
+					}
+
+					v = validator.New()
+
+					if data.ValidateInvoiceDetail(v, invoiceDetail); !v.Valid() {
+						tx.Rollback()
+						app.failedValidationResponse(w, r, v.Errors)
+						return
+					}
+
+					err := app.gorm.InvoiceDetails.InsertWithTx(invoiceDetail, tx)
+					if err != nil {
+						tx.Rollback()
+						app.serverErrorResponse(w, r, err)
+						return
+					}
+
+					lines[bid] = append(lines[bid], data.NewPromotionLine(productDetail, quantities[j]))
+
+					if productDetail.Product.IsGiftCard {
+						giftCards[bid] += int(invoiceDetail.Total)
+						giftCardTotal += int(invoiceDetail.Total)
+					}
+
+					// Xendit InvoiceItem logic
+					invoiceItem := xendit.InvoiceItem{
+						Name:     productDetail.Product.Name,
+						Price:    float64(productDetail.EffectivePrice()),
+						Quantity: quantities[j],
+					}
+
+					x.InvoiceItem = append(x.InvoiceItem, invoiceItem)
 				}
 
-				err := app.gorm.InvoiceDetails.InsertWithTx(invoiceDetail, tx)
+				alert, err := app.gorm.InventoryMovements.ReserveWithTx(orderDetailID, input.ProductDetail[i].ID, input.Quantity[i], tx)
 				if err != nil {
 					tx.Rollback()
-					app.serverErrorResponse(w, r, err)
+					switch {
+					case errors.Is(err, data.ErrOutOfStock):
+						v.AddError("quantity_"+strconv.Itoa(i), "exceeds the available stock")
+						app.failedValidationResponse(w, r, v.Errors)
+					case errors.Is(err, data.ErrRecordNotFound):
+						app.notFoundResponse(w, r)
+					default:
+						app.serverErrorResponse(w, r, err)
+					}
 					return
 				}
 
-				// Xendit InvoiceItem logic
-				invoiceItem := xendit.InvoiceItem{
-					Name:     input.ProductDetail[i].Product.Name,
-					Price:    float64(input.ProductDetail[i].Price),
-					Quantity: input.Quantity[i],
+				if alert != nil {
+					alerts = append(alerts, alert)
 				}
-
-				x.InvoiceItem = append(x.InvoiceItem, invoiceItem)
 			}
 		}
 	}
@@ -302,8 +452,33 @@ func (app *application) createOrdersHandler(w http.ResponseWriter, r *http.Reque
 		voucher = &data.Voucher{}
 	}
 
+	promotions, err := app.gorm.Promotions.GetActive()
+	if err != nil {
+		tx.Rollback()
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	tx.Commit()
 
+	app.notifyLowStock(alerts)
+
+	// The stock and campaign quota are reserved from here on. Unless the order is paid
+	// outright or gets an invoice, whose expiry callback settles it, release them as an
+	// expired invoice would.
+	settled := false
+
+	defer func() {
+		if settled {
+			return
+		}
+
+		settleErr := app.gorm.InventoryMovements.SettleOrder(orderID, false)
+		if settleErr != nil {
+			app.logError(r, settleErr)
+		}
+	}()
+
 	// ===========
 	// Total Logic
 	// ===========
@@ -330,17 +505,37 @@ func (app *application) createOrdersHandler(w http.ResponseWriter, r *http.Reque
 			subtotal += int(id.Total)
 		}
 
-		if voucher.Type == "brand" && voucher.BrandID.Int64 == od.BrandID {
-			if voucher.IsPercent {
-				total = subtotal - (subtotal * voucher.Value / 100)
-			} else {
-				total = subtotal - voucher.Value
+		// Promotions are evaluated per brand, as each brand is invoiced on its own, and
+		// come off the subtotal before the voucher.
+		discounts := data.ApplyPromotions(promotions, lines[od.BrandID])
+		discounted := subtotal
+
+		for _, discount := range discounts {
+			discounted -= int(discount.Value)
+
+			// Xendit InvoiceFee logic
+			invoiceFee := xendit.InvoiceFee{
+				Type:  discount.Name,
+				Value: float64(discount.Value * -1),
 			}
 
+			x.InvoiceFee = append(x.InvoiceFee, invoiceFee)
+		}
+
+		err = app.gorm.OrderDiscounts.InsertWithTx(od.ID, discounts, tx)
+		if err != nil {
+			tx.Rollback()
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if voucher.Type == "brand" && voucher.BrandID.Int64 == od.BrandID {
+			total = discounted - voucherDiscount(voucher, discounted-giftCards[od.BrandID])
+
 			// Xendit InvoiceFee logic
 			invoiceFee := xendit.InvoiceFee{
 				Type:  "discount",
-				Value: float64((subtotal - total) * -1),
+				Value: float64((discounted - total) * -1),
 			}
 
 			x.InvoiceFee = append(x.InvoiceFee, invoiceFee)
@@ -359,7 +554,7 @@ func (app *application) createOrdersHandler(w http.ResponseWriter, r *http.Reque
 				return
 			}
 		} else {
-			total = subtotal
+			total = discounted
 			err = app.gorm.OrderDetails.SetTotalWithTx(od.ID, int64(subtotal), int64(total), tx)
 			if err != nil {
 				tx.Rollback()
@@ -389,11 +584,7 @@ func (app *application) createOrdersHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	if voucher.Type == "total" {
-		if voucher.IsPercent {
-			total = subtotal - (subtotal * voucher.Value / 100)
-		} else {
-			total = subtotal - voucher.Value
-		}
+		total = subtotal - voucherDiscount(voucher, subtotal-giftCardTotal)
 
 		// Xendit InvoiceFee logic
 		invoiceFee := xendit.InvoiceFee{
@@ -426,8 +617,119 @@ func (app *application) createOrdersHandler(w http.ResponseWriter, r *http.Reque
 		}
 	}
 
+	// =====================
+	// Loyalty Points Logic
+	// =====================
+
+	var loyaltyDiscount int64
+
+	if loyaltyPoints > 0 {
+		// Points pay at most MaxRedeemPercent of the order without its gift cards, and only
+		// whole points are spent.
+		maxDiscount := int64(total-giftCardTotal) * int64(loyalty.MaxRedeemPercent) / 100
+		if maxDiscount < 0 {
+			maxDiscount = 0
+		}
+
+		if loyaltyPoints*loyalty.PointValue > maxDiscount {
+			loyaltyPoints = maxDiscount / loyalty.PointValue
+		}
+
+		loyaltyDiscount = loyaltyPoints * loyalty.PointValue
+	}
+
+	if loyaltyPoints > 0 {
+		err = app.gorm.Loyalty.RedeemWithTx(user.ID, orderID, loyaltyPoints, loyaltyDiscount, tx)
+		if err != nil {
+			tx.Rollback()
+
+			switch {
+			case errors.Is(err, data.ErrInsufficientPoints):
+				v = validator.New()
+				v.AddError("loyalty_points", "exceeds the available points")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		// Xendit InvoiceFee logic
+		invoiceFee := xendit.InvoiceFee{
+			Type:  "loyalty points",
+			Value: float64(loyaltyDiscount * -1),
+		}
+
+		x.InvoiceFee = append(x.InvoiceFee, invoiceFee)
+
+		total -= int(loyaltyDiscount)
+	}
+
+	// ==================
+	// Store Credit Logic
+	// ==================
+
+	if storeCredit > int64(total) {
+		storeCredit = int64(total)
+	}
+
+	if storeCredit > 0 {
+		err = app.gorm.Wallet.PayOrderWithTx(user.ID, orderID, storeCredit, tx)
+		if err == nil {
+			err = app.gorm.Orders.SetStoreCreditWithTx(orderID, storeCredit, tx)
+		}
+		if err != nil {
+			tx.Rollback()
+
+			switch {
+			case errors.Is(err, data.ErrInsufficientCredit):
+				v = validator.New()
+				v.AddError("store_credit", "exceeds the available store credit")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		// Xendit InvoiceFee logic
+		invoiceFee := xendit.InvoiceFee{
+			Type:  "store credit",
+			Value: float64(storeCredit * -1),
+		}
+
+		x.InvoiceFee = append(x.InvoiceFee, invoiceFee)
+
+		total -= int(storeCredit)
+	}
+
 	tx.Commit()
 
+	// An order paid in full with store credit or points needs no invoice.
+	if (storeCredit > 0 || loyaltyPoints > 0) && total == 0 {
+		err = app.gorm.Orders.UpdateStatus(orderID, "paid")
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		settled = true
+
+		err = app.gorm.InventoryMovements.SettleOrder(orderID, true)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.issueGiftCards(orderID, user.Email)
+
+		err = app.writeJSON(w, http.StatusCreated, http.StatusText(http.StatusCreated), envelope{"order": order, "invoice": nil}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
 	// Generate Xendit invoice
 	notificationType := []string{"email", "sms"}
 	invoice, err := app.xendit.GenerateInvoice(orderID, x.Customer, x.CustomerAddress, x.InvoiceItem, x.InvoiceFee, notificationType, total)
@@ -436,12 +738,33 @@ func (app *application) createOrdersHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	settled = true
+
 	err = app.writeJSON(w, http.StatusCreated, http.StatusText(http.StatusCreated), envelope{"order": order, "invoice": invoice}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// voucherDiscount returns what a voucher takes off amount, never more than amount.
+func voucherDiscount(voucher *data.Voucher, amount int) int {
+	discount := voucher.Value
+
+	if voucher.IsPercent {
+		discount = amount * voucher.Value / 100
+	}
+
+	if discount > amount {
+		discount = amount
+	}
+
+	if discount < 0 {
+		discount = 0
+	}
+
+	return discount
+}
+
 func (app *application) updateOrdersHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
@@ -459,6 +782,13 @@ func (app *application) updateOrdersHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	v := validator.New()
+
+	if v.Check(validator.In(input.Status, data.OrderStatuses...), "status", "must be valid to enum defined"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	order, err := app.gorm.Orders.Get(id)
 	if err != nil {
 		switch {
@@ -470,7 +800,92 @@ func (app *application) updateOrdersHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	order.Status = input.Status
+	app.changeOrderStatus(w, r, order, input.Status)
+}
+
+// updateUserOrderHandler lets a customer confirm the delivery of one of their orders or
+// ask for it to be refunded; every other status change is left to admins.
+func (app *application) updateUserOrderHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Status string `json:"status"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	order, err := app.gorm.Orders.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if order.UserID != user.ID {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	switch input.Status {
+	case "completed":
+		v.Check(order.Status == "delivery", "status", "can only be set on an order being delivered")
+	case "refund_requested":
+		v.Check(validator.In(order.Status, "delivery", "completed"), "status", "can only be set on a delivered order")
+	default:
+		v.AddError("status", "must be completed or refund_requested")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	app.changeOrderStatus(w, r, order, input.Status)
+}
+
+// changeOrderStatus saves the new status of an order along with the points and referral
+// rewards it settles.
+func (app *application) changeOrderStatus(w http.ResponseWriter, r *http.Request, order *data.Order, status string) {
+	var err error
+
+	previous := order.Status
+	order.Status = status
+
+	// Points and referral rewards are settled before the status is saved, so a failure
+	// leaves the order as it was to be retried; each happens once per order either way.
+	// Only an order that was paid for earns them, and a refunded one gives them back.
+	switch {
+	case order.Status == "completed" && validator.In(previous, "paid", "processing", "delivery", "refund_rejected"):
+		err = app.gorm.Loyalty.EarnForOrder(order)
+		if err == nil {
+			_, err = app.gorm.Referrals.RewardForOrder(order)
+		}
+	case order.Status == "refund_completed" && previous != "refund_completed":
+		err = app.gorm.Loyalty.ReverseForOrder(order)
+		if err == nil {
+			err = app.gorm.Referrals.ReverseForOrder(order)
+		}
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
 	err = app.gorm.Orders.Update(order)
 	if err != nil {
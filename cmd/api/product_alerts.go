@@ -0,0 +1,259 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kervinch/internal/data"
+	"github.com/kervinch/internal/validator"
+)
+
+const (
+	// productAlertBatchSize is how many queued events one run of the job fans out.
+	productAlertBatchSize = 100
+
+	// productAlertsPerUserPerDay caps the alerts a user receives in 24 hours, on top of
+	// a single alert per variant.
+	productAlertsPerUserPerDay = 3
+)
+
+// ====================================================================================
+// Business Handlers
+// ====================================================================================
+
+func (app *application) getBackInStockSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var pagination data.Pagination
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	pagination.Page = app.readInt(qs, "page", 1, v)
+	pagination.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	subscriptions, metadata, err := app.gorm.BackInStockSubscriptions.GetAll(pagination, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSONWithMeta(w, http.StatusOK, http.StatusText(http.StatusOK), subscriptions, nil, metadata)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createBackInStockSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		ProductDetailID int64 `json:"product_detail_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	subscription := &data.BackInStockSubscription{
+		UserID:          user.ID,
+		ProductDetailID: input.ProductDetailID,
+	}
+
+	v := validator.New()
+
+	if data.ValidateBackInStockSubscription(v, subscription); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	productDetail, err := app.gorm.ProductDetails.Get(subscription.ProductDetailID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("product_detail_id", "does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if v.Check(productDetail.Stock <= 0, "product_detail_id", "is in stock"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.gorm.BackInStockSubscriptions.Insert(subscription)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateKeyValue):
+			app.violateUniqueConstraint(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, http.StatusText(http.StatusCreated), subscription, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteBackInStockSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.gorm.BackInStockSubscriptions.Delete(id, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), "subscription successfully deleted", nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// ====================================================================================
+// Jobs
+// ====================================================================================
+
+// sendProductAlerts fans out the queued back in stock and price drop events to the inbox
+// and email of the interested users.
+func (app *application) sendProductAlerts() {
+	events, err := app.gorm.ProductAlerts.GetPending(productAlertBatchSize)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	for _, event := range events {
+		// Subscribers held back by their daily cap are still waiting for the restock, so
+		// the event is tried again later rather than marked processed.
+		if app.sendProductAlert(event) {
+			err = app.gorm.ProductAlerts.Defer(event, time.Now().Add(time.Hour))
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{
+					"product_alert_event_id": fmt.Sprint(event.ID),
+				})
+			}
+			continue
+		}
+
+		err = app.gorm.ProductAlerts.MarkProcessed(event)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{
+				"product_alert_event_id": fmt.Sprint(event.ID),
+			})
+		}
+	}
+}
+
+// sendProductAlert fans an event out to its recipients. It reports whether back in stock
+// subscribers were held back by their daily cap, in which case the event must be kept.
+func (app *application) sendProductAlert(event *data.ProductAlertEvent) bool {
+	productDetail := event.ProductDetail
+	product := productDetail.Product
+	price := productDetail.EffectivePrice()
+
+	if !productDetail.IsActive || !product.IsActive {
+		return false
+	}
+
+	// The variant is checked again as it is now: stock released by an expired order may
+	// already be sold, and a price may be raised back before the job runs.
+	switch event.Type {
+	case data.ProductAlertBackInStock:
+		if productDetail.Stock <= 0 {
+			return false
+		}
+	case data.ProductAlertPriceDrop:
+		if price >= event.OldPrice {
+			return false
+		}
+	}
+
+	properties := map[string]string{
+		"product_alert_event_id": fmt.Sprint(event.ID),
+	}
+
+	users, held, err := app.gorm.ProductAlerts.GetRecipients(event, productAlertsPerUserPerDay)
+	if err != nil {
+		app.logger.PrintError(err, properties)
+		return false
+	}
+
+	name := product.Name
+	if variant := strings.TrimSpace(productDetail.Color + " " + productDetail.Size); variant != "" {
+		name = fmt.Sprintf("%s (%s)", name, variant)
+	}
+
+	var title, content string
+
+	switch event.Type {
+	case data.ProductAlertBackInStock:
+		title = "Back in stock"
+		content = fmt.Sprintf("%s is back in stock for Rp %d. Get it before it runs out again!", name, price)
+	default:
+		title = "Price drop"
+		content = fmt.Sprintf("%s from your favorites is now Rp %d, down from Rp %d.", name, price, event.OldPrice)
+	}
+
+	var imageURL string
+	for _, image := range productDetail.ProductImage {
+		if imageURL == "" || image.IsMain {
+			imageURL = image.ImageURL
+		}
+	}
+
+	for _, user := range users {
+		inbox := &data.Inbox{
+			Title:    title,
+			Content:  content,
+			ImageURL: imageURL,
+			Deeplink: "/products/" + product.Slug,
+			Slug:     fmt.Sprintf("%s-%d-%d", strings.ReplaceAll(event.Type, "_", "-"), event.ID, user.ID),
+			UserID:   sql.NullInt64{Int64: user.ID, Valid: true},
+		}
+
+		err = app.gorm.ProductAlerts.Deliver(event, inbox)
+		if err != nil {
+			app.logger.PrintError(err, properties)
+			continue
+		}
+
+		data := map[string]interface{}{
+			"name":    user.Name,
+			"title":   title,
+			"content": content,
+		}
+
+		err = app.mailer.Send(user.Email, title, "product_alert.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{
+				"user_id": fmt.Sprint(user.ID),
+			})
+		}
+	}
+
+	return event.Type == data.ProductAlertBackInStock && held > 0
+}
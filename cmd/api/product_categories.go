@@ -17,15 +17,17 @@ import (
 // ====================================================================================
 
 func (app *application) listProductCategoriesHandler(w http.ResponseWriter, r *http.Request) {
-	var pagination data.Pagination
-
 	v := validator.New()
 	qs := r.URL.Query()
 
-	pagination.Page = app.readInt(qs, "page", 1, v)
-	pagination.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters := app.readFilters(qs, "id", data.ProductCategorySortSafeList, data.ProductCategoryFilterSafeList, v)
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 
-	productCategories, metadata, err := app.gorm.ProductCategories.GetAll(pagination)
+	productCategories, metadata, err := app.gorm.ProductCategories.GetAll(filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -121,6 +123,8 @@ func (app *application) createProductCategoryHandler(w http.ResponseWriter, r *h
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/product_categories/%d", productCategory.ID))
 
+	app.invalidateCatalogueCache("GET_PRODUCT_CATEGORIES_API")
+
 	err = app.writeJSON(w, http.StatusCreated, http.StatusText(http.StatusCreated), productCategory, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -203,6 +207,8 @@ func (app *application) updateProductCategoryHandler(w http.ResponseWriter, r *h
 		return
 	}
 
+	app.invalidateCatalogueCache("GET_PRODUCT_CATEGORIES_API")
+
 	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), productCategory, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -227,6 +233,8 @@ func (app *application) deleteProductCategoryHandler(w http.ResponseWriter, r *h
 		return
 	}
 
+	app.invalidateCatalogueCache("GET_PRODUCT_CATEGORIES_API")
+
 	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), "product category successfully deleted", nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kervinch/internal/data"
+	"github.com/kervinch/internal/spreadsheet"
+	"github.com/kervinch/internal/validator"
+)
+
+const (
+	maxImportFileSize = 10 << 20
+	maxImportRows     = 5000
+
+	// importProgressInterval is how many products are applied between progress updates.
+	importProgressInterval = 10
+)
+
+// importColumns are the columns an import file must have. The remaining columns are
+// optional: minimum_order defaults to 1, condition to new and is_active to true.
+var importColumns = []string{"name", "description", "category", "brand", "weight", "sku", "price", "stock"}
+
+func (app *application) importProductsHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportFileSize)
+
+	err := r.ParseMultipartForm(data.DefaultMaxMemory)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		app.fileNotFoundResponse(w, r, "file")
+		return
+	}
+	defer file.Close()
+
+	dryRun := r.FormValue("dry_run") == "true"
+
+	v := validator.New()
+
+	format := spreadsheet.FormatFromFilename(header.Filename)
+	if v.Check(format != "", "file", "must be a csv or xlsx file"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// The header row is read on top of the products themselves.
+	records, err := spreadsheet.Read(file, header.Size, format, maxImportRows+1)
+	if err != nil {
+		switch {
+		case errors.Is(err, spreadsheet.ErrTooManyRows):
+			v.AddError("file", fmt.Sprintf("must not have more than %d products and variants", maxImportRows))
+		default:
+			v.AddError("file", "could not be read as "+format)
+		}
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	rows, missing := app.readProductImportRows(records)
+	v.Check(len(missing) == 0, "file", "is missing the columns "+strings.Join(missing, ", "))
+	v.Check(len(missing) > 0 || len(rows) > 0, "file", "must contain at least one product")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	items, errs, err := app.gorm.ProductImports.Plan(rows)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	variants := 0
+	for _, item := range items {
+		variants += len(item.Variants)
+	}
+
+	report := envelope{
+		"rows":     len(rows),
+		"products": len(items),
+		"variants": variants,
+		"errors":   errs,
+	}
+
+	if errs == nil {
+		report["errors"] = data.ProductImportErrors{}
+	}
+
+	if dryRun {
+		err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), report, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Nothing is imported unless the whole file is valid, so a file can be fixed and
+	// uploaded again without creating duplicates.
+	if len(errs) > 0 {
+		err = app.writeJSON(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity), report, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	productImport := &data.ProductImport{
+		Filename:      header.Filename,
+		Status:        data.ProductImportPending,
+		TotalProducts: len(items),
+		CreatedBy:     app.contextGetUser(r).ID,
+	}
+
+	err = app.gorm.ProductImports.Insert(productImport)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	job := *productImport
+
+	app.background(func() {
+		app.runProductImport(&job, items)
+	})
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/cms/product-imports/%d", productImport.ID))
+
+	err = app.writeJSON(w, http.StatusAccepted, http.StatusText(http.StatusAccepted), productImport, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showProductImportHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	productImport, err := app.gorm.ProductImports.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), productImport, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readProductImportRows parses the cells of an import file, the first row being the
+// header. Header names are matched case-insensitively so hand-made files work too. Blank
+// rows are skipped but still counted, so row numbers match the spreadsheet.
+func (app *application) readProductImportRows(records [][]string) ([]*data.ProductImportRow, []string) {
+	if len(records) == 0 {
+		return nil, importColumns
+	}
+
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		name = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), " ", "_")
+		if _, ok := columns[name]; !ok {
+			columns[name] = i
+		}
+	}
+
+	var missing []string
+	for _, name := range importColumns {
+		if _, ok := columns[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+
+	if len(missing) > 0 {
+		return nil, missing
+	}
+
+	var rows []*data.ProductImportRow
+
+	for i, record := range records[1:] {
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+
+		row := &data.ProductImportRow{Row: i + 2, Errors: make(map[string]string)}
+
+		cell := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		integer := func(name string, fallback int) int {
+			if cell(name) == "" {
+				return fallback
+			}
+			n, err := strconv.Atoi(cell(name))
+			if err != nil {
+				row.Errors[name] = "must be an integer"
+			}
+			return n
+		}
+
+		boolean := func(name string, fallback bool) bool {
+			if cell(name) == "" {
+				return fallback
+			}
+			b, err := strconv.ParseBool(strings.ToLower(cell(name)))
+			if err != nil {
+				row.Errors[name] = "must be true or false"
+			}
+			return b
+		}
+
+		condition := cell("condition")
+		if condition == "" {
+			condition = "new"
+		}
+
+		isActive := boolean("is_active", true)
+
+		row.Product = data.Product{
+			Name:              cell("name"),
+			Description:       cell("description"),
+			Weight:            integer("weight", 0),
+			MinimumOrder:      integer("minimum_order", 1),
+			PreorderDays:      integer("preorder_days", 0),
+			Condition:         strings.ToLower(condition),
+			Slug:              app.slugify(cell("name")),
+			InsuranceRequired: boolean("insurance_required", false),
+			IsGiftCard:        boolean("is_gift_card", false),
+			IsActive:          isActive,
+		}
+
+		row.ProductDetail = data.ProductDetail{
+			Color:    cell("color"),
+			Size:     cell("size"),
+			Price:    int64(integer("price", 0)),
+			SKU:      cell("sku"),
+			Stock:    integer("stock", 0),
+			IsActive: isActive,
+		}
+
+		row.CategorySlug = cell("category")
+		row.BrandSlug = cell("brand")
+		row.StorefrontSlugs = splitImportList(cell("storefronts"))
+		row.ImageURLs = splitImportList(cell("image_urls"))
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// splitImportList splits a comma separated cell, dropping blanks and repeated values.
+func splitImportList(s string) []string {
+	var values []string
+
+	seen := make(map[string]bool)
+
+	for _, value := range strings.Split(s, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" || seen[value] {
+			continue
+		}
+		seen[value] = true
+		values = append(values, value)
+	}
+
+	return values
+}
+
+// runProductImport creates the planned products one transaction at a time, so a product
+// that fails, e.g. because its name was taken since the file was checked, does not undo
+// the others. Progress is saved periodically for the CMS to poll.
+func (app *application) runProductImport(productImport *data.ProductImport, items []*data.ProductImportItem) {
+	properties := map[string]string{
+		"product_import_id": fmt.Sprint(productImport.ID),
+	}
+
+	productImport.Status = data.ProductImportProcessing
+
+	err := app.gorm.ProductImports.UpdateProgress(productImport)
+	if err != nil {
+		app.logger.PrintError(err, properties)
+	}
+
+	for i, item := range items {
+		variants, err := app.applyProductImportItem(item)
+		if err != nil {
+			message := "could not be created"
+			if errors.Is(err, data.ErrDuplicateSlug) {
+				message = "a product with this name already exists"
+			} else {
+				app.logger.PrintError(err, properties)
+			}
+
+			productImport.Errors = append(productImport.Errors, data.ProductImportError{
+				Row:    item.Row,
+				Errors: map[string]string{"name": message},
+			})
+		} else {
+			productImport.CreatedProducts++
+			productImport.CreatedVariants += variants
+		}
+
+		productImport.ProcessedProducts = i + 1
+
+		if productImport.ProcessedProducts%importProgressInterval == 0 {
+			err = app.gorm.ProductImports.UpdateProgress(productImport)
+			if err != nil {
+				app.logger.PrintError(err, properties)
+			}
+		}
+	}
+
+	finishedAt := time.Now()
+	productImport.FinishedAt = &finishedAt
+
+	productImport.Status = data.ProductImportCompleted
+	if productImport.CreatedProducts == 0 {
+		productImport.Status = data.ProductImportFailed
+	}
+
+	err = app.gorm.ProductImports.UpdateProgress(productImport)
+	if err != nil {
+		app.logger.PrintError(err, properties)
+	}
+
+	app.invalidateCatalogueCache()
+}
+
+// applyProductImportItem creates a product with its storefronts, variants and images, and
+// returns the number of variants created.
+func (app *application) applyProductImportItem(item *data.ProductImportItem) (int, error) {
+	tx := app.gorm.Transaction.DB.Begin()
+
+	product := item.Product
+
+	productID, err := app.gorm.Products.InsertWithTx(&product, tx)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	err = app.gorm.ProductStorefrontSubscriptions.InsertWithTx(productID, item.StorefrontIDs, tx)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	for _, variant := range item.Variants {
+		productDetail := variant.ProductDetail
+		productDetail.ProductID = productID
+
+		productDetailID, err := app.gorm.ProductDetails.InsertWithTx(&productDetail, tx)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+
+		for i, imageURL := range variant.ImageURLs {
+			err = app.gorm.ProductImages.InsertWithTx(&data.ProductImage{
+				ProductDetailID: productDetailID,
+				ImageURL:        imageURL,
+				IsMain:          i == 0,
+			}, tx)
+			if err != nil {
+				tx.Rollback()
+				return 0, err
+			}
+		}
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return 0, err
+	}
+
+	return len(item.Variants), nil
+}
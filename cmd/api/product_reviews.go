@@ -0,0 +1,309 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kervinch/internal/data"
+	"github.com/kervinch/internal/s3"
+	"github.com/kervinch/internal/validator"
+)
+
+// maxReviewUploadSize leaves room for the review itself on top of its photos.
+const maxReviewUploadSize = data.MaxReviewPhotos*(5<<20) + (1 << 20)
+
+// ====================================================================================
+// Backoffice Handlers
+// ====================================================================================
+
+func (app *application) listProductReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters := app.readFilters(qs, "-created_at", data.ProductReviewSortSafeList, data.ProductReviewFilterSafeList, v)
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	reviews, metadata, err := app.gorm.ProductReviews.GetAll(filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSONWithMeta(w, http.StatusOK, http.StatusText(http.StatusOK), reviews, nil, metadata)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showProductReviewHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	review, err := app.gorm.ProductReviews.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), review, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// moderateProductReviewHandler hides, flags or replies to a review. Fields left out of the
+// request keep their current value, an empty reply removes the reply.
+func (app *application) moderateProductReviewHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	review, err := app.gorm.ProductReviews.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		IsHidden   *bool   `json:"is_hidden"`
+		IsFlagged  *bool   `json:"is_flagged"`
+		FlagReason *string `json:"flag_reason"`
+		Reply      *string `json:"reply"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.IsHidden != nil {
+		review.IsHidden = *input.IsHidden
+	}
+
+	if input.IsFlagged != nil {
+		review.IsFlagged = *input.IsFlagged
+		if !review.IsFlagged {
+			review.FlagReason = ""
+		}
+	}
+
+	if input.FlagReason != nil {
+		review.FlagReason = *input.FlagReason
+	}
+
+	if input.Reply != nil && *input.Reply != review.Reply {
+		review.Reply = *input.Reply
+		review.RepliedAt = nil
+
+		if review.Reply != "" {
+			repliedAt := time.Now()
+			review.RepliedAt = &repliedAt
+		}
+	}
+
+	v := validator.New()
+
+	if data.ValidateProductReviewModeration(v, review); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.gorm.ProductReviews.Moderate(review)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.invalidateCatalogueCache()
+
+	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), review, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// ====================================================================================
+// Business Handlers
+// ====================================================================================
+
+func (app *application) getProductReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	slug := app.readSlugParam(r)
+
+	var pagination data.Pagination
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	pagination.Page = app.readInt(qs, "page", 1, v)
+	pagination.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	product, err := app.gorm.Products.GetBySlug(slug)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	reviews, metadata, err := app.gorm.ProductReviews.GetAllForProduct(product.ID, pagination)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSONWithMeta(w, http.StatusOK, http.StatusText(http.StatusOK), reviews, nil, metadata)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getUserProductReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var pagination data.Pagination
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	pagination.Page = app.readInt(qs, "page", 1, v)
+	pagination.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	reviews, metadata, err := app.gorm.ProductReviews.GetAllForUser(user, pagination)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSONWithMeta(w, http.StatusOK, http.StatusText(http.StatusOK), reviews, nil, metadata)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createProductReviewHandler reviews a line of a completed order. The request is a
+// multipart form with invoice_detail_id, rating, content and up to three photos.
+func (app *application) createProductReviewHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxReviewUploadSize)
+
+	err := r.ParseMultipartForm(data.DefaultMaxMemory)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	photos := r.MultipartForm.File["photos"]
+
+	review := &data.ProductReview{
+		InvoiceDetailID: int64(app.readInt(r.PostForm, "invoice_detail_id", 0, v)),
+		UserID:          user.ID,
+		Rating:          app.readInt(r.PostForm, "rating", 0, v),
+		Content:         r.PostForm.Get("content"),
+		Photos:          make([]string, len(photos)),
+	}
+
+	if data.ValidateProductReview(v, review); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	invoiceDetail, err := app.gorm.ProductReviews.GetReviewable(review.InvoiceDetailID, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("invoice_detail_id", "does not match any of your orders")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The status is kept on the order, the one of its details is not updated past checkout.
+	if v.Check(invoiceDetail.OrderDetail.Order.Status == "completed", "invoice_detail_id", "must belong to a completed order"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	review.ProductID = invoiceDetail.ProductDetail.ProductID
+	review.ProductDetailID = invoiceDetail.ProductDetailID
+
+	for i, handler := range photos {
+		file, err := handler.Open()
+		if err != nil {
+			app.fileNotFoundResponse(w, r, "photos")
+			return
+		}
+
+		// Photos get a unique name, as uploads from different users would otherwise
+		// overwrite each other.
+		filename := fmt.Sprintf("%d-%d-%s", review.InvoiceDetailID, time.Now().UnixNano(), handler.Filename)
+
+		url, err := app.s3.Upload(file, s3.PRODUCT_REVIEW, filename, handler.Header.Get("Content-Type"))
+		file.Close()
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrImageFormat):
+				v.AddError("photos", "must be jpeg or png images")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		review.Photos[i] = url
+	}
+
+	err = app.gorm.ProductReviews.Insert(review)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateKeyValue):
+			v.AddError("invoice_detail_id", "has already been reviewed")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.invalidateCatalogueCache()
+
+	err = app.writeJSON(w, http.StatusCreated, http.StatusText(http.StatusCreated), review, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
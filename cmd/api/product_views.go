@@ -0,0 +1,136 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kervinch/internal/data"
+	"github.com/kervinch/internal/validator"
+)
+
+// trendingWindow is how far back views count towards the trending products.
+const trendingWindow = 48 * time.Hour
+
+// ====================================================================================
+// Business Handlers
+// ====================================================================================
+
+// getRecentlyViewedProductsHandler returns the products viewed by the user, or by the
+// device sending X-Device-ID when signed out.
+func (app *application) getRecentlyViewedProductsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	limit := app.readInt(qs, "limit", 20, v)
+
+	if v.Check(limit > 0 && limit <= data.MaxRecentlyViewed, "limit", fmt.Sprintf("must be between 1 and %d", data.MaxRecentlyViewed)); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var userID int64
+
+	user := app.contextGetUser(r)
+	if !user.IsAnonymous() {
+		userID = user.ID
+	}
+
+	deviceID := app.readDeviceID(r)
+
+	products := []*data.Product{}
+
+	if userID > 0 || deviceID != "" {
+		var err error
+
+		products, err = app.gorm.ProductViews.GetRecentlyViewed(userID, deviceID, limit)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err := app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), products, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getTrendingProductsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	limit := app.readInt(qs, "limit", 10, v)
+
+	if v.Check(limit > 0 && limit <= 50, "limit", "must be between 1 and 50"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	products, err := app.gorm.ProductViews.GetTrending(time.Now().Add(-trendingWindow), limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), products, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// recordProductView records a product page view in the background, so a slow write never
+// delays the page.
+func (app *application) recordProductView(r *http.Request, productID int64) {
+	view := &data.ProductView{
+		ProductID: productID,
+		DeviceID:  app.readDeviceID(r),
+	}
+
+	user := app.contextGetUser(r)
+	if !user.IsAnonymous() {
+		view.UserID = sql.NullInt64{Int64: user.ID, Valid: true}
+	}
+
+	app.background(func() {
+		err := app.gorm.ProductViews.Record(view)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+}
+
+// mergeRecentlyViewed moves the products viewed while signed out on the device making the
+// request to the account of the user signing in.
+func (app *application) mergeRecentlyViewed(r *http.Request, userID int64) {
+	deviceID := app.readDeviceID(r)
+	if deviceID == "" {
+		return
+	}
+
+	app.background(func() {
+		err := app.gorm.ProductViews.Merge(deviceID, userID)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{
+				"user_id": fmt.Sprint(userID),
+			})
+		}
+	})
+}
+
+// ====================================================================================
+// Jobs
+// ====================================================================================
+
+func (app *application) deleteExpiredProductViews() {
+	deleted, err := app.gorm.ProductViews.DeleteExpired()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	app.logger.PrintInfo("expired product views deleted", map[string]string{
+		"deleted": fmt.Sprint(deleted),
+	})
+}
@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/kervinch/internal/data"
 	"github.com/kervinch/internal/s3"
+	"github.com/kervinch/internal/spreadsheet"
 	"github.com/kervinch/internal/validator"
 	"golang.org/x/exp/slices"
 )
@@ -17,15 +19,17 @@ import (
 // ====================================================================================
 
 func (app *application) listProductsHandler(w http.ResponseWriter, r *http.Request) {
-	var pagination data.Pagination
-
 	v := validator.New()
 	qs := r.URL.Query()
 
-	pagination.Page = app.readInt(qs, "page", 1, v)
-	pagination.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters := app.readFilters(qs, "id", data.ProductSortSafeList, data.ProductFilterSafeList, v)
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 
-	products, metadata, err := app.gorm.Products.GetAll(pagination)
+	products, metadata, err := app.gorm.Products.GetAll(filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -37,6 +41,27 @@ func (app *application) listProductsHandler(w http.ResponseWriter, r *http.Reque
 	}
 }
 
+func (app *application) exportProductsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters := app.readFilters(qs, "id", data.ProductSortSafeList, data.ProductFilterSafeList, v)
+	format := app.readStrings(qs, "format", spreadsheet.FormatCSV)
+
+	v.Check(validator.In(format, spreadsheet.FormatCSV, spreadsheet.FormatXLSX), "format", "must be csv or xlsx")
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	app.streamExport(w, r, "products", format, data.ProductExportHeader, func(write func(row []interface{}) error) error {
+		return app.gorm.Products.Export(filters, func(row *data.ProductExportRow) error {
+			return write(row.Values())
+		})
+	})
+}
+
 func (app *application) showProductHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
@@ -115,11 +140,15 @@ func (app *application) createProductHandler(w http.ResponseWriter, r *http.Requ
 		Condition:         r.FormValue("condition"),
 		Slug:              app.slugify(r.FormValue("name")),
 		InsuranceRequired: r.FormValue("insurance_required") == "true",
+		IsGiftCard:        r.FormValue("is_gift_card") == "true",
 		IsActive:          r.FormValue("is_active") == "true",
 	}
 
 	v := validator.New()
 
+	product.PublishAt = app.readTime(r.Form, "publish_at", v)
+	product.UnpublishAt = app.readTime(r.Form, "unpublish_at", v)
+
 	if data.ValidateProduct(v, product); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -254,6 +283,9 @@ func (app *application) createProductHandler(w http.ResponseWriter, r *http.Requ
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/products/%d", product.ID))
 
+	app.invalidateCatalogueCache()
+	app.reschedulePublishing()
+
 	err = app.writeJSON(w, http.StatusCreated, http.StatusText(http.StatusCreated), product, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -341,10 +373,14 @@ func (app *application) updateProductHandler(w http.ResponseWriter, r *http.Requ
 	product.Condition = r.FormValue("condition")
 	product.Slug = app.slugify(r.FormValue("name"))
 	product.InsuranceRequired = r.FormValue("insurance_required") == "true"
+	product.IsGiftCard = r.FormValue("is_gift_card") == "true"
 	product.IsActive = r.FormValue("is_active") == "true"
 
 	v := validator.New()
 
+	product.PublishAt = app.readTime(r.Form, "publish_at", v)
+	product.UnpublishAt = app.readTime(r.Form, "unpublish_at", v)
+
 	if data.ValidateProduct(v, product); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -434,6 +470,9 @@ func (app *application) updateProductHandler(w http.ResponseWriter, r *http.Requ
 
 	tx.Commit()
 
+	app.invalidateCatalogueCache()
+	app.reschedulePublishing()
+
 	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), "product successfully updated", nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -514,6 +553,8 @@ func (app *application) deleteProductHandler(w http.ResponseWriter, r *http.Requ
 
 	tx.Commit()
 
+	app.invalidateCatalogueCache()
+
 	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), "product successfully deleted", nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -574,11 +615,15 @@ func (app *application) createProductVariantsHandler(w http.ResponseWriter, r *h
 		Condition:         r.FormValue("condition"),
 		Slug:              app.slugify(r.FormValue("name")),
 		InsuranceRequired: r.FormValue("insurance_required") == "true",
+		IsGiftCard:        r.FormValue("is_gift_card") == "true",
 		IsActive:          r.FormValue("is_active") == "true",
 	}
 
 	v := validator.New()
 
+	product.PublishAt = app.readTime(r.Form, "publish_at", v)
+	product.UnpublishAt = app.readTime(r.Form, "unpublish_at", v)
+
 	if data.ValidateProduct(v, product); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -729,6 +774,9 @@ func (app *application) createProductVariantsHandler(w http.ResponseWriter, r *h
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/products/%d", product.ID))
 
+	app.invalidateCatalogueCache()
+	app.reschedulePublishing()
+
 	err = app.writeJSON(w, http.StatusCreated, http.StatusText(http.StatusCreated), product, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -805,10 +853,14 @@ func (app *application) updateProductVariantsHandler(w http.ResponseWriter, r *h
 	product.Condition = r.FormValue("condition")
 	product.Slug = app.slugify(r.FormValue("name"))
 	product.InsuranceRequired = r.FormValue("insurance_required") == "true"
+	product.IsGiftCard = r.FormValue("is_gift_card") == "true"
 	product.IsActive = r.FormValue("is_active") == "true"
 
 	v := validator.New()
 
+	product.PublishAt = app.readTime(r.Form, "publish_at", v)
+	product.UnpublishAt = app.readTime(r.Form, "unpublish_at", v)
+
 	if data.ValidateProduct(v, product); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -980,6 +1032,9 @@ func (app *application) updateProductVariantsHandler(w http.ResponseWriter, r *h
 
 	tx.Commit()
 
+	app.invalidateCatalogueCache()
+	app.reschedulePublishing()
+
 	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), "product variants successfully updated", nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -992,12 +1047,7 @@ func (app *application) updateProductVariantsHandler(w http.ResponseWriter, r *h
 
 func (app *application) getProductsHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Name         string
-		Size         string
-		MinimumPrice int
-		MaximumPrice int
-		CategoryID   []string
-		Color        []string
+		data.ProductSearch
 		data.Pagination
 		data.Sort
 	}
@@ -1007,22 +1057,77 @@ func (app *application) getProductsHandler(w http.ResponseWriter, r *http.Reques
 
 	input.Pagination.Page = app.readInt(qs, "page", 1, v)
 	input.Pagination.PageSize = app.readInt(qs, "page_size", 20, v)
-	input.Name = app.readStrings(qs, "name", "")
+	input.Pagination.After = app.readStrings(qs, "after", "")
+	input.Query = strings.TrimSpace(app.readStrings(qs, "name", ""))
 	input.Size = app.readStrings(qs, "size", "")
 	input.MinimumPrice = app.readInt(qs, "minimum_price", 0, v)
 	input.MaximumPrice = app.readInt(qs, "maximum_price", 100000000, v)
-	input.CategoryID = app.readCSV(qs, "categories", []string{})
-	input.Color = app.readCSV(qs, "colors", []string{})
-	input.Sort.List = app.readCSV(qs, "sort", []string{"id"})
-	input.Sort.SortSafeList = []string{"id", "price", "-id", "-price"}
+	input.CategoryIDs = app.readCSV(qs, "categories", []string{})
+	input.BrandIDs = app.readCSV(qs, "brands", []string{})
+	input.Colors = app.readCSV(qs, "colors", []string{})
+
+	// Searches are ordered by relevance unless the client asks for something else.
+	defaultSort := "id"
+	if input.Query != "" {
+		defaultSort = "-relevance"
+	}
+
+	input.Sort.List = app.readCSV(qs, "sort", []string{defaultSort})
+	input.Sort.SortSafeList = []string{"id", "price", "relevance", "-id", "-price", "-relevance"}
 
-	products, metadata, err := app.gorm.Products.GetAPI(input.Pagination, input.Name, input.Size, input.MinimumPrice, input.MaximumPrice, input.CategoryID, input.Sort)
+	if data.ValidatePagination(v, input.Pagination); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if data.ValidateSort(v, input.Sort); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// A cursor stores the position for one sort key, so it cannot follow a combined sort.
+	if input.Pagination.After != "" {
+		v.Check(len(input.Sort.List) == 1, "sort", "must be a single value when paginating with a cursor")
+
+		if data.ValidateCursor(v, input.Pagination.After, input.Sort.List[0]); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+
+	products, metadata, err := app.gorm.Products.GetAPI(input.Pagination, input.ProductSearch, input.Sort)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSONWithMeta(w, http.StatusOK, http.StatusText(http.StatusOK), products, nil, metadata)
+	facets, err := app.gorm.Products.GetAPIFacets(input.ProductSearch)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	productMetadata := data.ProductMetadata{Metadata: metadata, Facets: facets}
+
+	// Later cursor pages are not counted, so only the first page can tell that a search
+	// found nothing, and only it is logged as a search.
+	if metadata.TotalRecords == 0 && input.Query != "" && input.Pagination.After == "" {
+		productMetadata.DidYouMean, err = app.gorm.Search.DidYouMean(input.Query, 3)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if input.Query != "" && input.Pagination.After == "" {
+		productMetadata.SearchID, err = app.logSearchEvent(r, input.ProductSearch, input.Sort, metadata.TotalRecords)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSONWithMeta(w, http.StatusOK, http.StatusText(http.StatusOK), products, nil, productMetadata)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -1041,13 +1146,64 @@ func (app *application) getProductsLatestHandler(w http.ResponseWriter, r *http.
 	}
 }
 
+// getProductsRecommendationHandler serves the precomputed recommendations: personal ones
+// by default, or the products bought together with or similar to ?product=slug. Anonymous
+// users, and requests without any recommendation yet, get the popular products instead.
 func (app *application) getProductsRecommendationHandler(w http.ResponseWriter, r *http.Request) {
-	products, err := app.gorm.Products.GetRecommendation()
+	v := validator.New()
+	qs := r.URL.Query()
+
+	kind := app.readStrings(qs, "type", data.RecommendationPersonal)
+	slug := app.readStrings(qs, "product", "")
+	limit := app.readInt(qs, "limit", 10, v)
+
+	v.Check(validator.In(kind, data.RecommendationPersonal, data.RecommendationBoughtTogether, data.RecommendationSimilar), "type", "must be personal, bought_together or similar")
+	v.Check(kind == data.RecommendationPersonal || slug != "", "product", "must be provided")
+	v.Check(limit > 0 && limit <= 20, "limit", "must be between 1 and 20")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var products []*data.Product
+	var err error
+
+	switch kind {
+	case data.RecommendationPersonal:
+		user := app.contextGetUser(r)
+		if !user.IsAnonymous() {
+			products, err = app.gorm.Recommendations.GetForUser(user.ID, limit)
+		}
+	default:
+		var product *data.Product
+
+		product, err = app.gorm.Products.GetBySlug(slug)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		products, err = app.gorm.Recommendations.GetForProduct(product.ID, kind, limit)
+	}
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	if len(products) == 0 {
+		products, err = app.gorm.Recommendations.GetPopular(limit)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
 	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), products, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -1068,6 +1224,8 @@ func (app *application) getProductBySlugHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	app.recordProductView(r, products.ID)
+
 	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), products, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
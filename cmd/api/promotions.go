@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/kervinch/internal/data"
+	"github.com/kervinch/internal/validator"
+)
+
+// promotionInput is the request body of a promotion.
+type promotionInput struct {
+	Name              string     `json:"name"`
+	Type              string     `json:"type"`
+	BrandID           *int64     `json:"brand_id"`
+	ProductCategoryID *int64     `json:"product_category_id"`
+	SKUs              []string   `json:"skus"`
+	MinQuantity       int        `json:"min_quantity"`
+	FreeQuantity      int        `json:"free_quantity"`
+	DiscountPercent   int        `json:"discount_percent"`
+	BundlePrice       int64      `json:"bundle_price"`
+	Priority          int        `json:"priority"`
+	StartsAt          *time.Time `json:"starts_at"`
+	EndsAt            *time.Time `json:"ends_at"`
+	IsActive          *bool      `json:"is_active"`
+}
+
+func (input *promotionInput) apply(promotion *data.Promotion) {
+	promotion.Name = input.Name
+	promotion.Type = input.Type
+	promotion.BrandID = input.BrandID
+	promotion.ProductCategoryID = input.ProductCategoryID
+	promotion.SKUs = input.SKUs
+	promotion.MinQuantity = input.MinQuantity
+	promotion.FreeQuantity = input.FreeQuantity
+	promotion.DiscountPercent = input.DiscountPercent
+	promotion.BundlePrice = input.BundlePrice
+	promotion.Priority = input.Priority
+	promotion.StartsAt = input.StartsAt
+	promotion.EndsAt = input.EndsAt
+
+	if promotion.SKUs == nil {
+		promotion.SKUs = []string{}
+	}
+
+	if input.IsActive != nil {
+		promotion.IsActive = *input.IsActive
+	}
+}
+
+// ====================================================================================
+// Backoffice Handlers
+// ====================================================================================
+
+func (app *application) listPromotionsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters := app.readFilters(qs, "-priority", data.PromotionSortSafeList, data.PromotionFilterSafeList, v)
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	promotions, metadata, err := app.gorm.Promotions.GetAll(filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSONWithMeta(w, http.StatusOK, http.StatusText(http.StatusOK), promotions, nil, metadata)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showPromotionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	promotion, err := app.gorm.Promotions.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), promotion, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createPromotionHandler(w http.ResponseWriter, r *http.Request) {
+	var input promotionInput
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	promotion := &data.Promotion{
+		IsActive:  true,
+		CreatedBy: user.ID,
+		UpdatedBy: user.ID,
+	}
+
+	input.apply(promotion)
+
+	v := validator.New()
+
+	if data.ValidatePromotion(v, promotion); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.gorm.Promotions.Insert(promotion)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, http.StatusText(http.StatusCreated), promotion, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updatePromotionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	promotion, err := app.gorm.Promotions.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input promotionInput
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	input.apply(promotion)
+	promotion.UpdatedBy = app.contextGetUser(r).ID
+
+	v := validator.New()
+
+	if data.ValidatePromotion(v, promotion); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.gorm.Promotions.Update(promotion)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), promotion, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deletePromotionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.gorm.Promotions.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), "promotion successfully deleted", nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"time"
+)
+
+// publishingRecheckInterval bounds how long the publishing scheduler sleeps, so windows
+// changed outside the CMS, by an import or straight in the database, are still honoured.
+const publishingRecheckInterval = time.Hour
+
+// reschedulePublishing wakes the publishing scheduler after a publishing window was
+// edited, so it looks up the next transition again.
+func (app *application) reschedulePublishing() {
+	select {
+	case app.publishing <- struct{}{}:
+	default:
+	}
+}
+
+// schedulePublishing invalidates the cached catalogue responses at the moment a banner,
+// storefront or product enters or leaves its publishing window, or a campaign starts or
+// ends. The cache lives for hours, so without it a scheduled banner would only appear on
+// the next edit.
+func (app *application) schedulePublishing() {
+	go func() {
+		after := time.Now()
+
+		for {
+			wait := publishingRecheckInterval
+
+			next, err := app.gorm.Publishing.NextTransition(after)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+
+			if next != nil && time.Until(*next) < wait {
+				wait = time.Until(*next)
+			}
+
+			timer := time.NewTimer(wait)
+
+			select {
+			case <-timer.C:
+				if next == nil || next.After(time.Now()) {
+					continue
+				}
+
+				app.invalidateCatalogueCache("GET_BANNERS_API")
+				after = *next
+
+				app.logger.PrintInfo("catalogue cache invalidated on schedule", map[string]string{
+					"transition": next.Format(time.RFC3339),
+				})
+			case <-app.publishing:
+				timer.Stop()
+			}
+		}
+	}()
+}
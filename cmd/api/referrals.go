@@ -0,0 +1,178 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/kervinch/internal/data"
+	"github.com/kervinch/internal/validator"
+)
+
+// ====================================================================================
+// Backoffice Handlers
+// ====================================================================================
+
+func (app *application) listReferralsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters := app.readFilters(qs, "-id", data.ReferralSortSafeList, data.ReferralFilterSafeList, v)
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	referrals, metadata, err := app.gorm.Referrals.GetAll(filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSONWithMeta(w, http.StatusOK, http.StatusText(http.StatusOK), referrals, nil, metadata)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getReferralReportHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	days := app.readInt(qs, "days", 30, v)
+	limit := app.readInt(qs, "limit", 20, v)
+
+	v.Check(days > 0 && days <= 365, "days", "must be between 1 and 365")
+	v.Check(limit > 0 && limit <= 100, "limit", "must be between 1 and 100")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	report, err := app.gorm.Referrals.GetReport(days, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), report, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showReferralSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	settings, err := app.gorm.Referrals.GetSettings()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), settings, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateReferralSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		IsActive          bool   `json:"is_active"`
+		ReferrerVoucherID *int64 `json:"referrer_voucher_id"`
+		ReferrerCredit    int64  `json:"referrer_credit"`
+		RefereeVoucherID  *int64 `json:"referee_voucher_id"`
+		RefereeCredit     int64  `json:"referee_credit"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	settings := &data.ReferralSettings{
+		IsActive:       input.IsActive,
+		ReferrerCredit: input.ReferrerCredit,
+		RefereeCredit:  input.RefereeCredit,
+		UpdatedBy:      sql.NullInt64{Int64: app.contextGetUser(r).ID, Valid: true},
+	}
+
+	v := validator.New()
+
+	for key, id := range map[string]*int64{"referrer_voucher_id": input.ReferrerVoucherID, "referee_voucher_id": input.RefereeVoucherID} {
+		if id == nil {
+			continue
+		}
+
+		_, err := app.gorm.Vouchers.Get(*id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError(key, "does not match any voucher")
+			default:
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+	}
+
+	if input.ReferrerVoucherID != nil {
+		settings.ReferrerVoucherID = sql.NullInt64{Int64: *input.ReferrerVoucherID, Valid: true}
+	}
+
+	if input.RefereeVoucherID != nil {
+		settings.RefereeVoucherID = sql.NullInt64{Int64: *input.RefereeVoucherID, Valid: true}
+	}
+
+	if data.ValidateReferralSettings(v, settings); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.gorm.Referrals.UpdateSettings(settings)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.showReferralSettingsHandler(w, r)
+}
+
+// ====================================================================================
+// Business Handlers
+// ====================================================================================
+
+func (app *application) getReferralsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var pagination data.Pagination
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	pagination.Page = app.readInt(qs, "page", 1, v)
+	pagination.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	if data.ValidatePagination(v, pagination); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	code, err := app.gorm.Referrals.GetCode(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	referrals, metadata, err := app.gorm.Referrals.GetAllForUser(user.ID, pagination)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSONWithMeta(w, http.StatusOK, http.StatusText(http.StatusOK), envelope{"code": code, "referrals": referrals}, nil, metadata)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -24,11 +24,19 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodGet, "/api/users/activated", app.activateUserHandler)
 	router.HandlerFunc(http.MethodPut, "/api/users/password", app.updateUserPasswordHandler)
 	router.HandlerFunc(http.MethodGet, "/api/users", app.requireAuthenticatedUser(app.getUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/api/users", app.requireAuthenticatedUser(app.deleteUserHandler))
+	router.HandlerFunc(http.MethodGet, "/api/users/export", app.requireAuthenticatedUser(app.exportUserDataHandler))
+	router.HandlerFunc(http.MethodPut, "/api/users/deletion/cancel", app.requireAuthenticatedUser(app.cancelUserDeletionHandler))
 
 	router.HandlerFunc(http.MethodPut, "/api/users/update/name", app.requireAuthenticatedUser(app.updateUserNameHandler))
 	router.HandlerFunc(http.MethodPut, "/api/users/update/gender", app.requireAuthenticatedUser(app.updateUserGenderHandler))
 	router.HandlerFunc(http.MethodPut, "/api/users/update/date-of-birth", app.requireAuthenticatedUser(app.updateUserDateOfBirthHandler))
 	router.HandlerFunc(http.MethodPut, "/api/users/update/phone-number", app.requireAuthenticatedUser(app.updateUserPhoneNumberHandler))
+	router.HandlerFunc(http.MethodPut, "/api/users/update/email", app.requireAuthenticatedUser(app.updateUserEmailHandler))
+	router.HandlerFunc(http.MethodGet, "/api/users/email/confirmed", app.confirmUserEmailHandler)
+	router.HandlerFunc(http.MethodGet, "/api/users/email/reverted", app.revertUserEmailHandler)
+	router.HandlerFunc(http.MethodPost, "/api/users/phone-number/otp", app.requireAuthenticatedUser(app.createPhoneOTPHandler))
+	router.HandlerFunc(http.MethodPut, "/api/users/phone-number/verify", app.requireAuthenticatedUser(app.verifyUserPhoneNumberHandler))
 
 	router.HandlerFunc(http.MethodGet, "/api/user-addresses", app.requireAuthenticatedUser(app.getUserAddressesHandler))
 	router.HandlerFunc(http.MethodPost, "/api/user-addresses", app.requireAuthenticatedUser(app.createUserAddressHandler))
@@ -38,6 +46,8 @@ func (app *application) routes() http.Handler {
 
 	// Tokens
 	router.HandlerFunc(http.MethodPost, "/api/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/api/tokens/oauth", app.createOAuthAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/api/tokens/two-factor", app.createTwoFactorAuthenticationTokenHandler)
 	router.HandlerFunc(http.MethodPost, "/api/tokens/activation", app.createActivationTokenHandler)
 	router.HandlerFunc(http.MethodPost, "/api/tokens/password-reset", app.createPasswordResetTokenHandler)
 
@@ -61,6 +71,7 @@ func (app *application) routes() http.Handler {
 
 	// Carts
 	router.HandlerFunc(http.MethodGet, "/api/carts", app.requireAuthenticatedUser(app.getCartsHandler))
+	router.HandlerFunc(http.MethodGet, "/api/carts/promotions", app.requireAuthenticatedUser(app.getCartPromotionsHandler))
 	router.HandlerFunc(http.MethodPost, "/api/carts", app.requireAuthenticatedUser(app.createCartHandler))
 	router.HandlerFunc(http.MethodPut, "/api/carts/:id", app.requireAuthenticatedUser(app.updateCartHandler))
 	router.HandlerFunc(http.MethodDelete, "/api/carts/:id", app.requireAuthenticatedUser(app.deleteCartHandler))
@@ -68,16 +79,34 @@ func (app *application) routes() http.Handler {
 	// Favorites
 	router.HandlerFunc(http.MethodGet, "/api/favorites", app.requireAuthenticatedUser(app.getFavoritesHandler))
 	router.HandlerFunc(http.MethodPost, "/api/favorites", app.requireAuthenticatedUser(app.createFavoriteHandler))
+	router.HandlerFunc(http.MethodPut, "/api/favorites/:id", app.requireAuthenticatedUser(app.moveFavoriteHandler))
 	router.HandlerFunc(http.MethodDelete, "/api/favorites/:id", app.requireAuthenticatedUser(app.deleteFavoriteHandler))
+	router.HandlerFunc(http.MethodPost, "/api/favorites/:id/cart", app.requireAuthenticatedUser(app.moveFavoriteToCartHandler))
+
+	// Wishlists
+	router.HandlerFunc(http.MethodGet, "/api/wishlists", app.requireAuthenticatedUser(app.getWishlistsHandler))
+	router.HandlerFunc(http.MethodGet, "/api/wishlists/:id", app.requireAuthenticatedUser(app.showWishlistHandler))
+	router.HandlerFunc(http.MethodPost, "/api/wishlists", app.requireAuthenticatedUser(app.createWishlistHandler))
+	router.HandlerFunc(http.MethodPut, "/api/wishlists/:id", app.requireAuthenticatedUser(app.updateWishlistHandler))
+	router.HandlerFunc(http.MethodDelete, "/api/wishlists/:id", app.requireAuthenticatedUser(app.deleteWishlistHandler))
+	router.HandlerFunc(http.MethodGet, "/api/shared-wishlists/:slug", app.showSharedWishlistHandler)
+
+	// Back in stock subscriptions
+	router.HandlerFunc(http.MethodGet, "/api/back-in-stock-subscriptions", app.requireAuthenticatedUser(app.getBackInStockSubscriptionsHandler))
+	router.HandlerFunc(http.MethodPost, "/api/back-in-stock-subscriptions", app.requireAuthenticatedUser(app.createBackInStockSubscriptionHandler))
+	router.HandlerFunc(http.MethodDelete, "/api/back-in-stock-subscriptions/:id", app.requireAuthenticatedUser(app.deleteBackInStockSubscriptionHandler))
 
 	// Inbox
 	router.HandlerFunc(http.MethodGet, "/api/inbox", app.requireAuthenticatedUser(app.getInboxHandler))
 	router.HandlerFunc(http.MethodGet, "/api/inbox/:slug", app.requireAuthenticatedUser(app.getInboxBySlugHandler))
 
+	// Loyalty
+	router.HandlerFunc(http.MethodGet, "/api/loyalty", app.requireAuthenticatedUser(app.getLoyaltyHandler))
+
 	// Orders
 	router.HandlerFunc(http.MethodGet, "/api/orders", app.requireAuthenticatedUser(app.getOrdersHandler))
 	router.HandlerFunc(http.MethodPost, "/api/orders", app.requireAuthenticatedUser(app.createOrdersHandler))
-	router.HandlerFunc(http.MethodPut, "/api/orders/:id", app.requireAuthenticatedUser(app.updateOrdersHandler))
+	router.HandlerFunc(http.MethodPut, "/api/orders/:id", app.requireAuthenticatedUser(app.updateUserOrderHandler))
 
 	// Order Refunds
 	router.HandlerFunc(http.MethodPost, "/api/order-refunds", app.requireAuthenticatedUser(app.createOrderRefundHandler))
@@ -87,21 +116,43 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodGet, "/api/products", app.getProductsHandler)
 	router.HandlerFunc(http.MethodGet, "/api/products/latest", app.getProductsLatestHandler)
 	router.HandlerFunc(http.MethodGet, "/api/products/recommendation", app.getProductsRecommendationHandler)
+	router.HandlerFunc(http.MethodGet, "/api/products/recently-viewed", app.getRecentlyViewedProductsHandler)
+	router.HandlerFunc(http.MethodGet, "/api/products/trending", app.getTrendingProductsHandler)
 	router.HandlerFunc(http.MethodGet, "/api/product/:slug", app.getProductBySlugHandler)
+	router.HandlerFunc(http.MethodGet, "/api/product/:slug/reviews", app.getProductReviewsHandler)
 	router.HandlerFunc(http.MethodGet, "/api/products/product-categories/:slug", app.getProductsByCategoryHandler)
 	router.HandlerFunc(http.MethodGet, "/api/products/brands/:slug", app.getProductsByBrandHandler)
 	router.HandlerFunc(http.MethodGet, "/api/products/storefronts/:slug", app.getProductsByStorefrontHandler)
 
+	// Product Reviews
+	router.HandlerFunc(http.MethodGet, "/api/product-reviews", app.requireAuthenticatedUser(app.getUserProductReviewsHandler))
+	router.HandlerFunc(http.MethodPost, "/api/product-reviews", app.requireAuthenticatedUser(app.createProductReviewHandler))
+
+	// Search
+	router.HandlerFunc(http.MethodGet, "/api/search/suggest", app.getSearchSuggestionsHandler)
+	router.HandlerFunc(http.MethodPost, "/api/search/clicks", app.createSearchClickHandler)
+
 	// Product Categories
 	router.HandlerFunc(http.MethodGet, "/api/product-categories", app.getProductCategoriesHandler)
 	router.HandlerFunc(http.MethodGet, "/api/product-categories/:slug", app.getProductCategoriesBySlugHandler)
 
+	// Referrals
+	router.HandlerFunc(http.MethodGet, "/api/referrals", app.requireAuthenticatedUser(app.getReferralsHandler))
+
+	// Wallet
+	router.HandlerFunc(http.MethodGet, "/api/wallet", app.requireAuthenticatedUser(app.getWalletHandler))
+	router.HandlerFunc(http.MethodPost, "/api/wallet/gift-cards", app.requireAuthenticatedUser(app.redeemGiftCardHandler))
+
 	// ====================================================================================
 	// CMS - Backoffice Routes
 	// ====================================================================================
 
 	// Users
-	router.HandlerFunc(http.MethodPost, "/cms/users", app.registerAdminHandler)
+	router.HandlerFunc(http.MethodPost, "/cms/users", app.requirePermission("admins:write", app.registerAdminHandler))
+	router.HandlerFunc(http.MethodPost, "/cms/users/two-factor", app.requireAuthenticatedAdmin(app.createTwoFactorSecretHandler))
+	router.HandlerFunc(http.MethodPut, "/cms/users/two-factor/enable", app.requireAuthenticatedAdmin(app.enableTwoFactorHandler))
+	router.HandlerFunc(http.MethodPut, "/cms/users/two-factor/disable", app.requireAuthenticatedAdmin(app.disableTwoFactorHandler))
+	router.HandlerFunc(http.MethodPost, "/cms/users/two-factor/recovery-codes", app.requireAuthenticatedAdmin(app.createRecoveryCodesHandler))
 
 	// Banners
 	router.HandlerFunc(http.MethodGet, "/sql/banners", app.listBannersHandler)
@@ -137,6 +188,17 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodPut, "/cms/brands/:id", app.updateBrandHandler)
 	router.HandlerFunc(http.MethodDelete, "/cms/brands/:id", app.deleteBrandHandler)
 
+	// Campaigns
+	router.HandlerFunc(http.MethodGet, "/cms/campaigns", app.requireAuthenticatedAdmin(app.listCampaignsHandler))
+	router.HandlerFunc(http.MethodGet, "/cms/campaigns/:id", app.requireAuthenticatedAdmin(app.showCampaignHandler))
+	router.HandlerFunc(http.MethodPost, "/cms/campaigns", app.requirePermission("products:write", app.createCampaignHandler))
+	router.HandlerFunc(http.MethodPut, "/cms/campaigns/:id", app.requirePermission("products:write", app.updateCampaignHandler))
+	router.HandlerFunc(http.MethodDelete, "/cms/campaigns/:id", app.requirePermission("products:write", app.deleteCampaignHandler))
+
+	// Gift Cards
+	router.HandlerFunc(http.MethodGet, "/cms/gift-cards", app.requireAuthenticatedAdmin(app.listGiftCardsHandler))
+	router.HandlerFunc(http.MethodPost, "/cms/gift-cards", app.requirePermission("gift-cards:write", app.createGiftCardHandler))
+
 	// Inbox
 	router.HandlerFunc(http.MethodGet, "/cms/inbox", app.listInboxHandler)
 	router.HandlerFunc(http.MethodGet, "/cms/inbox/:id", app.showInboxHandler)
@@ -144,6 +206,15 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodPut, "/cms/inbox/:id", app.updateInboxHandler)
 	router.HandlerFunc(http.MethodDelete, "/cms/inbox/:id", app.deleteInboxHandler)
 
+	// Inventory
+	router.HandlerFunc(http.MethodGet, "/cms/inventory/movements", app.requireAuthenticatedAdmin(app.listInventoryMovementsHandler))
+	router.HandlerFunc(http.MethodGet, "/cms/inventory/discrepancies", app.requireAuthenticatedAdmin(app.listInventoryDiscrepanciesHandler))
+	router.HandlerFunc(http.MethodPost, "/cms/inventory/adjustments", app.requirePermission("products:write", app.adjustInventoryHandler))
+
+	// Loyalty
+	router.HandlerFunc(http.MethodGet, "/cms/loyalty", app.requireAuthenticatedAdmin(app.showLoyaltySettingsHandler))
+	router.HandlerFunc(http.MethodPut, "/cms/loyalty", app.requirePermission("loyalty:write", app.updateLoyaltySettingsHandler))
+
 	// Movies
 	router.HandlerFunc(http.MethodGet, "/cms/movies", app.requireAuthenticatedAdmin(app.listMoviesHandler))
 	router.HandlerFunc(http.MethodPost, "/cms/movies", app.requirePermission("movies:write", app.createMovieHandler))
@@ -154,24 +225,26 @@ func (app *application) routes() http.Handler {
 
 	// Orders
 	router.HandlerFunc(http.MethodGet, "/cms/orders", app.listOrdersHandler)
-	router.HandlerFunc(http.MethodGet, "/cms/orders/:id", app.showOrderHandler)
-	router.HandlerFunc(http.MethodPut, "/cms/orders/:id", app.updateOrdersHandler)
+	router.HandlerFunc(http.MethodGet, "/cms/orders/:id", app.routeSegment("export", app.requireAuthenticatedAdmin(app.exportOrdersHandler), app.showOrderHandler))
+	router.HandlerFunc(http.MethodPut, "/cms/orders/:id", app.requirePermission("orders:write", app.updateOrdersHandler))
 
 	// Order Refunds
 	router.HandlerFunc(http.MethodGet, "/cms/order-refunds", app.listOrderRefundsHandler)
-	router.HandlerFunc(http.MethodGet, "/cms/order-refunds/:id", app.showOrderRefundHandler)
-	router.HandlerFunc(http.MethodPut, "/cms/order-refunds/:id/status", app.updateOrderRefundStatusHandler)
+	router.HandlerFunc(http.MethodGet, "/cms/order-refunds/:id", app.routeSegment("export", app.requireAuthenticatedAdmin(app.exportOrderRefundsHandler), app.showOrderRefundHandler))
+	router.HandlerFunc(http.MethodPut, "/cms/order-refunds/:id/status", app.requirePermission("order-refunds:write", app.updateOrderRefundStatusHandler))
 	router.HandlerFunc(http.MethodPut, "/cms/order-refunds/:id/receipt-number", app.updateOrderRefundReceiptNumberHandler)
-	router.HandlerFunc(http.MethodPut, "/cms/order-refunds/:id/refund-value", app.updateOrderRefundRefundValueHandler)
+	router.HandlerFunc(http.MethodPut, "/cms/order-refunds/:id/refund-value", app.requirePermission("order-refunds:write", app.updateOrderRefundRefundValueHandler))
 
 	// Products
 	router.HandlerFunc(http.MethodGet, "/cms/products", app.listProductsHandler)
-	router.HandlerFunc(http.MethodGet, "/cms/products/:id", app.showProductHandler)
-	router.HandlerFunc(http.MethodPost, "/cms/products", app.createProductHandler)
-	router.HandlerFunc(http.MethodPut, "/cms/products/:id", app.updateProductHandler)
-	router.HandlerFunc(http.MethodDelete, "/cms/products/:id", app.deleteProductHandler)
-	router.HandlerFunc(http.MethodPost, "/cms/products/variants", app.createProductVariantsHandler)
-	router.HandlerFunc(http.MethodPut, "/cms/products/:id/variants", app.updateProductVariantsHandler)
+	router.HandlerFunc(http.MethodGet, "/cms/products/:id", app.routeSegment("export", app.requireAuthenticatedAdmin(app.exportProductsHandler), app.showProductHandler))
+	router.HandlerFunc(http.MethodPost, "/cms/products", app.requirePermission("products:write", app.createProductHandler))
+	router.HandlerFunc(http.MethodPut, "/cms/products/:id", app.requirePermission("products:write", app.updateProductHandler))
+	router.HandlerFunc(http.MethodDelete, "/cms/products/:id", app.requirePermission("products:write", app.deleteProductHandler))
+	router.HandlerFunc(http.MethodPost, "/cms/products/variants", app.requirePermission("products:write", app.createProductVariantsHandler))
+	router.HandlerFunc(http.MethodPost, "/cms/products/import", app.requirePermission("products:write", app.importProductsHandler))
+	router.HandlerFunc(http.MethodPut, "/cms/products/:id/variants", app.requirePermission("products:write", app.updateProductVariantsHandler))
+	router.HandlerFunc(http.MethodGet, "/cms/product-imports/:id", app.requireAuthenticatedAdmin(app.showProductImportHandler))
 
 	// Product Categories
 	router.HandlerFunc(http.MethodGet, "/cms/product-categories", app.listProductCategoriesHandler)
@@ -185,6 +258,27 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodPut, "/cms/product-images/:id", app.updateProductImageHandler)
 	router.HandlerFunc(http.MethodDelete, "/cms/product-images/:id", app.deleteProductImageHandler)
 
+	// Product Reviews
+	router.HandlerFunc(http.MethodGet, "/cms/product-reviews", app.requireAuthenticatedAdmin(app.listProductReviewsHandler))
+	router.HandlerFunc(http.MethodGet, "/cms/product-reviews/:id", app.requireAuthenticatedAdmin(app.showProductReviewHandler))
+	router.HandlerFunc(http.MethodPut, "/cms/product-reviews/:id", app.requirePermission("products:write", app.moderateProductReviewHandler))
+
+	// Promotions
+	router.HandlerFunc(http.MethodGet, "/cms/promotions", app.requireAuthenticatedAdmin(app.listPromotionsHandler))
+	router.HandlerFunc(http.MethodGet, "/cms/promotions/:id", app.requireAuthenticatedAdmin(app.showPromotionHandler))
+	router.HandlerFunc(http.MethodPost, "/cms/promotions", app.requirePermission("products:write", app.createPromotionHandler))
+	router.HandlerFunc(http.MethodPut, "/cms/promotions/:id", app.requirePermission("products:write", app.updatePromotionHandler))
+	router.HandlerFunc(http.MethodDelete, "/cms/promotions/:id", app.requirePermission("products:write", app.deletePromotionHandler))
+
+	// Referrals
+	router.HandlerFunc(http.MethodGet, "/cms/referrals", app.requireAuthenticatedAdmin(app.listReferralsHandler))
+	router.HandlerFunc(http.MethodGet, "/cms/referrals/report", app.requireAuthenticatedAdmin(app.getReferralReportHandler))
+	router.HandlerFunc(http.MethodGet, "/cms/referrals/settings", app.requireAuthenticatedAdmin(app.showReferralSettingsHandler))
+	router.HandlerFunc(http.MethodPut, "/cms/referrals/settings", app.requirePermission("referrals:write", app.updateReferralSettingsHandler))
+
+	// Search
+	router.HandlerFunc(http.MethodGet, "/cms/search/reports", app.requireAuthenticatedAdmin(app.getSearchReportsHandler))
+
 	// Storefronts
 	router.HandlerFunc(http.MethodGet, "/cms/storefronts", app.listStorefrontsHandler)
 	router.HandlerFunc(http.MethodGet, "/cms/storefronts/:id", app.showStorefrontHandler)
@@ -204,6 +298,10 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodPut, "/cms/vouchers/:id", app.requireAuthenticatedAdmin(app.updateVoucherHandler))
 	router.HandlerFunc(http.MethodDelete, "/cms/vouchers/:id", app.deleteVoucherHandler)
 
+	// Wallet
+	router.HandlerFunc(http.MethodGet, "/cms/wallet-entries", app.requireAuthenticatedAdmin(app.listWalletEntriesHandler))
+	router.HandlerFunc(http.MethodPost, "/cms/wallet-entries", app.requirePermission("wallet:write", app.adjustWalletHandler))
+
 	// ====================================================================================
 	// Miscellaneous Routes
 	// ====================================================================================
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/kervinch/internal/data"
+	"github.com/kervinch/internal/validator"
+)
+
+// ====================================================================================
+// Backoffice Handlers
+// ====================================================================================
+
+func (app *application) getSearchReportsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Report          string
+		Days            int
+		Limit           int
+		MinimumSearches int
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Report = app.readStrings(qs, "report", "top_queries")
+	input.Days = app.readInt(qs, "days", 30, v)
+	input.Limit = app.readInt(qs, "limit", 50, v)
+	input.MinimumSearches = app.readInt(qs, "minimum_searches", 10, v)
+
+	v.Check(validator.In(input.Report, "top_queries", "zero_results", "click_through"), "report", "must be either top_queries, zero_results or click_through")
+	v.Check(input.Days > 0 && input.Days <= 365, "days", "must be between 1 and 365")
+	v.Check(input.Limit > 0 && input.Limit <= 100, "limit", "must be between 1 and 100")
+	v.Check(input.MinimumSearches > 0, "minimum_searches", "must be greater than zero")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var reports []*data.SearchQueryReport
+	var err error
+
+	switch input.Report {
+	case "zero_results":
+		reports, err = app.gorm.SearchEvents.GetTopZeroResultQueries(input.Days, input.Limit)
+	case "click_through":
+		reports, err = app.gorm.SearchEvents.GetLowestClickThroughQueries(input.Days, input.Limit, input.MinimumSearches)
+	default:
+		reports, err = app.gorm.SearchEvents.GetTopQueries(input.Days, input.Limit)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), reports, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// ====================================================================================
+// Business Handlers
+// ====================================================================================
+
+func (app *application) createSearchClickHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		SearchID  string `json:"search_id"`
+		ProductID int64  `json:"product_id"`
+		Position  int    `json:"position"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	searchClick := &data.SearchClick{
+		SearchID:  input.SearchID,
+		ProductID: input.ProductID,
+		Position:  input.Position,
+	}
+
+	v := validator.New()
+
+	if data.ValidateSearchClick(v, searchClick); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.gorm.SearchEvents.InsertClick(searchClick)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, http.StatusText(http.StatusCreated), envelope{"message": "search click successfully recorded"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getSearchSuggestionsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	query := strings.TrimSpace(app.readStrings(qs, "q", ""))
+
+	if data.ValidateSearchQuery(v, query); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	key := app.searchSuggestionsCacheKey(query)
+
+	entry, _ := app.cache.Get(key)
+	if entry != nil {
+		var e any
+		err := json.Unmarshal(entry, &e)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), e, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	suggestions, err := app.gorm.Search.Suggest(query, 5)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if suggestions.IsEmpty() {
+		suggestions.DidYouMean, err = app.gorm.Search.DidYouMean(query, 3)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	s, _ := json.Marshal(suggestions)
+	app.cache.Set(key, s)
+
+	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), suggestions, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
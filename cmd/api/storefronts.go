@@ -15,15 +15,17 @@ import (
 // ====================================================================================
 
 func (app *application) listStorefrontsHandler(w http.ResponseWriter, r *http.Request) {
-	var pagination data.Pagination
-
 	v := validator.New()
 	qs := r.URL.Query()
 
-	pagination.Page = app.readInt(qs, "page", 1, v)
-	pagination.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters := app.readFilters(qs, "id", data.StorefrontSortSafeList, data.StorefrontFilterSafeList, v)
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 
-	storefronts, metadata, err := app.gorm.Storefronts.GetAll(pagination)
+	storefronts, metadata, err := app.gorm.Storefronts.GetAll(filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -94,6 +96,9 @@ func (app *application) createStorefrontHandler(w http.ResponseWriter, r *http.R
 
 	v := validator.New()
 
+	storefront.PublishAt = app.readTime(r.Form, "publish_at", v)
+	storefront.UnpublishAt = app.readTime(r.Form, "unpublish_at", v)
+
 	if data.ValidateStorefront(v, storefront); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -114,6 +119,9 @@ func (app *application) createStorefrontHandler(w http.ResponseWriter, r *http.R
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/storefronts/%d", storefront.ID))
 
+	app.invalidateCatalogueCache()
+	app.reschedulePublishing()
+
 	err = app.writeJSON(w, http.StatusCreated, http.StatusText(http.StatusCreated), storefront, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -168,6 +176,9 @@ func (app *application) updateStorefrontHandler(w http.ResponseWriter, r *http.R
 
 	v := validator.New()
 
+	storefront.PublishAt = app.readTime(r.Form, "publish_at", v)
+	storefront.UnpublishAt = app.readTime(r.Form, "unpublish_at", v)
+
 	if data.ValidateStorefront(v, storefront); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -189,6 +200,9 @@ func (app *application) updateStorefrontHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	app.invalidateCatalogueCache()
+	app.reschedulePublishing()
+
 	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), storefront, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -213,6 +227,8 @@ func (app *application) deleteStorefrontHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	app.invalidateCatalogueCache()
+
 	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), "storefront successfully deleted", nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
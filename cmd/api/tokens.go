@@ -1,11 +1,15 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"errors"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/kervinch/internal/data"
+	"github.com/kervinch/internal/oauth"
 	"github.com/kervinch/internal/validator"
 )
 
@@ -53,12 +57,139 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
+	app.writeAuthenticationToken(w, r, user)
+}
+
+// writeAuthenticationToken issues an authentication token once a user has proven their
+// identity. Admins who have enrolled in two-factor authentication receive a short-lived
+// challenge token instead, which is exchanged at /api/tokens/two-factor.
+func (app *application) writeAuthenticationToken(w http.ResponseWriter, r *http.Request, user *data.User) {
+	if user.Role == "admin" && user.TOTPEnabled {
+		challenge, err := app.models.Tokens.New(user.ID, data.TwoFactorTTL, data.ScopeTwoFactor)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		env := envelope{"two_factor_required": true, "challenge": challenge}
+		err = app.writeJSON(w, http.StatusAccepted, http.StatusText(http.StatusAccepted), env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
 	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	app.mergeRecentlyViewed(r, user.ID)
+
+	err = app.writeJSON(w, http.StatusCreated, http.StatusText(http.StatusCreated), token, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createTwoFactorAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Challenge    string `json:"challenge"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateTokenPlaintext(v, input.Challenge)
+
+	if input.RecoveryCode != "" {
+		data.ValidateRecoveryCode(v, input.RecoveryCode)
+	} else {
+		data.ValidateOTPPlaintext(v, input.Code)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeTwoFactor, input.Challenge)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("challenge", "invalid or expired challenge token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	verified := false
+
+	if input.RecoveryCode != "" {
+		err = app.models.RecoveryCodes.Use(user.ID, input.RecoveryCode)
+		switch {
+		case err == nil:
+			verified = true
+		case !errors.Is(err, data.ErrRecordNotFound):
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	} else {
+		verified, err = app.validateTOTP(user, input.Code)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if !verified {
+		attempts, err := app.models.Tokens.IncrementAttempts(data.ScopeTwoFactor, input.Challenge)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// Burn the challenge once too many codes have been guessed, forcing the admin to
+		// sign in with their password again.
+		if attempts >= data.OTPMaxAttempts {
+			err = app.models.Tokens.DeleteAllForUser(data.ScopeTwoFactor, user.ID)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			app.rateLimitExceededResponse(w, r)
+			return
+		}
+
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeTwoFactor, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.mergeRecentlyViewed(r, user.ID)
+
 	err = app.writeJSON(w, http.StatusCreated, http.StatusText(http.StatusCreated), token, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -190,3 +321,170 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+func (app *application) createOAuthAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Provider string `json:"provider"`
+		IDToken  string `json:"id_token"`
+		Name     string `json:"name"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(validator.In(input.Provider, oauth.GOOGLE, oauth.APPLE), "provider", "must be either google or apple")
+	v.Check(input.IDToken != "", "id_token", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	claims, err := app.oauth.Verify(input.Provider, input.IDToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, oauth.ErrUnknownProvider):
+			v.AddError("provider", "this provider is not enabled")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, oauth.ErrInvalidToken):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Look for an identity we have already linked, then fall back to an existing account
+	// with the same verified email, and finally create a new activated account.
+	user, err := app.models.Users.GetByOAuthIdentity(input.Provider, claims.Subject)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if user == nil {
+		if claims.Email == "" || !claims.EmailVerified {
+			v.AddError("id_token", "must contain a verified email address")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		user, err = app.models.Users.GetByEmail(claims.Email)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				user, err = app.createOAuthUser(claims, input.Name)
+				if err != nil {
+					app.serverErrorResponse(w, r, err)
+					return
+				}
+			default:
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+
+		// The provider has verified the email address, which is what activation proves. The
+		// account was never activated, so its password was set by someone who could not prove
+		// they own the address: replace it and sign out anyone who logged in with it.
+		if !user.Activated {
+			user.Activated = true
+
+			err = setRandomPassword(user)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			err = app.models.Tokens.DeleteAllForUser(data.ScopeAuthentication, user.ID)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			err = app.models.Users.Update(user)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrEditConflict):
+					app.editConflictResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+		}
+
+		identity := &data.OAuthIdentity{
+			UserID:   user.ID,
+			Provider: input.Provider,
+			Subject:  claims.Subject,
+			Email:    claims.Email,
+		}
+
+		if data.ValidateOAuthIdentity(v, identity); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		err = app.models.OAuthIdentities.Insert(identity)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrDuplicateKeyValue):
+				app.violateUniqueConstraint(w, r, err)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	app.writeAuthenticationToken(w, r, user)
+}
+
+func (app *application) createOAuthUser(claims *oauth.Claims, name string) (*data.User, error) {
+	if name == "" {
+		name = claims.Name
+	}
+
+	if name == "" {
+		name = strings.Split(claims.Email, "@")[0]
+	}
+
+	user := &data.User{
+		Name:      name,
+		Email:     claims.Email,
+		Activated: true,
+	}
+
+	// Social accounts never log in with a password, so store the hash of a random one to
+	// satisfy the password_hash constraint.
+	err := setRandomPassword(user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.models.Users.Insert(user, "user")
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// setRandomPassword sets the password of a user to a random one nobody knows. The user can
+// still choose a password of their own through a password reset.
+func setRandomPassword(user *data.User) error {
+	randomBytes := make([]byte, 32)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	return user.Password.Set(base64.RawURLEncoding.EncodeToString(randomBytes))
+}
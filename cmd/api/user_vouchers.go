@@ -14,15 +14,17 @@ import (
 // ====================================================================================
 
 func (app *application) listUserVouchersHandler(w http.ResponseWriter, r *http.Request) {
-	var pagination data.Pagination
-
 	v := validator.New()
 	qs := r.URL.Query()
 
-	pagination.Page = app.readInt(qs, "page", 1, v)
-	pagination.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters := app.readFilters(qs, "-created_at", data.UserVoucherSortSafeList, data.UserVoucherFilterSafeList, v)
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 
-	userVouchers, metadata, err := app.gorm.UserVouchers.GetAll(pagination)
+	userVouchers, metadata, err := app.gorm.UserVouchers.GetAll(filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
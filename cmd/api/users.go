@@ -2,10 +2,13 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/kervinch/internal/data"
+	"github.com/kervinch/internal/totp"
 	"github.com/kervinch/internal/validator"
 )
 
@@ -15,9 +18,10 @@ import (
 
 func (app *application) registerAdminHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Name     string `json:"name"`
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Name        string   `json:"name"`
+		Email       string   `json:"email"`
+		Password    string   `json:"password"`
+		Permissions []string `json:"permissions"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -26,6 +30,13 @@ func (app *application) registerAdminHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	// An admin can only hand out the permissions they hold themselves.
+	granted, err := app.models.Permissions.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	admin := &data.User{
 		Name:      input.Name,
 		Email:     input.Email,
@@ -40,6 +51,10 @@ func (app *application) registerAdminHandler(w http.ResponseWriter, r *http.Requ
 
 	v := validator.New()
 
+	for _, code := range input.Permissions {
+		v.Check(granted.Include(code), "permissions", fmt.Sprintf("must only contain permissions you hold, %q is not one of them", code))
+	}
+
 	if data.ValidateUser(v, admin); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -57,8 +72,10 @@ func (app *application) registerAdminHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	// Add the "movies:read permission for the new user"
-	err = app.models.Permissions.AddForUser(admin.ID, "movies:read")
+	// Add the default permission for the new admin, along with the ones granted by the admin
+	// who registered them. The sensitive ones stay unusable until the new admin enables
+	// two-factor authentication.
+	err = app.models.Permissions.AddForUser(admin.ID, append([]string{"movies:read"}, input.Permissions...)...)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -70,15 +87,244 @@ func (app *application) registerAdminHandler(w http.ResponseWriter, r *http.Requ
 	}
 }
 
+func (app *application) createTwoFactorSecretHandler(w http.ResponseWriter, r *http.Request) {
+	admin := app.contextGetUser(r)
+
+	if admin.TOTPEnabled {
+		v := validator.New()
+		v.AddError("two_factor", "two-factor authentication is already enabled")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	admin.TOTPSecret = secret
+
+	err = app.models.Users.UpdateTOTP(admin)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{
+		"secret": secret,
+		"uri":    totp.URI("KIN", admin.Email, secret),
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, http.StatusText(http.StatusCreated), env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) enableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	admin := app.contextGetUser(r)
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateOTPPlaintext(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if admin.TOTPEnabled {
+		v.AddError("two_factor", "two-factor authentication is already enabled")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if admin.TOTPSecret == "" {
+		v.AddError("two_factor", "a two-factor secret must be created first")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	valid, err := app.validateTOTP(admin, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !valid {
+		v.AddError("code", "invalid verification code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	admin.TOTPEnabled = true
+
+	err = app.models.Users.UpdateTOTP(admin)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Sessions started before enrolment never passed the two-factor challenge, so they must
+	// not reach the sensitive permissions it now unlocks.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeAuthentication, admin.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	codes, err := app.models.RecoveryCodes.New(admin.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	admin := app.contextGetUser(r)
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateOTPPlaintext(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	valid := false
+
+	if admin.TOTPEnabled {
+		valid, err = app.validateTOTP(admin, input.Code)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if !valid {
+		v.AddError("code", "invalid verification code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	admin.TOTPSecret = ""
+	admin.TOTPEnabled = false
+
+	err = app.models.Users.UpdateTOTP(admin)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.RecoveryCodes.DeleteAllForUser(admin.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"message": "two-factor authentication has been successfully disabled"}
+	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	admin := app.contextGetUser(r)
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateOTPPlaintext(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	valid := false
+
+	if admin.TOTPEnabled {
+		valid, err = app.validateTOTP(admin, input.Code)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if !valid {
+		v.AddError("code", "invalid verification code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	codes, err := app.models.RecoveryCodes.New(admin.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, http.StatusText(http.StatusCreated), envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 // ====================================================================================
 // Business Handlers
 // ====================================================================================
 
 func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Name     string `json:"name"`
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Name         string `json:"name"`
+		Email        string `json:"email"`
+		Password     string `json:"password"`
+		ReferralCode string `json:"referral_code"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -106,6 +352,34 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// A code is only taken while the referral programme runs, but a mistyped one is
+	// always reported.
+	var referrerID int64
+
+	if input.ReferralCode != "" {
+		referrerID, err = app.gorm.Referrals.GetReferrerID(input.ReferralCode)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("referral_code", "does not match any user")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		settings, err := app.gorm.Referrals.GetSettings()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !settings.IsActive {
+			referrerID = 0
+		}
+	}
+
 	err = app.models.Users.Insert(user, "user")
 	if err != nil {
 		switch {
@@ -118,6 +392,19 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// The account exists by now, so a referral that fails to be recorded is only logged.
+	if referrerID != 0 {
+		err = app.gorm.Referrals.Insert(&data.Referral{
+			ReferrerID: referrerID,
+			RefereeID:  user.ID,
+			Code:       strings.ToUpper(strings.TrimSpace(input.ReferralCode)),
+			DeviceID:   app.readDeviceID(r),
+		})
+		if err != nil {
+			app.logError(r, err)
+		}
+	}
+
 	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -446,8 +733,478 @@ func (app *application) updateUserPhoneNumberHandler(w http.ResponseWriter, r *h
 		return
 	}
 
+	// Send a verification code to the new phone number. Hitting the rate limit here is not
+	// fatal, the user can request another code later.
+	err = app.sendPhoneOTP(user)
+	if err != nil && !errors.Is(err, data.ErrOTPTooManyRequests) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	// Send the user a confirmation message.
-	env := envelope{"message": "user phone number has been successfully updated"}
+	env := envelope{"message": "user phone number has been successfully updated, a verification code will be sent to it"}
+	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createPhoneOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	v := validator.New()
+
+	if data.ValidatePhoneNumber(v, user.PhoneNumber); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if user.PhoneVerified {
+		v.AddError("phone_number", "phone number has already been verified")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.sendPhoneOTP(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrOTPTooManyRequests):
+			app.rateLimitExceededResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{"message": "a verification code will be sent to your phone number"}
+	err = app.writeJSON(w, http.StatusAccepted, http.StatusText(http.StatusAccepted), env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) verifyUserPhoneNumberHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	user := app.contextGetUser(r)
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateOTPPlaintext(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Tokens.VerifyOTP(user.ID, user.PhoneNumber, data.ScopePhoneOTP, input.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("code", "invalid or expired verification code")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrOTPMismatch):
+			v.AddError("code", "invalid or expired verification code")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrOTPTooManyAttempts):
+			app.rateLimitExceededResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user.PhoneVerified = true
+
+	err = app.models.Users.UpdatePhoneVerified(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopePhoneOTP, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"message": "user phone number has been successfully verified"}
+	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateUserEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	user := app.contextGetUser(r)
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateEmail(v, input.Email)
+	data.ValidatePasswordPlaintext(v, input.Password)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// A session alone is not enough to move the account to another address.
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !match {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	if strings.EqualFold(input.Email, user.Email) {
+		v.AddError("email", "must be different from the current email address")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.models.Users.GetByEmail(input.Email)
+	switch {
+	case err == nil:
+		v.AddError("email", "a user with this email address already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	user.PendingEmail = input.Email
+
+	err = app.models.Users.UpdatePendingEmail(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Only the most recent request can be confirmed.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeEmailChange, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeEmailChange)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The old address can take the account back for a week, even once the change has been
+	// confirmed.
+	revertToken, err := app.models.Tokens.NewEmailRevert(user.ID, user.Email, 7*24*time.Hour)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	oldEmail := user.Email
+	newEmail := user.PendingEmail
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"emailChangeToken": token.Plaintext,
+		}
+
+		err := app.mailer.Send(newEmail, "Confirm your new Kin email address", "email_change_confirmation.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+
+		data = map[string]interface{}{
+			"newEmail":         newEmail,
+			"emailRevertToken": revertToken.Plaintext,
+		}
+
+		err = app.mailer.Send(oldEmail, "Your Kin email address is being changed", "email_change_notice.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	env := envelope{"message": "a confirmation link will be sent to the new email address"}
+	err = app.writeJSON(w, http.StatusAccepted, http.StatusText(http.StatusAccepted), env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) confirmUserEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	qs := r.URL.Query()
+	input.TokenPlaintext = app.readStrings(qs, "token", "")
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeEmailChange, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.PendingEmail == "" {
+		v.AddError("token", "invalid or expired email change token")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.ConfirmPendingEmail(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Sign the user out everywhere, since the login identifier has changed.
+	for _, scope := range []string{data.ScopeEmailChange, data.ScopeAuthentication, data.ScopePasswordReset} {
+		err = app.models.Tokens.DeleteAllForUser(scope, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	env := envelope{"message": "email address has been successfully changed, please sign in again"}
+	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revertUserEmailHandler serves the link mailed to the old address of an email change. It
+// gives the account back to that address and signs it out everywhere, as whoever asked
+// for the change may hold a session.
+func (app *application) revertUserEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	qs := r.URL.Query()
+	input.TokenPlaintext = app.readStrings(qs, "token", "")
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.RevertEmail(input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email revert token")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	for _, scope := range []string{data.ScopeEmailChange, data.ScopeEmailRevert, data.ScopeAuthentication, data.ScopePasswordReset} {
+		err = app.models.Tokens.DeleteAllForUser(scope, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	env := envelope{"message": "email address has been successfully restored, please reset your password and sign in again"}
+	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) exportUserDataHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	addresses, err := app.gorm.UserAddresses.GetAPI(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	orders, err := app.gorm.Orders.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	orderRefunds, err := app.gorm.OrderRefunds.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	inbox, err := app.gorm.InboxUsers.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"exported_at":   time.Now(),
+		"profile":       user,
+		"addresses":     addresses,
+		"orders":        orders,
+		"order_refunds": orderRefunds,
+		"inbox":         inbox,
+	}
+
+	headers := make(http.Header)
+	headers.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="kin-data-export-%d.json"`, user.ID))
+
+	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	v := validator.New()
+
+	if user.DeletionScheduledAt != nil {
+		v.AddError("account", "account deletion has already been requested")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Anonymising the account strips the shipping address from its orders, so it must
+	// wait until every order has been delivered or closed.
+	openOrders, err := app.gorm.Orders.CountOpenForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if openOrders > 0 {
+		v.AddError("account", "account cannot be deleted while orders are still in progress")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	scheduledAt := time.Now().Add(app.config.accountDeletion.gracePeriod).Truncate(time.Second)
+	user.DeletionScheduledAt = &scheduledAt
+
+	err = app.models.Users.UpdateDeletionSchedule(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"deletionDate": scheduledAt.Format("2 January 2006"),
+		}
+
+		err := app.mailer.Send(user.Email, "Your Kin account is scheduled for deletion", "account_deletion_scheduled.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	env := envelope{
+		"message":               "your account is scheduled for deletion and can be restored until then",
+		"deletion_scheduled_at": scheduledAt,
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, http.StatusText(http.StatusAccepted), env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) cancelUserDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	if user.DeletionScheduledAt == nil {
+		v := validator.New()
+		v.AddError("account", "account deletion has not been requested")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user.DeletionScheduledAt = nil
+
+	err := app.models.Users.UpdateDeletionSchedule(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{"message": "account deletion has been successfully cancelled"}
 	err = app.writeJSON(w, http.StatusOK, http.StatusText(http.StatusOK), env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -18,15 +18,17 @@ import (
 // ====================================================================================
 
 func (app *application) listVouchersHandler(w http.ResponseWriter, r *http.Request) {
-	var pagination data.Pagination
-
 	v := validator.New()
 	qs := r.URL.Query()
 
-	pagination.Page = app.readInt(qs, "page", 1, v)
-	pagination.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters := app.readFilters(qs, "-created_at", data.VoucherSortSafeList, data.VoucherFilterSafeList, v)
 
-	productCategories, metadata, err := app.gorm.Vouchers.GetAll(pagination)
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	productCategories, metadata, err := app.gorm.Vouchers.GetAll(filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -162,6 +164,9 @@ func (app *application) createVoucherHandler(w http.ResponseWriter, r *http.Requ
 
 	v := validator.New()
 
+	voucher.PublishAt = app.readTime(r.Form, "publish_at", v)
+	voucher.UnpublishAt = app.readTime(r.Form, "unpublish_at", v)
+
 	if data.ValidateVoucher(v, voucher); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -302,6 +307,9 @@ func (app *application) updateVoucherHandler(w http.ResponseWriter, r *http.Requ
 
 	v := validator.New()
 
+	voucher.PublishAt = app.readTime(r.Form, "publish_at", v)
+	voucher.UnpublishAt = app.readTime(r.Form, "unpublish_at", v)
+
 	if data.ValidateVoucher(v, voucher); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kervinch/internal/data"
+	"github.com/kervinch/internal/validator"
+)
+
+// ====================================================================================
+// Backoffice Handlers
+// ====================================================================================
+
+func (app *application) listWalletEntriesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters := app.readFilters(qs, "-id", data.WalletEntrySortSafeList, data.WalletEntryFilterSafeList, v)
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entries, metadata, err := app.gorm.Wallet.GetAll(filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSONWithMeta(w, http.StatusOK, http.StatusText(http.StatusOK), entries, nil, metadata)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) adjustWalletHandler(w http.ResponseWriter, r *http.Request) {
+	var input data.WalletAdjustment
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateWalletAdjustment(v, &input); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entry, err := app.gorm.Wallet.Adjust(&input, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInsufficientCredit):
+			v.AddError("amount", "must not take the store credit below zero")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, http.StatusText(http.StatusCreated), entry, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listGiftCardsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters := app.readFilters(qs, "-id", data.GiftCardSortSafeList, data.GiftCardFilterSafeList, v)
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	giftCards, metadata, err := app.gorm.GiftCards.GetAll(filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSONWithMeta(w, http.StatusOK, http.StatusText(http.StatusOK), giftCards, nil, metadata)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createGiftCardHandler issues a gift card by hand, e.g. as a goodwill gesture.
+func (app *application) createGiftCardHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Value     int64      `json:"value"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	giftCard := &data.GiftCard{
+		Value:     input.Value,
+		Unit:      1,
+		ExpiresAt: input.ExpiresAt,
+		CreatedBy: sql.NullInt64{Int64: app.contextGetUser(r).ID, Valid: true},
+	}
+
+	v := validator.New()
+
+	if data.ValidateGiftCard(v, giftCard); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.gorm.GiftCards.Insert(giftCard)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, http.StatusText(http.StatusCreated), giftCard, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// ====================================================================================
+// Business Handlers
+// ====================================================================================
+
+func (app *application) getWalletHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var pagination data.Pagination
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	pagination.Page = app.readInt(qs, "page", 1, v)
+	pagination.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	if data.ValidatePagination(v, pagination); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	balance, err := app.gorm.Wallet.GetBalance(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	entries, metadata, err := app.gorm.Wallet.GetAllForUser(user, pagination)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSONWithMeta(w, http.StatusOK, http.StatusText(http.StatusOK), envelope{"balance": balance, "entries": entries}, nil, metadata)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) redeemGiftCardHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Code != "", "code", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entry, err := app.gorm.GiftCards.Redeem(input.Code, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("code", "does not match any gift card")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrGiftCardRedeemed):
+			v.AddError("code", "has already been redeemed")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrGiftCardExpired):
+			v.AddError("code", "has expired")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, http.StatusText(http.StatusCreated), entry, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// issueGiftCards issues the gift cards bought in a paid order and emails their codes to
+// the buyer. The order is paid either way, so a failure is logged and not returned.
+func (app *application) issueGiftCards(orderID int64, email string) {
+	giftCards, err := app.gorm.GiftCards.IssueForOrder(orderID)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{
+			"order_id": fmt.Sprint(orderID),
+		})
+		return
+	}
+
+	if len(giftCards) == 0 {
+		return
+	}
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"orderID":   orderID,
+			"giftCards": giftCards,
+		}
+
+		err := app.mailer.Send(email, "Your KIN gift cards", "gift_cards.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{
+				"order_id": fmt.Sprint(orderID),
+			})
+		}
+	})
+}
+
+// expireStoreCredit writes off the store credit that reached its expiry unspent.
+func (app *application) expireStoreCredit() {
+	expired, err := app.gorm.Wallet.Expire()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+	}
+
+	if expired > 0 {
+		app.logger.PrintInfo("store credit expired", map[string]string{
+			"credits": fmt.Sprint(expired),
+		})
+	}
+}
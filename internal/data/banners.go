@@ -12,14 +12,16 @@ import (
 )
 
 type Banner struct {
-	ID          int64     `json:"id"`
-	ImageURL    string    `json:"image_url"`
-	Title       string    `json:"title"`
-	Deeplink    string    `json:"deeplink"`
-	OutboundURL string    `json:"outbound_url"`
-	IsActive    bool      `json:"is_active"`
-	CreatedAt   time.Time `json:"-"`
-	UpdatedAt   time.Time `json:"-"`
+	ID          int64      `json:"id"`
+	ImageURL    string     `json:"image_url"`
+	Title       string     `json:"title"`
+	Deeplink    string     `json:"deeplink"`
+	OutboundURL string     `json:"outbound_url"`
+	IsActive    bool       `json:"is_active"`
+	PublishAt   *time.Time `json:"publish_at"`
+	UnpublishAt *time.Time `json:"unpublish_at"`
+	CreatedAt   time.Time  `json:"-"`
+	UpdatedAt   time.Time  `json:"-"`
 }
 
 func ValidateBanner(v *validator.Validator, banner *Banner) {
@@ -28,12 +30,24 @@ func ValidateBanner(v *validator.Validator, banner *Banner) {
 	v.Check(len(banner.Title) <= 500, "title", "must not be more than 500 bytes long")
 	v.Check(len(banner.Deeplink) <= 500, "deeplink", "must not be more than 500 bytes long")
 	v.Check(len(banner.OutboundURL) <= 500, "outbound_url", "must not be more than 500 bytes long")
+
+	ValidatePublishingWindow(v, banner.PublishAt, banner.UnpublishAt)
 }
 
 type BannerModel struct {
 	DB *sql.DB
 }
 
+var BannerSortSafeList = SortSafeList("id", "title")
+
+var BannerFilterSafeList = map[string]FilterField{
+	"title":        {Column: "banners.title", Type: FilterString, Operators: TextOperators},
+	"is_active":    {Column: "banners.is_active", Type: FilterBool, Operators: BoolOperators},
+	"publish_at":   {Column: "banners.publish_at", Type: FilterTime, Operators: RangeOperators},
+	"unpublish_at": {Column: "banners.unpublish_at", Type: FilterTime, Operators: RangeOperators},
+	"created_at":   {Column: "banners.created_at", Type: FilterTime, Operators: RangeOperators},
+}
+
 type GormBannerModel struct {
 	DB *gorm.DB
 }
@@ -209,17 +223,18 @@ func (m BannerModel) Delete(id int64) error {
 // ====================================================================================
 
 func (m BannerModel) GetAPI() ([]*Banner, error) {
-	query := fmt.Sprintln(`
-		SELECT id, image_url, title, deeplink, outbound_url, is_active
+	query := fmt.Sprintf(`
+		SELECT id, image_url, title, deeplink, outbound_url, is_active, publish_at, unpublish_at
 		FROM banners
 		WHERE (is_active = true)
+		AND %s
 		ORDER BY id ASC
-		LIMIT 5`)
+		LIMIT 5`, publishedCondition("banners", "$1"))
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, time.Now())
 	if err != nil {
 		return nil, err
 	}
@@ -238,6 +253,8 @@ func (m BannerModel) GetAPI() ([]*Banner, error) {
 			&banner.Deeplink,
 			&banner.OutboundURL,
 			&banner.IsActive,
+			&banner.PublishAt,
+			&banner.UnpublishAt,
 		)
 		if err != nil {
 			return nil, err
@@ -257,24 +274,24 @@ func (m BannerModel) GetAPI() ([]*Banner, error) {
 // GORM Functions
 // ====================================================================================
 
-func (g GormBannerModel) GetAll(p Pagination) ([]*Banner, Metadata, error) {
+func (g GormBannerModel) GetAll(f Filters) ([]*Banner, Metadata, error) {
 	var banners []*Banner
 	var count int64
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := g.DB.WithContext(ctx).Scopes(Paginate(p)).Find(&banners).Error
+	err := g.DB.WithContext(ctx).Scopes(Filter(f), OrderBy(f), Paginate(f.Pagination())).Find(&banners).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
-	err = g.DB.Table("banners").Count(&count).Error
+	err = g.DB.Table("banners").Scopes(Filter(f)).Count(&count).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(int(count), p.Page, p.PageSize)
+	metadata := calculateMetadata(int(count), f.Page, f.PageSize)
 
 	return banners, metadata, nil
 }
@@ -332,6 +349,8 @@ func (g GormBannerModel) Update(b *Banner) error {
 	banner.Deeplink = b.Deeplink
 	banner.OutboundURL = b.OutboundURL
 	banner.IsActive = b.IsActive
+	banner.PublishAt = b.PublishAt
+	banner.UnpublishAt = b.UnpublishAt
 
 	err = g.DB.Save(&banner).Error
 	if err != nil {
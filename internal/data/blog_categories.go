@@ -28,6 +28,14 @@ func ValidateBlogCategory(v *validator.Validator, blogCategory *BlogCategory) {
 	v.Check(len(blogCategory.Name) <= 100, "name", "must not be more than 100 bytes long")
 }
 
+var BlogCategorySortSafeList = SortSafeList("id", "name", "order_number", "status")
+
+var BlogCategoryFilterSafeList = map[string]FilterField{
+	"name":   {Column: "blog_categories.name", Type: FilterString, Operators: TextOperators},
+	"type":   {Column: "blog_categories.type", Type: FilterString, Operators: EqualityOperators},
+	"status": {Column: "blog_categories.status", Type: FilterString, Operators: EqualityOperators},
+}
+
 type BlogCategoryModel struct {
 	DB *gorm.DB
 }
@@ -36,24 +44,24 @@ type BlogCategoryModel struct {
 // Backoffice Functions
 // ====================================================================================
 
-func (m BlogCategoryModel) GetAll(p Pagination) ([]*BlogCategory, Metadata, error) {
+func (m BlogCategoryModel) GetAll(f Filters) ([]*BlogCategory, Metadata, error) {
 	var blogCategories []*BlogCategory
 	var count int64
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.WithContext(ctx).Scopes(Paginate(p)).Find(&blogCategories).Error
+	err := m.DB.WithContext(ctx).Scopes(Filter(f), OrderBy(f), Paginate(f.Pagination())).Find(&blogCategories).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
-	err = m.DB.Table("blog_categories").Count(&count).Error
+	err = m.DB.Table("blog_categories").Scopes(Filter(f)).Count(&count).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(int(count), p.Page, p.PageSize)
+	metadata := calculateMetadata(int(count), f.Page, f.PageSize)
 
 	return blogCategories, metadata, nil
 }
@@ -3,10 +3,13 @@ package data
 import (
 	"context"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/kervinch/internal/validator"
+	"github.com/lib/pq"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Blog struct {
@@ -20,6 +23,8 @@ type Blog struct {
 	Slug           string       `json:"slug"`
 	Type           string       `json:"type"`
 	PublishedAt    time.Time    `json:"published_at"`
+	PublishAt      *time.Time   `json:"publish_at"`
+	UnpublishAt    *time.Time   `json:"unpublish_at"`
 	Feature        bool         `json:"feature"`
 	Status         string       `json:"status"`
 	Tags           string       `json:"tags"`
@@ -36,6 +41,23 @@ func ValidateBlog(v *validator.Validator, blog *Blog) {
 	v.Check(len(blog.Title) <= 500, "title", "must not be more than 500 bytes long")
 	v.Check(blog.Description != "", "description", "must be provided")
 	v.Check(blog.Content != "", "content", "must be provided")
+
+	ValidatePublishingWindow(v, blog.PublishAt, blog.UnpublishAt)
+}
+
+var BlogSortSafeList = SortSafeList("id", "title", "status", "published_at", "created_at")
+
+var BlogFilterSafeList = map[string]FilterField{
+	"title":            {Column: "blogs.title", Type: FilterString, Operators: TextOperators},
+	"type":             {Column: "blogs.type", Type: FilterString, Operators: EqualityOperators},
+	"status":           {Column: "blogs.status", Type: FilterString, Operators: EqualityOperators},
+	"blog_category_id": {Column: "blogs.blog_category_id", Type: FilterInt, Operators: EqualityOperators},
+	"feature":          {Column: "blogs.feature", Type: FilterBool, Operators: BoolOperators},
+	"created_by":       {Column: "blogs.created_by", Type: FilterInt, Operators: EqualityOperators},
+	"published_at":     {Column: "blogs.published_at", Type: FilterTime, Operators: RangeOperators},
+	"publish_at":       {Column: "blogs.publish_at", Type: FilterTime, Operators: RangeOperators},
+	"unpublish_at":     {Column: "blogs.unpublish_at", Type: FilterTime, Operators: RangeOperators},
+	"created_at":       {Column: "blogs.created_at", Type: FilterTime, Operators: RangeOperators},
 }
 
 type BlogModel struct {
@@ -46,24 +68,24 @@ type BlogModel struct {
 // Backoffice Functions
 // ====================================================================================
 
-func (m BlogModel) GetAll(p Pagination) ([]*Blog, Metadata, error) {
+func (m BlogModel) GetAll(f Filters) ([]*Blog, Metadata, error) {
 	var blogs []*Blog
 	var count int64
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.WithContext(ctx).Scopes(Paginate(p)).Order("status").Preload("BlogCategory").Find(&blogs).Error
+	err := m.DB.WithContext(ctx).Scopes(Filter(f), OrderBy(f), Paginate(f.Pagination())).Preload("BlogCategory").Find(&blogs).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
-	err = m.DB.Table("blogs").Count(&count).Error
+	err = m.DB.Table("blogs").Scopes(Filter(f)).Count(&count).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(int(count), p.Page, p.PageSize)
+	metadata := calculateMetadata(int(count), f.Page, f.PageSize)
 
 	return blogs, metadata, nil
 }
@@ -134,6 +156,8 @@ func (m BlogModel) Update(b *Blog) error {
 	blog.Feature = b.Feature
 	blog.Status = b.Status
 	blog.Tags = b.Tags
+	blog.PublishAt = b.PublishAt
+	blog.UnpublishAt = b.UnpublishAt
 
 	err = m.DB.WithContext(ctx).Save(&blog).Error
 	if err != nil {
@@ -177,17 +201,21 @@ func (m BlogModel) GetAPI(p Pagination) ([]*Blog, Metadata, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.WithContext(ctx).Scopes(Paginate(p)).Where("status = ?", "published").Preload("BlogCategory").Find(&blogs).Error
+	err := m.DB.WithContext(ctx).Scopes(Keyset(p, NewestFirst, "created_at", "id"), Published("blogs")).Where("status = ?", "published").Preload("BlogCategory").Find(&blogs).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
-	err = m.DB.Table("blogs").Where("status = ?", "published").Count(&count).Error
-	if err != nil {
-		return nil, Metadata{}, err
+	if p.After == "" {
+		err = m.DB.Table("blogs").Scopes(Published("blogs")).Where("status = ?", "published").Count(&count).Error
+		if err != nil {
+			return nil, Metadata{}, err
+		}
 	}
 
-	metadata := calculateMetadata(int(count), p.Page, p.PageSize)
+	blogs, metadata := keysetMetadata(blogs, p, count, NewestFirst, func(b *Blog) (string, int64) {
+		return timeCursorValue(b.CreatedAt), b.ID
+	})
 
 	return blogs, metadata, nil
 }
@@ -199,7 +227,7 @@ func (m BlogModel) GetBySlug(slug string) (*Blog, error) {
 
 	var blog *Blog
 
-	err := m.DB.Preload("BlogCategory").Where("slug = ?", slug).First(&blog).Error
+	err := m.DB.Preload("BlogCategory").Scopes(Published("blogs")).Where("slug = ?", slug).First(&blog).Error
 	if err != nil {
 		switch {
 		case errors.Is(err, gorm.ErrRecordNotFound):
@@ -212,13 +240,38 @@ func (m BlogModel) GetBySlug(slug string) (*Blog, error) {
 	return blog, nil
 }
 
-func (m BlogModel) GetRecommendations() ([]*Blog, error) {
+// GetRecommendations returns the blogs to read next. Read after a blog, the ones in its
+// category and sharing most of its tags come first. Featured blogs come next, then the
+// most recent ones.
+func (m BlogModel) GetRecommendations(blog *Blog) ([]*Blog, error) {
 	var blogs []*Blog
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.WithContext(ctx).Where("status = ?", "published").Order("created_at desc").Limit(8).Preload("BlogCategory").Find(&blogs).Error
+	db := m.DB.WithContext(ctx).Scopes(Published("blogs")).Where("status = ?", "published")
+
+	if blog != nil {
+		var tags []string
+
+		for _, tag := range strings.Split(blog.Tags, ",") {
+			tag = strings.ToLower(strings.TrimSpace(tag))
+			if tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+
+		db = db.Where("id <> ?", blog.ID).Clauses(clause.OrderBy{Expression: clause.Expr{
+			SQL: `blog_category_id = ? DESC,
+				(SELECT COUNT(*) FROM unnest(regexp_split_to_array(LOWER(COALESCE(tags, '')), '\s*,\s*')) AS tag WHERE tag = ANY(?)) DESC,
+				feature DESC, published_at DESC`,
+			Vars: []interface{}{blog.BlogCategoryID, pq.Array(tags)},
+		}})
+	} else {
+		db = db.Order("feature desc").Order("published_at desc")
+	}
+
+	err := db.Limit(8).Preload("BlogCategory").Find(&blogs).Error
 	if err != nil {
 		return nil, err
 	}
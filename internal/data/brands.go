@@ -27,6 +27,14 @@ func ValidateBrand(v *validator.Validator, brand *Brand) {
 	v.Check(len(brand.Name) <= 500, "name", "must not be more than 500 bytes long")
 }
 
+var BrandSortSafeList = SortSafeList("id", "name", "order_number")
+
+var BrandFilterSafeList = map[string]FilterField{
+	"name":       {Column: "brands.name", Type: FilterString, Operators: TextOperators},
+	"is_active":  {Column: "brands.is_active", Type: FilterBool, Operators: BoolOperators},
+	"created_at": {Column: "brands.created_at", Type: FilterTime, Operators: RangeOperators},
+}
+
 type BrandModel struct {
 	DB *gorm.DB
 }
@@ -35,24 +43,24 @@ type BrandModel struct {
 // Backoffice Functions
 // ====================================================================================
 
-func (m BrandModel) GetAll(p Pagination) ([]*Brand, Metadata, error) {
+func (m BrandModel) GetAll(f Filters) ([]*Brand, Metadata, error) {
 	var brands []*Brand
 	var count int64
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.WithContext(ctx).Scopes(Paginate(p)).Find(&brands).Error
+	err := m.DB.WithContext(ctx).Scopes(Filter(f), OrderBy(f), Paginate(f.Pagination())).Find(&brands).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
-	err = m.DB.Table("brands").Count(&count).Error
+	err = m.DB.Table("brands").Scopes(Filter(f)).Count(&count).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(int(count), p.Page, p.PageSize)
+	metadata := calculateMetadata(int(count), f.Page, f.PageSize)
 
 	return brands, metadata, nil
 }
@@ -177,7 +185,7 @@ func (m BrandModel) GetBySlugWithProducts(slug string) (*Brand, error) {
 
 	var brand *Brand
 
-	err := m.DB.WithContext(ctx).Where("is_active = ? AND slug = ?", true, slug).Preload("Product.ProductCategory").Preload("Product.Brand").Preload("Product.Storefront").Preload("Product.ProductDetail.ProductImage").First(&brand).Error
+	err := m.DB.WithContext(ctx).Where("is_active = ? AND slug = ?", true, slug).Preload("Product", listedProducts).Preload("Product.ProductCategory").Preload("Product.Brand").Preload("Product.Storefront").Preload("Product.ProductDetail", withSalePrice).Preload("Product.ProductDetail.ProductImage").First(&brand).Error
 	if err != nil {
 		switch {
 		case errors.Is(err, gorm.ErrRecordNotFound):
@@ -0,0 +1,332 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/kervinch/internal/validator"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Campaign is a flash sale: a set of variants sold at a lower price during a time window.
+type Campaign struct {
+	ID           int64          `json:"id"`
+	Name         string         `json:"name"`
+	StartsAt     time.Time      `json:"starts_at"`
+	EndsAt       time.Time      `json:"ends_at"`
+	IsActive     bool           `json:"is_active"`
+	CampaignItem []CampaignItem `json:"campaign_items"`
+	CreatedBy    int64          `json:"created_by"`
+	UpdatedBy    int64          `json:"updated_by"`
+	CreatedAt    time.Time      `json:"-"`
+	UpdatedAt    time.Time      `json:"-"`
+}
+
+// CampaignItem puts a variant on sale, either at a fixed SalePrice or at DiscountPercent
+// off its regular price, until Quota units are sold. PerUserLimit caps the units a single
+// user may buy at the sale price, 0 means no limit.
+type CampaignItem struct {
+	ID              int64         `json:"id"`
+	CampaignID      int64         `json:"campaign_id"`
+	ProductDetailID int64         `json:"product_detail_id"`
+	ProductDetail   ProductDetail `json:"product_detail"`
+	SalePrice       *int64        `json:"sale_price"`
+	DiscountPercent *int          `json:"discount_percent"`
+	PerUserLimit    int           `json:"per_user_limit"`
+	Quota           int           `json:"quota"`
+	Sold            int           `json:"sold"`
+	CreatedAt       time.Time     `json:"-"`
+	UpdatedAt       time.Time     `json:"-"`
+}
+
+func ValidateCampaign(v *validator.Validator, campaign *Campaign) {
+	v.Check(campaign.Name != "", "name", "must be provided")
+	v.Check(len(campaign.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.Check(!campaign.StartsAt.IsZero(), "starts_at", "must be provided")
+	v.Check(!campaign.EndsAt.IsZero(), "ends_at", "must be provided")
+	v.Check(campaign.EndsAt.After(campaign.StartsAt), "ends_at", "must be later than starts_at")
+	v.Check(len(campaign.CampaignItem) > 0, "campaign_items", "must contain at least one item")
+	v.Check(len(campaign.CampaignItem) <= 500, "campaign_items", "must not contain more than 500 items")
+
+	seen := make(map[int64]bool, len(campaign.CampaignItem))
+
+	for _, item := range campaign.CampaignItem {
+		v.Check(item.ProductDetailID > 0, "campaign_items", "must have a product_detail_id")
+		v.Check(!seen[item.ProductDetailID], "campaign_items", "must not contain the same variant twice")
+		v.Check((item.SalePrice == nil) != (item.DiscountPercent == nil), "campaign_items", "must have either a sale_price or a discount_percent")
+		v.Check(item.SalePrice == nil || *item.SalePrice > 0, "campaign_items", "must have a positive sale_price")
+		v.Check(item.DiscountPercent == nil || (*item.DiscountPercent >= 1 && *item.DiscountPercent <= 99), "campaign_items", "must have a discount_percent between 1 and 99")
+		v.Check(item.PerUserLimit >= 0, "campaign_items", "must not have a negative per_user_limit")
+		v.Check(item.Quota > 0, "campaign_items", "must have a positive quota")
+
+		seen[item.ProductDetailID] = true
+	}
+}
+
+var CampaignSortSafeList = SortSafeList("id", "name", "starts_at", "ends_at", "created_at")
+
+var CampaignFilterSafeList = map[string]FilterField{
+	"name":              {Column: "campaigns.name", Type: FilterString, Operators: TextOperators},
+	"is_active":         {Column: "campaigns.is_active", Type: FilterBool, Operators: BoolOperators},
+	"starts_at":         {Column: "campaigns.starts_at", Type: FilterTime, Operators: RangeOperators},
+	"ends_at":           {Column: "campaigns.ends_at", Type: FilterTime, Operators: RangeOperators},
+	"product_detail_id": {Column: "campaign_items.product_detail_id", Type: FilterInt, Operators: EqualityOperators, Exists: "SELECT 1 FROM campaign_items WHERE campaign_items.campaign_id = campaigns.id"},
+}
+
+type CampaignModel struct {
+	DB *gorm.DB
+}
+
+// salePriceQuery finds the lowest price a variant is on sale for right now. Items whose
+// quota is sold out, or whose price is not below the regular price, are skipped.
+const salePriceQuery = `
+	LEFT JOIN LATERAL (
+		SELECT campaign_items.id AS campaign_item_id, campaign_items.campaign_id, campaigns.ends_at AS sale_ends_at,
+			COALESCE(campaign_items.sale_price, product_details.price - product_details.price * campaign_items.discount_percent / 100) AS sale_price
+		FROM campaign_items
+		JOIN campaigns ON campaigns.id = campaign_items.campaign_id
+		WHERE campaign_items.product_detail_id = product_details.id
+		AND campaigns.is_active AND campaigns.starts_at <= @now AND campaigns.ends_at > @now
+		AND campaign_items.sold < campaign_items.quota
+		AND COALESCE(campaign_items.sale_price, product_details.price - product_details.price * campaign_items.discount_percent / 100) < product_details.price
+		ORDER BY sale_price, campaign_items.id
+		LIMIT 1
+	) sale ON TRUE`
+
+// withSalePrice adds the campaign price of every variant that is on sale, so listings,
+// carts and checkout all charge the same price.
+func withSalePrice(db *gorm.DB) *gorm.DB {
+	return db.Select("product_details.*, sale.sale_price, sale.sale_ends_at, sale.campaign_id, sale.campaign_item_id").
+		Joins(salePriceQuery, map[string]interface{}{"now": time.Now()})
+}
+
+// releaseCampaignQuota gives the units of an unpaid order back to the quota of the
+// campaigns that priced them.
+func releaseCampaignQuota(orderID int64, tx *gorm.DB) error {
+	return tx.Exec(`
+		UPDATE campaign_items
+		SET sold = campaign_items.sold - released.quantity
+		FROM (
+			SELECT invoice_details.campaign_item_id, SUM(invoice_details.quantity) AS quantity
+			FROM invoice_details
+			JOIN order_details ON order_details.id = invoice_details.order_detail_id
+			WHERE order_details.order_id = ? AND invoice_details.campaign_item_id IS NOT NULL
+			GROUP BY invoice_details.campaign_item_id
+		) released
+		WHERE campaign_items.id = released.campaign_item_id`, orderID).Error
+}
+
+// campaignItemError translates the constraint violations of a campaign item.
+func campaignItemError(err error) error {
+	switch {
+	case err.Error() == `pq: new row for relation "campaign_items" violates check constraint "campaign_items_sold_check"`:
+		return ErrQuotaBelowSold
+	case err.Error() == `pq: insert or update on table "campaign_items" violates foreign key constraint "campaign_items_product_detail_id_fkey"`:
+		return ErrUnknownProductDetail
+	default:
+		return err
+	}
+}
+
+// ====================================================================================
+// Backoffice Functions
+// ====================================================================================
+
+func (m CampaignModel) GetAll(f Filters) ([]*Campaign, Metadata, error) {
+	var campaigns []*Campaign
+	var count int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Scopes(Filter(f), OrderBy(f), Paginate(f.Pagination())).Preload("CampaignItem").Find(&campaigns).Error
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	err = m.DB.Table("campaigns").Scopes(Filter(f)).Count(&count).Error
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(int(count), f.Page, f.PageSize)
+
+	return campaigns, metadata, nil
+}
+
+func (m CampaignModel) Get(id int64) (*Campaign, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	var campaign *Campaign
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Preload("CampaignItem", func(db *gorm.DB) *gorm.DB {
+		return db.Order("campaign_items.id")
+	}).Preload("CampaignItem.ProductDetail").First(&campaign, id).Error
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return campaign, nil
+}
+
+func (m CampaignModel) Insert(campaign *Campaign) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Omit(clause.Associations).Create(campaign).Error
+		if err != nil {
+			return err
+		}
+
+		for i := range campaign.CampaignItem {
+			campaign.CampaignItem[i].CampaignID = campaign.ID
+		}
+
+		err = tx.Omit("ProductDetail").Create(&campaign.CampaignItem).Error
+		if err != nil {
+			return campaignItemError(err)
+		}
+
+		return nil
+	})
+}
+
+// Update saves a campaign and replaces its items. Items of variants that stay in the
+// campaign keep the quantity already sold, so the quota may not go below it.
+func (m CampaignModel) Update(campaign *Campaign) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Campaign{}).Where("id = ?", campaign.ID).Updates(map[string]interface{}{
+			"name":       campaign.Name,
+			"starts_at":  campaign.StartsAt,
+			"ends_at":    campaign.EndsAt,
+			"is_active":  campaign.IsActive,
+			"updated_by": campaign.UpdatedBy,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return ErrEditConflict
+		}
+
+		productDetailIDs := make([]int64, len(campaign.CampaignItem))
+
+		for i := range campaign.CampaignItem {
+			campaign.CampaignItem[i].CampaignID = campaign.ID
+			productDetailIDs[i] = campaign.CampaignItem[i].ProductDetailID
+		}
+
+		err := tx.Where("campaign_id = ? AND product_detail_id NOT IN ?", campaign.ID, productDetailIDs).Delete(&CampaignItem{}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Omit("ProductDetail", "Sold").Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "campaign_id"}, {Name: "product_detail_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"sale_price", "discount_percent", "per_user_limit", "quota", "updated_at"}),
+		}).Create(&campaign.CampaignItem).Error
+		if err != nil {
+			return campaignItemError(err)
+		}
+
+		return tx.Where("campaign_id = ?", campaign.ID).Order("id").Find(&campaign.CampaignItem).Error
+	})
+}
+
+func (m CampaignModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	ra := m.DB.WithContext(ctx).Delete(&Campaign{}, id).RowsAffected
+	if ra < 1 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// ====================================================================================
+// Business Functions
+// ====================================================================================
+
+// ReserveWithTx takes up to quantity units from the quota of a campaign item for a
+// checkout and returns how many it took, checking that the campaign is still running and
+// that the user stays within the limit. Units past the quota left are not taken, the
+// caller sells them at the regular price. The item is locked, so concurrent checkouts of
+// the same sale are counted in turn. Units of orders that expire go back to the quota
+// when the order is settled.
+func (m CampaignModel) ReserveWithTx(campaignItemID int64, userID int64, quantity int, tx *gorm.DB) (int, error) {
+	var item *CampaignItem
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	now := time.Now()
+
+	err := tx.WithContext(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ? AND campaign_id IN (SELECT id FROM campaigns WHERE is_active AND starts_at <= ? AND ends_at > ?)", campaignItemID, now, now).
+		First(&item).Error
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return 0, ErrCampaignUnavailable
+		default:
+			return 0, err
+		}
+	}
+
+	if left := item.Quota - item.Sold; quantity > left {
+		quantity = left
+	}
+
+	if quantity <= 0 {
+		return 0, nil
+	}
+
+	if item.PerUserLimit > 0 {
+		var bought int
+
+		err = tx.WithContext(ctx).Table("invoice_details").
+			Select("COALESCE(SUM(invoice_details.quantity), 0)").
+			Joins("JOIN order_details ON order_details.id = invoice_details.order_detail_id").
+			Joins("JOIN orders ON orders.id = order_details.order_id").
+			Where("invoice_details.campaign_item_id = ? AND orders.user_id = ? AND orders.status <> ?", item.ID, userID, "expired").
+			Scan(&bought).Error
+		if err != nil {
+			return 0, err
+		}
+
+		if bought+quantity > item.PerUserLimit {
+			return 0, ErrCampaignLimitExceeded
+		}
+	}
+
+	err = tx.WithContext(ctx).Model(&CampaignItem{}).Where("id = ?", item.ID).Update("sold", gorm.Expr("sold + ?", quantity)).Error
+	if err != nil {
+		return 0, err
+	}
+
+	return quantity, nil
+}
@@ -16,10 +16,74 @@ type Cart struct {
 	ProductDetail   ProductDetail `json:"product_detail"`
 	ProductDetailID int64         `json:"product_detail_id"`
 	Quantity        int           `json:"quantity"`
+	AddedPrice      int64         `json:"added_price"`
+	SavedForLater   bool          `json:"saved_for_later"`
+	Issues          []CartIssue   `json:"issues" gorm:"-"`
 	CreatedAt       time.Time     `json:"-"`
 	UpdatedAt       time.Time     `json:"-"`
 }
 
+const (
+	CartUnavailable       = "unavailable"
+	CartInsufficientStock = "insufficient_stock"
+	CartPriceChanged      = "price_changed"
+	CartBelowMinimumOrder = "below_minimum_order"
+)
+
+// CartIssue is something that stops a cart line from being checked out as it is, or that
+// the shopper should know before they do.
+type CartIssue struct {
+	Code         string `json:"code"`
+	OldPrice     int64  `json:"old_price,omitempty"`
+	NewPrice     int64  `json:"new_price,omitempty"`
+	Stock        *int   `json:"stock,omitempty"`
+	MinimumOrder int    `json:"minimum_order,omitempty"`
+}
+
+// check sets the issues of a line read with its variant and product. A line whose
+// variant can no longer be bought, or was deleted, has no other issue.
+func (cart *Cart) check(now time.Time) {
+	pd := &cart.ProductDetail
+	product := &pd.Product
+
+	cart.Issues = []CartIssue{}
+
+	if cart.ProductDetailID == 0 || !pd.IsActive || !product.IsActive || !isPublished(product.PublishAt, product.UnpublishAt, now) {
+		cart.Issues = append(cart.Issues, CartIssue{Code: CartUnavailable})
+		return
+	}
+
+	if pd.Stock < cart.Quantity {
+		stock := pd.Stock
+		cart.Issues = append(cart.Issues, CartIssue{Code: CartInsufficientStock, Stock: &stock})
+	}
+
+	if price := pd.EffectivePrice(); price != cart.AddedPrice {
+		cart.Issues = append(cart.Issues, CartIssue{Code: CartPriceChanged, OldPrice: cart.AddedPrice, NewPrice: price})
+	}
+
+	if cart.Quantity < product.MinimumOrder {
+		cart.Issues = append(cart.Issues, CartIssue{Code: CartBelowMinimumOrder, MinimumOrder: product.MinimumOrder})
+	}
+}
+
+// cartPrice returns the price a variant would be put in the cart at.
+func cartPrice(db *gorm.DB, productDetailID int64) (int64, error) {
+	var pd *ProductDetail
+
+	err := db.Scopes(withSalePrice).Where("product_details.id = ?", productDetailID).First(&pd).Error
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return 0, ErrUnknownProductDetail
+		default:
+			return 0, err
+		}
+	}
+
+	return pd.EffectivePrice(), nil
+}
+
 func ValidateCart(v *validator.Validator, cart *Cart) {
 	v.Check(cart.UserID != 0, "user_id", "must be provided")
 	v.Check(cart.UserID > 0, "user_id", "must be a positive integer")
@@ -37,28 +101,58 @@ type CartModel struct {
 // Business Functions
 // ====================================================================================
 
-func (m CartModel) GetAll(p Pagination, user *User) ([]*Cart, Metadata, error) {
+// GetAll returns the lines of the cart of a user, or the lines saved for later, each
+// checked against its variant as it is now.
+func (m CartModel) GetAll(p Pagination, user *User, savedForLater bool) ([]*Cart, Metadata, error) {
 	var carts []*Cart
 	var count int64
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.WithContext(ctx).Scopes(Paginate(p)).Preload("ProductDetail").Where("user_id = ?", user.ID).Order("id").Find(&carts).Error
+	err := m.DB.WithContext(ctx).Scopes(Paginate(p)).Preload("ProductDetail", withSalePrice).Preload("ProductDetail.Product").Where("user_id = ? AND saved_for_later = ?", user.ID, savedForLater).Order("id").Find(&carts).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
-	err = m.DB.Table("carts").Where("user_id = ?", user.ID).Count(&count).Error
+	err = m.DB.Table("carts").Where("user_id = ? AND saved_for_later = ?", user.ID, savedForLater).Count(&count).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
+	now := time.Now()
+
+	for _, cart := range carts {
+		cart.check(now)
+	}
+
 	metadata := calculateMetadata(int(count), p.Page, p.PageSize)
 
 	return carts, metadata, nil
 }
 
+// GetAllWithProducts returns the whole cart of a user but the lines saved for later, with
+// the product of each variant, for evaluating promotions.
+func (m CartModel) GetAllWithProducts(user *User) ([]*Cart, error) {
+	var carts []*Cart
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Preload("ProductDetail", withSalePrice).Preload("ProductDetail.Product").Where("user_id = ? AND NOT saved_for_later", user.ID).Order("id").Find(&carts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	for _, cart := range carts {
+		cart.check(now)
+	}
+
+	return carts, nil
+}
+
 func (m CartModel) Get(id int64, user *User) (*Cart, error) {
 	var cart *Cart
 
@@ -78,11 +172,19 @@ func (m CartModel) Get(id int64, user *User) (*Cart, error) {
 	return cart, nil
 }
 
+// Insert puts a variant in the cart at its current price.
 func (m CartModel) Insert(cart *Cart) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.WithContext(ctx).Create(&cart).Error
+	price, err := cartPrice(m.DB.WithContext(ctx), cart.ProductDetailID)
+	if err != nil {
+		return err
+	}
+
+	cart.AddedPrice = price
+
+	err = m.DB.WithContext(ctx).Create(&cart).Error
 
 	return err
 }
@@ -103,7 +205,15 @@ func (m CartModel) Update(c *Cart) error {
 		}
 	}
 
+	// Editing a line takes the price again, the shopper has seen the new one by then.
+	price, err := cartPrice(m.DB.WithContext(ctx), cart.ProductDetailID)
+	if err != nil {
+		return err
+	}
+
 	cart.Quantity = c.Quantity
+	cart.SavedForLater = c.SavedForLater
+	cart.AddedPrice = price
 
 	err = m.DB.WithContext(ctx).Save(&cart).Error
 	if err != nil {
@@ -115,6 +225,8 @@ func (m CartModel) Update(c *Cart) error {
 		}
 	}
 
+	c.AddedPrice = price
+
 	return nil
 }
 
@@ -0,0 +1,140 @@
+package data
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/kervinch/internal/validator"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// NewestFirst is the cursor key of lists that always show the most recent rows first.
+const NewestFirst = "-created_at"
+
+// cursor is the position after which the next page starts: the sort it was issued for,
+// the value of the sort column and the ID of the last row, which breaks ties between
+// equal values. It is sent to clients base64 encoded so they treat it as opaque.
+type cursor struct {
+	Key   string `json:"k"`
+	Value string `json:"v,omitempty"`
+	ID    int64  `json:"id"`
+}
+
+func encodeCursor(key string, value string, id int64) string {
+	js, _ := json.Marshal(cursor{Key: key, Value: value, ID: id})
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+// decodeCursor rejects cursors that are malformed or were issued for another sort, since
+// the value would be compared against the wrong column.
+func decodeCursor(s string, key string) (cursor, error) {
+	var c cursor
+
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	err = json.Unmarshal(js, &c)
+	if err != nil || c.ID < 1 || c.Key != key {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	return c, nil
+}
+
+func timeCursorValue(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func ValidateCursor(v *validator.Validator, after string, key string) {
+	if after == "" {
+		return
+	}
+
+	_, err := decodeCursor(after, key)
+	v.Check(err == nil, "after", "must be a valid cursor")
+}
+
+func pageSize(p Pagination) int {
+	switch {
+	case p.PageSize > 100:
+		return 100
+	case p.PageSize <= 0:
+		return 10
+	default:
+		return p.PageSize
+	}
+}
+
+// Keyset orders rows by expression and then by idColumn, descending when key starts with
+// a minus. When an ?after= cursor is given it replaces OFFSET with a row comparison
+// against the cursor and fetches one extra row to tell whether there is a next page.
+// Without a cursor it falls back to page numbers, so existing clients keep working.
+func Keyset(p Pagination, key string, expression string, idColumn string, args ...interface{}) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		direction, comparison := "ASC", ">"
+		if strings.HasPrefix(key, "-") {
+			direction, comparison = "DESC", "<"
+		}
+
+		if expression == idColumn {
+			db = db.Order(idColumn + " " + direction)
+		} else {
+			db = db.Clauses(clause.OrderBy{Expression: clause.Expr{SQL: expression + " " + direction + ", " + idColumn + " " + direction, Vars: args}})
+		}
+
+		if p.After == "" {
+			return db.Scopes(Paginate(p))
+		}
+
+		c, err := decodeCursor(p.After, key)
+		if err != nil {
+			db.AddError(err)
+			return db
+		}
+
+		if expression == idColumn {
+			db = db.Where(idColumn+" "+comparison+" ?", c.ID)
+		} else {
+			db = db.Where("("+expression+", "+idColumn+") "+comparison+" (?, ?)", append(append([]interface{}{}, args...), c.Value, c.ID)...)
+		}
+
+		return db.Limit(pageSize(p) + 1)
+	}
+}
+
+// keysetMetadata trims the extra row fetched by Keyset and builds the metadata. In cursor
+// mode only the next cursor is reported, since counting every matching row is what makes
+// deep pages slow. In page mode the usual totals are returned along with a cursor for the
+// following page, so clients can switch to cursors after the first request.
+func keysetMetadata[T any](rows []T, p Pagination, count int64, key string, position func(T) (string, int64)) ([]T, Metadata) {
+	size := pageSize(p)
+
+	if p.After != "" {
+		metadata := Metadata{PageSize: size}
+
+		if len(rows) > size {
+			rows = rows[:size]
+			value, id := position(rows[size-1])
+			metadata.NextCursor = encodeCursor(key, value, id)
+		}
+
+		return rows, metadata
+	}
+
+	metadata := calculateMetadata(int(count), p.Page, size)
+
+	if len(rows) > 0 && int64(p.Page*size) < count {
+		value, id := position(rows[len(rows)-1])
+		metadata.NextCursor = encodeCursor(key, value, id)
+	}
+
+	return rows, metadata
+}
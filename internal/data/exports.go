@@ -0,0 +1,283 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ExportTimeout is kept well under the server write timeout of 30 seconds, so an export
+// that runs too long is cut off by us, while the response can still be aborted, rather
+// than silently truncated by the server.
+const ExportTimeout = 20 * time.Second
+
+// OrderExportRow is an invoice line flattened together with its order detail, order,
+// voucher and user. Orders without lines are exported as a single row.
+type OrderExportRow struct {
+	OrderID             int64
+	OrderCreatedAt      time.Time
+	OrderStatus         string
+	UserID              int64
+	UserName            string
+	UserEmail           string
+	Receiver            string
+	PhoneNumber         string
+	City                string
+	PostalCode          string
+	Address             string
+	OrderSubtotal       int64
+	VoucherCode         string
+	VoucherName         string
+	OrderTotal          int64
+	InvoiceNumber       string
+	Brand               string
+	OrderDetailStatus   string
+	OrderDetailSubtotal int64
+	OrderDetailVoucher  string
+	OrderDetailTotal    int64
+	ProductName         string
+	SKU                 string `gorm:"column:sku"`
+	Color               string
+	Size                string
+	Quantity            int
+	Price               int64
+	LineTotal           int64
+}
+
+var OrderExportHeader = []interface{}{
+	"order_id", "order_created_at", "order_status", "user_id", "user_name", "user_email",
+	"receiver", "phone_number", "city", "postal_code", "address", "order_subtotal",
+	"voucher_code", "voucher_name", "order_total", "invoice_number", "brand",
+	"order_detail_status", "order_detail_subtotal", "order_detail_voucher", "order_detail_total",
+	"product_name", "sku", "color", "size", "quantity", "price", "line_total",
+}
+
+func (r *OrderExportRow) Values() []interface{} {
+	return []interface{}{
+		r.OrderID, r.OrderCreatedAt, r.OrderStatus, r.UserID, r.UserName, r.UserEmail,
+		r.Receiver, r.PhoneNumber, r.City, r.PostalCode, r.Address, r.OrderSubtotal,
+		r.VoucherCode, r.VoucherName, r.OrderTotal, r.InvoiceNumber, r.Brand,
+		r.OrderDetailStatus, r.OrderDetailSubtotal, r.OrderDetailVoucher, r.OrderDetailTotal,
+		r.ProductName, r.SKU, r.Color, r.Size, r.Quantity, r.Price, r.LineTotal,
+	}
+}
+
+type OrderRefundExportRow struct {
+	RefundID          int64
+	RefundCreatedAt   time.Time
+	RefundStatus      string
+	UserID            int64
+	UserName          string
+	UserEmail         string
+	OrderID           int64
+	InvoiceNumber     string
+	Brand             string
+	OrderDetailStatus string
+	OrderDetailTotal  int64
+	Explanation       string
+	ReceiptNumber     string
+	RefundValue       int64
+}
+
+var OrderRefundExportHeader = []interface{}{
+	"refund_id", "refund_created_at", "refund_status", "user_id", "user_name", "user_email",
+	"order_id", "invoice_number", "brand", "order_detail_status", "order_detail_total",
+	"explanation", "receipt_number", "refund_value",
+}
+
+func (r *OrderRefundExportRow) Values() []interface{} {
+	return []interface{}{
+		r.RefundID, r.RefundCreatedAt, r.RefundStatus, r.UserID, r.UserName, r.UserEmail,
+		r.OrderID, r.InvoiceNumber, r.Brand, r.OrderDetailStatus, r.OrderDetailTotal,
+		r.Explanation, r.ReceiptNumber, r.RefundValue,
+	}
+}
+
+// ProductExportRow is a product variant flattened together with its product. Products
+// without variants are exported as a single row.
+type ProductExportRow struct {
+	ProductID        int64
+	Name             string
+	ProductCategory  string
+	Brand            string
+	Condition        string
+	Weight           int
+	MinimumOrder     int
+	PreorderDays     int
+	IsActive         bool
+	ProductCreatedAt time.Time
+	ProductDetailID  int64
+	SKU              string `gorm:"column:sku"`
+	Color            string
+	Size             string
+	Price            int64
+	Stock            int
+	DetailIsActive   bool
+}
+
+var ProductExportHeader = []interface{}{
+	"product_id", "name", "product_category", "brand", "condition", "weight", "minimum_order",
+	"preorder_days", "is_active", "product_created_at", "product_detail_id", "sku", "color",
+	"size", "price", "stock", "detail_is_active",
+}
+
+func (r *ProductExportRow) Values() []interface{} {
+	return []interface{}{
+		r.ProductID, r.Name, r.ProductCategory, r.Brand, r.Condition, r.Weight, r.MinimumOrder,
+		r.PreorderDays, r.IsActive, r.ProductCreatedAt, r.ProductDetailID, r.SKU, r.Color,
+		r.Size, r.Price, r.Stock, r.DetailIsActive,
+	}
+}
+
+// exportOrder sorts an export like the list it was filtered from. The joined rows of a
+// record are kept together by the tiebreak columns.
+func (f Filters) exportOrder(table string, tiebreaks ...string) string {
+	direction := f.sortDirection()
+
+	order := table + "." + f.sortColumn() + " " + direction
+	if f.sortColumn() != "id" {
+		order += ", " + table + ".id " + direction
+	}
+
+	for _, tiebreak := range tiebreaks {
+		order += ", " + tiebreak
+	}
+
+	return order
+}
+
+// stream runs a query and hands its rows to fn one at a time, instead of loading the
+// whole result like Find does.
+func stream[T any](db *gorm.DB, query *gorm.DB, fn func(*T) error) error {
+	rows, err := query.Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row T
+
+		err = db.ScanRows(rows, &row)
+		if err != nil {
+			return err
+		}
+
+		err = fn(&row)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// ====================================================================================
+// Backoffice Functions
+// ====================================================================================
+
+func (m OrderModel) Export(f Filters, fn func(*OrderExportRow) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ExportTimeout)
+	defer cancel()
+
+	query := m.DB.WithContext(ctx).Table("orders").
+		Select(`orders.id AS order_id,
+			orders.created_at AS order_created_at,
+			orders.status AS order_status,
+			orders.user_id,
+			users.name AS user_name,
+			users.email AS user_email,
+			orders.receiver,
+			orders.phone_number,
+			orders.city,
+			orders.postal_code,
+			orders.address,
+			orders.subtotal AS order_subtotal,
+			COALESCE(vouchers.code, '') AS voucher_code,
+			COALESCE(vouchers.name, '') AS voucher_name,
+			orders.total AS order_total,
+			COALESCE(order_details.invoice_number, '') AS invoice_number,
+			COALESCE(brands.name, '') AS brand,
+			COALESCE(order_details.status::text, '') AS order_detail_status,
+			COALESCE(order_details.subtotal, 0) AS order_detail_subtotal,
+			COALESCE(order_detail_vouchers.code, '') AS order_detail_voucher,
+			COALESCE(order_details.total, 0) AS order_detail_total,
+			COALESCE(invoice_details.product_name, '') AS product_name,
+			COALESCE(product_details.sku, '') AS sku,
+			COALESCE(product_details.color, '') AS color,
+			COALESCE(product_details.size, '') AS size,
+			COALESCE(invoice_details.quantity, 0) AS quantity,
+			COALESCE(invoice_details.price, 0) AS price,
+			COALESCE(invoice_details.total, 0) AS line_total`).
+		Joins("JOIN users ON users.id = orders.user_id").
+		Joins("LEFT JOIN vouchers ON vouchers.id = orders.voucher_id").
+		Joins("LEFT JOIN order_details ON order_details.order_id = orders.id").
+		Joins("LEFT JOIN brands ON brands.id = order_details.brand_id").
+		Joins("LEFT JOIN vouchers order_detail_vouchers ON order_detail_vouchers.id = order_details.voucher_id").
+		Joins("LEFT JOIN invoice_details ON invoice_details.order_detail_id = order_details.id").
+		Joins("LEFT JOIN product_details ON product_details.id = invoice_details.product_detail_id").
+		Scopes(Filter(f), FilterJoined(f, "order_details")).
+		Order(f.exportOrder("orders", "order_details.id", "invoice_details.id"))
+
+	return stream(m.DB, query, fn)
+}
+
+func (m OrderRefundModel) Export(f Filters, fn func(*OrderRefundExportRow) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ExportTimeout)
+	defer cancel()
+
+	query := m.DB.WithContext(ctx).Table("order_refunds").
+		Select(`order_refunds.id AS refund_id,
+			order_refunds.created_at AS refund_created_at,
+			order_refunds.status AS refund_status,
+			order_refunds.user_id,
+			users.name AS user_name,
+			users.email AS user_email,
+			order_details.order_id,
+			order_details.invoice_number,
+			brands.name AS brand,
+			order_details.status::text AS order_detail_status,
+			order_details.total AS order_detail_total,
+			order_refunds.explanation,
+			COALESCE(order_refunds.receipt_number, '') AS receipt_number,
+			COALESCE(order_refunds.refund_value, 0) AS refund_value`).
+		Joins("JOIN users ON users.id = order_refunds.user_id").
+		Joins("JOIN order_details ON order_details.id = order_refunds.order_detail_id").
+		Joins("JOIN brands ON brands.id = order_refunds.brand_id").
+		Scopes(Filter(f)).
+		Order(f.exportOrder("order_refunds"))
+
+	return stream(m.DB, query, fn)
+}
+
+func (m ProductModel) Export(f Filters, fn func(*ProductExportRow) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ExportTimeout)
+	defer cancel()
+
+	query := m.DB.WithContext(ctx).Table("products").
+		Select(`products.id AS product_id,
+			products.name,
+			product_categories.name AS product_category,
+			brands.name AS brand,
+			products.condition,
+			products.weight,
+			products.minimum_order,
+			products.preorder_days,
+			products.is_active,
+			products.created_at AS product_created_at,
+			COALESCE(product_details.id, 0) AS product_detail_id,
+			COALESCE(product_details.sku, '') AS sku,
+			COALESCE(product_details.color, '') AS color,
+			COALESCE(product_details.size, '') AS size,
+			COALESCE(product_details.price, 0) AS price,
+			COALESCE(product_details.stock, 0) AS stock,
+			COALESCE(product_details.is_active, FALSE) AS detail_is_active`).
+		Joins("JOIN product_categories ON product_categories.id = products.product_category_id").
+		Joins("JOIN brands ON brands.id = products.brand_id").
+		Joins("LEFT JOIN product_details ON product_details.product_id = products.id").
+		Scopes(Filter(f)).
+		Order(f.exportOrder("products", "product_details.id"))
+
+	return stream(m.DB, query, fn)
+}
@@ -2,11 +2,13 @@ package data
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"time"
 
 	"github.com/kervinch/internal/validator"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Favorite struct {
@@ -15,6 +17,7 @@ type Favorite struct {
 	GormUser        GormUser      `json:"user" gorm:"foreignKey:UserID"`
 	ProductDetail   ProductDetail `json:"product_detail"`
 	ProductDetailID int64         `json:"product_detail_id"`
+	CollectionID    sql.NullInt64 `json:"collection_id"`
 	CreatedAt       time.Time     `json:"-"`
 	UpdatedAt       time.Time     `json:"-"`
 }
@@ -34,6 +37,7 @@ type FavoriteModel struct {
 // Business Functions
 // ====================================================================================
 
+// GetAll returns the default collection of a user, the favorites not in a named one.
 func (m FavoriteModel) GetAll(p Pagination, user *User) ([]*Favorite, Metadata, error) {
 	var favorites []*Favorite
 	var count int64
@@ -41,12 +45,35 @@ func (m FavoriteModel) GetAll(p Pagination, user *User) ([]*Favorite, Metadata,
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.WithContext(ctx).Scopes(Paginate(p)).Preload("ProductDetail").Where("user_id = ?", user.ID).Order("id").Find(&favorites).Error
+	err := m.DB.WithContext(ctx).Scopes(Paginate(p)).Preload("ProductDetail", withSalePrice).Where("user_id = ? AND collection_id IS NULL", user.ID).Order("id").Find(&favorites).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
-	err = m.DB.Table("favorites").Where("user_id = ?", user.ID).Count(&count).Error
+	err = m.DB.Table("favorites").Where("user_id = ? AND collection_id IS NULL", user.ID).Count(&count).Error
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(int(count), p.Page, p.PageSize)
+
+	return favorites, metadata, nil
+}
+
+// GetAllInCollection returns the favorites of a named collection.
+func (m FavoriteModel) GetAllInCollection(p Pagination, collectionID int64) ([]*Favorite, Metadata, error) {
+	var favorites []*Favorite
+	var count int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Scopes(Paginate(p)).Preload("ProductDetail", withSalePrice).Where("collection_id = ?", collectionID).Order("id").Find(&favorites).Error
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	err = m.DB.Table("favorites").Where("collection_id = ?", collectionID).Count(&count).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -107,3 +134,84 @@ func (m FavoriteModel) Delete(id int64, user *User) error {
 
 	return nil
 }
+
+// Move puts a favorite in another collection of its user, or back in the default one
+// when collectionID is not valid.
+func (m FavoriteModel) Move(favorite *Favorite, collectionID sql.NullInt64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Model(&Favorite{}).Where("id = ?", favorite.ID).Update("collection_id", collectionID).Error
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "idx_favorites"`:
+			return ErrDuplicateKeyValue
+		default:
+			return err
+		}
+	}
+
+	favorite.CollectionID = collectionID
+
+	return nil
+}
+
+// MoveToCart adds a favorite to the cart of its user as the variant picked, which must be
+// a variant of the same product, and takes it off the wishlist. A variant already in the
+// cart has its quantity increased, and is brought back if it was saved for later.
+func (m FavoriteModel) MoveToCart(favorite *Favorite, productDetailID int64, quantity int) (*Cart, error) {
+	cart := &Cart{
+		UserID:          favorite.UserID,
+		ProductDetailID: productDetailID,
+		Quantity:        quantity,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var sameProduct int64
+
+		err := tx.Table("product_details AS picked").
+			Joins("JOIN product_details AS favorite ON favorite.product_id = picked.product_id").
+			Where("picked.id = ? AND favorite.id = ?", productDetailID, favorite.ProductDetailID).
+			Count(&sameProduct).Error
+		if err != nil {
+			return err
+		}
+
+		if sameProduct == 0 {
+			return ErrUnknownProductDetail
+		}
+
+		cart.AddedPrice, err = cartPrice(tx, productDetailID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Omit(clause.Associations).Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "user_id"}, {Name: "product_detail_id"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"quantity":        gorm.Expr("carts.quantity + excluded.quantity"),
+				"added_price":     gorm.Expr("excluded.added_price"),
+				"saved_for_later": false,
+			}),
+		}).Create(cart).Error
+		if err != nil {
+			return err
+		}
+
+		// Read the quantity back, as it adds up with what was in the cart already.
+		err = tx.Select("quantity", "saved_for_later").Where("id = ?", cart.ID).Take(cart).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Where("id = ?", favorite.ID).Delete(&Favorite{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return cart, nil
+}
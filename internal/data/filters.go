@@ -1,23 +1,75 @@
 package data
 
 import (
+	"errors"
 	"math"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/kervinch/internal/validator"
 	"golang.org/x/exp/slices"
 )
 
 type Filters struct {
-	Page         int
-	PageSize     int
-	Sort         string
-	SortSafeList []string
+	Page           int
+	PageSize       int
+	Sort           string
+	SortSafeList   []string
+	Conditions     []Condition
+	FilterSafeList map[string]FilterField
+}
+
+// Condition is a single filter[field][operator]=value query string parameter.
+type Condition struct {
+	Field    string
+	Operator string
+	Value    string
+}
+
+const (
+	FilterString = "string"
+	FilterInt    = "int"
+	FilterBool   = "bool"
+	FilterTime   = "time"
+)
+
+// FilterField maps a filter name to the column it compares, the type its value is parsed
+// as and the operators it supports. Columns on a one-to-many relation set Exists to a
+// subquery correlated with the listed row, the comparison is added to its WHERE clause.
+// Enum columns list their Values, as Postgres rejects any other value with an error.
+type FilterField struct {
+	Column    string
+	Type      string
+	Operators []string
+	Exists    string
+	Values    []string
 }
 
+var (
+	EqualityOperators = []string{"eq", "ne", "in"}
+	RangeOperators    = []string{"eq", "gt", "gte", "lt", "lte"}
+	TextOperators     = []string{"eq", "like"}
+	BoolOperators     = []string{"eq"}
+)
+
+var sqlOperators = map[string]string{
+	"eq":   "=",
+	"ne":   "<>",
+	"gt":   ">",
+	"gte":  ">=",
+	"lt":   "<",
+	"lte":  "<=",
+	"in":   "IN",
+	"like": "ILIKE",
+}
+
+var errInvalidFilterValue = errors.New("invalid filter value")
+
 type Pagination struct {
 	Page     int
 	PageSize int
+	After    string
 }
 
 type Sort struct {
@@ -26,11 +78,12 @@ type Sort struct {
 }
 
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
 }
 
 func (f Filters) sortColumn() string {
@@ -79,6 +132,92 @@ func (s Sort) sortColumnAndDirection() string {
 	return sortString
 }
 
+func (f Filters) Pagination() Pagination {
+	return Pagination{Page: f.Page, PageSize: f.PageSize}
+}
+
+// parse converts a query string value to the type of the column.
+func (ff FilterField) parse(value string) (interface{}, error) {
+	switch ff.Type {
+	case FilterInt:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, errInvalidFilterValue
+		}
+		return i, nil
+	case FilterBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, errInvalidFilterValue
+		}
+		return b, nil
+	case FilterTime:
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t, err = time.Parse("2006-01-02", value)
+		}
+		if err != nil {
+			return nil, errInvalidFilterValue
+		}
+		return t, nil
+	default:
+		if len(ff.Values) > 0 && !validator.In(value, ff.Values...) {
+			return nil, errInvalidFilterValue
+		}
+		return value, nil
+	}
+}
+
+// condition returns the SQL comparison for a filter and its argument. A date without a
+// time covers the whole day, so lte and gt compare against the start of the next day.
+func (ff FilterField) condition(c Condition) (string, interface{}, error) {
+	operator := c.Operator
+
+	switch operator {
+	case "in":
+		var values []interface{}
+
+		for _, value := range strings.Split(c.Value, ",") {
+			v, err := ff.parse(strings.TrimSpace(value))
+			if err != nil {
+				return "", nil, err
+			}
+			values = append(values, v)
+		}
+
+		return ff.Column + " IN ?", values, nil
+	case "like":
+		return ff.Column + " ILIKE ?", "%" + escapeLike(c.Value) + "%", nil
+	}
+
+	value, err := ff.parse(c.Value)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if t, ok := value.(time.Time); ok && len(c.Value) == len("2006-01-02") {
+		switch operator {
+		case "lte":
+			operator, value = "lt", t.AddDate(0, 0, 1)
+		case "gt":
+			operator, value = "gte", t.AddDate(0, 0, 1)
+		}
+	}
+
+	return ff.Column + " " + sqlOperators[operator] + " ?", value, nil
+}
+
+// SortSafeList returns the columns together with their descending variants.
+func SortSafeList(columns ...string) []string {
+	safeList := make([]string, 0, len(columns)*2)
+
+	for _, column := range columns {
+		safeList = append(safeList, column, "-"+column)
+	}
+
+	return safeList
+}
+
 func (f Filters) limit() int {
 	return f.PageSize
 }
@@ -107,6 +246,35 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
 	v.Check(validator.In(f.Sort, f.SortSafeList...), "sort", "invalid sort value")
+
+	for _, c := range f.Conditions {
+		key := "filter[" + c.Field + "]"
+
+		field, ok := f.FilterSafeList[c.Field]
+		if !ok {
+			v.AddError(key, "unknown filter")
+			continue
+		}
+
+		if !validator.In(c.Operator, field.Operators...) {
+			v.AddError(key, "unsupported operator "+c.Operator)
+			continue
+		}
+
+		_, _, err := field.condition(c)
+		if err != nil && len(field.Values) > 0 {
+			v.AddError(key, "must be one of "+strings.Join(field.Values, ", "))
+			continue
+		}
+
+		v.Check(err == nil, key, "must be a valid "+field.Type)
+	}
+}
+
+func ValidateSort(v *validator.Validator, s Sort) {
+	for _, value := range s.List {
+		v.Check(validator.In(value, s.SortSafeList...), "sort", "invalid sort value")
+	}
 }
 
 func ValidatePagination(v *validator.Validator, p Pagination) {
@@ -0,0 +1,245 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/kervinch/internal/validator"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GiftCardValidity is how long a gift card bought in the shop can be redeemed.
+const GiftCardValidity = 365 * 24 * time.Hour
+
+// GiftCard is a code worth Value in store credit once redeemed. Cards bought in the shop
+// are issued for every unit of a gift card product paid for, and point back to the
+// invoice detail; the CMS can issue cards by hand too.
+type GiftCard struct {
+	ID              int64         `json:"id"`
+	Code            string        `json:"code"`
+	Value           int64         `json:"value"`
+	InvoiceDetailID sql.NullInt64 `json:"invoice_detail_id"`
+	Unit            int           `json:"-"`
+	PurchasedBy     sql.NullInt64 `json:"purchased_by"`
+	RedeemedBy      sql.NullInt64 `json:"redeemed_by"`
+	RedeemedAt      *time.Time    `json:"redeemed_at"`
+	ExpiresAt       *time.Time    `json:"expires_at"`
+	CreatedBy       sql.NullInt64 `json:"created_by"`
+	CreatedAt       time.Time     `json:"created_at"`
+	UpdatedAt       time.Time     `json:"-"`
+}
+
+func ValidateGiftCard(v *validator.Validator, giftCard *GiftCard) {
+	v.Check(giftCard.Value > 0, "value", "must be a positive integer")
+	v.Check(giftCard.ExpiresAt == nil || giftCard.ExpiresAt.After(time.Now()), "expires_at", "must be in the future")
+}
+
+var GiftCardSortSafeList = SortSafeList("id", "value", "redeemed_at", "expires_at", "created_at")
+
+var GiftCardFilterSafeList = map[string]FilterField{
+	"code":         {Column: "gift_cards.code", Type: FilterString, Operators: TextOperators},
+	"purchased_by": {Column: "gift_cards.purchased_by", Type: FilterInt, Operators: EqualityOperators},
+	"redeemed_by":  {Column: "gift_cards.redeemed_by", Type: FilterInt, Operators: EqualityOperators},
+	"redeemed_at":  {Column: "gift_cards.redeemed_at", Type: FilterTime, Operators: RangeOperators},
+	"expires_at":   {Column: "gift_cards.expires_at", Type: FilterTime, Operators: RangeOperators},
+	"created_at":   {Column: "gift_cards.created_at", Type: FilterTime, Operators: RangeOperators},
+}
+
+// generateGiftCardCode returns a random code of 16 characters, grouped by 4 so it can be
+// typed in from a printed card.
+func generateGiftCardCode() (string, error) {
+	randomBytes := make([]byte, 10)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	code := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+
+	return code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16], nil
+}
+
+// giftCardLines selects the invoice details of gift card products, joined with their order
+// details. Gift cards are worth their price in store credit, so they neither get discounts
+// nor earn points, and are not refunded.
+func giftCardLines(db *gorm.DB) *gorm.DB {
+	return db.Table("invoice_details").
+		Joins("JOIN order_details ON order_details.id = invoice_details.order_detail_id").
+		Joins("JOIN product_details ON product_details.id = invoice_details.product_detail_id").
+		Joins("JOIN products ON products.id = product_details.product_id").
+		Where("products.is_gift_card")
+}
+
+type GiftCardModel struct {
+	DB *gorm.DB
+}
+
+// ====================================================================================
+// Backoffice Functions
+// ====================================================================================
+
+func (m GiftCardModel) GetAll(f Filters) ([]*GiftCard, Metadata, error) {
+	var giftCards []*GiftCard
+	var count int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Scopes(Filter(f), OrderBy(f), Paginate(f.Pagination())).Find(&giftCards).Error
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	err = m.DB.Table("gift_cards").Scopes(Filter(f)).Count(&count).Error
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(int(count), f.Page, f.PageSize)
+
+	return giftCards, metadata, nil
+}
+
+func (m GiftCardModel) Insert(giftCard *GiftCard) error {
+	code, err := generateGiftCardCode()
+	if err != nil {
+		return err
+	}
+
+	giftCard.Code = code
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.WithContext(ctx).Create(giftCard).Error
+}
+
+// ====================================================================================
+// Business Functions
+// ====================================================================================
+
+// IssueForOrder issues a gift card for every unit of a gift card product in a paid order
+// and returns the cards issued. Cards already issued for the order are not issued again,
+// so a payment callback delivered twice issues them once.
+func (m GiftCardModel) IssueForOrder(orderID int64) ([]*GiftCard, error) {
+	var invoiceDetails []*InvoiceDetail
+	var giftCards []*GiftCard
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).
+		Select("invoice_details.*").
+		Joins("JOIN order_details ON order_details.id = invoice_details.order_detail_id").
+		Joins("JOIN product_details ON product_details.id = invoice_details.product_detail_id").
+		Joins("JOIN products ON products.id = product_details.product_id").
+		Where("order_details.order_id = ? AND products.is_gift_card", orderID).
+		Order("invoice_details.id").
+		Find(&invoiceDetails).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if len(invoiceDetails) == 0 {
+		return nil, nil
+	}
+
+	var userID int64
+
+	err = m.DB.WithContext(ctx).Table("orders").Select("user_id").Where("id = ?", orderID).Scan(&userID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(GiftCardValidity)
+
+	for _, invoiceDetail := range invoiceDetails {
+		for unit := 1; unit <= invoiceDetail.Quantity; unit++ {
+			code, err := generateGiftCardCode()
+			if err != nil {
+				return nil, err
+			}
+
+			giftCard := &GiftCard{
+				Code:            code,
+				Value:           invoiceDetail.Price,
+				InvoiceDetailID: sql.NullInt64{Int64: invoiceDetail.ID, Valid: true},
+				Unit:            unit,
+				PurchasedBy:     sql.NullInt64{Int64: userID, Valid: true},
+				ExpiresAt:       &expiresAt,
+			}
+
+			result := m.DB.WithContext(ctx).Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "invoice_detail_id"}, {Name: "unit"}},
+				DoNothing: true,
+			}).Create(giftCard)
+			if result.Error != nil {
+				return nil, result.Error
+			}
+
+			if result.RowsAffected > 0 {
+				giftCards = append(giftCards, giftCard)
+			}
+		}
+	}
+
+	return giftCards, nil
+}
+
+// Redeem adds the value of a gift card to the store credit of a user. A card can only be
+// redeemed once and not after it expires.
+func (m GiftCardModel) Redeem(code string, userID int64) (*WalletEntry, error) {
+	var giftCard *GiftCard
+	var entry *WalletEntry
+
+	err := m.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("code = ?", strings.ToUpper(strings.TrimSpace(code))).First(&giftCard).Error
+		if err != nil {
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		now := time.Now()
+
+		switch {
+		case giftCard.RedeemedAt != nil:
+			return ErrGiftCardRedeemed
+		case giftCard.ExpiresAt != nil && !giftCard.ExpiresAt.After(now):
+			return ErrGiftCardExpired
+		}
+
+		err = tx.Model(&GiftCard{}).Where("id = ?", giftCard.ID).Updates(map[string]interface{}{
+			"redeemed_by": userID,
+			"redeemed_at": now,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		entry = &WalletEntry{
+			UserID:     userID,
+			Type:       WalletCredit,
+			Amount:     giftCard.Value,
+			Reason:     "gift card redeemed",
+			GiftCardID: sql.NullInt64{Int64: giftCard.ID, Valid: true},
+		}
+
+		return WalletModel{DB: tx}.ApplyWithTx(entry, tx)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
@@ -2,6 +2,7 @@ package data
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"time"
 
@@ -10,15 +11,16 @@ import (
 )
 
 type Inbox struct {
-	ID        int64       `json:"id"`
-	Title     string      `json:"title"`
-	Content   string      `json:"content"`
-	ImageURL  string      `json:"image_url"`
-	Deeplink  string      `json:"deeplink"`
-	Slug      string      `json:"slug"`
-	InboxUser []InboxUser `json:"inbox_users"`
-	CreatedAt time.Time   `json:"-"`
-	UpdatedAt time.Time   `json:"-"`
+	ID        int64         `json:"id"`
+	Title     string        `json:"title"`
+	Content   string        `json:"content"`
+	ImageURL  string        `json:"image_url"`
+	Deeplink  string        `json:"deeplink"`
+	Slug      string        `json:"slug"`
+	UserID    sql.NullInt64 `json:"-"`
+	InboxUser []InboxUser   `json:"inbox_users"`
+	CreatedAt time.Time     `json:"-"`
+	UpdatedAt time.Time     `json:"-"`
 }
 
 func ValidateInbox(v *validator.Validator, inbox *Inbox) {
@@ -27,10 +29,25 @@ func ValidateInbox(v *validator.Validator, inbox *Inbox) {
 	v.Check(inbox.Slug != "", "slug", "must be provided")
 }
 
+var InboxSortSafeList = SortSafeList("id", "title", "created_at")
+
+var InboxFilterSafeList = map[string]FilterField{
+	"title":      {Column: "inbox.title", Type: FilterString, Operators: TextOperators},
+	"created_at": {Column: "inbox.created_at", Type: FilterTime, Operators: RangeOperators},
+}
+
 type InboxModel struct {
 	DB *gorm.DB
 }
 
+// visibleTo limits the inbox to the messages sent to everyone and the personal messages,
+// e.g. product alerts, sent to the user.
+func visibleTo(user *User) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("inbox.user_id IS NULL OR inbox.user_id = ?", user.ID)
+	}
+}
+
 func (Inbox) TableName() string {
 	return "inbox"
 }
@@ -39,24 +56,24 @@ func (Inbox) TableName() string {
 // Backoffice Functions
 // ====================================================================================
 
-func (m InboxModel) GetAll(p Pagination) ([]*Inbox, Metadata, error) {
+func (m InboxModel) GetAll(f Filters) ([]*Inbox, Metadata, error) {
 	var inbox []*Inbox
 	var count int64
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.WithContext(ctx).Scopes(Paginate(p)).Order("created_at DESC").Find(&inbox).Error
+	err := m.DB.WithContext(ctx).Where("inbox.user_id IS NULL").Scopes(Filter(f), OrderBy(f), Paginate(f.Pagination())).Find(&inbox).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
-	err = m.DB.Table("inbox").Count(&count).Error
+	err = m.DB.Table("inbox").Where("inbox.user_id IS NULL").Scopes(Filter(f)).Count(&count).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(int(count), p.Page, p.PageSize)
+	metadata := calculateMetadata(int(count), f.Page, f.PageSize)
 
 	return inbox, metadata, nil
 }
@@ -160,23 +177,27 @@ func (m InboxModel) GetAPI(p Pagination, user *User) ([]*Inbox, Metadata, error)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	err := m.DB.WithContext(ctx).Scopes(Paginate(p)).Preload("InboxUser").Order("created_at DESC").Find(&inbox).Error
+	err := m.DB.WithContext(ctx).Scopes(visibleTo(user), Keyset(p, NewestFirst, "created_at", "id")).Preload("InboxUser", "user_id = ?", user.ID).Find(&inbox).Error
 
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
-	err = m.DB.Table("inbox").Count(&count).Error
-	if err != nil {
-		return nil, Metadata{}, err
+	if p.After == "" {
+		err = m.DB.Table("inbox").Scopes(visibleTo(user)).Count(&count).Error
+		if err != nil {
+			return nil, Metadata{}, err
+		}
 	}
 
-	metadata := calculateMetadata(int(count), p.Page, p.PageSize)
+	inbox, metadata := keysetMetadata(inbox, p, count, NewestFirst, func(i *Inbox) (string, int64) {
+		return timeCursorValue(i.CreatedAt), i.ID
+	})
 
 	return inbox, metadata, nil
 }
 
-func (m InboxModel) GetBySlug(slug string) (*Inbox, error) {
+func (m InboxModel) GetBySlug(slug string, user *User) (*Inbox, error) {
 	if slug == "" {
 		return nil, ErrRecordNotFound
 	}
@@ -186,7 +207,7 @@ func (m InboxModel) GetBySlug(slug string) (*Inbox, error) {
 
 	var inbox *Inbox
 
-	err := m.DB.WithContext(ctx).Where("slug = ?", slug).First(&inbox).Error
+	err := m.DB.WithContext(ctx).Scopes(visibleTo(user)).Where("slug = ?", slug).First(&inbox).Error
 	if err != nil {
 		switch {
 		case errors.Is(err, gorm.ErrRecordNotFound):
@@ -90,3 +90,17 @@ func (m InboxUserModel) Get(inboxID int64, user *User) (*InboxUser, error) {
 
 	return inboxUser, nil
 }
+
+func (m InboxUserModel) GetAllForUser(userID int64) ([]*InboxUser, error) {
+	var inboxUsers []*InboxUser
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Preload("Inbox").Where("user_id = ?", userID).Order("created_at DESC").Find(&inboxUsers).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return inboxUsers, nil
+}
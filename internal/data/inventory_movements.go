@@ -0,0 +1,455 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kervinch/internal/validator"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	MovementReceipt     = "receipt"
+	MovementSale        = "sale"
+	MovementReturn      = "return"
+	MovementAdjustment  = "adjustment"
+	MovementReservation = "reservation"
+)
+
+// InventoryMovement is an entry of the stock ledger. Quantity is signed, so the stock of
+// a variant is the sum of its movements, and StockAfter records the stock right after the
+// movement was applied.
+type InventoryMovement struct {
+	ID              int64         `json:"id"`
+	ProductDetailID int64         `json:"product_detail_id"`
+	SKU             string        `json:"sku"`
+	Type            string        `json:"type"`
+	Quantity        int           `json:"quantity"`
+	StockAfter      int           `json:"stock_after"`
+	Reason          string        `json:"reason"`
+	OrderDetailID   sql.NullInt64 `json:"order_detail_id"`
+	CreatedBy       sql.NullInt64 `json:"created_by"`
+	CreatedAt       time.Time     `json:"created_at"`
+}
+
+// InventoryAdjustment is a line of a batch adjustment made from the CMS. A line may only
+// change the low stock threshold of a variant, in which case Quantity is zero.
+type InventoryAdjustment struct {
+	SKU               string `json:"sku"`
+	Type              string `json:"type"`
+	Quantity          int    `json:"quantity"`
+	Reason            string `json:"reason"`
+	LowStockThreshold *int   `json:"low_stock_threshold"`
+}
+
+// LowStockAlert is a variant whose stock just fell to its low stock threshold.
+type LowStockAlert struct {
+	ProductDetailID int64  `json:"product_detail_id"`
+	SKU             string `json:"sku"`
+	ProductName     string `json:"product_name"`
+	Color           string `json:"color"`
+	Size            string `json:"size"`
+	Stock           int    `json:"stock"`
+	Threshold       int    `json:"threshold"`
+}
+
+// AdjustmentError tells which line of a batch adjustment could not be applied.
+type AdjustmentError struct {
+	Index int
+	Err   error
+}
+
+func (e *AdjustmentError) Error() string {
+	return fmt.Sprintf("adjustment %d: %s", e.Index, e.Err)
+}
+
+func (e *AdjustmentError) Unwrap() error {
+	return e.Err
+}
+
+// InventoryDiscrepancy is a variant whose stock does not add up to its ledger.
+type InventoryDiscrepancy struct {
+	ProductDetailID int64  `json:"product_detail_id"`
+	SKU             string `json:"sku"`
+	Stock           int    `json:"stock"`
+	LedgerStock     int    `json:"ledger_stock"`
+}
+
+func ValidateInventoryAdjustments(v *validator.Validator, adjustments []InventoryAdjustment) {
+	v.Check(len(adjustments) > 0, "adjustments", "must contain at least one adjustment")
+	v.Check(len(adjustments) <= 500, "adjustments", "must not contain more than 500 adjustments")
+
+	skus := make([]string, len(adjustments))
+
+	for i, adjustment := range adjustments {
+		key := fmt.Sprintf("adjustments[%d].", i)
+
+		v.Check(adjustment.SKU != "", key+"sku", "must be provided")
+		v.Check(validator.In(adjustment.Type, MovementReceipt, MovementAdjustment), key+"type", "must be either receipt or adjustment")
+		v.Check(adjustment.Quantity != 0 || adjustment.LowStockThreshold != nil, key+"quantity", "must not be zero")
+		v.Check(adjustment.Type != MovementReceipt || adjustment.Quantity >= 0, key+"quantity", "must not be negative for a receipt")
+		v.Check(len(adjustment.Reason) <= 500, key+"reason", "must not be more than 500 bytes long")
+
+		if adjustment.LowStockThreshold != nil {
+			v.Check(*adjustment.LowStockThreshold >= 0, key+"low_stock_threshold", "must not be negative")
+		}
+
+		skus[i] = adjustment.SKU
+	}
+
+	v.Check(validator.Unique(skus), "adjustments", "must not contain the same sku twice")
+}
+
+var InventoryMovementSortSafeList = SortSafeList("id", "quantity", "created_at")
+
+var InventoryMovementFilterSafeList = map[string]FilterField{
+	"type":            {Column: "inventory_movements.type", Type: FilterString, Operators: EqualityOperators, Values: []string{MovementReceipt, MovementSale, MovementReturn, MovementAdjustment, MovementReservation}},
+	"order_detail_id": {Column: "inventory_movements.order_detail_id", Type: FilterInt, Operators: EqualityOperators},
+	"created_by":      {Column: "inventory_movements.created_by", Type: FilterInt, Operators: EqualityOperators},
+	"created_at":      {Column: "inventory_movements.created_at", Type: FilterTime, Operators: RangeOperators},
+}
+
+type InventoryMovementModel struct {
+	DB *gorm.DB
+}
+
+// ====================================================================================
+// Backoffice Functions
+// ====================================================================================
+
+// GetAllForSKU lists the movements of the variant that currently has the SKU, including
+// those recorded before its SKU was edited.
+func (m InventoryMovementModel) GetAllForSKU(sku string, f Filters) ([]*InventoryMovement, Metadata, error) {
+	var movements []*InventoryMovement
+	var count int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Where("product_detail_id IN (SELECT id FROM product_details WHERE sku = ?)", sku).Scopes(Filter(f), OrderBy(f), Paginate(f.Pagination())).Find(&movements).Error
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	err = m.DB.Table("inventory_movements").Where("product_detail_id IN (SELECT id FROM product_details WHERE sku = ?)", sku).Scopes(Filter(f)).Count(&count).Error
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(int(count), f.Page, f.PageSize)
+
+	return movements, metadata, nil
+}
+
+// Adjust applies a batch of adjustments in a single transaction, so either every line is
+// recorded or none is. Each SKU must match exactly one variant, a line that cannot be
+// applied is reported as an AdjustmentError.
+func (m InventoryMovementModel) Adjust(adjustments []InventoryAdjustment, userID int64) ([]*InventoryMovement, []*LowStockAlert, error) {
+	var movements []*InventoryMovement
+	var alerts []*LowStockAlert
+
+	err := m.DB.Transaction(func(tx *gorm.DB) error {
+		for i, adjustment := range adjustments {
+			var ids []int64
+
+			err := tx.Table("product_details").Where("sku = ?", adjustment.SKU).Limit(2).Pluck("id", &ids).Error
+			if err != nil {
+				return err
+			}
+
+			switch len(ids) {
+			case 0:
+				return &AdjustmentError{Index: i, Err: ErrRecordNotFound}
+			case 2:
+				return &AdjustmentError{Index: i, Err: ErrAmbiguousSKU}
+			}
+
+			if adjustment.LowStockThreshold != nil {
+				err = tx.Model(&ProductDetail{}).Where("id = ?", ids[0]).Update("low_stock_threshold", *adjustment.LowStockThreshold).Error
+				if err != nil {
+					return err
+				}
+			}
+
+			if adjustment.Quantity == 0 {
+				continue
+			}
+
+			movement := &InventoryMovement{
+				ProductDetailID: ids[0],
+				Type:            adjustment.Type,
+				Quantity:        adjustment.Quantity,
+				Reason:          adjustment.Reason,
+				CreatedBy:       sql.NullInt64{Int64: userID, Valid: true},
+			}
+
+			alert, err := m.ApplyWithTx(movement, tx)
+			if err != nil {
+				if errors.Is(err, ErrOutOfStock) {
+					return &AdjustmentError{Index: i, Err: err}
+				}
+				return err
+			}
+
+			movements = append(movements, movement)
+
+			if alert != nil {
+				alerts = append(alerts, alert)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return movements, alerts, nil
+}
+
+// GetDiscrepancies lists the variants whose stock differs from the sum of their ledger,
+// which means stock was changed without recording a movement.
+func (m InventoryMovementModel) GetDiscrepancies() ([]*InventoryDiscrepancy, error) {
+	var discrepancies []*InventoryDiscrepancy
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Table("product_details").
+		Select("product_details.id AS product_detail_id, COALESCE(product_details.sku, '') AS sku, product_details.stock, COALESCE(SUM(inventory_movements.quantity), 0) AS ledger_stock").
+		Joins("LEFT JOIN inventory_movements ON inventory_movements.product_detail_id = product_details.id").
+		Group("product_details.id").
+		Having("product_details.stock <> COALESCE(SUM(inventory_movements.quantity), 0)").
+		Order("product_details.id").
+		Scan(&discrepancies).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return discrepancies, nil
+}
+
+// ====================================================================================
+// Business Functions
+// ====================================================================================
+
+// ApplyWithTx changes the stock of a variant by the quantity of the movement and records
+// it. The variant is locked until the transaction ends, so concurrent movements cannot
+// oversell. An alert is returned when the movement takes the stock down to the low stock
+// threshold of the variant.
+func (m InventoryMovementModel) ApplyWithTx(movement *InventoryMovement, tx *gorm.DB) (*LowStockAlert, error) {
+	var productDetail *ProductDetail
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := tx.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).First(&productDetail, movement.ProductDetailID).Error
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	previous := productDetail.Stock
+
+	stock := previous + movement.Quantity
+	if stock < 0 {
+		return nil, ErrOutOfStock
+	}
+
+	err = tx.WithContext(ctx).Model(productDetail).Update("stock", stock).Error
+	if err != nil {
+		return nil, err
+	}
+
+	movement.SKU = productDetail.SKU
+	movement.StockAfter = stock
+
+	err = tx.WithContext(ctx).Create(movement).Error
+	if err != nil {
+		return nil, err
+	}
+
+	threshold := productDetail.LowStockThreshold
+	if previous <= threshold || stock > threshold {
+		return nil, nil
+	}
+
+	alert := &LowStockAlert{
+		ProductDetailID: productDetail.ID,
+		SKU:             productDetail.SKU,
+		Color:           productDetail.Color,
+		Size:            productDetail.Size,
+		Stock:           stock,
+		Threshold:       threshold,
+	}
+
+	err = tx.WithContext(ctx).Table("products").Where("id = ?", productDetail.ProductID).Pluck("name", &alert.ProductName).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return alert, nil
+}
+
+// InsertWithTx records a movement whose change of stock was already saved, such as the
+// initial stock of a new variant.
+func (m InventoryMovementModel) InsertWithTx(movement *InventoryMovement, tx *gorm.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return tx.WithContext(ctx).Create(movement).Error
+}
+
+// ReserveWithTx holds stock for an invoice line until its order is paid or expires.
+func (m InventoryMovementModel) ReserveWithTx(orderDetailID int64, productDetailID int64, quantity int, tx *gorm.DB) (*LowStockAlert, error) {
+	return m.ApplyWithTx(&InventoryMovement{
+		ProductDetailID: productDetailID,
+		Type:            MovementReservation,
+		Quantity:        -quantity,
+		Reason:          "order placed",
+		OrderDetailID:   sql.NullInt64{Int64: orderDetailID, Valid: true},
+	}, tx)
+}
+
+// SettleOrder releases the stock reserved by an order once its invoice is paid or expires.
+// An expired order also gives its units back to the quota of the campaigns that priced them
+// and the store credit spent on it back to the user.
+// A paid order turns each reservation into a sale, so the stock stays the same but the
+// ledger shows why. Payment callbacks may be delivered more than once, an order that was
+// already settled is left alone; the order row is locked so duplicates arriving together
+// are settled one after the other. An order released before it got to its invoice, e.g.
+// by a failed checkout, is marked expired.
+func (m InventoryMovementModel) SettleOrder(orderID int64, sold bool) error {
+	return m.DB.Transaction(func(tx *gorm.DB) error {
+		var reservations []*InventoryMovement
+		var settled int64
+		var order Order
+
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").Where("id = ?", orderID).First(&order).Error
+		if err != nil {
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		if !sold {
+			err = tx.Model(&Order{}).Where("id = ? AND status = ?", orderID, "awaiting_payment").Update("status", "expired").Error
+			if err != nil {
+				return err
+			}
+		}
+
+		err = tx.Table("inventory_movements").
+			Joins("JOIN order_details ON order_details.id = inventory_movements.order_detail_id").
+			Where("order_details.order_id = ?", orderID).
+			Where("inventory_movements.type = ? OR (inventory_movements.type = ? AND inventory_movements.quantity > 0)", MovementSale, MovementReservation).
+			Count(&settled).Error
+		if err != nil || settled > 0 {
+			return err
+		}
+
+		err = tx.Joins("JOIN order_details ON order_details.id = inventory_movements.order_detail_id").
+			Where("order_details.order_id = ?", orderID).
+			Where("inventory_movements.type = ? AND inventory_movements.quantity < 0", MovementReservation).
+			Order("inventory_movements.id").
+			Find(&reservations).Error
+		if err != nil {
+			return err
+		}
+
+		for _, reservation := range reservations {
+			reason := "invoice expired"
+			if sold {
+				reason = "invoice paid"
+			}
+
+			_, err = m.ApplyWithTx(&InventoryMovement{
+				ProductDetailID: reservation.ProductDetailID,
+				Type:            MovementReservation,
+				Quantity:        -reservation.Quantity,
+				Reason:          reason,
+				OrderDetailID:   reservation.OrderDetailID,
+			}, tx)
+			if err != nil {
+				return err
+			}
+
+			if !sold {
+				continue
+			}
+
+			_, err = m.ApplyWithTx(&InventoryMovement{
+				ProductDetailID: reservation.ProductDetailID,
+				Type:            MovementSale,
+				Quantity:        reservation.Quantity,
+				Reason:          reason,
+				OrderDetailID:   reservation.OrderDetailID,
+			}, tx)
+			if err != nil {
+				return err
+			}
+		}
+
+		if sold || len(reservations) == 0 {
+			return nil
+		}
+
+		err = releaseCampaignQuota(orderID, tx)
+		if err != nil {
+			return err
+		}
+
+		err = restoreStoreCredit(orderID, tx)
+		if err != nil {
+			return err
+		}
+
+		return restoreLoyaltyPoints(orderID, tx)
+	})
+}
+
+// ReturnOrderDetail puts the items sold in an order detail back in stock when they are
+// returned. Only sales recorded in the ledger are returned, and only once.
+func (m InventoryMovementModel) ReturnOrderDetail(orderDetailID int64, userID int64) error {
+	return m.DB.Transaction(func(tx *gorm.DB) error {
+		var sales []*InventoryMovement
+		var returned int64
+
+		err := tx.Table("inventory_movements").Where("order_detail_id = ? AND type = ?", orderDetailID, MovementReturn).Count(&returned).Error
+		if err != nil || returned > 0 {
+			return err
+		}
+
+		err = tx.Where("order_detail_id = ? AND type = ?", orderDetailID, MovementSale).Order("id").Find(&sales).Error
+		if err != nil {
+			return err
+		}
+
+		for _, sale := range sales {
+			_, err = m.ApplyWithTx(&InventoryMovement{
+				ProductDetailID: sale.ProductDetailID,
+				Type:            MovementReturn,
+				Quantity:        -sale.Quantity,
+				Reason:          "order refund returned",
+				OrderDetailID:   sale.OrderDetailID,
+				CreatedBy:       sql.NullInt64{Int64: userID, Valid: true},
+			}, tx)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
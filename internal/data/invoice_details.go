@@ -19,6 +19,8 @@ type InvoiceDetail struct {
 	Quantity        int           `json:"quantity"`
 	Price           int64         `json:"price"`
 	Total           int64         `json:"total"`
+	CampaignID      *int64        `json:"campaign_id"`
+	CampaignItemID  *int64        `json:"-"`
 	CreatedAt       time.Time     `json:"-"`
 	UpdatedAt       time.Time     `json:"-"`
 }
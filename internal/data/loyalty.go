@@ -0,0 +1,602 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/kervinch/internal/validator"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	LoyaltyEarn     = "earn"
+	LoyaltyReversal = "reversal"
+	LoyaltyRedeem   = "redeem"
+	LoyaltyRestore  = "restore"
+	LoyaltyExpiry   = "expiry"
+)
+
+// LoyaltySettings are the earn and burn rates of the loyalty programme. A completed order
+// earns EarnPercent of its total in points, times the multiplier of the tier of the user,
+// and a point is worth PointValue at checkout, where points may pay up to
+// MaxRedeemPercent of an order. Points expire ValidityDays after they are earned.
+type LoyaltySettings struct {
+	ID               bool          `json:"-" gorm:"primaryKey"`
+	EarnPercent      float64       `json:"earn_percent"`
+	PointValue       int64         `json:"point_value"`
+	MaxRedeemPercent int           `json:"max_redeem_percent"`
+	ValidityDays     int           `json:"validity_days"`
+	UpdatedBy        sql.NullInt64 `json:"updated_by"`
+	UpdatedAt        time.Time     `json:"updated_at"`
+}
+
+func (LoyaltySettings) TableName() string {
+	return "loyalty_settings"
+}
+
+// LoyaltyTier is a level of the programme, reached by spending MinSpend on completed
+// orders over the last 12 months. EarnMultiplier is a percentage, 150 earns one and a
+// half times the points.
+type LoyaltyTier struct {
+	ID             int64     `json:"id"`
+	Name           string    `json:"name"`
+	MinSpend       int64     `json:"min_spend"`
+	EarnMultiplier int       `json:"earn_multiplier"`
+	CreatedAt      time.Time `json:"-"`
+	UpdatedAt      time.Time `json:"-"`
+}
+
+func ValidateLoyaltySettings(v *validator.Validator, settings *LoyaltySettings, tiers []LoyaltyTier) {
+	v.Check(settings.EarnPercent >= 0 && settings.EarnPercent <= 100, "earn_percent", "must be between 0 and 100")
+	v.Check(settings.PointValue > 0, "point_value", "must be a positive integer")
+	v.Check(settings.MaxRedeemPercent >= 0 && settings.MaxRedeemPercent <= 100, "max_redeem_percent", "must be between 0 and 100")
+	v.Check(settings.ValidityDays > 0, "validity_days", "must be a positive integer")
+
+	seen := make(map[int64]bool, len(tiers))
+
+	for _, tier := range tiers {
+		v.Check(tier.Name != "", "tiers", "must have a name")
+		v.Check(tier.MinSpend >= 0, "tiers", "must not have a negative min_spend")
+		v.Check(!seen[tier.MinSpend], "tiers", "must not have the same min_spend twice")
+		v.Check(tier.EarnMultiplier > 0, "tiers", "must have a positive earn_multiplier")
+
+		seen[tier.MinSpend] = true
+	}
+}
+
+// LoyaltyEntry is an entry of the points ledger, kept like the store credit ledger.
+type LoyaltyEntry struct {
+	ID           int64         `json:"id"`
+	UserID       int64         `json:"user_id"`
+	Type         string        `json:"type"`
+	Points       int64         `json:"points"`
+	BalanceAfter int64         `json:"balance_after"`
+	Remaining    int64         `json:"remaining"`
+	ExpiresAt    *time.Time    `json:"expires_at"`
+	Reason       string        `json:"reason"`
+	OrderID      sql.NullInt64 `json:"order_id"`
+	CreatedAt    time.Time     `json:"created_at"`
+}
+
+// LoyaltySpend is the part of the points of an entry a redemption or reversal took.
+type LoyaltySpend struct {
+	EntryID  int64
+	SourceID int64
+	Points   int64
+}
+
+// LoyaltySummary is the standing of a user in the programme.
+type LoyaltySummary struct {
+	Points         int64            `json:"points"`
+	PointsValue    int64            `json:"points_value"`
+	Spend          int64            `json:"spend"`
+	Tier           *LoyaltyTier     `json:"tier"`
+	NextTier       *LoyaltyTier     `json:"next_tier"`
+	ExpiringPoints int64            `json:"expiring_points"`
+	ExpiringAt     *time.Time       `json:"expiring_at"`
+	Settings       *LoyaltySettings `json:"settings"`
+}
+
+// tierFor returns the tier reached with spend and the one after it. Tiers must be sorted
+// by MinSpend.
+func tierFor(tiers []*LoyaltyTier, spend int64) (tier *LoyaltyTier, next *LoyaltyTier) {
+	for _, t := range tiers {
+		if t.MinSpend > spend {
+			return tier, t
+		}
+
+		tier = t
+	}
+
+	return tier, nil
+}
+
+type LoyaltyModel struct {
+	DB *gorm.DB
+}
+
+// ====================================================================================
+// Backoffice Functions
+// ====================================================================================
+
+func (m LoyaltyModel) GetSettings() (*LoyaltySettings, error) {
+	var settings *LoyaltySettings
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).First(&settings).Error
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return settings, nil
+}
+
+func (m LoyaltyModel) GetTiers() ([]*LoyaltyTier, error) {
+	var tiers []*LoyaltyTier
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Order("min_spend").Find(&tiers).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return tiers, nil
+}
+
+// UpdateSettings saves the settings of the programme and replaces its tiers.
+func (m LoyaltyModel) UpdateSettings(settings *LoyaltySettings, tiers []LoyaltyTier) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&LoyaltySettings{}).Where("id").Updates(map[string]interface{}{
+			"earn_percent":       settings.EarnPercent,
+			"point_value":        settings.PointValue,
+			"max_redeem_percent": settings.MaxRedeemPercent,
+			"validity_days":      settings.ValidityDays,
+			"updated_by":         settings.UpdatedBy,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where("TRUE").Delete(&LoyaltyTier{}).Error
+		if err != nil {
+			return err
+		}
+
+		if len(tiers) == 0 {
+			return nil
+		}
+
+		return tx.Create(&tiers).Error
+	})
+}
+
+// ====================================================================================
+// Business Functions
+// ====================================================================================
+
+func (m LoyaltyModel) GetSummary(userID int64) (*LoyaltySummary, error) {
+	settings, err := m.GetSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	tiers, err := m.GetTiers()
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &LoyaltySummary{Settings: settings}
+
+	summary.Spend, err = m.spend(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	summary.Tier, summary.NextTier = tierFor(tiers, summary.Spend)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	now := time.Now()
+
+	err = m.DB.WithContext(ctx).Model(&LoyaltyEntry{}).
+		Select("COALESCE(SUM(remaining), 0)").
+		Where("user_id = ? AND remaining > 0 AND (expires_at IS NULL OR expires_at > ?)", userID, now).
+		Scan(&summary.Points).Error
+	if err != nil {
+		return nil, err
+	}
+
+	summary.PointsValue = summary.Points * settings.PointValue
+
+	// The points expiring next, so the app can remind the user to spend them.
+	var expiring struct {
+		ExpiresAt *time.Time
+		Points    int64
+	}
+
+	err = m.DB.WithContext(ctx).Model(&LoyaltyEntry{}).
+		Select("expires_at, SUM(remaining) AS points").
+		Where("user_id = ? AND remaining > 0 AND expires_at > ?", userID, now).
+		Group("expires_at").
+		Order("expires_at").
+		Limit(1).
+		Scan(&expiring).Error
+	if err != nil {
+		return nil, err
+	}
+
+	summary.ExpiringPoints = expiring.Points
+	summary.ExpiringAt = expiring.ExpiresAt
+
+	return summary, nil
+}
+
+func (m LoyaltyModel) GetAllForUser(user *User, p Pagination) ([]*LoyaltyEntry, Metadata, error) {
+	var entries []*LoyaltyEntry
+	var count int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Scopes(Paginate(p)).Where("user_id = ?", user.ID).Order("id DESC").Find(&entries).Error
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	err = m.DB.Table("loyalty_entries").Where("user_id = ?", user.ID).Count(&count).Error
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(int(count), p.Page, p.PageSize)
+
+	return entries, metadata, nil
+}
+
+// spend returns the total of the orders a user completed over the last 12 months.
+func (m LoyaltyModel) spend(userID int64) (int64, error) {
+	var spend int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Table("orders").
+		Select("COALESCE(SUM(total), 0)").
+		Where("user_id = ? AND status = ? AND created_at > ?", userID, "completed", time.Now().AddDate(-1, 0, 0)).
+		Scan(&spend).Error
+	if err != nil {
+		return 0, err
+	}
+
+	return spend, nil
+}
+
+// EarnForOrder credits the points earned by an order being completed, at the rate of the
+// tier the user is in with the order counted. An order earns its points once.
+func (m LoyaltyModel) EarnForOrder(order *Order) error {
+	settings, err := m.GetSettings()
+	if err != nil {
+		return err
+	}
+
+	tiers, err := m.GetTiers()
+	if err != nil {
+		return err
+	}
+
+	return m.DB.Transaction(func(tx *gorm.DB) error {
+		var earned int64
+
+		err := tx.Model(&LoyaltyEntry{}).Where("order_id = ? AND type = ?", order.ID, LoyaltyEarn).Count(&earned).Error
+		if err != nil || earned > 0 {
+			return err
+		}
+
+		// The order is not completed yet, so it is added to the spend of the others.
+		var spend int64
+
+		err = tx.Table("orders").
+			Select("COALESCE(SUM(total), 0)").
+			Where("user_id = ? AND status = ? AND created_at > ? AND id <> ?", order.UserID, "completed", time.Now().AddDate(-1, 0, 0), order.ID).
+			Scan(&spend).Error
+		if err != nil {
+			return err
+		}
+
+		spend += order.Total
+
+		multiplier := 100
+
+		if tier, _ := tierFor(tiers, spend); tier != nil {
+			multiplier = tier.EarnMultiplier
+		}
+
+		// Gift cards earn nothing, they are worth their price in store credit.
+		var giftCards int64
+
+		err = tx.Scopes(giftCardLines).
+			Select("COALESCE(SUM(invoice_details.total), 0)").
+			Where("order_details.order_id = ?", order.ID).
+			Scan(&giftCards).Error
+		if err != nil {
+			return err
+		}
+
+		points := int64(float64(order.Total-giftCards) * settings.EarnPercent / 100 * float64(multiplier) / 100)
+		if points <= 0 {
+			return nil
+		}
+
+		expiresAt := time.Now().AddDate(0, 0, settings.ValidityDays)
+
+		return m.applyWithTx(&LoyaltyEntry{
+			UserID:    order.UserID,
+			Type:      LoyaltyEarn,
+			Points:    points,
+			ExpiresAt: &expiresAt,
+			Reason:    "order completed",
+			OrderID:   sql.NullInt64{Int64: order.ID, Valid: true},
+		}, tx)
+	})
+}
+
+// ReverseForOrder takes back the points earned by an order that was refunded. Points
+// already spent cannot be taken back, so the reversal stops at the balance of the user.
+func (m LoyaltyModel) ReverseForOrder(order *Order) error {
+	return m.DB.Transaction(func(tx *gorm.DB) error {
+		var earn *LoyaltyEntry
+		var reversed int64
+
+		err := tx.Where("order_id = ? AND type = ?", order.ID, LoyaltyEarn).First(&earn).Error
+		if err != nil {
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				return nil
+			default:
+				return err
+			}
+		}
+
+		err = tx.Model(&LoyaltyEntry{}).Where("order_id = ? AND type = ?", order.ID, LoyaltyReversal).Count(&reversed).Error
+		if err != nil || reversed > 0 {
+			return err
+		}
+
+		var balance int64
+
+		err = tx.Model(&LoyaltyEntry{}).
+			Select("COALESCE(SUM(remaining), 0)").
+			Where("user_id = ? AND remaining > 0 AND (expires_at IS NULL OR expires_at > ?)", order.UserID, time.Now()).
+			Scan(&balance).Error
+		if err != nil {
+			return err
+		}
+
+		points := earn.Points
+		if points > balance {
+			points = balance
+		}
+
+		if points == 0 {
+			return nil
+		}
+
+		return m.applyWithTx(&LoyaltyEntry{
+			UserID:  order.UserID,
+			Type:    LoyaltyReversal,
+			Points:  -points,
+			Reason:  "order refunded",
+			OrderID: sql.NullInt64{Int64: order.ID, Valid: true},
+		}, tx)
+	})
+}
+
+// RedeemWithTx spends points on an order at checkout.
+func (m LoyaltyModel) RedeemWithTx(userID int64, orderID int64, points int64, discount int64, tx *gorm.DB) error {
+	err := m.applyWithTx(&LoyaltyEntry{
+		UserID:  userID,
+		Type:    LoyaltyRedeem,
+		Points:  -points,
+		Reason:  "checkout",
+		OrderID: sql.NullInt64{Int64: orderID, Valid: true},
+	}, tx)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return tx.WithContext(ctx).Model(&Order{}).Where("id = ?", orderID).Updates(map[string]interface{}{
+		"loyalty_points":   points,
+		"loyalty_discount": discount,
+	}).Error
+}
+
+// applyWithTx writes an entry to the ledger and updates the points of the user, with the
+// user locked. Redemptions and reversals spend the points that expire first.
+func (m LoyaltyModel) applyWithTx(entry *LoyaltyEntry, tx *gorm.DB) error {
+	var balance int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := tx.WithContext(ctx).Table("users").
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select("loyalty_points").
+		Where("id = ?", entry.UserID).
+		Scan(&balance).Error
+	if err != nil {
+		return err
+	}
+
+	var spends []*LoyaltySpend
+
+	switch entry.Type {
+	case LoyaltyEarn, LoyaltyRestore:
+		entry.Remaining = entry.Points
+	case LoyaltyRedeem, LoyaltyReversal:
+		spends, err = m.spendWithTx(entry.UserID, -entry.Points, tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	balance += entry.Points
+	if balance < 0 {
+		return ErrInsufficientPoints
+	}
+
+	err = tx.WithContext(ctx).Table("users").Where("id = ?", entry.UserID).Update("loyalty_points", balance).Error
+	if err != nil {
+		return err
+	}
+
+	entry.BalanceAfter = balance
+
+	err = tx.WithContext(ctx).Create(entry).Error
+	if err != nil || len(spends) == 0 {
+		return err
+	}
+
+	for _, spend := range spends {
+		spend.EntryID = entry.ID
+	}
+
+	return tx.WithContext(ctx).Create(&spends).Error
+}
+
+// spendWithTx takes points off the remaining part of the points earned by a user, the
+// ones expiring first first, and returns how many were taken from each entry.
+func (m LoyaltyModel) spendWithTx(userID int64, points int64, tx *gorm.DB) ([]*LoyaltySpend, error) {
+	var earned []*LoyaltyEntry
+	var spends []*LoyaltySpend
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := tx.WithContext(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND remaining > 0 AND (expires_at IS NULL OR expires_at > ?)", userID, time.Now()).
+		Order("expires_at NULLS LAST, id").
+		Find(&earned).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range earned {
+		if points == 0 {
+			break
+		}
+
+		spent := entry.Remaining
+		if spent > points {
+			spent = points
+		}
+
+		err = tx.WithContext(ctx).Model(&LoyaltyEntry{}).Where("id = ?", entry.ID).Update("remaining", entry.Remaining-spent).Error
+		if err != nil {
+			return nil, err
+		}
+
+		spends = append(spends, &LoyaltySpend{SourceID: entry.ID, Points: spent})
+		points -= spent
+	}
+
+	if points > 0 {
+		return nil, ErrInsufficientPoints
+	}
+
+	return spends, nil
+}
+
+// Expire writes off the points that reached their expiry without being spent, and
+// returns the number of entries written off.
+func (m LoyaltyModel) Expire() (int, error) {
+	var entries []*LoyaltyEntry
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Where("remaining > 0 AND expires_at <= ?", time.Now()).Order("id").Limit(1000).Find(&entries).Error
+	if err != nil {
+		return 0, err
+	}
+
+	for i, entry := range entries {
+		err = m.DB.Transaction(func(tx *gorm.DB) error {
+			// The points may have been spent since they were read.
+			result := tx.Model(&LoyaltyEntry{}).Where("id = ? AND remaining = ?", entry.ID, entry.Remaining).Update("remaining", 0)
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+
+			return m.applyWithTx(&LoyaltyEntry{
+				UserID: entry.UserID,
+				Type:   LoyaltyExpiry,
+				Points: -entry.Remaining,
+				Reason: "points expired",
+			}, tx)
+		})
+		if err != nil {
+			return i, err
+		}
+	}
+
+	return len(entries), nil
+}
+
+// restoreLoyaltyPoints gives back the points spent on an order whose invoice expired.
+// Each part is restored with the expiry of the points it was spent from, so spending
+// points on an invoice left to expire does not extend their validity.
+func restoreLoyaltyPoints(orderID int64, tx *gorm.DB) error {
+	var sources []struct {
+		UserID    int64
+		OrderID   sql.NullInt64
+		Points    int64
+		ExpiresAt *time.Time
+	}
+
+	err := tx.Table("loyalty_entries AS redemptions").
+		Select("redemptions.user_id, redemptions.order_id, loyalty_spends.points, earned.expires_at").
+		Joins("JOIN loyalty_spends ON loyalty_spends.entry_id = redemptions.id").
+		Joins("JOIN loyalty_entries AS earned ON earned.id = loyalty_spends.source_id").
+		Where("redemptions.order_id = ? AND redemptions.type = ?", orderID, LoyaltyRedeem).
+		Order("redemptions.id, loyalty_spends.source_id").
+		Scan(&sources).Error
+	if err != nil {
+		return err
+	}
+
+	for _, source := range sources {
+		err = LoyaltyModel{DB: tx}.applyWithTx(&LoyaltyEntry{
+			UserID:    source.UserID,
+			Type:      LoyaltyRestore,
+			Points:    source.Points,
+			ExpiresAt: source.ExpiresAt,
+			Reason:    "invoice expired",
+			OrderID:   source.OrderID,
+		}, tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
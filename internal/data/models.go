@@ -8,16 +8,25 @@ import (
 )
 
 var (
-	ErrRecordNotFound    = errors.New("record not found")
-	ErrEditConflict      = errors.New("edit conflict")
-	ErrDuplicateSlug     = errors.New("duplicate slug")
-	ErrDuplicateKeyValue = errors.New("violates unique constraint")
-	ErrInvalidEnum       = errors.New("invalid enum value")
-	ErrBadRequest        = errors.New("bad request")
-	ErrImageFormat       = errors.New("unknown image format")
-	ErrVideoFormat       = errors.New("unknown video format")
-	ErrOutOfStock        = errors.New("out of stock")
-	ErrOutOfQuantity     = errors.New("out of quantity")
+	ErrRecordNotFound        = errors.New("record not found")
+	ErrEditConflict          = errors.New("edit conflict")
+	ErrDuplicateSlug         = errors.New("duplicate slug")
+	ErrDuplicateKeyValue     = errors.New("violates unique constraint")
+	ErrInvalidEnum           = errors.New("invalid enum value")
+	ErrBadRequest            = errors.New("bad request")
+	ErrImageFormat           = errors.New("unknown image format")
+	ErrVideoFormat           = errors.New("unknown video format")
+	ErrOutOfStock            = errors.New("out of stock")
+	ErrOutOfQuantity         = errors.New("out of quantity")
+	ErrAmbiguousSKU          = errors.New("sku matches more than one variant")
+	ErrCampaignUnavailable   = errors.New("campaign price is no longer available")
+	ErrCampaignLimitExceeded = errors.New("campaign limit per user exceeded")
+	ErrQuotaBelowSold        = errors.New("quota is below the quantity sold")
+	ErrUnknownProductDetail  = errors.New("product detail does not exist")
+	ErrInsufficientCredit    = errors.New("insufficient store credit")
+	ErrGiftCardRedeemed      = errors.New("gift card already redeemed")
+	ErrGiftCardExpired       = errors.New("gift card expired")
+	ErrInsufficientPoints    = errors.New("insufficient loyalty points")
 )
 
 type TransactionModel struct {
@@ -25,49 +34,71 @@ type TransactionModel struct {
 }
 
 type Models struct {
-	Movies      MovieModel
-	Permissions PermissionModel
-	Tokens      TokenModel
-	Users       UserModel
-	Banners     BannerModel
+	Movies          MovieModel
+	OAuthIdentities OAuthIdentityModel
+	Permissions     PermissionModel
+	RecoveryCodes   RecoveryCodeModel
+	Tokens          TokenModel
+	Users           UserModel
+	Banners         BannerModel
 }
 
 type Gorm struct {
 	Transaction                    TransactionModel
 	Banners                        GormBannerModel
 	Brands                         BrandModel
+	Campaigns                      CampaignModel
 	Blogs                          BlogModel
 	BlogCategories                 BlogCategoryModel
+	BackInStockSubscriptions       BackInStockSubscriptionModel
 	Carts                          CartModel
 	Favorites                      FavoriteModel
+	GiftCards                      GiftCardModel
 	GormUsers                      GormUserModel
 	Inbox                          InboxModel
 	InboxUsers                     InboxUserModel
+	InventoryMovements             InventoryMovementModel
 	InvoiceDetails                 InvoiceDetailModel
 	Logistics                      LogisticModel
+	Loyalty                        LoyaltyModel
 	Orders                         OrderModel
 	OrderDetails                   OrderDetailModel
+	OrderDiscounts                 OrderDiscountModel
 	OrderRefunds                   OrderRefundModel
 	OrderShippings                 OrderShippingModel
 	Products                       ProductModel
+	ProductAlerts                  ProductAlertModel
 	ProductCategories              ProductCategoryModel
 	ProductDetails                 ProductDetailModel
+	ProductReviews                 ProductReviewModel
 	ProductImages                  ProductImageModel
+	ProductImports                 ProductImportModel
 	ProductVideos                  ProductVideoModel
+	ProductViews                   ProductViewModel
 	ProductStorefrontSubscriptions ProductStorefrontSubscriptionModel
+	Promotions                     PromotionModel
+	Publishing                     PublishingModel
+	Recommendations                RecommendationModel
+	Referrals                      ReferralModel
+	Search                         SearchModel
+	SearchEvents                   SearchEventModel
 	Storefronts                    StorefrontModel
 	UserAddresses                  UserAddressModel
 	UserVouchers                   UserVoucherModel
 	Vouchers                       VoucherModel
+	Wallet                         WalletModel
+	Wishlists                      WishlistModel
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Permissions: PermissionModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Users:       UserModel{DB: db},
-		Banners:     BannerModel{DB: db},
+		Movies:          MovieModel{DB: db},
+		OAuthIdentities: OAuthIdentityModel{DB: db},
+		Permissions:     PermissionModel{DB: db},
+		RecoveryCodes:   RecoveryCodeModel{DB: db},
+		Tokens:          TokenModel{DB: db},
+		Users:           UserModel{DB: db},
+		Banners:         BannerModel{DB: db},
 	}
 }
 
@@ -76,28 +107,46 @@ func GormModels(db *gorm.DB) Gorm {
 		Transaction:                    TransactionModel{DB: db},
 		Banners:                        GormBannerModel{DB: db},
 		Brands:                         BrandModel{DB: db},
+		Campaigns:                      CampaignModel{DB: db},
 		Blogs:                          BlogModel{DB: db},
 		BlogCategories:                 BlogCategoryModel{DB: db},
+		BackInStockSubscriptions:       BackInStockSubscriptionModel{DB: db},
 		Carts:                          CartModel{DB: db},
 		Favorites:                      FavoriteModel{DB: db},
+		GiftCards:                      GiftCardModel{DB: db},
 		GormUsers:                      GormUserModel{DB: db},
 		Inbox:                          InboxModel{DB: db},
 		InboxUsers:                     InboxUserModel{DB: db},
+		InventoryMovements:             InventoryMovementModel{DB: db},
 		InvoiceDetails:                 InvoiceDetailModel{DB: db},
 		Logistics:                      LogisticModel{DB: db},
+		Loyalty:                        LoyaltyModel{DB: db},
 		Orders:                         OrderModel{DB: db},
 		OrderDetails:                   OrderDetailModel{DB: db},
+		OrderDiscounts:                 OrderDiscountModel{DB: db},
 		OrderRefunds:                   OrderRefundModel{DB: db},
 		OrderShippings:                 OrderShippingModel{DB: db},
 		Products:                       ProductModel{DB: db},
+		ProductAlerts:                  ProductAlertModel{DB: db},
 		ProductCategories:              ProductCategoryModel{DB: db},
 		ProductDetails:                 ProductDetailModel{DB: db},
+		ProductReviews:                 ProductReviewModel{DB: db},
 		ProductImages:                  ProductImageModel{DB: db},
+		ProductImports:                 ProductImportModel{DB: db},
 		ProductVideos:                  ProductVideoModel{DB: db},
+		ProductViews:                   ProductViewModel{DB: db},
 		ProductStorefrontSubscriptions: ProductStorefrontSubscriptionModel{DB: db},
+		Promotions:                     PromotionModel{DB: db},
+		Publishing:                     PublishingModel{DB: db},
+		Recommendations:                RecommendationModel{DB: db},
+		Referrals:                      ReferralModel{DB: db},
+		Search:                         SearchModel{DB: db},
+		SearchEvents:                   SearchEventModel{DB: db},
 		Storefronts:                    StorefrontModel{DB: db},
 		UserAddresses:                  UserAddressModel{DB: db},
 		UserVouchers:                   UserVoucherModel{DB: db},
 		Vouchers:                       VoucherModel{DB: db},
+		Wallet:                         WalletModel{DB: db},
+		Wishlists:                      WishlistModel{DB: db},
 	}
 }
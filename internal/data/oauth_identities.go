@@ -0,0 +1,54 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/kervinch/internal/validator"
+)
+
+type OAuthIdentity struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"-"`
+	UpdatedAt time.Time `json:"-"`
+}
+
+func ValidateOAuthIdentity(v *validator.Validator, identity *OAuthIdentity) {
+	v.Check(identity.UserID != 0, "user_id", "must be provided")
+	v.Check(identity.Provider != "", "provider", "must be provided")
+	v.Check(validator.In(identity.Provider, "google", "apple"), "provider", "must be either google or apple")
+	v.Check(identity.Subject != "", "subject", "must be provided")
+}
+
+type OAuthIdentityModel struct {
+	DB *sql.DB
+}
+
+func (m OAuthIdentityModel) Insert(identity *OAuthIdentity) error {
+	query := `
+		INSERT INTO oauth_identities (user_id, provider, subject, email)
+		VALUES ($1, $2, $3, NULLIF($4, ''))
+		RETURNING id, created_at, updated_at`
+
+	args := []interface{}{identity.UserID, identity.Provider, identity.Subject, identity.Email}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&identity.ID, &identity.CreatedAt, &identity.UpdatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "idx_oauth_identities"`:
+			return ErrDuplicateKeyValue
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
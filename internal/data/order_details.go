@@ -25,6 +25,7 @@ type OrderDetail struct {
 	CreatedAt     time.Time       `json:"-"`
 	UpdatedAt     time.Time       `json:"-"`
 	InvoiceDetail []InvoiceDetail `json:"invoice_details"`
+	OrderDiscount []OrderDiscount `json:"discounts"`
 }
 
 func ValidateOrderDetail(v *validator.Validator, orderDetail *OrderDetail) {
@@ -70,7 +71,7 @@ func (m OrderDetailModel) Get(id int64) (*OrderDetail, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.WithContext(ctx).Where("id = ?", id).First(&orderDetail).Error
+	err := m.DB.WithContext(ctx).Preload("OrderDiscount").Where("id = ?", id).First(&orderDetail).Error
 	if err != nil {
 		switch {
 		case errors.Is(err, gorm.ErrRecordNotFound):
@@ -83,6 +84,34 @@ func (m OrderDetailModel) Get(id int64) (*OrderDetail, error) {
 	return orderDetail, nil
 }
 
+// GetRefundableTotal returns the part of the total of an invoice that can be refunded,
+// which is all of it but its gift cards.
+func (m OrderDetailModel) GetRefundableTotal(id int64) (int64, error) {
+	orderDetail, err := m.Get(id)
+	if err != nil {
+		return 0, err
+	}
+
+	var giftCards int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.WithContext(ctx).Scopes(giftCardLines).
+		Select("COALESCE(SUM(invoice_details.total), 0)").
+		Where("order_details.id = ?", id).
+		Scan(&giftCards).Error
+	if err != nil {
+		return 0, err
+	}
+
+	if giftCards > orderDetail.Total {
+		return 0, nil
+	}
+
+	return orderDetail.Total - giftCards, nil
+}
+
 func (m OrderDetailModel) GetAllByBrandID(id int64) ([]*OrderDetail, error) {
 	var orderDetail []*OrderDetail
 
@@ -0,0 +1,51 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// OrderDiscount is a discount a promotion gave an order detail at checkout. The name is
+// copied so the line still reads the same after the promotion is edited or deleted.
+type OrderDiscount struct {
+	ID            int64     `json:"id"`
+	OrderDetailID int64     `json:"order_detail_id"`
+	PromotionID   *int64    `json:"promotion_id"`
+	Name          string    `json:"name"`
+	Value         int64     `json:"value"`
+	CreatedAt     time.Time `json:"-"`
+}
+
+type OrderDiscountModel struct {
+	DB *gorm.DB
+}
+
+// ====================================================================================
+// Business Functions
+// ====================================================================================
+
+func (m OrderDiscountModel) InsertWithTx(orderDetailID int64, discounts []Discount, tx *gorm.DB) error {
+	if len(discounts) == 0 {
+		return nil
+	}
+
+	orderDiscounts := make([]OrderDiscount, len(discounts))
+
+	for i, discount := range discounts {
+		promotionID := discount.PromotionID
+
+		orderDiscounts[i] = OrderDiscount{
+			OrderDetailID: orderDetailID,
+			PromotionID:   &promotionID,
+			Name:          discount.Name,
+			Value:         discount.Value,
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return tx.WithContext(ctx).Create(&orderDiscounts).Error
+}
@@ -25,6 +25,7 @@ type OrderRefund struct {
 	Status        string      `json:"status"`
 	ReceiptNumber string      `json:"receipt_number"`
 	RefundValue   int64       `json:"refund_value"`
+	RefundTo      string      `json:"refund_to" gorm:"default:gateway"`
 	CreatedAt     time.Time   `json:"-"`
 	UpdatedAt     time.Time   `json:"-"`
 }
@@ -35,6 +36,19 @@ func ValidateOrderRefund(v *validator.Validator, orderRefund *OrderRefund) {
 	v.Check(orderRefund.Explanation != "", "explanation", "must be provided")
 }
 
+var OrderRefundSortSafeList = SortSafeList("id", "status", "refund_value", "created_at")
+
+var OrderRefundFilterSafeList = map[string]FilterField{
+	"status":          {Column: "order_refunds.status", Type: FilterString, Operators: EqualityOperators, Values: []string{"processing", "reject_immediately", "refund_immediately", "return", "refund"}},
+	"user_id":         {Column: "order_refunds.user_id", Type: FilterInt, Operators: EqualityOperators},
+	"user_email":      {Column: "users.email", Type: FilterString, Operators: TextOperators, Exists: "SELECT 1 FROM users WHERE users.id = order_refunds.user_id"},
+	"brand_id":        {Column: "order_refunds.brand_id", Type: FilterInt, Operators: EqualityOperators},
+	"order_detail_id": {Column: "order_refunds.order_detail_id", Type: FilterInt, Operators: EqualityOperators},
+	"receipt_number":  {Column: "order_refunds.receipt_number", Type: FilterString, Operators: TextOperators},
+	"refund_value":    {Column: "order_refunds.refund_value", Type: FilterInt, Operators: RangeOperators},
+	"created_at":      {Column: "order_refunds.created_at", Type: FilterTime, Operators: RangeOperators},
+}
+
 type OrderRefundModel struct {
 	DB *gorm.DB
 }
@@ -43,24 +57,24 @@ type OrderRefundModel struct {
 // Backoffice Functions
 // ====================================================================================
 
-func (m OrderRefundModel) GetAll(p Pagination) ([]*OrderRefund, Metadata, error) {
+func (m OrderRefundModel) GetAll(f Filters) ([]*OrderRefund, Metadata, error) {
 	var orderRefund []*OrderRefund
 	var count int64
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.WithContext(ctx).Scopes(Paginate(p)).Preload("OrderDetail.Order.Voucher").Preload("Brand").Preload("GormUser").Order("created_at DESC").Find(&orderRefund).Error
+	err := m.DB.WithContext(ctx).Scopes(Filter(f), OrderBy(f), Paginate(f.Pagination())).Preload("OrderDetail.Order.Voucher").Preload("Brand").Preload("GormUser").Find(&orderRefund).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
-	err = m.DB.Table("order_refunds").Count(&count).Error
+	err = m.DB.Table("order_refunds").Scopes(Filter(f)).Count(&count).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(int(count), p.Page, p.PageSize)
+	metadata := calculateMetadata(int(count), f.Page, f.PageSize)
 
 	return orderRefund, metadata, nil
 }
@@ -107,28 +121,50 @@ func (m OrderRefundModel) GetAPI(p Pagination, user *User) ([]*OrderRefund, Meta
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.WithContext(ctx).Scopes(Paginate(p)).Preload("OrderDetail.Order.Voucher").Preload("Brand").Preload("GormUser").Where("user_id = ?", user.ID).Order("created_at DESC").Find(&orderRefund).Error
+	err := m.DB.WithContext(ctx).Scopes(Keyset(p, NewestFirst, "created_at", "id")).Preload("OrderDetail.Order.Voucher").Preload("Brand").Preload("GormUser").Where("user_id = ?", user.ID).Find(&orderRefund).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
-	err = m.DB.Table("order_refunds").Where("user_id = ?", user.ID).Count(&count).Error
-	if err != nil {
-		return nil, Metadata{}, err
+	if p.After == "" {
+		err = m.DB.Table("order_refunds").Where("user_id = ?", user.ID).Count(&count).Error
+		if err != nil {
+			return nil, Metadata{}, err
+		}
 	}
 
-	metadata := calculateMetadata(int(count), p.Page, p.PageSize)
+	orderRefund, metadata := keysetMetadata(orderRefund, p, count, NewestFirst, func(or *OrderRefund) (string, int64) {
+		return timeCursorValue(or.CreatedAt), or.ID
+	})
 
 	return orderRefund, metadata, nil
 }
 
+func (m OrderRefundModel) GetAllForUser(userID int64) ([]*OrderRefund, error) {
+	var orderRefunds []*OrderRefund
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Preload("Brand").Where("user_id = ?", userID).Order("created_at DESC").Find(&orderRefunds).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return orderRefunds, nil
+}
+
 func (m OrderRefundModel) UpdateStatus(or *OrderRefund, status string) error {
+	return m.UpdateStatusWithTx(or, status, m.DB)
+}
+
+func (m OrderRefundModel) UpdateStatusWithTx(or *OrderRefund, status string, tx *gorm.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	var orderRefund *OrderRefund
 
-	err := m.DB.WithContext(ctx).First(&orderRefund, or.ID).Error
+	err := tx.WithContext(ctx).First(&orderRefund, or.ID).Error
 	if err != nil {
 		switch {
 		case errors.Is(err, gorm.ErrRecordNotFound):
@@ -140,7 +176,7 @@ func (m OrderRefundModel) UpdateStatus(or *OrderRefund, status string) error {
 
 	orderRefund.Status = status
 
-	err = m.DB.WithContext(ctx).Save(&orderRefund).Error
+	err = tx.WithContext(ctx).Save(&orderRefund).Error
 	if err != nil {
 		switch {
 		case errors.Is(err, gorm.ErrRecordNotFound):
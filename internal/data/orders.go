@@ -11,24 +11,30 @@ import (
 )
 
 type Order struct {
-	ID          int64         `json:"id"`
-	UserID      int64         `json:"user_id"`
-	GormUser    GormUser      `json:"user" gorm:"foreignKey:UserID"`
-	Receiver    string        `json:"receiver"`
-	PhoneNumber string        `json:"phone_number"`
-	City        string        `json:"city"`
-	PostalCode  string        `json:"postal_code"`
-	Address     string        `json:"address"`
-	Subtotal    int64         `json:"subtotal"`
-	VoucherID   sql.NullInt64 `json:"voucher_id"`
-	Voucher     Voucher       `json:"voucher"`
-	Total       int64         `json:"total"`
-	Status      string        `json:"status"`
-	CreatedAt   time.Time     `json:"-"`
-	UpdatedAt   time.Time     `json:"-"`
-	OrderDetail []OrderDetail `json:"order_details"`
+	ID              int64         `json:"id"`
+	UserID          int64         `json:"user_id"`
+	GormUser        GormUser      `json:"user" gorm:"foreignKey:UserID"`
+	Receiver        string        `json:"receiver"`
+	PhoneNumber     string        `json:"phone_number"`
+	City            string        `json:"city"`
+	PostalCode      string        `json:"postal_code"`
+	Address         string        `json:"address"`
+	Subtotal        int64         `json:"subtotal"`
+	VoucherID       sql.NullInt64 `json:"voucher_id"`
+	Voucher         Voucher       `json:"voucher"`
+	Total           int64         `json:"total"`
+	StoreCredit     int64         `json:"store_credit"`
+	LoyaltyPoints   int64         `json:"loyalty_points"`
+	LoyaltyDiscount int64         `json:"loyalty_discount"`
+	Status          string        `json:"status"`
+	CreatedAt       time.Time     `json:"-"`
+	UpdatedAt       time.Time     `json:"-"`
+	OrderDetail     []OrderDetail `json:"order_details"`
 }
 
+// OrderStatuses are the values of orders_status_enum.
+var OrderStatuses = []string{"awaiting_payment", "expired", "paid", "pending", "processing", "delivery", "completed", "refund_requested", "refund_rejected", "refund_completed"}
+
 func ValidateOrder(v *validator.Validator, order *Order) {
 	v.Check(order.UserID != 0, "user_id", "must be not be zero")
 	v.Check(order.Receiver != "", "receiver", "must be provided")
@@ -36,7 +42,21 @@ func ValidateOrder(v *validator.Validator, order *Order) {
 	v.Check(order.City != "", "city", "must be provided")
 	v.Check(order.PostalCode != "", "postal_code", "must be provided")
 	v.Check(order.Address != "", "address", "must be provided")
-	v.Check(validator.In(order.Status, "awaiting_payment", "expired", "paid", "pending", "processing", "delivery", "completed", "refund_requested", "refund_rejected", "refund_completed"), "status", "must be valid to enum defined")
+	v.Check(validator.In(order.Status, OrderStatuses...), "status", "must be valid to enum defined")
+}
+
+var OrderSortSafeList = SortSafeList("id", "status", "subtotal", "total", "created_at")
+
+var OrderFilterSafeList = map[string]FilterField{
+	"status":         {Column: "orders.status", Type: FilterString, Operators: EqualityOperators, Values: OrderStatuses},
+	"user_id":        {Column: "orders.user_id", Type: FilterInt, Operators: EqualityOperators},
+	"user_email":     {Column: "users.email", Type: FilterString, Operators: TextOperators, Exists: "SELECT 1 FROM users WHERE users.id = orders.user_id"},
+	"brand_id":       {Column: "order_details.brand_id", Type: FilterInt, Operators: EqualityOperators, Exists: "SELECT 1 FROM order_details WHERE order_details.order_id = orders.id"},
+	"invoice_number": {Column: "order_details.invoice_number", Type: FilterString, Operators: TextOperators, Exists: "SELECT 1 FROM order_details WHERE order_details.order_id = orders.id"},
+	"receiver":       {Column: "orders.receiver", Type: FilterString, Operators: TextOperators},
+	"city":           {Column: "orders.city", Type: FilterString, Operators: TextOperators},
+	"total":          {Column: "orders.total", Type: FilterInt, Operators: RangeOperators},
+	"created_at":     {Column: "orders.created_at", Type: FilterTime, Operators: RangeOperators},
 }
 
 type OrderModel struct {
@@ -47,24 +67,24 @@ type OrderModel struct {
 // Backoffice Functions
 // ====================================================================================
 
-func (m OrderModel) GetAll(p Pagination) ([]*Order, Metadata, error) {
+func (m OrderModel) GetAll(f Filters) ([]*Order, Metadata, error) {
 	var order []*Order
 	var count int64
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.WithContext(ctx).Scopes(Paginate(p)).Preload("Voucher").Preload("GormUser").Order("created_at DESC").Find(&order).Error
+	err := m.DB.WithContext(ctx).Scopes(Filter(f), OrderBy(f), Paginate(f.Pagination())).Preload("Voucher").Preload("GormUser").Find(&order).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
-	err = m.DB.Table("orders").Count(&count).Error
+	err = m.DB.Table("orders").Scopes(Filter(f)).Count(&count).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(int(count), p.Page, p.PageSize)
+	metadata := calculateMetadata(int(count), f.Page, f.PageSize)
 
 	return order, metadata, nil
 }
@@ -131,32 +151,69 @@ func (m OrderModel) GetAPI(p Pagination, userID int64, statusType string) ([]*Or
 	defer cancel()
 
 	if statusType == "" {
-		err := m.DB.WithContext(ctx).Preload("Voucher").Preload("GormUser").Scopes(Paginate(p)).Where("user_id = ?", userID).Order("created_at DESC").Find(&orders).Error
+		err := m.DB.WithContext(ctx).Preload("Voucher").Preload("GormUser").Scopes(Keyset(p, NewestFirst, "created_at", "id")).Where("user_id = ?", userID).Find(&orders).Error
 		if err != nil {
 			return nil, Metadata{}, err
 		}
 
-		err = m.DB.Table("orders").Where("user_id = ?", userID).Count(&count).Error
-		if err != nil {
-			return nil, Metadata{}, err
+		if p.After == "" {
+			err = m.DB.Table("orders").Where("user_id = ?", userID).Count(&count).Error
+			if err != nil {
+				return nil, Metadata{}, err
+			}
 		}
 	} else {
-		err := m.DB.WithContext(ctx).Preload("Voucher").Scopes(Paginate(p)).Where("user_id = ?", userID).Where("status = ?", statusType).Order("created_at DESC").Find(&orders).Error
+		err := m.DB.WithContext(ctx).Preload("Voucher").Scopes(Keyset(p, NewestFirst, "created_at", "id")).Where("user_id = ?", userID).Where("status = ?", statusType).Find(&orders).Error
 		if err != nil {
 			return nil, Metadata{}, err
 		}
 
-		err = m.DB.Table("orders").Where("user_id = ?", userID).Where("status = ?", statusType).Count(&count).Error
-		if err != nil {
-			return nil, Metadata{}, err
+		if p.After == "" {
+			err = m.DB.Table("orders").Where("user_id = ?", userID).Where("status = ?", statusType).Count(&count).Error
+			if err != nil {
+				return nil, Metadata{}, err
+			}
 		}
 	}
 
-	metadata := calculateMetadata(int(count), p.Page, p.PageSize)
+	orders, metadata := keysetMetadata(orders, p, count, NewestFirst, func(o *Order) (string, int64) {
+		return timeCursorValue(o.CreatedAt), o.ID
+	})
 
 	return orders, metadata, nil
 }
 
+func (m OrderModel) GetAllForUser(userID int64) ([]*Order, error) {
+	var orders []*Order
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Preload("Voucher").Preload("OrderDetail.InvoiceDetail").Preload("OrderDetail.OrderDiscount").Where("user_id = ?", userID).Order("created_at DESC").Find(&orders).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
+
+// CountOpenForUser counts orders that are still being paid for, fulfilled or refunded.
+func (m OrderModel) CountOpenForUser(userID int64) (int64, error) {
+	var count int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	statuses := []string{"awaiting_payment", "paid", "pending", "processing", "delivery", "refund_requested"}
+
+	err := m.DB.WithContext(ctx).Table("orders").Where("user_id = ? AND status IN ?", userID, statuses).Count(&count).Error
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
 func (m OrderModel) GetWithTx(id int64, tx *gorm.DB) (*Order, error) {
 	var order *Order
 
@@ -255,6 +312,15 @@ func (m OrderModel) SetTotalWithVoucherAndTx(id int64, subtotal int64, voucherID
 	return nil
 }
 
+// SetStoreCreditWithTx records the store credit spent on an order, which is taken off the
+// amount invoiced.
+func (m OrderModel) SetStoreCreditWithTx(id int64, storeCredit int64, tx *gorm.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return tx.WithContext(ctx).Model(&Order{}).Where("id = ?", id).Update("store_credit", storeCredit).Error
+}
+
 func (m OrderModel) UpdateStatus(id int64, status string) error {
 	var order *Order
 
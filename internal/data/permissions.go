@@ -10,6 +10,10 @@ import (
 
 type Permissions []string
 
+// SensitivePermissions can only be exercised by admins who have enabled two-factor
+// authentication.
+var SensitivePermissions = Permissions{"admins:write", "gift-cards:write", "loyalty:write", "order-refunds:write", "orders:write", "products:write", "referrals:write", "wallet:write"}
+
 func (p Permissions) Include(code string) bool {
 	for i := range p {
 		if code == p[i] {
@@ -28,7 +32,7 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 		SELECT permissions.code
 		FROM permissions
 		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
-		INNER JOIN users ON user_permissions.user_id = users.id
+		INNER JOIN users ON users_permissions.user_id = users.id
 		WHERE users.id = $1`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
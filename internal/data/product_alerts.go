@@ -0,0 +1,236 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/kervinch/internal/validator"
+	"gorm.io/gorm"
+)
+
+const (
+	ProductAlertBackInStock = "back_in_stock"
+	ProductAlertPriceDrop   = "price_drop"
+)
+
+type BackInStockSubscription struct {
+	ID              int64         `json:"id"`
+	UserID          int64         `json:"user_id"`
+	ProductDetailID int64         `json:"product_detail_id"`
+	ProductDetail   ProductDetail `json:"product_detail"`
+	CreatedAt       time.Time     `json:"created_at"`
+}
+
+func ValidateBackInStockSubscription(v *validator.Validator, subscription *BackInStockSubscription) {
+	v.Check(subscription.UserID > 0, "user_id", "must be a positive integer")
+	v.Check(subscription.ProductDetailID != 0, "product_detail_id", "must be provided")
+	v.Check(subscription.ProductDetailID > 0, "product_detail_id", "must be a positive integer")
+}
+
+// ProductAlertEvent is queued by a trigger on product_details whenever a variant comes
+// back in stock or gets cheaper, and is fanned out to users by a background job.
+type ProductAlertEvent struct {
+	ID              int64         `json:"id"`
+	ProductDetailID int64         `json:"product_detail_id"`
+	ProductDetail   ProductDetail `json:"product_detail"`
+	Type            string        `json:"type"`
+	OldPrice        int64         `json:"old_price"`
+	NewPrice        int64         `json:"new_price"`
+	CreatedAt       time.Time     `json:"created_at"`
+	ProcessedAt     *time.Time    `json:"processed_at"`
+	DeferredUntil   *time.Time    `json:"deferred_until"`
+}
+
+type ProductAlertDelivery struct {
+	ID        int64     `json:"id"`
+	EventID   int64     `json:"event_id"`
+	UserID    int64     `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type BackInStockSubscriptionModel struct {
+	DB *gorm.DB
+}
+
+type ProductAlertModel struct {
+	DB *gorm.DB
+}
+
+// ====================================================================================
+// Business Functions
+// ====================================================================================
+
+func (m BackInStockSubscriptionModel) GetAll(p Pagination, user *User) ([]*BackInStockSubscription, Metadata, error) {
+	var subscriptions []*BackInStockSubscription
+	var count int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Scopes(Paginate(p)).Preload("ProductDetail.ProductImage").Where("user_id = ?", user.ID).Order("id DESC").Find(&subscriptions).Error
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	err = m.DB.Table("back_in_stock_subscriptions").Where("user_id = ?", user.ID).Count(&count).Error
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(int(count), p.Page, p.PageSize)
+
+	return subscriptions, metadata, nil
+}
+
+func (m BackInStockSubscriptionModel) Insert(subscription *BackInStockSubscription) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Create(subscription).Error
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "idx_back_in_stock_subscriptions"`:
+			return ErrDuplicateKeyValue
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m BackInStockSubscriptionModel) Delete(id int64, user *User) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result := m.DB.WithContext(ctx).Where("id = ? AND user_id = ?", id, user.ID).Delete(&BackInStockSubscription{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetPending returns the oldest events that were not fanned out yet and are not deferred,
+// with the variant at its current sale price.
+func (m ProductAlertModel) GetPending(limit int) ([]*ProductAlertEvent, error) {
+	var events []*ProductAlertEvent
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).
+		Preload("ProductDetail", withSalePrice).Preload("ProductDetail.Product").Preload("ProductDetail.ProductImage").
+		Where("processed_at IS NULL AND (deferred_until IS NULL OR deferred_until <= ?)", time.Now()).
+		Order("id").Limit(limit).Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// GetRecipients returns the users to notify of an event: the subscribers of a variant
+// back in stock, or the users who favorited a variant that got cheaper. Users who were
+// already alerted about the variant in the last day, or who received perDay alerts in
+// the last day, are skipped so a flurry of edits does not turn into spam. It also counts
+// the users skipped, who can be alerted once their day is over.
+func (m ProductAlertModel) GetRecipients(event *ProductAlertEvent, perDay int) ([]*GormUser, int64, error) {
+	var users []*GormUser
+	var held int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	since := time.Now().Add(-24 * time.Hour)
+
+	query := m.DB.WithContext(ctx).Model(&GormUser{}).Where("users.activated = ?", true)
+
+	switch event.Type {
+	case ProductAlertBackInStock:
+		query = query.Where("EXISTS (SELECT 1 FROM back_in_stock_subscriptions WHERE back_in_stock_subscriptions.user_id = users.id AND back_in_stock_subscriptions.product_detail_id = ?)", event.ProductDetailID)
+	default:
+		query = query.Where("EXISTS (SELECT 1 FROM favorites WHERE favorites.user_id = users.id AND favorites.product_detail_id = ?)", event.ProductDetailID)
+	}
+
+	err := query.Session(&gorm.Session{}).Count(&held).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = query.
+		Where(`NOT EXISTS (SELECT 1 FROM product_alert_deliveries
+			JOIN product_alert_events ON product_alert_events.id = product_alert_deliveries.event_id
+			WHERE product_alert_deliveries.user_id = users.id AND product_alert_events.product_detail_id = ? AND product_alert_deliveries.created_at > ?)`, event.ProductDetailID, since).
+		Where("(SELECT COUNT(*) FROM product_alert_deliveries WHERE product_alert_deliveries.user_id = users.id AND product_alert_deliveries.created_at > ?) < ?", since, perDay).
+		Order("users.id").
+		Find(&users).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	held -= int64(len(users))
+
+	return users, held, nil
+}
+
+// Deliver puts the alert in the inbox of a user and records the delivery. A back in stock
+// subscription only fires once, so it is removed.
+func (m ProductAlertModel) Deliver(event *ProductAlertEvent, inbox *Inbox) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(inbox).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Create(&ProductAlertDelivery{EventID: event.ID, UserID: inbox.UserID.Int64}).Error
+		if err != nil {
+			return err
+		}
+
+		if event.Type == ProductAlertBackInStock {
+			err = tx.Where("user_id = ? AND product_detail_id = ?", inbox.UserID.Int64, event.ProductDetailID).Delete(&BackInStockSubscription{}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Defer leaves an event pending until a later run, for the users who could not be alerted
+// yet.
+func (m ProductAlertModel) Defer(event *ProductAlertEvent, until time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.WithContext(ctx).Model(&ProductAlertEvent{}).Where("id = ?", event.ID).Update("deferred_until", until).Error
+}
+
+func (m ProductAlertModel) MarkProcessed(event *ProductAlertEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result := m.DB.WithContext(ctx).Model(&ProductAlertEvent{}).Where("id = ?", event.ID).Update("processed_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -26,6 +26,13 @@ func ValidateProductCategory(v *validator.Validator, productCategory *ProductCat
 	v.Check(len(productCategory.Name) <= 100, "name", "must not be more than 100 bytes long")
 }
 
+var ProductCategorySortSafeList = SortSafeList("id", "name", "order_number")
+
+var ProductCategoryFilterSafeList = map[string]FilterField{
+	"name":      {Column: "product_categories.name", Type: FilterString, Operators: TextOperators},
+	"is_active": {Column: "product_categories.is_active", Type: FilterBool, Operators: BoolOperators},
+}
+
 type ProductCategoryModel struct {
 	DB *gorm.DB
 }
@@ -34,24 +41,24 @@ type ProductCategoryModel struct {
 // Backoffice Functions
 // ====================================================================================
 
-func (m ProductCategoryModel) GetAll(p Pagination) ([]*ProductCategory, Metadata, error) {
+func (m ProductCategoryModel) GetAll(f Filters) ([]*ProductCategory, Metadata, error) {
 	var productCategories []*ProductCategory
 	var count int64
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.WithContext(ctx).Scopes(Paginate(p)).Find(&productCategories).Error
+	err := m.DB.WithContext(ctx).Scopes(Filter(f), OrderBy(f), Paginate(f.Pagination())).Find(&productCategories).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
-	err = m.DB.Table("product_categories").Count(&count).Error
+	err = m.DB.Table("product_categories").Scopes(Filter(f)).Count(&count).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(int(count), p.Page, p.PageSize)
+	metadata := calculateMetadata(int(count), f.Page, f.PageSize)
 
 	return productCategories, metadata, nil
 }
@@ -200,7 +207,7 @@ func (m ProductCategoryModel) GetBySlugWithProducts(slug string) (*ProductCatego
 
 	var productCategory *ProductCategory
 
-	err := m.DB.WithContext(ctx).Where("is_active = ? AND slug = ?", true, slug).Preload("Product.ProductCategory").Preload("Product.Brand").Preload("Product.Storefront").Preload("Product.ProductDetail.ProductImage").First(&productCategory).Error
+	err := m.DB.WithContext(ctx).Where("is_active = ? AND slug = ?", true, slug).Preload("Product", listedProducts).Preload("Product.ProductCategory").Preload("Product.Brand").Preload("Product.Storefront").Preload("Product.ProductDetail", withSalePrice).Preload("Product.ProductDetail.ProductImage").First(&productCategory).Error
 	if err != nil {
 		switch {
 		case errors.Is(err, gorm.ErrRecordNotFound):
@@ -7,21 +7,49 @@ import (
 
 	"github.com/kervinch/internal/validator"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type ProductDetail struct {
-	ID           int64          `json:"id"`
-	Product      Product        `json:"-"`
-	ProductID    int64          `json:"product_id"`
-	Color        string         `json:"color"`
-	Size         string         `json:"size"`
-	Price        int64          `json:"price"`
-	SKU          string         `json:"sku"`
-	Stock        int            `json:"stock"`
-	IsActive     bool           `json:"is_active"`
-	ProductImage []ProductImage `json:"product_images"`
-	CreatedAt    time.Time      `json:"-"`
-	UpdatedAt    time.Time      `json:"-"`
+	ID                int64          `json:"id"`
+	Product           Product        `json:"-"`
+	ProductID         int64          `json:"product_id"`
+	Color             string         `json:"color"`
+	Size              string         `json:"size"`
+	Price             int64          `json:"price"`
+	SKU               string         `json:"sku"`
+	Stock             int            `json:"stock"`
+	LowStockThreshold int            `json:"low_stock_threshold" gorm:"default:5"`
+	IsActive          bool           `json:"is_active"`
+	ProductImage      []ProductImage `json:"product_images"`
+	SalePrice         *int64         `json:"sale_price" gorm:"->"`
+	SaleEndsAt        *time.Time     `json:"sale_ends_at" gorm:"->"`
+	CampaignID        *int64         `json:"campaign_id" gorm:"->"`
+	CampaignItemID    *int64         `json:"-" gorm:"->"`
+	CreatedAt         time.Time      `json:"-"`
+	UpdatedAt         time.Time      `json:"-"`
+}
+
+// EffectivePrice is the price a variant sells for, its campaign price while it is on
+// sale. The sale fields are only loaded by queries using withSalePrice.
+func (productDetail *ProductDetail) EffectivePrice() int64 {
+	if productDetail.SalePrice != nil {
+		return *productDetail.SalePrice
+	}
+
+	return productDetail.Price
+}
+
+// stockMovement records a change of stock made by editing a variant directly.
+func stockMovement(productDetail *ProductDetail, movementType string, quantity int, reason string) *InventoryMovement {
+	return &InventoryMovement{
+		ProductDetailID: productDetail.ID,
+		SKU:             productDetail.SKU,
+		Type:            movementType,
+		Quantity:        quantity,
+		StockAfter:      productDetail.Stock,
+		Reason:          reason,
+	}
 }
 
 func ValidateProductDetail(v *validator.Validator, productDetail *ProductDetail) {
@@ -71,7 +99,7 @@ func (m ProductDetailModel) Get(id int64) (*ProductDetail, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.WithContext(ctx).Preload("Product").Preload("ProductImage").First(&productDetail, id).Error
+	err := m.DB.WithContext(ctx).Scopes(withSalePrice).Preload("Product").Preload("ProductImage").First(&productDetail, id).Error
 	if err != nil {
 		switch {
 		case errors.Is(err, gorm.ErrRecordNotFound):
@@ -89,6 +117,11 @@ func (m ProductDetailModel) Insert(productDetail *ProductDetail) (int64, error)
 	defer cancel()
 
 	err := m.DB.WithContext(ctx).Create(&productDetail).Error
+	if err != nil {
+		return 0, err
+	}
+
+	err = m.DB.WithContext(ctx).Create(stockMovement(productDetail, MovementReceipt, productDetail.Stock, "variant created")).Error
 
 	productDetailID := productDetail.ID
 
@@ -100,6 +133,11 @@ func (m ProductDetailModel) InsertWithTx(productDetail *ProductDetail, tx *gorm.
 	defer cancel()
 
 	err := tx.WithContext(ctx).Create(&productDetail).Error
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.WithContext(ctx).Create(stockMovement(productDetail, MovementReceipt, productDetail.Stock, "variant created")).Error
 
 	productDetailID := productDetail.ID
 
@@ -107,49 +145,21 @@ func (m ProductDetailModel) InsertWithTx(productDetail *ProductDetail, tx *gorm.
 }
 
 func (m ProductDetailModel) Update(p *ProductDetail) error {
-	var productDetail *ProductDetail
-
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	err := m.DB.WithContext(ctx).First(&productDetail, p.ID).Error
-	if err != nil {
-		switch {
-		case errors.Is(err, gorm.ErrRecordNotFound):
-			return ErrRecordNotFound
-		default:
-			return err
-		}
-	}
-
-	productDetail.ProductID = p.ProductID
-	productDetail.Color = p.Color
-	productDetail.Size = p.Size
-	productDetail.Price = p.Price
-	productDetail.SKU = p.SKU
-	productDetail.Stock = p.Stock
-	productDetail.IsActive = p.IsActive
-
-	err = m.DB.WithContext(ctx).Save(&productDetail).Error
-	if err != nil {
-		switch {
-		case errors.Is(err, gorm.ErrRecordNotFound):
-			return ErrEditConflict
-		default:
-			return err
-		}
-	}
-
-	return nil
+	return m.DB.Transaction(func(tx *gorm.DB) error {
+		return m.UpdateWithTx(p, tx)
+	})
 }
 
+// UpdateWithTx saves a variant. The variant is locked before it is read, and a change of
+// stock is applied as a movement of the difference, so reservations made while the variant
+// was being edited are neither lost nor missing from the ledger.
 func (m ProductDetailModel) UpdateWithTx(p *ProductDetail, tx *gorm.DB) error {
 	var productDetail *ProductDetail
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.WithContext(ctx).First(&productDetail, p.ID).Error
+	err := tx.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).First(&productDetail, p.ID).Error
 	if err != nil {
 		switch {
 		case errors.Is(err, gorm.ErrRecordNotFound):
@@ -164,9 +174,10 @@ func (m ProductDetailModel) UpdateWithTx(p *ProductDetail, tx *gorm.DB) error {
 	productDetail.Size = p.Size
 	productDetail.Price = p.Price
 	productDetail.SKU = p.SKU
-	productDetail.Stock = p.Stock
 	productDetail.IsActive = p.IsActive
 
+	quantity := p.Stock - productDetail.Stock
+
 	err = tx.WithContext(ctx).Save(&productDetail).Error
 	if err != nil {
 		switch {
@@ -177,6 +188,18 @@ func (m ProductDetailModel) UpdateWithTx(p *ProductDetail, tx *gorm.DB) error {
 		}
 	}
 
+	if quantity != 0 {
+		_, err = InventoryMovementModel{DB: m.DB}.ApplyWithTx(&InventoryMovement{
+			ProductDetailID: productDetail.ID,
+			Type:            MovementAdjustment,
+			Quantity:        quantity,
+			Reason:          "variant edited",
+		}, tx)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
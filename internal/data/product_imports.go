@@ -0,0 +1,364 @@
+package data
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/kervinch/internal/validator"
+	"gorm.io/gorm"
+)
+
+const (
+	ProductImportPending    = "pending"
+	ProductImportProcessing = "processing"
+	ProductImportCompleted  = "completed"
+	ProductImportFailed     = "failed"
+)
+
+// ProductImport tracks a file of products being applied in the background, so the CMS
+// can poll its progress.
+type ProductImport struct {
+	ID                int64               `json:"id"`
+	Filename          string              `json:"filename"`
+	Status            string              `json:"status"`
+	TotalProducts     int                 `json:"total_products"`
+	ProcessedProducts int                 `json:"processed_products"`
+	CreatedProducts   int                 `json:"created_products"`
+	CreatedVariants   int                 `json:"created_variants"`
+	Errors            ProductImportErrors `json:"errors" gorm:"type:jsonb"`
+	CreatedBy         int64               `json:"created_by"`
+	FinishedAt        *time.Time          `json:"finished_at"`
+	CreatedAt         time.Time           `json:"created_at"`
+	UpdatedAt         time.Time           `json:"-"`
+}
+
+// ProductImportError lists what is wrong with a row of the file, keyed by column. Row is
+// the line number as shown by spreadsheet apps, the header being row 1.
+type ProductImportError struct {
+	Row    int               `json:"row"`
+	Errors map[string]string `json:"errors"`
+}
+
+type ProductImportErrors []ProductImportError
+
+func (e ProductImportErrors) Value() (driver.Value, error) {
+	if e == nil {
+		return "[]", nil
+	}
+
+	js, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(js), nil
+}
+
+func (e *ProductImportErrors) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, e)
+	case string:
+		return json.Unmarshal([]byte(v), e)
+	case nil:
+		*e = nil
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into ProductImportErrors", value)
+	}
+}
+
+// ProductImportRow is a line of the file after its cells were parsed. Product and
+// ProductDetail hold the plain columns, the slugs are resolved to IDs by Plan. Errors
+// holds the cells that could not be parsed at all.
+type ProductImportRow struct {
+	Row             int
+	Product         Product
+	ProductDetail   ProductDetail
+	CategorySlug    string
+	BrandSlug       string
+	StorefrontSlugs []string
+	ImageURLs       []string
+	Errors          map[string]string
+}
+
+type ProductImportVariant struct {
+	Row           int
+	ProductDetail ProductDetail
+	ImageURLs     []string
+}
+
+// ProductImportItem is a product to create, along with all of its variants.
+type ProductImportItem struct {
+	Row           int
+	Product       Product
+	StorefrontIDs []int64
+	Variants      []ProductImportVariant
+}
+
+// importErrorColumns renames validation errors to the columns of the file they come from.
+var importErrorColumns = map[string]string{
+	"product_category_id": "category",
+	"brand_id":            "brand",
+}
+
+type ProductImportModel struct {
+	DB *gorm.DB
+}
+
+// ====================================================================================
+// Backoffice Functions
+// ====================================================================================
+
+func (m ProductImportModel) Get(id int64) (*ProductImport, error) {
+	var productImport *ProductImport
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Where("id = ?", id).First(&productImport).Error
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return productImport, nil
+}
+
+func (m ProductImportModel) Insert(productImport *ProductImport) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.WithContext(ctx).Create(productImport).Error
+}
+
+// UpdateProgress saves the counters, errors and status of an import.
+func (m ProductImportModel) UpdateProgress(productImport *ProductImport) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.WithContext(ctx).Model(productImport).Select(
+		"Status", "ProcessedProducts", "CreatedProducts", "CreatedVariants", "Errors", "FinishedAt",
+	).Updates(productImport).Error
+}
+
+// Plan resolves the slugs of every row and validates it with the same rules as the CMS
+// forms. Rows sharing a product name become variants of one product, whose own columns
+// are read from its first row. A product is only planned when all of its rows are valid.
+// Imports only create products, so names and SKUs already in the catalogue are rejected.
+func (m ProductImportModel) Plan(rows []*ProductImportRow) ([]*ProductImportItem, ProductImportErrors, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var categorySlugs, brandSlugs, storefrontSlugs, productSlugs, skus []string
+
+	for _, row := range rows {
+		categorySlugs = append(categorySlugs, row.CategorySlug)
+		brandSlugs = append(brandSlugs, row.BrandSlug)
+		storefrontSlugs = append(storefrontSlugs, row.StorefrontSlugs...)
+		productSlugs = append(productSlugs, row.Product.Slug)
+		skus = append(skus, row.ProductDetail.SKU)
+	}
+
+	categories, err := m.slugIDs(ctx, "product_categories", categorySlugs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	brands, err := m.slugIDs(ctx, "brands", brandSlugs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	storefronts, err := m.slugIDs(ctx, "storefronts", storefrontSlugs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	existingProducts, err := m.existing(ctx, "products", "slug", productSlugs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	existingSKUs, err := m.existing(ctx, "product_details", "sku", skus)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var items []*ProductImportItem
+	var errs ProductImportErrors
+
+	products := make(map[string]*ProductImportItem)
+	invalid := make(map[*ProductImportItem]bool)
+	skuRows := make(map[string]int)
+
+	for _, row := range rows {
+		v := validator.New()
+
+		for key, message := range row.Errors {
+			v.AddError(key, message)
+		}
+
+		item, ok := products[row.Product.Slug]
+
+		// Different names that make the same slug would be merged into one product, so the
+		// later row is rejected rather than joining a product it does not name.
+		if ok && row.Product.Slug != "" && row.Product.Name != item.Product.Name {
+			v.AddError("name", fmt.Sprintf("is duplicated in row %d as %q", item.Row, item.Product.Name))
+			errs = append(errs, ProductImportError{Row: row.Row, Errors: v.Errors})
+			continue
+		}
+
+		if !ok || row.Product.Slug == "" {
+			item = &ProductImportItem{Row: row.Row, Product: row.Product}
+
+			if row.CategorySlug == "" {
+				v.AddError("category", "must be provided")
+			} else if id, ok := categories[row.CategorySlug]; ok {
+				item.Product.ProductCategoryID = id
+			} else {
+				v.AddError("category", "does not exist")
+			}
+
+			if row.BrandSlug == "" {
+				v.AddError("brand", "must be provided")
+			} else if id, ok := brands[row.BrandSlug]; ok {
+				item.Product.BrandID = id
+			} else {
+				v.AddError("brand", "does not exist")
+			}
+
+			for _, slug := range row.StorefrontSlugs {
+				id, ok := storefronts[slug]
+				if !ok {
+					v.AddError("storefronts", fmt.Sprintf("%q does not exist", slug))
+					continue
+				}
+				item.StorefrontIDs = append(item.StorefrontIDs, id)
+			}
+
+			pv := validator.New()
+			product := item.Product
+			ValidateProduct(pv, &product)
+			addImportErrors(v, pv)
+
+			v.Check(!existingProducts[row.Product.Slug], "name", "a product with this name already exists")
+
+			products[row.Product.Slug] = item
+			items = append(items, item)
+		}
+
+		// The product does not exist yet, so the variant is validated against a placeholder.
+		detail := row.ProductDetail
+		detail.ProductID = 1
+
+		dv := validator.New()
+		ValidateProductDetail(dv, &detail)
+		addImportErrors(v, dv)
+
+		v.Check(row.ProductDetail.SKU != "", "sku", "must be provided")
+		v.Check(!existingSKUs[row.ProductDetail.SKU], "sku", "already exists")
+
+		if previous, ok := skuRows[row.ProductDetail.SKU]; ok && row.ProductDetail.SKU != "" {
+			v.AddError("sku", fmt.Sprintf("is duplicated in row %d", previous))
+		} else {
+			skuRows[row.ProductDetail.SKU] = row.Row
+		}
+
+		for _, imageURL := range row.ImageURLs {
+			u, err := url.Parse(imageURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				v.AddError("image_urls", fmt.Sprintf("%q must be an absolute http or https URL", imageURL))
+			}
+		}
+
+		if !v.Valid() {
+			errs = append(errs, ProductImportError{Row: row.Row, Errors: v.Errors})
+			invalid[item] = true
+			continue
+		}
+
+		item.Variants = append(item.Variants, ProductImportVariant{
+			Row:           row.Row,
+			ProductDetail: row.ProductDetail,
+			ImageURLs:     row.ImageURLs,
+		})
+	}
+
+	planned := items[:0]
+	for _, item := range items {
+		if !invalid[item] {
+			planned = append(planned, item)
+		}
+	}
+
+	return planned, errs, nil
+}
+
+// addImportErrors copies the errors of a model validator, named after the file columns.
+// The IDs linking a variant to its product are not part of the file and are skipped.
+func addImportErrors(v *validator.Validator, model *validator.Validator) {
+	for key, message := range model.Errors {
+		if key == "product_id" {
+			continue
+		}
+		if column, ok := importErrorColumns[key]; ok {
+			key = column
+		}
+		v.AddError(key, message)
+	}
+}
+
+func (m ProductImportModel) slugIDs(ctx context.Context, table string, slugs []string) (map[string]int64, error) {
+	var rows []struct {
+		ID   int64
+		Slug string
+	}
+
+	ids := make(map[string]int64)
+
+	if len(slugs) == 0 {
+		return ids, nil
+	}
+
+	err := m.DB.WithContext(ctx).Table(table).Select("id, slug").Where("slug IN ?", slugs).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		ids[row.Slug] = row.ID
+	}
+
+	return ids, nil
+}
+
+func (m ProductImportModel) existing(ctx context.Context, table string, column string, values []string) (map[string]bool, error) {
+	var found []string
+
+	exists := make(map[string]bool)
+
+	if len(values) == 0 {
+		return exists, nil
+	}
+
+	err := m.DB.WithContext(ctx).Table(table).Where(column+" IN ?", values).Distinct().Pluck(column, &found).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, value := range found {
+		exists[value] = true
+	}
+
+	return exists, nil
+}
@@ -0,0 +1,242 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/kervinch/internal/validator"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+const MaxReviewPhotos = 3
+
+type ProductReview struct {
+	ID              int64          `json:"id"`
+	InvoiceDetailID int64          `json:"invoice_detail_id"`
+	ProductID       int64          `json:"product_id"`
+	ProductDetailID int64          `json:"product_detail_id"`
+	ProductDetail   ProductDetail  `json:"product_detail"`
+	UserID          int64          `json:"user_id"`
+	Reviewer        string         `json:"reviewer" gorm:"->"`
+	Rating          int            `json:"rating"`
+	Content         string         `json:"content"`
+	Photos          pq.StringArray `json:"photos" gorm:"type:text[]"`
+	IsHidden        bool           `json:"is_hidden"`
+	IsFlagged       bool           `json:"is_flagged"`
+	FlagReason      string         `json:"flag_reason"`
+	Reply           string         `json:"reply"`
+	RepliedAt       *time.Time     `json:"replied_at"`
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"-"`
+}
+
+func ValidateProductReview(v *validator.Validator, review *ProductReview) {
+	v.Check(review.InvoiceDetailID != 0, "invoice_detail_id", "must be provided")
+	v.Check(review.InvoiceDetailID > 0, "invoice_detail_id", "must be a positive integer")
+	v.Check(review.Rating >= 1 && review.Rating <= 5, "rating", "must be between 1 and 5")
+	v.Check(len(review.Content) <= 2000, "content", "must not be more than 2000 bytes long")
+	v.Check(len(review.Photos) <= MaxReviewPhotos, "photos", "must not contain more than 3 photos")
+}
+
+func ValidateProductReviewModeration(v *validator.Validator, review *ProductReview) {
+	v.Check(!review.IsFlagged || review.FlagReason != "", "flag_reason", "must be provided")
+	v.Check(len(review.FlagReason) <= 500, "flag_reason", "must not be more than 500 bytes long")
+	v.Check(len(review.Reply) <= 2000, "reply", "must not be more than 2000 bytes long")
+}
+
+var ProductReviewSortSafeList = SortSafeList("id", "rating", "created_at")
+
+var ProductReviewFilterSafeList = map[string]FilterField{
+	"product_id": {Column: "product_reviews.product_id", Type: FilterInt, Operators: EqualityOperators},
+	"user_id":    {Column: "product_reviews.user_id", Type: FilterInt, Operators: EqualityOperators},
+	"rating":     {Column: "product_reviews.rating", Type: FilterInt, Operators: RangeOperators},
+	"is_hidden":  {Column: "product_reviews.is_hidden", Type: FilterBool, Operators: BoolOperators},
+	"is_flagged": {Column: "product_reviews.is_flagged", Type: FilterBool, Operators: BoolOperators},
+	"created_at": {Column: "product_reviews.created_at", Type: FilterTime, Operators: RangeOperators},
+}
+
+type ProductReviewModel struct {
+	DB *gorm.DB
+}
+
+// withReviewer adds the name of the reviewer, without exposing the rest of their account.
+func withReviewer(db *gorm.DB) *gorm.DB {
+	return db.Select("product_reviews.*, (SELECT name FROM users WHERE users.id = product_reviews.user_id) AS reviewer")
+}
+
+// refreshProductRating recalculates the rating and review count shown on a product from
+// its visible reviews.
+func refreshProductRating(productID int64, tx *gorm.DB) error {
+	return tx.Exec(`
+		UPDATE products
+		SET rating = COALESCE((SELECT ROUND(AVG(rating), 2) FROM product_reviews WHERE product_id = @id AND NOT is_hidden), 0),
+		    review_count = (SELECT COUNT(*) FROM product_reviews WHERE product_id = @id AND NOT is_hidden)
+		WHERE id = @id`, map[string]interface{}{"id": productID}).Error
+}
+
+// ====================================================================================
+// Backoffice Functions
+// ====================================================================================
+
+func (m ProductReviewModel) GetAll(f Filters) ([]*ProductReview, Metadata, error) {
+	var reviews []*ProductReview
+	var count int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Scopes(withReviewer, Filter(f), OrderBy(f), Paginate(f.Pagination())).Preload("ProductDetail").Find(&reviews).Error
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	err = m.DB.Table("product_reviews").Scopes(Filter(f)).Count(&count).Error
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(int(count), f.Page, f.PageSize)
+
+	return reviews, metadata, nil
+}
+
+func (m ProductReviewModel) Get(id int64) (*ProductReview, error) {
+	var review *ProductReview
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Scopes(withReviewer).Preload("ProductDetail").Where("product_reviews.id = ?", id).First(&review).Error
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return review, nil
+}
+
+// Moderate saves the moderation state and reply of a review. Hiding or showing a review
+// changes the rating of its product, so both are updated together.
+func (m ProductReviewModel) Moderate(review *ProductReview) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&ProductReview{}).Where("id = ?", review.ID).Updates(map[string]interface{}{
+			"is_hidden":   review.IsHidden,
+			"is_flagged":  review.IsFlagged,
+			"flag_reason": review.FlagReason,
+			"reply":       review.Reply,
+			"replied_at":  review.RepliedAt,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return ErrEditConflict
+		}
+
+		return refreshProductRating(review.ProductID, tx)
+	})
+}
+
+// ====================================================================================
+// Business Functions
+// ====================================================================================
+
+func (m ProductReviewModel) GetAllForProduct(productID int64, p Pagination) ([]*ProductReview, Metadata, error) {
+	var reviews []*ProductReview
+	var count int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Scopes(withReviewer, Paginate(p)).Preload("ProductDetail").Where("product_reviews.product_id = ? AND NOT product_reviews.is_hidden", productID).Order("product_reviews.created_at DESC, product_reviews.id DESC").Find(&reviews).Error
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	err = m.DB.Table("product_reviews").Where("product_id = ? AND NOT is_hidden", productID).Count(&count).Error
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(int(count), p.Page, p.PageSize)
+
+	return reviews, metadata, nil
+}
+
+func (m ProductReviewModel) GetAllForUser(user *User, p Pagination) ([]*ProductReview, Metadata, error) {
+	var reviews []*ProductReview
+	var count int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Scopes(withReviewer, Paginate(p)).Preload("ProductDetail").Where("product_reviews.user_id = ?", user.ID).Order("product_reviews.id DESC").Find(&reviews).Error
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	err = m.DB.Table("product_reviews").Where("user_id = ?", user.ID).Count(&count).Error
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(int(count), p.Page, p.PageSize)
+
+	return reviews, metadata, nil
+}
+
+// GetReviewable returns an invoice line of the user with its order detail and variant, so
+// the caller can check that the order was completed before accepting a review.
+func (m ProductReviewModel) GetReviewable(invoiceDetailID int64, user *User) (*InvoiceDetail, error) {
+	var invoiceDetail *InvoiceDetail
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).
+		Joins("JOIN order_details ON order_details.id = invoice_details.order_detail_id").
+		Joins("JOIN orders ON orders.id = order_details.order_id").
+		Preload("OrderDetail.Order").
+		Preload("ProductDetail").
+		Where("invoice_details.id = ? AND orders.user_id = ?", invoiceDetailID, user.ID).
+		First(&invoiceDetail).Error
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return invoiceDetail, nil
+}
+
+func (m ProductReviewModel) Insert(review *ProductReview) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Omit("ProductDetail").Create(review).Error
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "product_reviews_invoice_detail_id_key"`:
+				return ErrDuplicateKeyValue
+			default:
+				return err
+			}
+		}
+
+		return refreshProductRating(review.ProductID, tx)
+	})
+}
@@ -0,0 +1,261 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ProductSearch holds the public catalogue filters. Query is matched against product,
+// brand and category text, the remaining fields narrow the result down.
+type ProductSearch struct {
+	Query        string   `json:"-"`
+	Size         string   `json:"size,omitempty"`
+	Colors       []string `json:"colors,omitempty"`
+	MinimumPrice int      `json:"minimum_price"`
+	MaximumPrice int      `json:"maximum_price"`
+	CategoryIDs  []string `json:"categories,omitempty"`
+	BrandIDs     []string `json:"brands,omitempty"`
+}
+
+type FacetCount struct {
+	ID    int64  `json:"id,omitempty"`
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+type PriceBucket struct {
+	MinimumPrice int64 `json:"minimum_price"`
+	MaximumPrice int64 `json:"maximum_price,omitempty"`
+	Count        int64 `json:"count"`
+}
+
+type ProductFacets struct {
+	Categories []FacetCount  `json:"categories"`
+	Brands     []FacetCount  `json:"brands"`
+	Sizes      []FacetCount  `json:"sizes"`
+	Colors     []FacetCount  `json:"colors"`
+	Prices     []PriceBucket `json:"prices"`
+}
+
+// ProductMetadata adds facet counts, and spelling suggestions for searches that found
+// nothing, to the usual pagination metadata.
+type ProductMetadata struct {
+	Metadata
+	Facets     ProductFacets `json:"facets"`
+	DidYouMean []string      `json:"did_you_mean,omitempty"`
+	SearchID   string        `json:"search_id,omitempty"`
+}
+
+// priceBuckets are the upper bounds, in rupiah, of every price facet except the last one,
+// which is open ended.
+var priceBuckets = []int64{100_000, 250_000, 500_000, 1_000_000}
+
+// The query is parsed with both configs so either language matches, and the trigram
+// operator catches typos that stemming cannot.
+const productTSQuery = "(websearch_to_tsquery('indonesian', ?) || websearch_to_tsquery('english', ?))"
+
+// productFilters applies the product level filters. The dimension named in except is
+// skipped, so a facet can count the values a user could still switch to.
+func (s ProductSearch) productFilters(except string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		db = db.Where("products.is_active = ?", true).Scopes(Published("products"))
+
+		if s.Query != "" {
+			db = db.Where("(products.search_vector @@ "+productTSQuery+" OR ? <% products.name)", s.Query, s.Query, s.Query)
+		}
+
+		if except != "category" {
+			db = db.Scopes(In("products.product_category_id", s.CategoryIDs))
+		}
+
+		if except != "brand" {
+			db = db.Scopes(In("products.brand_id", s.BrandIDs))
+		}
+
+		return db
+	}
+}
+
+// detailCondition returns the variant level filters as a condition on the product_details
+// table, skipping the dimension named in except.
+func (s ProductSearch) detailCondition(except string) (string, []interface{}) {
+	condition := "product_details.is_active = TRUE"
+	args := []interface{}{}
+
+	if except != "price" {
+		condition += " AND product_details.price >= ? AND product_details.price <= ?"
+		args = append(args, s.MinimumPrice, s.MaximumPrice)
+	}
+
+	if except != "size" && s.Size != "" {
+		condition += " AND product_details.size ILIKE ?"
+		args = append(args, s.Size)
+	}
+
+	if except != "color" && len(s.Colors) > 0 {
+		condition += " AND product_details.color IN ?"
+		args = append(args, s.Colors)
+	}
+
+	return condition, args
+}
+
+// hasDetail keeps products with at least one variant matching the variant level filters.
+func (s ProductSearch) hasDetail(except string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		condition, args := s.detailCondition(except)
+		return db.Where("EXISTS (SELECT 1 FROM product_details WHERE product_details.product_id = products.id AND "+condition+")", args...)
+	}
+}
+
+func (s ProductSearch) scope() func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Scopes(s.productFilters(""), s.hasDetail(""))
+	}
+}
+
+// sortExpression returns the SQL behind a sortable column. Price is the cheapest variant
+// that matches the filters.
+func (s ProductSearch) sortExpression(column string) (string, []interface{}) {
+	switch column {
+	case "id":
+		return "products.id", nil
+	case "price":
+		condition, args := s.detailCondition("")
+		return "(SELECT MIN(product_details.price) FROM product_details WHERE product_details.product_id = products.id AND " + condition + ")", args
+	case "relevance":
+		if s.Query == "" {
+			return "CAST(0 AS real)", nil
+		}
+		return "(ts_rank_cd(products.search_vector, " + productTSQuery + ") + word_similarity(?, products.name))", []interface{}{s.Query, s.Query, s.Query}
+	}
+
+	panic("unsafe sort parameter: " + column)
+}
+
+// selectColumns adds the computed price and relevance columns used for sorting.
+func (s ProductSearch) selectColumns() func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		price, args := s.sortExpression("price")
+		relevance, relevanceArgs := s.sortExpression("relevance")
+
+		return db.Select("products.*, "+price+" AS price, "+relevance+" AS relevance", append(args, relevanceArgs...)...)
+	}
+}
+
+// productPosition returns the cursor position of a product in a listing sorted by column.
+func productPosition(column string) func(*Product) (string, int64) {
+	return func(product *Product) (string, int64) {
+		switch column {
+		case "price":
+			return strconv.FormatInt(product.Price, 10), product.ID
+		case "relevance":
+			// Relevance is a real, formatting it as such keeps the cursor exact.
+			return strconv.FormatFloat(product.Relevance, 'g', -1, 32), product.ID
+		default:
+			return "", product.ID
+		}
+	}
+}
+
+// ====================================================================================
+// Business Functions
+// ====================================================================================
+
+func (m ProductModel) GetAPIFacets(s ProductSearch) (ProductFacets, error) {
+	facets := ProductFacets{
+		Categories: []FacetCount{},
+		Brands:     []FacetCount{},
+		Sizes:      []FacetCount{},
+		Colors:     []FacetCount{},
+		Prices:     []PriceBucket{},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Table("products").
+		Select("product_categories.id AS id, product_categories.name AS value, COUNT(*) AS count").
+		Joins("JOIN product_categories ON product_categories.id = products.product_category_id").
+		Scopes(s.productFilters("category"), s.hasDetail("")).
+		Group("product_categories.id, product_categories.name").Order("count DESC, value").
+		Scan(&facets.Categories).Error
+	if err != nil {
+		return ProductFacets{}, err
+	}
+
+	err = m.DB.WithContext(ctx).Table("products").
+		Select("brands.id AS id, brands.name AS value, COUNT(*) AS count").
+		Joins("JOIN brands ON brands.id = products.brand_id").
+		Scopes(s.productFilters("brand"), s.hasDetail("")).
+		Group("brands.id, brands.name").Order("count DESC, value").
+		Scan(&facets.Brands).Error
+	if err != nil {
+		return ProductFacets{}, err
+	}
+
+	for _, facet := range []struct {
+		column string
+		dst    *[]FacetCount
+	}{
+		{"size", &facets.Sizes},
+		{"color", &facets.Colors},
+	} {
+		condition, args := s.detailCondition(facet.column)
+
+		err = m.DB.WithContext(ctx).Table("products").
+			Select("product_details."+facet.column+" AS value, COUNT(DISTINCT products.id) AS count").
+			Joins("JOIN product_details ON product_details.product_id = products.id").
+			Scopes(s.productFilters("")).
+			Where(condition, args...).
+			Where("COALESCE(product_details." + facet.column + ", '') <> ''").
+			Group("product_details." + facet.column).Order("count DESC, value").
+			Scan(facet.dst).Error
+		if err != nil {
+			return ProductFacets{}, err
+		}
+	}
+
+	bucket := "CASE"
+	for i, upper := range priceBuckets {
+		bucket += fmt.Sprintf(" WHEN product_details.price < %d THEN %d", upper, i)
+	}
+	bucket += fmt.Sprintf(" ELSE %d END", len(priceBuckets))
+
+	var prices []struct {
+		Bucket int
+		Count  int64
+	}
+
+	condition, args := s.detailCondition("price")
+
+	err = m.DB.WithContext(ctx).Table("products").
+		Select(bucket+" AS bucket, COUNT(DISTINCT products.id) AS count").
+		Joins("JOIN product_details ON product_details.product_id = products.id").
+		Scopes(s.productFilters("")).
+		Where(condition, args...).
+		Group("bucket").Order("bucket").
+		Scan(&prices).Error
+	if err != nil {
+		return ProductFacets{}, err
+	}
+
+	for _, price := range prices {
+		b := PriceBucket{Count: price.Count}
+
+		if price.Bucket > 0 {
+			b.MinimumPrice = priceBuckets[price.Bucket-1]
+		}
+		if price.Bucket < len(priceBuckets) {
+			b.MaximumPrice = priceBuckets[price.Bucket] - 1
+		}
+
+		facets.Prices = append(facets.Prices, b)
+	}
+
+	return facets, nil
+}
@@ -0,0 +1,195 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// MaxRecentlyViewed is how many products are remembered per user or device.
+	MaxRecentlyViewed = 50
+
+	// ProductViewRetention is how long views are kept for the trending products.
+	ProductViewRetention = 7 * 24 * time.Hour
+)
+
+// ProductView is a single view of a product page, by a user or by an anonymous device.
+type ProductView struct {
+	ID        int64         `json:"id"`
+	ProductID int64         `json:"product_id"`
+	UserID    sql.NullInt64 `json:"-"`
+	DeviceID  string        `json:"-"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+// RecentlyViewedProduct remembers the last time a viewer saw a product.
+type RecentlyViewedProduct struct {
+	ID        int64         `json:"id"`
+	UserID    sql.NullInt64 `json:"-"`
+	DeviceID  string        `json:"-"`
+	ProductID int64         `json:"product_id"`
+	ViewedAt  time.Time     `json:"viewed_at"`
+}
+
+type ProductViewModel struct {
+	DB *gorm.DB
+}
+
+// viewer selects the recently viewed products of a user, or of a device before its owner
+// signed in.
+func viewer(userID int64, deviceID string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if userID > 0 {
+			return db.Where("recently_viewed_products.user_id = ?", userID)
+		}
+		return db.Where("recently_viewed_products.user_id IS NULL AND recently_viewed_products.device_id = ?", deviceID)
+	}
+}
+
+// trimRecentlyViewed forgets all but the latest MaxRecentlyViewed products of a viewer.
+func trimRecentlyViewed(userID int64, deviceID string, tx *gorm.DB) error {
+	latest := tx.Table("recently_viewed_products").Select("id").Scopes(viewer(userID, deviceID)).Order("viewed_at DESC").Limit(MaxRecentlyViewed)
+
+	return tx.Scopes(viewer(userID, deviceID)).Where("id NOT IN (?)", latest).Delete(&RecentlyViewedProduct{}).Error
+}
+
+// ====================================================================================
+// Business Functions
+// ====================================================================================
+
+// Record adds a view to the stream used for trending products and moves the product to
+// the top of the recently viewed products of the viewer.
+func (m ProductViewModel) Record(view *ProductView) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(view).Error
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case view.UserID.Valid:
+			err = tx.Exec(`
+				INSERT INTO recently_viewed_products (user_id, product_id, viewed_at)
+				VALUES (?, ?, ?)
+				ON CONFLICT (user_id, product_id) WHERE user_id IS NOT NULL
+				DO UPDATE SET viewed_at = EXCLUDED.viewed_at`, view.UserID.Int64, view.ProductID, view.CreatedAt).Error
+		case view.DeviceID != "":
+			err = tx.Exec(`
+				INSERT INTO recently_viewed_products (device_id, product_id, viewed_at)
+				VALUES (?, ?, ?)
+				ON CONFLICT (device_id, product_id) WHERE user_id IS NULL
+				DO UPDATE SET viewed_at = EXCLUDED.viewed_at`, view.DeviceID, view.ProductID, view.CreatedAt).Error
+		default:
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		return trimRecentlyViewed(view.UserID.Int64, view.DeviceID, tx)
+	})
+}
+
+// Merge moves the products viewed on a device before signing in to the account of the
+// user, keeping the latest view of products seen on both.
+func (m ProductViewModel) Merge(deviceID string, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(`
+			INSERT INTO recently_viewed_products (user_id, product_id, viewed_at)
+			SELECT ?, product_id, viewed_at
+			FROM recently_viewed_products
+			WHERE user_id IS NULL AND device_id = ?
+			ON CONFLICT (user_id, product_id) WHERE user_id IS NOT NULL
+			DO UPDATE SET viewed_at = GREATEST(recently_viewed_products.viewed_at, EXCLUDED.viewed_at)`, userID, deviceID).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Scopes(viewer(0, deviceID)).Delete(&RecentlyViewedProduct{}).Error
+		if err != nil {
+			return err
+		}
+
+		return trimRecentlyViewed(userID, "", tx)
+	})
+}
+
+// GetRecentlyViewed returns the active products viewed by a user, or by an anonymous
+// device when userID is 0, latest first.
+func (m ProductViewModel) GetRecentlyViewed(userID int64, deviceID string, limit int) ([]*Product, error) {
+	var products []*Product
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).
+		Select("products.*").
+		Joins("JOIN recently_viewed_products ON recently_viewed_products.product_id = products.id").
+		Scopes(viewer(userID, deviceID)).
+		Where("products.is_active").
+		Scopes(Published("products")).
+		Preload("ProductCategory").Preload("Brand").Preload("Storefront").Preload("ProductDetail", withSalePrice).Preload("ProductDetail.ProductImage").
+		Order("recently_viewed_products.viewed_at DESC").
+		Limit(limit).
+		Find(&products).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return products, nil
+}
+
+// GetTrending ranks the active products by how fast they are being viewed. Each viewer
+// counts once per product and hour, so refreshing a page does not push it up, and a view
+// loses half its weight every six hours.
+func (m ProductViewModel) GetTrending(since time.Time, limit int) ([]*Product, error) {
+	var products []*Product
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).
+		Select("products.*").
+		Joins(`JOIN (
+			SELECT product_id, SUM(POWER(0.5, EXTRACT(EPOCH FROM NOW() - viewed_at) / 21600)) AS velocity
+			FROM (
+				SELECT DISTINCT product_id, COALESCE(user_id::text, device_id) AS viewer, date_trunc('hour', created_at) AS viewed_at
+				FROM product_views
+				WHERE created_at > ?
+			) views
+			GROUP BY product_id
+		) trending ON trending.product_id = products.id`, since).
+		Where("products.is_active").
+		Scopes(Published("products")).
+		Preload("ProductCategory").Preload("Brand").Preload("Storefront").Preload("ProductDetail", withSalePrice).Preload("ProductDetail.ProductImage").
+		Order("trending.velocity DESC, products.id DESC").
+		Limit(limit).
+		Find(&products).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return products, nil
+}
+
+// DeleteExpired removes the views older than ProductViewRetention from the stream.
+func (m ProductViewModel) DeleteExpired() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	result := m.DB.WithContext(ctx).Where("created_at < ?", time.Now().Add(-ProductViewRetention)).Delete(&ProductView{})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	return result.RowsAffected, nil
+}
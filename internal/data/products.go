@@ -3,6 +3,7 @@ package data
 import (
 	"context"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/kervinch/internal/validator"
@@ -23,9 +24,16 @@ type Product struct {
 	Condition         string          `json:"condition"`
 	Slug              string          `json:"slug"`
 	InsuranceRequired bool            `json:"insurance_required"`
+	IsGiftCard        bool            `json:"is_gift_card"`
 	ProductDetail     []ProductDetail `json:"product_details"`
 	Storefront        []*Storefront   `json:"storefronts" gorm:"many2many:product_storefront_subscriptions"`
 	IsActive          bool            `json:"is_active"`
+	PublishAt         *time.Time      `json:"publish_at"`
+	UnpublishAt       *time.Time      `json:"unpublish_at"`
+	Rating            float64         `json:"rating" gorm:"->"`
+	ReviewCount       int             `json:"review_count" gorm:"->"`
+	Price             int64           `json:"-" gorm:"->"`
+	Relevance         float64         `json:"-" gorm:"->"`
 	CreatedAt         time.Time       `json:"-"`
 	UpdatedAt         time.Time       `json:"-"`
 }
@@ -44,34 +52,57 @@ func ValidateProduct(v *validator.Validator, product *Product) {
 	v.Check(product.MinimumOrder != 0, "minimum_order", "must be provided")
 	v.Check(product.MinimumOrder > 0, "minimum_order", "must be a positive integer")
 	v.Check(validator.In(product.Condition, "new", "used"), "condition", "must be either new or used")
+
+	ValidatePublishingWindow(v, product.PublishAt, product.UnpublishAt)
+}
+
+var ProductSortSafeList = SortSafeList("id", "name", "created_at")
+
+var ProductFilterSafeList = map[string]FilterField{
+	"name":                {Column: "products.name", Type: FilterString, Operators: TextOperators},
+	"brand_id":            {Column: "products.brand_id", Type: FilterInt, Operators: EqualityOperators},
+	"product_category_id": {Column: "products.product_category_id", Type: FilterInt, Operators: EqualityOperators},
+	"storefront_id":       {Column: "product_storefront_subscriptions.storefront_id", Type: FilterInt, Operators: EqualityOperators, Exists: "SELECT 1 FROM product_storefront_subscriptions WHERE product_storefront_subscriptions.product_id = products.id"},
+	"sku":                 {Column: "product_details.sku", Type: FilterString, Operators: TextOperators, Exists: "SELECT 1 FROM product_details WHERE product_details.product_id = products.id"},
+	"condition":           {Column: "products.condition", Type: FilterString, Operators: EqualityOperators, Values: []string{"new", "used"}},
+	"is_active":           {Column: "products.is_active", Type: FilterBool, Operators: BoolOperators},
+	"publish_at":          {Column: "products.publish_at", Type: FilterTime, Operators: RangeOperators},
+	"unpublish_at":        {Column: "products.unpublish_at", Type: FilterTime, Operators: RangeOperators},
+	"created_at":          {Column: "products.created_at", Type: FilterTime, Operators: RangeOperators},
 }
 
 type ProductModel struct {
 	DB *gorm.DB
 }
 
+// listedProducts keeps the products shoppers may see when they are preloaded through a
+// category, brand or storefront.
+func listedProducts(db *gorm.DB) *gorm.DB {
+	return db.Where("products.is_active").Scopes(Published("products"))
+}
+
 // ====================================================================================
 // Backoffice Functions
 // ====================================================================================
 
-func (m ProductModel) GetAll(p Pagination) ([]*Product, Metadata, error) {
+func (m ProductModel) GetAll(f Filters) ([]*Product, Metadata, error) {
 	var products []*Product
 	var count int64
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.WithContext(ctx).Preload("ProductCategory").Preload("Brand").Preload("ProductDetail.ProductImage").Preload("Storefront").Scopes(Paginate(p)).Find(&products).Error
+	err := m.DB.WithContext(ctx).Preload("ProductCategory").Preload("Brand").Preload("ProductDetail.ProductImage").Preload("Storefront").Scopes(Filter(f), OrderBy(f), Paginate(f.Pagination())).Find(&products).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
-	err = m.DB.Table("products").Count(&count).Error
+	err = m.DB.Table("products").Scopes(Filter(f)).Count(&count).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(int(count), p.Page, p.PageSize)
+	metadata := calculateMetadata(int(count), f.Page, f.PageSize)
 
 	return products, metadata, nil
 }
@@ -163,7 +194,10 @@ func (m ProductModel) Update(p *Product) error {
 	product.Condition = p.Condition
 	product.Slug = p.Slug
 	product.InsuranceRequired = p.InsuranceRequired
+	product.IsGiftCard = p.IsGiftCard
 	product.IsActive = p.IsActive
+	product.PublishAt = p.PublishAt
+	product.UnpublishAt = p.UnpublishAt
 
 	err = m.DB.WithContext(ctx).Save(&product).Error
 	if err != nil {
@@ -206,7 +240,10 @@ func (m ProductModel) UpdateWithTx(p *Product, tx *gorm.DB) error {
 	product.Condition = p.Condition
 	product.Slug = p.Slug
 	product.InsuranceRequired = p.InsuranceRequired
+	product.IsGiftCard = p.IsGiftCard
 	product.IsActive = p.IsActive
+	product.PublishAt = p.PublishAt
+	product.UnpublishAt = p.UnpublishAt
 
 	err = tx.WithContext(ctx).Save(&product).Error
 	if err != nil {
@@ -259,31 +296,43 @@ func (m ProductModel) DeleteWithTx(id int64, tx *gorm.DB) error {
 // Business Functions
 // ====================================================================================
 
-func (m ProductModel) GetAPI(p Pagination, name string, size string, minimumPrice int, maximumPrice int, categoryID []string, sort Sort) ([]*Product, Metadata, error) {
+func (m ProductModel) GetAPI(p Pagination, s ProductSearch, sort Sort) ([]*Product, Metadata, error) {
 	var products []*Product
 	var count int64
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	if size != "" {
-		err := m.DB.WithContext(ctx).Joins("JOIN product_details ON product_details.product_id = products.id AND size ILIKE ? AND (price >= ? AND price <= ?)", size, minimumPrice, maximumPrice).Preload("ProductCategory").Preload("Brand").Preload("ProductDetail.ProductImage").Preload("Storefront").Scopes(Paginate(p)).Where("name ILIKE ?", "%"+name+"%").Scopes(In("product_category_id", categoryID)).Order(sort.sortColumnAndDirection()).Find(&products).Error
-		if err != nil {
-			return nil, Metadata{}, err
-		}
+	query := m.DB.WithContext(ctx).Scopes(s.selectColumns(), s.scope()).Preload("ProductCategory").Preload("Brand").Preload("ProductDetail", withSalePrice).Preload("ProductDetail.ProductImage").Preload("Storefront")
+
+	// Cursors can only follow a single sort key, a combined sort is paginated by page
+	// number alone.
+	if len(sort.List) == 1 {
+		key := sort.List[0]
+		column := strings.TrimPrefix(key, "-")
+		expression, args := s.sortExpression(column)
+		query = query.Scopes(Keyset(p, key, expression, "products.id", args...))
 	} else {
-		err := m.DB.WithContext(ctx).Joins("JOIN product_details ON product_details.product_id = products.id AND (price >= ? AND price <= ?)", minimumPrice, maximumPrice).Preload("ProductCategory").Preload("Brand").Preload("ProductDetail.ProductImage").Preload("Storefront").Scopes(Paginate(p)).Where("name ILIKE ?", "%"+name+"%").Scopes(In("product_category_id", categoryID)).Order(sort.sortColumnAndDirection()).Find(&products).Error
+		query = query.Scopes(Paginate(p)).Order(sort.sortColumnAndDirection())
+	}
+
+	err := query.Find(&products).Error
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	if p.After == "" {
+		err = m.DB.WithContext(ctx).Model(&Product{}).Scopes(s.scope()).Count(&count).Error
 		if err != nil {
 			return nil, Metadata{}, err
 		}
 	}
 
-	err := m.DB.Table("products").Count(&count).Error
-	if err != nil {
-		return nil, Metadata{}, err
+	if len(sort.List) != 1 {
+		return products, calculateMetadata(int(count), p.Page, p.PageSize), nil
 	}
 
-	metadata := calculateMetadata(int(count), p.Page, p.PageSize)
+	products, metadata := keysetMetadata(products, p, count, sort.List[0], productPosition(strings.TrimPrefix(sort.List[0], "-")))
 
 	return products, metadata, nil
 }
@@ -294,21 +343,7 @@ func (m ProductModel) GetLatest() ([]*Product, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.WithContext(ctx).Where("is_active = ?", true).Preload("ProductCategory").Preload("Brand").Preload("Storefront").Preload("ProductDetail.ProductImage").Order("created_at desc").Limit(10).Find(&products).Error
-	if err != nil {
-		return nil, err
-	}
-
-	return products, nil
-}
-
-func (m ProductModel) GetRecommendation() ([]*Product, error) {
-	var products []*Product
-
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	err := m.DB.WithContext(ctx).Where("is_active = ?", true).Preload("ProductCategory").Preload("Brand").Preload("Storefront").Preload("ProductDetail.ProductImage").Order("id").Limit(10).Find(&products).Error
+	err := m.DB.WithContext(ctx).Where("is_active = ?", true).Scopes(Published("products")).Preload("ProductCategory").Preload("Brand").Preload("Storefront").Preload("ProductDetail", withSalePrice).Preload("ProductDetail.ProductImage").Order("created_at desc").Limit(10).Find(&products).Error
 	if err != nil {
 		return nil, err
 	}
@@ -326,7 +361,7 @@ func (m ProductModel) GetBySlug(slug string) (*Product, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.WithContext(ctx).Where("is_active = ? AND slug = ?", true, slug).Preload("ProductCategory").Preload("Brand").Preload("Storefront").Preload("ProductDetail.ProductImage").First(&product).Error
+	err := m.DB.WithContext(ctx).Where("is_active = ? AND slug = ?", true, slug).Scopes(Published("products")).Preload("ProductCategory").Preload("Brand").Preload("Storefront").Preload("ProductDetail", withSalePrice).Preload("ProductDetail.ProductImage").First(&product).Error
 	if err != nil {
 		switch {
 		case errors.Is(err, gorm.ErrRecordNotFound):
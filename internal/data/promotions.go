@@ -0,0 +1,420 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/kervinch/internal/validator"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+const (
+	PromotionQuantityThreshold = "quantity_threshold"
+	PromotionBuyXGetY          = "buy_x_get_y"
+	PromotionBundlePrice       = "bundle_price"
+)
+
+// Promotion is a discount rule evaluated over the lines of a cart. The rule depends on
+// Type:
+//
+//   - quantity_threshold takes DiscountPercent off the matching lines once MinQuantity
+//     units of them are bought.
+//   - buy_x_get_y gives FreeQuantity units for free for every MinQuantity units bought,
+//     the cheapest units of each group being the free ones.
+//   - bundle_price sells one unit of each of SKUs together for BundlePrice.
+//
+// BrandID, ProductCategoryID and SKUs restrict the lines the rule applies to.
+type Promotion struct {
+	ID                int64          `json:"id"`
+	Name              string         `json:"name"`
+	Type              string         `json:"type"`
+	BrandID           *int64         `json:"brand_id"`
+	ProductCategoryID *int64         `json:"product_category_id"`
+	SKUs              pq.StringArray `json:"skus" gorm:"column:skus;type:text[]"`
+	MinQuantity       int            `json:"min_quantity"`
+	FreeQuantity      int            `json:"free_quantity"`
+	DiscountPercent   int            `json:"discount_percent"`
+	BundlePrice       int64          `json:"bundle_price"`
+	Priority          int            `json:"priority"`
+	StartsAt          *time.Time     `json:"starts_at"`
+	EndsAt            *time.Time     `json:"ends_at"`
+	IsActive          bool           `json:"is_active"`
+	CreatedBy         int64          `json:"created_by"`
+	UpdatedBy         int64          `json:"updated_by"`
+	CreatedAt         time.Time      `json:"-"`
+	UpdatedAt         time.Time      `json:"-"`
+}
+
+func ValidatePromotion(v *validator.Validator, promotion *Promotion) {
+	v.Check(promotion.Name != "", "name", "must be provided")
+	v.Check(len(promotion.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.Check(validator.In(promotion.Type, PromotionQuantityThreshold, PromotionBuyXGetY, PromotionBundlePrice), "type", "must be either quantity_threshold, buy_x_get_y or bundle_price")
+	v.Check(promotion.BrandID == nil || *promotion.BrandID > 0, "brand_id", "must be a positive integer")
+	v.Check(promotion.ProductCategoryID == nil || *promotion.ProductCategoryID > 0, "product_category_id", "must be a positive integer")
+	v.Check(validator.Unique(promotion.SKUs), "skus", "must not contain duplicate values")
+	v.Check(promotion.StartsAt == nil || promotion.EndsAt == nil || promotion.EndsAt.After(*promotion.StartsAt), "ends_at", "must be later than starts_at")
+
+	switch promotion.Type {
+	case PromotionQuantityThreshold:
+		v.Check(promotion.MinQuantity > 0, "min_quantity", "must be a positive integer")
+		v.Check(promotion.DiscountPercent >= 1 && promotion.DiscountPercent <= 100, "discount_percent", "must be between 1 and 100")
+	case PromotionBuyXGetY:
+		v.Check(promotion.MinQuantity > 0, "min_quantity", "must be a positive integer")
+		v.Check(promotion.FreeQuantity > 0, "free_quantity", "must be a positive integer")
+	case PromotionBundlePrice:
+		v.Check(len(promotion.SKUs) >= 2, "skus", "must contain at least two SKUs")
+		v.Check(promotion.BundlePrice > 0, "bundle_price", "must be a positive integer")
+	}
+}
+
+var PromotionSortSafeList = SortSafeList("id", "name", "priority", "starts_at", "ends_at", "created_at")
+
+var PromotionFilterSafeList = map[string]FilterField{
+	"name":                {Column: "promotions.name", Type: FilterString, Operators: TextOperators},
+	"type":                {Column: "promotions.type", Type: FilterString, Operators: EqualityOperators, Values: []string{PromotionQuantityThreshold, PromotionBuyXGetY, PromotionBundlePrice}},
+	"brand_id":            {Column: "promotions.brand_id", Type: FilterInt, Operators: EqualityOperators},
+	"product_category_id": {Column: "promotions.product_category_id", Type: FilterInt, Operators: EqualityOperators},
+	"is_active":           {Column: "promotions.is_active", Type: FilterBool, Operators: BoolOperators},
+}
+
+// PromotionLine is a cart line as seen by the promotions.
+type PromotionLine struct {
+	ProductDetailID   int64
+	BrandID           int64
+	ProductCategoryID int64
+	SKU               string
+	Quantity          int
+	Price             int64
+	OnSale            bool
+	IsGiftCard        bool
+}
+
+// NewPromotionLine makes the line of quantity units of a variant. The variant must be
+// loaded with its product and sale price.
+func NewPromotionLine(productDetail *ProductDetail, quantity int) PromotionLine {
+	return PromotionLine{
+		ProductDetailID:   productDetail.ID,
+		BrandID:           productDetail.Product.BrandID,
+		ProductCategoryID: productDetail.Product.ProductCategoryID,
+		SKU:               productDetail.SKU,
+		Quantity:          quantity,
+		Price:             productDetail.EffectivePrice(),
+		OnSale:            productDetail.CampaignItemID != nil,
+		IsGiftCard:        productDetail.Product.IsGiftCard,
+	}
+}
+
+// Discount is the amount a promotion takes off a set of lines.
+type Discount struct {
+	PromotionID int64  `json:"promotion_id"`
+	Name        string `json:"name"`
+	Value       int64  `json:"value"`
+}
+
+// matches reports whether a line is in the scope of the promotion.
+func (promotion *Promotion) matches(line PromotionLine) bool {
+	if promotion.BrandID != nil && *promotion.BrandID != line.BrandID {
+		return false
+	}
+
+	if promotion.ProductCategoryID != nil && *promotion.ProductCategoryID != line.ProductCategoryID {
+		return false
+	}
+
+	if len(promotion.SKUs) == 0 {
+		return true
+	}
+
+	for _, sku := range promotion.SKUs {
+		if sku == line.SKU {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ApplyPromotions evaluates the promotions, in the order given, over the lines. A unit
+// counts towards at most one promotion, so the promotions that come first win the units
+// they use. Units on a flash sale are already discounted and never count, nor do gift
+// cards, which are worth their price in store credit.
+func ApplyPromotions(promotions []*Promotion, lines []PromotionLine) []Discount {
+	var discounts []Discount
+
+	remaining := make([]int, len(lines))
+
+	for i, line := range lines {
+		if !line.OnSale && !line.IsGiftCard {
+			remaining[i] = line.Quantity
+		}
+	}
+
+	for _, promotion := range promotions {
+		var eligible []int
+
+		for i, line := range lines {
+			if remaining[i] > 0 && promotion.matches(line) {
+				eligible = append(eligible, i)
+			}
+		}
+
+		var value int64
+
+		switch promotion.Type {
+		case PromotionQuantityThreshold:
+			value = applyQuantityThreshold(promotion, lines, eligible, remaining)
+		case PromotionBuyXGetY:
+			value = applyBuyXGetY(promotion, lines, eligible, remaining)
+		case PromotionBundlePrice:
+			value = applyBundlePrice(promotion, lines, eligible, remaining)
+		}
+
+		if value > 0 {
+			discounts = append(discounts, Discount{PromotionID: promotion.ID, Name: promotion.Name, Value: value})
+		}
+	}
+
+	return discounts
+}
+
+func applyQuantityThreshold(promotion *Promotion, lines []PromotionLine, eligible []int, remaining []int) int64 {
+	var quantity int
+	var amount int64
+
+	for _, i := range eligible {
+		quantity += remaining[i]
+		amount += int64(remaining[i]) * lines[i].Price
+	}
+
+	if quantity < promotion.MinQuantity {
+		return 0
+	}
+
+	for _, i := range eligible {
+		remaining[i] = 0
+	}
+
+	return amount * int64(promotion.DiscountPercent) / 100
+}
+
+func applyBuyXGetY(promotion *Promotion, lines []PromotionLine, eligible []int, remaining []int) int64 {
+	var units []int
+
+	for _, i := range eligible {
+		for n := 0; n < remaining[i]; n++ {
+			units = append(units, i)
+		}
+	}
+
+	sort.SliceStable(units, func(a, b int) bool {
+		return lines[units[a]].Price > lines[units[b]].Price
+	})
+
+	size := promotion.MinQuantity + promotion.FreeQuantity
+	groups := len(units) / size
+
+	var value int64
+
+	for n, i := range units[:groups*size] {
+		if n%size >= promotion.MinQuantity {
+			value += lines[i].Price
+		}
+
+		remaining[i]--
+	}
+
+	return value
+}
+
+func applyBundlePrice(promotion *Promotion, lines []PromotionLine, eligible []int, remaining []int) int64 {
+	bundles := -1
+
+	for _, sku := range promotion.SKUs {
+		var quantity int
+
+		for _, i := range eligible {
+			if lines[i].SKU == sku {
+				quantity += remaining[i]
+			}
+		}
+
+		if bundles < 0 || quantity < bundles {
+			bundles = quantity
+		}
+	}
+
+	if bundles <= 0 {
+		return 0
+	}
+
+	var amount int64
+
+	taken := make([]int, len(remaining))
+
+	for _, sku := range promotion.SKUs {
+		needed := bundles
+
+		for _, i := range eligible {
+			if lines[i].SKU != sku || needed == 0 {
+				continue
+			}
+
+			n := remaining[i] - taken[i]
+			if n > needed {
+				n = needed
+			}
+
+			amount += int64(n) * lines[i].Price
+			taken[i] += n
+			needed -= n
+		}
+	}
+
+	// A bundle priced above its parts is no deal, so its units are left to the promotions
+	// after it.
+	value := amount - int64(bundles)*promotion.BundlePrice
+	if value <= 0 {
+		return 0
+	}
+
+	for i, n := range taken {
+		remaining[i] -= n
+	}
+
+	return value
+}
+
+type PromotionModel struct {
+	DB *gorm.DB
+}
+
+// ====================================================================================
+// Backoffice Functions
+// ====================================================================================
+
+func (m PromotionModel) GetAll(f Filters) ([]*Promotion, Metadata, error) {
+	var promotions []*Promotion
+	var count int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Scopes(Filter(f), OrderBy(f), Paginate(f.Pagination())).Find(&promotions).Error
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	err = m.DB.Table("promotions").Scopes(Filter(f)).Count(&count).Error
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(int(count), f.Page, f.PageSize)
+
+	return promotions, metadata, nil
+}
+
+func (m PromotionModel) Get(id int64) (*Promotion, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	var promotion *Promotion
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).First(&promotion, id).Error
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return promotion, nil
+}
+
+func (m PromotionModel) Insert(promotion *Promotion) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.WithContext(ctx).Create(promotion).Error
+}
+
+func (m PromotionModel) Update(promotion *Promotion) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result := m.DB.WithContext(ctx).Model(&Promotion{}).Where("id = ?", promotion.ID).Updates(map[string]interface{}{
+		"name":                promotion.Name,
+		"type":                promotion.Type,
+		"brand_id":            promotion.BrandID,
+		"product_category_id": promotion.ProductCategoryID,
+		"skus":                promotion.SKUs,
+		"min_quantity":        promotion.MinQuantity,
+		"free_quantity":       promotion.FreeQuantity,
+		"discount_percent":    promotion.DiscountPercent,
+		"bundle_price":        promotion.BundlePrice,
+		"priority":            promotion.Priority,
+		"starts_at":           promotion.StartsAt,
+		"ends_at":             promotion.EndsAt,
+		"is_active":           promotion.IsActive,
+		"updated_by":          promotion.UpdatedBy,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+func (m PromotionModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	ra := m.DB.WithContext(ctx).Delete(&Promotion{}, id).RowsAffected
+	if ra < 1 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// ====================================================================================
+// Business Functions
+// ====================================================================================
+
+// GetActive returns the promotions running now, in the order ApplyPromotions should
+// evaluate them.
+func (m PromotionModel) GetActive() ([]*Promotion, error) {
+	var promotions []*Promotion
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	now := time.Now()
+
+	err := m.DB.WithContext(ctx).
+		Where("is_active AND (starts_at IS NULL OR starts_at <= ?) AND (ends_at IS NULL OR ends_at > ?)", now, now).
+		Order("priority DESC, id").
+		Find(&promotions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return promotions, nil
+}
@@ -0,0 +1,69 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/kervinch/internal/validator"
+	"gorm.io/gorm"
+)
+
+// publishedCondition is the SQL condition of Published for queries written by hand. now
+// is the placeholder holding the current time.
+func publishedCondition(table, now string) string {
+	return fmt.Sprintf("(%[1]s.publish_at IS NULL OR %[1]s.publish_at <= %[2]s) AND (%[1]s.unpublish_at IS NULL OR %[1]s.unpublish_at > %[2]s)", table, now)
+}
+
+// isPublished tells whether a publishing window is open at now, like publishedCondition.
+func isPublished(publishAt, unpublishAt *time.Time, now time.Time) bool {
+	return (publishAt == nil || !publishAt.After(now)) && (unpublishAt == nil || unpublishAt.After(now))
+}
+
+func ValidatePublishingWindow(v *validator.Validator, publishAt, unpublishAt *time.Time) {
+	v.Check(publishAt == nil || unpublishAt == nil || unpublishAt.After(*publishAt), "unpublish_at", "must be later than publish_at")
+}
+
+type PublishingModel struct {
+	DB *gorm.DB
+}
+
+// NextTransition returns the first publish_at or unpublish_at later than after among the
+// banners, storefronts and products, which are the rows behind cached responses, or the
+// first start or end of a campaign, which changes the prices in them. It returns nil when
+// nothing is scheduled.
+func (m PublishingModel) NextTransition(after time.Time) (*time.Time, error) {
+	var next sql.NullTime
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Raw(`
+		SELECT MIN(at) FROM (
+			SELECT MIN(publish_at) AS at FROM banners WHERE publish_at > @after
+			UNION ALL
+			SELECT MIN(unpublish_at) FROM banners WHERE unpublish_at > @after
+			UNION ALL
+			SELECT MIN(publish_at) FROM storefronts WHERE publish_at > @after
+			UNION ALL
+			SELECT MIN(unpublish_at) FROM storefronts WHERE unpublish_at > @after
+			UNION ALL
+			SELECT MIN(publish_at) FROM products WHERE publish_at > @after
+			UNION ALL
+			SELECT MIN(unpublish_at) FROM products WHERE unpublish_at > @after
+			UNION ALL
+			SELECT MIN(starts_at) FROM campaigns WHERE is_active AND starts_at > @after
+			UNION ALL
+			SELECT MIN(ends_at) FROM campaigns WHERE is_active AND ends_at > @after
+		) transitions`, map[string]interface{}{"after": after}).Scan(&next).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if !next.Valid {
+		return nil, nil
+	}
+
+	return &next.Time, nil
+}
@@ -0,0 +1,229 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	RecommendationPersonal       = "personal"
+	RecommendationBoughtTogether = "bought_together"
+	RecommendationSimilar        = "similar"
+
+	// associationsPerProduct and recommendationsPerUser bound the precomputed tables, which
+	// are only ever read a page at a time.
+	associationsPerProduct = 20
+	recommendationsPerUser = 20
+)
+
+// SoldOrderStatuses are the order statuses that count as a sale: the order was paid and
+// was not refunded. They are matched on orders, as order details keep the status they
+// were created with.
+var SoldOrderStatuses = []string{"paid", "processing", "delivery", "completed", "refund_requested", "refund_rejected"}
+
+type RecommendationModel struct {
+	DB *gorm.DB
+}
+
+// boughtTogetherQuery links products that were sold in the same order, the more orders
+// they share the stronger the link.
+const boughtTogetherQuery = `
+	WITH sold_products AS (
+		SELECT DISTINCT order_details.order_id, product_details.product_id
+		FROM invoice_details
+		JOIN order_details ON order_details.id = invoice_details.order_detail_id
+		JOIN orders ON orders.id = order_details.order_id
+		JOIN product_details ON product_details.id = invoice_details.product_detail_id
+		WHERE orders.status IN @statuses
+	)
+	INSERT INTO product_associations (product_id, related_product_id, kind, score, ranking)
+	SELECT product_id, related_product_id, 'bought_together'::product_associations_kind_enum, score, ranking
+	FROM (
+		SELECT a.product_id, b.product_id AS related_product_id, COUNT(*) AS score,
+			ROW_NUMBER() OVER (PARTITION BY a.product_id ORDER BY COUNT(*) DESC, b.product_id) AS ranking
+		FROM sold_products a
+		JOIN sold_products b ON b.order_id = a.order_id AND b.product_id <> a.product_id
+		GROUP BY a.product_id, b.product_id
+	) pairs
+	WHERE ranking <= @limit`
+
+// similarQuery links active products sharing a category or a brand, a shared category
+// weighing more than a shared brand. Products closer in price rank higher.
+const similarQuery = `
+	WITH priced_products AS (
+		SELECT products.id, products.product_category_id, products.brand_id, MIN(product_details.price) AS price
+		FROM products
+		JOIN product_details ON product_details.product_id = products.id AND product_details.is_active
+		WHERE products.is_active
+		GROUP BY products.id
+	)
+	INSERT INTO product_associations (product_id, related_product_id, kind, score, ranking)
+	SELECT product_id, related_product_id, 'similar'::product_associations_kind_enum, score, ranking
+	FROM (
+		SELECT product_id, related_product_id, score,
+			ROW_NUMBER() OVER (PARTITION BY product_id ORDER BY score DESC, related_product_id) AS ranking
+		FROM (
+			SELECT a.id AS product_id, b.id AS related_product_id,
+				CASE WHEN a.product_category_id = b.product_category_id THEN 2 ELSE 0 END
+				+ CASE WHEN a.brand_id = b.brand_id THEN 1 ELSE 0 END
+				+ 1 - ABS(a.price - b.price)::double precision / GREATEST(a.price, b.price, 1) AS score
+			FROM priced_products a
+			JOIN priced_products b ON b.id <> a.id AND (b.product_category_id = a.product_category_id OR b.brand_id = a.brand_id)
+		) candidates
+	) ranked
+	WHERE ranking <= @limit`
+
+// personalQuery recommends to every user the products associated with their favorites,
+// cart and past orders, in that order of importance. Products the user already has in
+// one of them are left out.
+const personalQuery = `
+	WITH signals AS (
+		SELECT favorites.user_id, product_details.product_id, 3 AS weight
+		FROM favorites
+		JOIN product_details ON product_details.id = favorites.product_detail_id
+		UNION ALL
+		SELECT carts.user_id, product_details.product_id, 2 AS weight
+		FROM carts
+		JOIN product_details ON product_details.id = carts.product_detail_id
+		UNION ALL
+		SELECT orders.user_id, product_details.product_id, 1 AS weight
+		FROM invoice_details
+		JOIN order_details ON order_details.id = invoice_details.order_detail_id
+		JOIN orders ON orders.id = order_details.order_id
+		JOIN product_details ON product_details.id = invoice_details.product_detail_id
+		WHERE orders.status IN @statuses
+	)
+	INSERT INTO user_recommendations (user_id, product_id, score, ranking)
+	SELECT user_id, product_id, score, ranking
+	FROM (
+		SELECT user_id, product_id, score,
+			ROW_NUMBER() OVER (PARTITION BY user_id ORDER BY score DESC, product_id) AS ranking
+		FROM (
+			SELECT signals.user_id, product_associations.related_product_id AS product_id,
+				SUM(signals.weight * CASE WHEN product_associations.kind = 'bought_together' THEN 2 ELSE 1 END / product_associations.ranking::double precision) AS score
+			FROM signals
+			JOIN product_associations ON product_associations.product_id = signals.product_id
+			WHERE NOT EXISTS (
+				SELECT 1 FROM signals owned
+				WHERE owned.user_id = signals.user_id AND owned.product_id = product_associations.related_product_id
+			)
+			GROUP BY signals.user_id, product_associations.related_product_id
+		) candidates
+	) ranked
+	WHERE ranking <= @limit`
+
+// Refresh recomputes every recommendation. It runs in a single transaction so readers
+// keep seeing the previous results until the new ones are complete.
+func (m RecommendationModel) Refresh() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	return m.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec("DELETE FROM user_recommendations").Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Exec("DELETE FROM product_associations").Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Exec(boughtTogetherQuery, map[string]interface{}{"statuses": SoldOrderStatuses, "limit": associationsPerProduct}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Exec(similarQuery, map[string]interface{}{"limit": associationsPerProduct}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Exec(personalQuery, map[string]interface{}{"statuses": SoldOrderStatuses, "limit": recommendationsPerUser}).Error
+	})
+}
+
+// ====================================================================================
+// Business Functions
+// ====================================================================================
+
+// GetForProduct returns the active products bought together with, or similar to, a
+// product, strongest first.
+func (m RecommendationModel) GetForProduct(productID int64, kind string, limit int) ([]*Product, error) {
+	var products []*Product
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).
+		Select("products.*").
+		Joins("JOIN product_associations ON product_associations.related_product_id = products.id").
+		Where("product_associations.product_id = ? AND product_associations.kind = ? AND products.is_active", productID, kind).
+		Scopes(Published("products")).
+		Preload("ProductCategory").Preload("Brand").Preload("Storefront").Preload("ProductDetail", withSalePrice).Preload("ProductDetail.ProductImage").
+		Order("product_associations.ranking").
+		Limit(limit).
+		Find(&products).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return products, nil
+}
+
+// GetForUser returns the active products recommended to a user, strongest first.
+func (m RecommendationModel) GetForUser(userID int64, limit int) ([]*Product, error) {
+	var products []*Product
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).
+		Select("products.*").
+		Joins("JOIN user_recommendations ON user_recommendations.product_id = products.id").
+		Where("user_recommendations.user_id = ? AND products.is_active", userID).
+		Scopes(Published("products")).
+		Preload("ProductCategory").Preload("Brand").Preload("Storefront").Preload("ProductDetail", withSalePrice).Preload("ProductDetail.ProductImage").
+		Order("user_recommendations.ranking").
+		Limit(limit).
+		Find(&products).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return products, nil
+}
+
+// GetPopular returns the best selling active products of the last 30 days, topped up with
+// the newest products. It is what anonymous users, and users without any history, see.
+func (m RecommendationModel) GetPopular(limit int) ([]*Product, error) {
+	var products []*Product
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).
+		Select("products.*").
+		Joins(`LEFT JOIN (
+			SELECT product_details.product_id, SUM(invoice_details.quantity) AS sold
+			FROM invoice_details
+			JOIN order_details ON order_details.id = invoice_details.order_detail_id
+			JOIN orders ON orders.id = order_details.order_id
+			JOIN product_details ON product_details.id = invoice_details.product_detail_id
+			WHERE orders.status IN ? AND orders.created_at > ?
+			GROUP BY product_details.product_id
+		) sales ON sales.product_id = products.id`, SoldOrderStatuses, time.Now().AddDate(0, 0, -30)).
+		Where("products.is_active").
+		Scopes(Published("products")).
+		Preload("ProductCategory").Preload("Brand").Preload("Storefront").Preload("ProductDetail", withSalePrice).Preload("ProductDetail.ProductImage").
+		Order("COALESCE(sales.sold, 0) DESC, products.created_at DESC, products.id DESC").
+		Limit(limit).
+		Find(&products).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return products, nil
+}
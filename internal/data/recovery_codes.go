@@ -0,0 +1,131 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kervinch/internal/validator"
+)
+
+const RecoveryCodeCount = 10
+
+type RecoveryCodeModel struct {
+	DB *sql.DB
+}
+
+// generateRecoveryCode returns a code such as "h3k9q-2mzpx". Codes are lowercased and
+// stripped of the dash before hashing, so users can type them either way.
+func generateRecoveryCode() (string, error) {
+	randomBytes := make([]byte, 7)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes))[:10]
+
+	return code[:5] + "-" + code[5:], nil
+}
+
+func hashRecoveryCode(userID int64, code string) []byte {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%d:%s", userID, normalized)))
+	return hash[:]
+}
+
+func ValidateRecoveryCode(v *validator.Validator, code string) {
+	v.Check(code != "", "recovery_code", "must be provided")
+	v.Check(len(strings.ReplaceAll(code, "-", "")) == 10, "recovery_code", "must be 10 characters long")
+}
+
+// New replaces any existing recovery codes for the user and returns the new plaintext
+// codes. They are only ever shown once.
+func (m RecoveryCodeModel) New(userID int64) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, RecoveryCodeCount)
+
+	for i := 0; i < RecoveryCodeCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		query := `
+			INSERT INTO recovery_codes (user_id, hash)
+			VALUES ($1, $2)`
+
+		_, err = tx.ExecContext(ctx, query, userID, hashRecoveryCode(userID, code))
+		if err != nil {
+			return nil, err
+		}
+
+		codes = append(codes, code)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Use marks an unused recovery code as spent. It returns ErrRecordNotFound when the code
+// does not exist or has already been used.
+func (m RecoveryCodeModel) Use(userID int64, code string) error {
+	query := `
+		UPDATE recovery_codes
+		SET used_at = NOW()
+		WHERE user_id = $1 AND hash = $2 AND used_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, hashRecoveryCode(userID, code))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m RecoveryCodeModel) DeleteAllForUser(userID int64) error {
+	query := `
+		DELETE FROM recovery_codes
+		WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
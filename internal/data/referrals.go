@@ -0,0 +1,539 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/kervinch/internal/validator"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	ReferralPending  = "pending"
+	ReferralRewarded = "rewarded"
+	ReferralRejected = "rejected"
+	ReferralReversed = "reversed"
+)
+
+// ReferralSettings are the rewards of the referral programme. Each side of a converted
+// referral is granted its voucher, or credited its store credit when no voucher is set.
+type ReferralSettings struct {
+	ID                bool          `json:"-" gorm:"primaryKey"`
+	IsActive          bool          `json:"is_active"`
+	ReferrerVoucherID sql.NullInt64 `json:"referrer_voucher_id"`
+	ReferrerCredit    int64         `json:"referrer_credit"`
+	RefereeVoucherID  sql.NullInt64 `json:"referee_voucher_id"`
+	RefereeCredit     int64         `json:"referee_credit"`
+	UpdatedBy         sql.NullInt64 `json:"updated_by"`
+	UpdatedAt         time.Time     `json:"updated_at"`
+}
+
+func (ReferralSettings) TableName() string {
+	return "referral_settings"
+}
+
+func ValidateReferralSettings(v *validator.Validator, settings *ReferralSettings) {
+	v.Check(settings.ReferrerCredit >= 0, "referrer_credit", "must not be negative")
+	v.Check(settings.RefereeCredit >= 0, "referee_credit", "must not be negative")
+
+	if settings.IsActive {
+		v.Check(settings.ReferrerVoucherID.Valid || settings.ReferrerCredit > 0, "referrer_credit", "must be set when there is no referrer voucher")
+		v.Check(settings.RefereeVoucherID.Valid || settings.RefereeCredit > 0, "referee_credit", "must be set when there is no referee voucher")
+	}
+}
+
+// Referral links a user to the user whose code they registered with. The vouchers and
+// store credit of a rewarded referral are the ones granted to each side.
+type Referral struct {
+	ID                int64         `json:"id"`
+	ReferrerID        int64         `json:"referrer_id"`
+	Referrer          *GormUser     `json:"referrer,omitempty" gorm:"foreignKey:ReferrerID"`
+	RefereeID         int64         `json:"referee_id"`
+	Referee           *GormUser     `json:"referee,omitempty" gorm:"foreignKey:RefereeID"`
+	Code              string        `json:"code"`
+	Status            string        `json:"status" gorm:"default:pending"`
+	RejectionReason   string        `json:"rejection_reason,omitempty"`
+	DeviceID          string        `json:"-"`
+	OrderID           sql.NullInt64 `json:"order_id"`
+	ReferrerVoucherID sql.NullInt64 `json:"referrer_voucher_id"`
+	ReferrerCredit    int64         `json:"referrer_credit"`
+	RefereeVoucherID  sql.NullInt64 `json:"referee_voucher_id"`
+	RefereeCredit     int64         `json:"referee_credit"`
+	ConvertedAt       *time.Time    `json:"converted_at"`
+	CreatedAt         time.Time     `json:"created_at"`
+	UpdatedAt         time.Time     `json:"-"`
+}
+
+var ReferralSortSafeList = SortSafeList("id", "converted_at", "created_at")
+
+var ReferralFilterSafeList = map[string]FilterField{
+	"referrer_id":  {Column: "referrals.referrer_id", Type: FilterInt, Operators: EqualityOperators},
+	"referee_id":   {Column: "referrals.referee_id", Type: FilterInt, Operators: EqualityOperators},
+	"code":         {Column: "referrals.code", Type: FilterString, Operators: TextOperators},
+	"status":       {Column: "referrals.status", Type: FilterString, Operators: EqualityOperators, Values: []string{ReferralPending, ReferralRewarded, ReferralRejected, ReferralReversed}},
+	"converted_at": {Column: "referrals.converted_at", Type: FilterTime, Operators: RangeOperators},
+	"created_at":   {Column: "referrals.created_at", Type: FilterTime, Operators: RangeOperators},
+}
+
+// ReferralReport counts the referrals made over a period and how many converted.
+type ReferralReport struct {
+	Referrals      int64             `json:"referrals"`
+	Pending        int64             `json:"pending"`
+	Rewarded       int64             `json:"rewarded"`
+	Rejected       int64             `json:"rejected"`
+	ConversionRate float64           `json:"conversion_rate"`
+	TopReferrers   []*ReferrerReport `json:"top_referrers"`
+}
+
+// ReferrerReport counts the referrals of a single referrer.
+type ReferrerReport struct {
+	ReferrerID int64  `json:"referrer_id"`
+	Name       string `json:"name"`
+	Email      string `json:"email"`
+	Referrals  int64  `json:"referrals"`
+	Rewarded   int64  `json:"rewarded"`
+	Rejected   int64  `json:"rejected"`
+}
+
+// generateReferralCode returns a random code of 8 characters, short enough to be shared by
+// word of mouth.
+func generateReferralCode() (string, error) {
+	randomBytes := make([]byte, 5)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes), nil
+}
+
+type ReferralModel struct {
+	DB *gorm.DB
+}
+
+// ====================================================================================
+// Backoffice Functions
+// ====================================================================================
+
+func (m ReferralModel) GetAll(f Filters) ([]*Referral, Metadata, error) {
+	var referrals []*Referral
+	var count int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Scopes(Filter(f), OrderBy(f), Paginate(f.Pagination())).Preload("Referrer").Preload("Referee").Find(&referrals).Error
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	err = m.DB.Table("referrals").Scopes(Filter(f)).Count(&count).Error
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(int(count), f.Page, f.PageSize)
+
+	return referrals, metadata, nil
+}
+
+// GetReport counts the referrals made over the last days, and the referrers who brought
+// in the most of them.
+func (m ReferralModel) GetReport(days int, limit int) (*ReferralReport, error) {
+	report := &ReferralReport{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	since := time.Now().AddDate(0, 0, -days)
+
+	err := m.DB.WithContext(ctx).Table("referrals").
+		Select(`COUNT(*) AS referrals,
+			COUNT(*) FILTER (WHERE status = 'pending') AS pending,
+			COUNT(*) FILTER (WHERE status = 'rewarded') AS rewarded,
+			COUNT(*) FILTER (WHERE status = 'rejected') AS rejected`).
+		Where("created_at > ?", since).
+		Scan(report).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if report.Referrals > 0 {
+		report.ConversionRate = float64(report.Rewarded) / float64(report.Referrals)
+	}
+
+	err = m.DB.WithContext(ctx).Table("referrals").
+		Select(`referrals.referrer_id, users.name, users.email,
+			COUNT(*) AS referrals,
+			COUNT(*) FILTER (WHERE referrals.status = 'rewarded') AS rewarded,
+			COUNT(*) FILTER (WHERE referrals.status = 'rejected') AS rejected`).
+		Joins("JOIN users ON users.id = referrals.referrer_id").
+		Where("referrals.created_at > ?", since).
+		Group("referrals.referrer_id, users.name, users.email").
+		Order("rewarded DESC, referrals DESC, referrals.referrer_id").
+		Limit(limit).
+		Scan(&report.TopReferrers).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+func (m ReferralModel) GetSettings() (*ReferralSettings, error) {
+	var settings *ReferralSettings
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).First(&settings).Error
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return settings, nil
+}
+
+func (m ReferralModel) UpdateSettings(settings *ReferralSettings) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.WithContext(ctx).Model(&ReferralSettings{}).Where("id").Updates(map[string]interface{}{
+		"is_active":           settings.IsActive,
+		"referrer_voucher_id": settings.ReferrerVoucherID,
+		"referrer_credit":     settings.ReferrerCredit,
+		"referee_voucher_id":  settings.RefereeVoucherID,
+		"referee_credit":      settings.RefereeCredit,
+		"updated_by":          settings.UpdatedBy,
+	}).Error
+}
+
+// ====================================================================================
+// Business Functions
+// ====================================================================================
+
+// GetCode returns the referral code of a user, giving them one the first time.
+func (m ReferralModel) GetCode(userID int64) (string, error) {
+	var code sql.NullString
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Table("users").Select("referral_code").Where("id = ?", userID).Scan(&code).Error
+	if err != nil {
+		return "", err
+	}
+
+	// Another user may hold the code drawn, in which case another one is drawn.
+	for attempt := 0; !code.Valid && attempt < 3; attempt++ {
+		generated, err := generateReferralCode()
+		if err != nil {
+			return "", err
+		}
+
+		err = m.DB.WithContext(ctx).Table("users").Where("id = ? AND referral_code IS NULL", userID).Update("referral_code", generated).Error
+		if err != nil && err.Error() != `pq: duplicate key value violates unique constraint "users_referral_code_key"` {
+			return "", err
+		}
+
+		err = m.DB.WithContext(ctx).Table("users").Select("referral_code").Where("id = ?", userID).Scan(&code).Error
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if !code.Valid {
+		return "", errors.New("could not generate a unique referral code")
+	}
+
+	return code.String, nil
+}
+
+// GetReferrerID returns the user a referral code belongs to.
+func (m ReferralModel) GetReferrerID(code string) (int64, error) {
+	var userID int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Table("users").Select("id").Where("referral_code = ?", strings.ToUpper(strings.TrimSpace(code))).Scan(&userID).Error
+	if err != nil {
+		return 0, err
+	}
+
+	if userID == 0 {
+		return 0, ErrRecordNotFound
+	}
+
+	return userID, nil
+}
+
+func (m ReferralModel) Insert(referral *Referral) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.WithContext(ctx).Create(referral).Error
+}
+
+func (m ReferralModel) GetAllForUser(userID int64, p Pagination) ([]*Referral, Metadata, error) {
+	var referrals []*Referral
+	var count int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Scopes(Paginate(p)).Where("referrer_id = ?", userID).Order("id DESC").Find(&referrals).Error
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	err = m.DB.Table("referrals").Where("referrer_id = ?", userID).Count(&count).Error
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(int(count), p.Page, p.PageSize)
+
+	return referrals, metadata, nil
+}
+
+// RewardForOrder converts the pending referral of the user who placed an order being
+// completed, which is their first. Both sides are rewarded unless the fraud checks find
+// the two accounts belong to the same person, in which case the referral is rejected.
+// It returns the referral settled, or nil when there was none.
+func (m ReferralModel) RewardForOrder(order *Order) (*Referral, error) {
+	settings, err := m.GetSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	var referral *Referral
+
+	err = m.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("referee_id = ? AND status = ?", order.UserID, ReferralPending).First(&referral).Error
+		if err != nil {
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				referral = nil
+				return nil
+			default:
+				return err
+			}
+		}
+
+		reason, err := referralFraud(referral, order, tx)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+
+		referral.OrderID = sql.NullInt64{Int64: order.ID, Valid: true}
+		referral.ConvertedAt = &now
+		referral.Status = ReferralRewarded
+		referral.RejectionReason = reason
+
+		if reason != "" {
+			referral.Status = ReferralRejected
+		} else {
+			referral.ReferrerVoucherID, referral.ReferrerCredit = rewardOf(settings.ReferrerVoucherID, settings.ReferrerCredit)
+			referral.RefereeVoucherID, referral.RefereeCredit = rewardOf(settings.RefereeVoucherID, settings.RefereeCredit)
+
+			err = grantReferralReward(referral.ReferrerID, referral.ReferrerVoucherID, referral.ReferrerCredit, tx)
+			if err != nil {
+				return err
+			}
+
+			err = grantReferralReward(referral.RefereeID, referral.RefereeVoucherID, referral.RefereeCredit, tx)
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Model(&Referral{}).Where("id = ?", referral.ID).Updates(map[string]interface{}{
+			"status":              referral.Status,
+			"rejection_reason":    referral.RejectionReason,
+			"order_id":            referral.OrderID,
+			"referrer_voucher_id": referral.ReferrerVoucherID,
+			"referrer_credit":     referral.ReferrerCredit,
+			"referee_voucher_id":  referral.RefereeVoucherID,
+			"referee_credit":      referral.RefereeCredit,
+			"converted_at":        referral.ConvertedAt,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return referral, nil
+}
+
+// ReverseForOrder takes back the rewards of the referral converted by an order that was
+// refunded, and marks the referral reversed. A voucher already used or store credit
+// already spent cannot be taken back, so the reversal stops at what the user has left.
+func (m ReferralModel) ReverseForOrder(order *Order) error {
+	return m.DB.Transaction(func(tx *gorm.DB) error {
+		var referral *Referral
+
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("order_id = ? AND status = ?", order.ID, ReferralRewarded).First(&referral).Error
+		if err != nil {
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				return nil
+			default:
+				return err
+			}
+		}
+
+		err = revokeReferralReward(referral.ReferrerID, referral.ReferrerVoucherID, referral.ReferrerCredit, tx)
+		if err != nil {
+			return err
+		}
+
+		err = revokeReferralReward(referral.RefereeID, referral.RefereeVoucherID, referral.RefereeCredit, tx)
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(&Referral{}).Where("id = ?", referral.ID).Update("status", ReferralReversed).Error
+	})
+}
+
+// referralFraud returns why a referral looks like a user referring themselves, or an
+// empty string when it does not. The referee must not share a device, a phone number or
+// the delivery address of their first order with the referrer.
+func referralFraud(referral *Referral, order *Order, tx *gorm.DB) (string, error) {
+	var found int64
+
+	if referral.DeviceID != "" {
+		err := tx.Table("referrals").Where("device_id = ? AND id <> ?", referral.DeviceID, referral.ID).Count(&found).Error
+		if err != nil || found > 0 {
+			return "device used by another referral", err
+		}
+
+		err = tx.Table("product_views").Where("device_id = ? AND user_id = ?", referral.DeviceID, referral.ReferrerID).Count(&found).Error
+		if err != nil || found > 0 {
+			return "same device as the referrer", err
+		}
+	}
+
+	var phoneNumber string
+
+	err := tx.Table("users").Select("COALESCE(phone_number, '')").Where("id = ?", referral.ReferrerID).Scan(&phoneNumber).Error
+	if err != nil {
+		return "", err
+	}
+
+	if phoneNumber != "" {
+		err = tx.Table("users").Where("id = ? AND phone_number = ?", referral.RefereeID, phoneNumber).Count(&found).Error
+		if err != nil || found > 0 {
+			return "same phone number as the referrer", err
+		}
+
+		if order.PhoneNumber == phoneNumber {
+			return "same phone number as the referrer", nil
+		}
+	}
+
+	postalCode := strings.ToLower(strings.TrimSpace(order.PostalCode))
+	address := strings.ToLower(strings.TrimSpace(order.Address))
+
+	err = tx.Table("user_addresses").
+		Where("user_id = ? AND LOWER(TRIM(postal_code)) = ? AND LOWER(TRIM(address)) = ?", referral.ReferrerID, postalCode, address).
+		Count(&found).Error
+	if err != nil || found > 0 {
+		return "same address as the referrer", err
+	}
+
+	err = tx.Table("orders").
+		Where("user_id = ? AND LOWER(TRIM(postal_code)) = ? AND LOWER(TRIM(address)) = ?", referral.ReferrerID, postalCode, address).
+		Count(&found).Error
+	if err != nil || found > 0 {
+		return "same address as the referrer", err
+	}
+
+	return "", nil
+}
+
+// grantReferralReward gives a user one more of a voucher, or credits their store credit
+// when there is no voucher.
+func grantReferralReward(userID int64, voucherID sql.NullInt64, credit int64, tx *gorm.DB) error {
+	if voucherID.Valid {
+		return tx.Omit(clause.Associations).Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "voucher_id"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"quantity": gorm.Expr("user_vouchers.quantity + 1")}),
+		}).Create(&UserVoucher{UserID: userID, VoucherID: voucherID.Int64, Quantity: 1}).Error
+	}
+
+	if credit == 0 {
+		return nil
+	}
+
+	return WalletModel{DB: tx}.ApplyWithTx(&WalletEntry{
+		UserID: userID,
+		Type:   WalletCredit,
+		Amount: credit,
+		Reason: "referral reward",
+	}, tx)
+}
+
+// rewardOf returns the reward granted for a side of a referral: its voucher, or its store
+// credit when there is no voucher.
+func rewardOf(voucherID sql.NullInt64, credit int64) (sql.NullInt64, int64) {
+	if voucherID.Valid {
+		return voucherID, 0
+	}
+
+	return sql.NullInt64{}, credit
+}
+
+// revokeReferralReward takes back a voucher granted to a user, or debits the store credit
+// credited to them, as far as they have not used it.
+func revokeReferralReward(userID int64, voucherID sql.NullInt64, credit int64, tx *gorm.DB) error {
+	if voucherID.Valid {
+		return tx.Model(&UserVoucher{}).
+			Where("user_id = ? AND voucher_id = ? AND quantity > 0", userID, voucherID.Int64).
+			Update("quantity", gorm.Expr("quantity - 1")).Error
+	}
+
+	if credit == 0 {
+		return nil
+	}
+
+	var balance int64
+
+	err := tx.Model(&WalletEntry{}).
+		Select("COALESCE(SUM(remaining), 0)").
+		Where("user_id = ? AND remaining > 0 AND (expires_at IS NULL OR expires_at > ?)", userID, time.Now()).
+		Scan(&balance).Error
+	if err != nil {
+		return err
+	}
+
+	if credit > balance {
+		credit = balance
+	}
+
+	if credit == 0 {
+		return nil
+	}
+
+	return WalletModel{DB: tx}.ApplyWithTx(&WalletEntry{
+		UserID: userID,
+		Type:   WalletDebit,
+		Amount: -credit,
+		Reason: "referral reward reversed",
+	}, tx)
+}
@@ -1,6 +1,9 @@
 package data
 
 import (
+	"strings"
+	"time"
+
 	"gorm.io/gorm"
 )
 
@@ -33,3 +36,76 @@ func In(fn string, s []string) func(db *gorm.DB) *gorm.DB {
 		return db.Where(fn+" IN ?", s)
 	}
 }
+
+// Published keeps the rows of table that are inside their publishing window. The window
+// is checked against the clock of the API rather than of the database, so it agrees with
+// the scheduler that invalidates the cache at every bound.
+func Published(table string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(publishedCondition(table, "@now"), map[string]interface{}{"now": time.Now()})
+	}
+}
+
+// Filter applies the conditions of a CMS list. They must have been checked with
+// ValidateFilters, an unknown field panics like an unsafe sort parameter does.
+func Filter(f Filters) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		for _, c := range f.Conditions {
+			field, ok := f.FilterSafeList[c.Field]
+			if !ok {
+				panic("unsafe filter parameter: " + c.Field)
+			}
+
+			condition, value, err := field.condition(c)
+			if err != nil {
+				db.AddError(err)
+				return db
+			}
+
+			if field.Exists != "" {
+				condition = "EXISTS (" + field.Exists + " AND " + condition + ")"
+			}
+
+			db = db.Where(condition, value)
+		}
+
+		return db
+	}
+}
+
+// FilterJoined applies the conditions on a one-to-many relation to its rows joined into
+// the query as well, so only the rows that matched are returned and not their siblings.
+func FilterJoined(f Filters, table string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		for _, c := range f.Conditions {
+			field, ok := f.FilterSafeList[c.Field]
+			if !ok || field.Exists == "" || !strings.HasPrefix(field.Column, table+".") {
+				continue
+			}
+
+			condition, value, err := field.condition(c)
+			if err != nil {
+				db.AddError(err)
+				return db
+			}
+
+			db = db.Where(condition, value)
+		}
+
+		return db
+	}
+}
+
+// OrderBy sorts by the requested column and then by ID, so rows with equal values keep a
+// stable order across pages.
+func OrderBy(f Filters) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		column, direction := f.sortColumn(), f.sortDirection()
+
+		if column == "id" {
+			return db.Order("id " + direction)
+		}
+
+		return db.Order(column + " " + direction + ", id " + direction)
+	}
+}
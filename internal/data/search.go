@@ -0,0 +1,121 @@
+package data
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/kervinch/internal/validator"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Suggestion struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+type Suggestions struct {
+	Products          []Suggestion `json:"products"`
+	Brands            []Suggestion `json:"brands"`
+	ProductCategories []Suggestion `json:"product_categories"`
+	Storefronts       []Suggestion `json:"storefronts"`
+	DidYouMean        []string     `json:"did_you_mean,omitempty"`
+}
+
+func (s Suggestions) IsEmpty() bool {
+	return len(s.Products) == 0 && len(s.Brands) == 0 && len(s.ProductCategories) == 0 && len(s.Storefronts) == 0
+}
+
+func ValidateSearchQuery(v *validator.Validator, query string) {
+	v.Check(len(query) >= 2, "q", "must be at least 2 bytes long")
+	v.Check(len(query) <= 100, "q", "must not be more than 100 bytes long")
+}
+
+type SearchModel struct {
+	DB *gorm.DB
+}
+
+// escapeLike escapes the LIKE wildcards in user input so they are matched literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// ====================================================================================
+// Business Functions
+// ====================================================================================
+
+// Suggest returns active catalogue entries whose name starts with the query, or has a
+// word starting with it, falling back to trigram word similarity to tolerate typos.
+// Prefix matches are ranked first.
+func (m SearchModel) Suggest(query string, limit int) (Suggestions, error) {
+	suggestions := Suggestions{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	prefix := escapeLike(query) + "%"
+	wordPrefix := "% " + escapeLike(query) + "%"
+
+	for _, source := range []struct {
+		table     string
+		dst       *[]Suggestion
+		scheduled bool
+	}{
+		{"products", &suggestions.Products, true},
+		{"brands", &suggestions.Brands, false},
+		{"product_categories", &suggestions.ProductCategories, false},
+		{"storefronts", &suggestions.Storefronts, true},
+	} {
+		*source.dst = []Suggestion{}
+
+		db := m.DB.WithContext(ctx).Table(source.table)
+		if source.scheduled {
+			db = db.Scopes(Published(source.table))
+		}
+
+		err := db.
+			Select("id, name, slug").
+			Where("is_active = ?", true).
+			Where("(name ILIKE ? OR name ILIKE ? OR ? <% name)", prefix, wordPrefix, query).
+			Clauses(clause.OrderBy{Expression: clause.Expr{SQL: "name ILIKE ? DESC, word_similarity(?, name) DESC, name", Vars: []interface{}{prefix, query}}}).
+			Limit(limit).
+			Scan(source.dst).Error
+		if err != nil {
+			return Suggestions{}, err
+		}
+	}
+
+	return suggestions, nil
+}
+
+// DidYouMean returns the catalogue names closest to a query that found nothing. Word
+// similarity is used so a misspelt word still matches a long product name.
+func (m SearchModel) DidYouMean(query string, limit int) ([]string, error) {
+	var names []string
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	sql := `
+		SELECT name FROM (
+			SELECT name, word_similarity(?, name) AS score FROM products WHERE is_active = TRUE AND ` + publishedCondition("products", "?") + `
+			UNION
+			SELECT name, word_similarity(?, name) AS score FROM brands WHERE is_active = TRUE
+			UNION
+			SELECT name, word_similarity(?, name) AS score FROM product_categories WHERE is_active = TRUE
+		) candidates
+		WHERE score >= 0.5
+		ORDER BY score DESC, name
+		LIMIT ?`
+
+	now := time.Now()
+
+	err := m.DB.WithContext(ctx).Raw(sql, query, now, now, query, query, limit).Scan(&names).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
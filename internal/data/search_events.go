@@ -0,0 +1,148 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"time"
+
+	"github.com/kervinch/internal/validator"
+	"gorm.io/gorm"
+)
+
+type SearchEvent struct {
+	ID          int64         `json:"id"`
+	SearchID    string        `json:"search_id"`
+	Query       string        `json:"query"`
+	Filters     string        `json:"filters" gorm:"type:jsonb"`
+	ResultCount int           `json:"result_count"`
+	UserID      sql.NullInt64 `json:"user_id"`
+	DeviceID    string        `json:"device_id"`
+	CreatedAt   time.Time     `json:"created_at"`
+}
+
+type SearchClick struct {
+	ID        int64     `json:"id"`
+	SearchID  string    `json:"search_id"`
+	ProductID int64     `json:"product_id"`
+	Position  int       `json:"position"`
+	CreatedAt time.Time `json:"-"`
+}
+
+// SearchQueryReport aggregates the search events of a single, case-insensitive query.
+type SearchQueryReport struct {
+	Query            string    `json:"query"`
+	Searches         int64     `json:"searches"`
+	AverageResults   float64   `json:"average_results"`
+	Clicks           int64     `json:"clicks"`
+	ClickThroughRate float64   `json:"click_through_rate"`
+	LastSearchedAt   time.Time `json:"last_searched_at"`
+}
+
+func ValidateSearchClick(v *validator.Validator, searchClick *SearchClick) {
+	v.Check(searchClick.SearchID != "", "search_id", "must be provided")
+	v.Check(len(searchClick.SearchID) == 26, "search_id", "must be 26 bytes long")
+	v.Check(searchClick.ProductID > 0, "product_id", "must be a positive integer")
+	v.Check(searchClick.Position >= 0, "position", "must not be negative")
+}
+
+// NewSearchID returns an identifier that is sent to the client with the search results
+// and later links clicks back to the search, even though the event is stored
+// asynchronously.
+func NewSearchID() (string, error) {
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes), nil
+}
+
+type SearchEventModel struct {
+	DB *gorm.DB
+}
+
+// ====================================================================================
+// Backoffice Functions
+// ====================================================================================
+
+// report groups search events from the last days by query. Each search counts as clicked
+// when at least one of its results was opened.
+func (m SearchEventModel) report(days int, limit int, zeroResultsOnly bool, minimumSearches int, order string) ([]*SearchQueryReport, error) {
+	var reports []*SearchQueryReport
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := m.DB.WithContext(ctx).Table("search_events").
+		Select(`LOWER(search_events.query) AS query,
+			COUNT(*) AS searches,
+			AVG(search_events.result_count) AS average_results,
+			COALESCE(SUM(clicks.count), 0) AS clicks,
+			COUNT(clicks.search_id)::float / COUNT(*) AS click_through_rate,
+			MAX(search_events.created_at) AS last_searched_at`).
+		Joins("LEFT JOIN (SELECT search_id, COUNT(*) AS count FROM search_clicks GROUP BY search_id) clicks ON clicks.search_id = search_events.search_id").
+		Where("search_events.created_at >= ?", time.Now().AddDate(0, 0, -days))
+
+	if zeroResultsOnly {
+		query = query.Where("search_events.result_count = 0")
+	}
+
+	err := query.Group("LOWER(search_events.query)").
+		Having("COUNT(*) >= ?", minimumSearches).
+		Order(order).
+		Limit(limit).
+		Scan(&reports).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return reports, nil
+}
+
+func (m SearchEventModel) GetTopQueries(days int, limit int) ([]*SearchQueryReport, error) {
+	return m.report(days, limit, false, 1, "searches DESC, query")
+}
+
+func (m SearchEventModel) GetTopZeroResultQueries(days int, limit int) ([]*SearchQueryReport, error) {
+	return m.report(days, limit, true, 1, "searches DESC, query")
+}
+
+// GetLowestClickThroughQueries lists popular queries whose results are rarely opened,
+// which usually points to poor ranking or a missing synonym.
+func (m SearchEventModel) GetLowestClickThroughQueries(days int, limit int, minimumSearches int) ([]*SearchQueryReport, error) {
+	return m.report(days, limit, false, minimumSearches, "click_through_rate ASC, searches DESC, query")
+}
+
+// ====================================================================================
+// Business Functions
+// ====================================================================================
+
+func (m SearchEventModel) Insert(searchEvent *SearchEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.WithContext(ctx).Create(searchEvent).Error
+}
+
+func (m SearchEventModel) InsertClick(searchClick *SearchClick) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Create(searchClick).Error
+	if err != nil {
+		switch {
+		case err.Error() == `pq: insert or update on table "search_clicks" violates foreign key constraint "search_clicks_search_id_fkey"`:
+			return ErrRecordNotFound
+		case err.Error() == `pq: insert or update on table "search_clicks" violates foreign key constraint "search_clicks_product_id_fkey"`:
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
@@ -17,6 +17,8 @@ type Storefront struct {
 	Slug        string     `json:"slug"`
 	Product     []*Product `gorm:"many2many:product_storefront_subscriptions"`
 	IsActive    bool       `json:"is_active"`
+	PublishAt   *time.Time `json:"publish_at"`
+	UnpublishAt *time.Time `json:"unpublish_at"`
 	CreatedAt   time.Time  `json:"-"`
 	UpdatedAt   time.Time  `json:"-"`
 }
@@ -27,6 +29,17 @@ func ValidateStorefront(v *validator.Validator, storefront *Storefront) {
 	v.Check(len(storefront.Name) <= 500, "name", "must not be more than 500 bytes long")
 	v.Check(storefront.Description != "", "description", "must be provided")
 	v.Check(len(storefront.Description) <= 500, "description", "must not be more than 500 bytes long")
+
+	ValidatePublishingWindow(v, storefront.PublishAt, storefront.UnpublishAt)
+}
+
+var StorefrontSortSafeList = SortSafeList("id", "name")
+
+var StorefrontFilterSafeList = map[string]FilterField{
+	"name":         {Column: "storefronts.name", Type: FilterString, Operators: TextOperators},
+	"is_active":    {Column: "storefronts.is_active", Type: FilterBool, Operators: BoolOperators},
+	"publish_at":   {Column: "storefronts.publish_at", Type: FilterTime, Operators: RangeOperators},
+	"unpublish_at": {Column: "storefronts.unpublish_at", Type: FilterTime, Operators: RangeOperators},
 }
 
 type StorefrontModel struct {
@@ -37,24 +50,24 @@ type StorefrontModel struct {
 // Backoffice Functions
 // ====================================================================================
 
-func (m StorefrontModel) GetAll(p Pagination) ([]*Storefront, Metadata, error) {
+func (m StorefrontModel) GetAll(f Filters) ([]*Storefront, Metadata, error) {
 	var storefronts []*Storefront
 	var count int64
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.WithContext(ctx).Scopes(Paginate(p)).Find(&storefronts).Error
+	err := m.DB.WithContext(ctx).Scopes(Filter(f), OrderBy(f), Paginate(f.Pagination())).Find(&storefronts).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
-	err = m.DB.Table("storefronts").Count(&count).Error
+	err = m.DB.Table("storefronts").Scopes(Filter(f)).Count(&count).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(int(count), p.Page, p.PageSize)
+	metadata := calculateMetadata(int(count), f.Page, f.PageSize)
 
 	return storefronts, metadata, nil
 }
@@ -120,6 +133,8 @@ func (m StorefrontModel) Update(s *Storefront) error {
 	storefront.ImageURL = s.ImageURL
 	storefront.Slug = s.Slug
 	storefront.IsActive = s.IsActive
+	storefront.PublishAt = s.PublishAt
+	storefront.UnpublishAt = s.UnpublishAt
 
 	err = m.DB.Save(&storefront).Error
 	if err != nil {
@@ -166,7 +181,7 @@ func (m StorefrontModel) GetBySlugWithProducts(slug string) (*Storefront, error)
 
 	var storefront *Storefront
 
-	err := m.DB.WithContext(ctx).Where("is_active = ? AND slug = ?", true, slug).Preload("Product.ProductCategory").Preload("Product.Brand").Preload("Product.Storefront").Preload("Product.ProductDetail.ProductImage").First(&storefront).Error
+	err := m.DB.WithContext(ctx).Where("is_active = ? AND slug = ?", true, slug).Scopes(Published("storefronts")).Preload("Product", listedProducts).Preload("Product.ProductCategory").Preload("Product.Brand").Preload("Product.Storefront").Preload("Product.ProductDetail", withSalePrice).Preload("Product.ProductDetail.ProductImage").First(&storefront).Error
 	if err != nil {
 		switch {
 		case errors.Is(err, gorm.ErrRecordNotFound):
@@ -4,8 +4,12 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
 	"encoding/base32"
+	"errors"
+	"fmt"
+	"math/big"
 	"time"
 
 	"github.com/kervinch/internal/validator"
@@ -15,6 +19,24 @@ const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
 	ScopePasswordReset  = "password-reset"
+	ScopePhoneOTP       = "phone-otp"
+	ScopeTwoFactor      = "two-factor"
+	ScopeEmailChange    = "email-change"
+	ScopeEmailRevert    = "email-revert"
+)
+
+const (
+	OTPTTL         = 5 * time.Minute
+	OTPCooldown    = time.Minute
+	OTPHourlyLimit = 5
+	OTPMaxAttempts = 5
+	TwoFactorTTL   = 5 * time.Minute
+)
+
+var (
+	ErrOTPMismatch        = errors.New("otp mismatch")
+	ErrOTPTooManyAttempts = errors.New("otp too many attempts")
+	ErrOTPTooManyRequests = errors.New("otp too many requests")
 )
 
 type Token struct {
@@ -57,6 +79,37 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 	return token, nil
 }
 
+// generateOTP creates a 6-digit one-time password. Because the code space is small, the
+// stored hash is salted with the user ID and phone number, which also keeps the hash
+// unique in the tokens table and invalidates the code if the phone number changes.
+func generateOTP(userID int64, phoneNumber string, ttl time.Duration, scope string) (*Token, error) {
+	token := &Token{
+		UserID: userID,
+		Expiry: time.Now().Add(ttl),
+		Scope:  scope,
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return nil, err
+	}
+
+	token.Plaintext = fmt.Sprintf("%06d", n.Int64())
+	token.Hash = hashOTP(userID, phoneNumber, token.Plaintext)
+
+	return token, nil
+}
+
+func hashOTP(userID int64, phoneNumber string, code string) []byte {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%d:%s:%s", userID, phoneNumber, code)))
+	return hash[:]
+}
+
+func ValidateOTPPlaintext(v *validator.Validator, code string) {
+	v.Check(code != "", "code", "must be provided")
+	v.Check(len(code) == 6, "code", "must be 6 digits long")
+}
+
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 	v.Check(tokenPlaintext != "", "token", "must be provided")
 	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
@@ -76,6 +129,27 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	return token, err
 }
 
+// NewEmailRevert issues a token that gives the account of a user back to email, the
+// address it had before a change was requested.
+func (m TokenModel) NewEmailRevert(userID int64, email string, ttl time.Duration) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeEmailRevert)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO tokens (hash, user_id, expiry, scope, email)
+		VALUES ($1, $2, $3, $4, $5)`
+
+	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope, email}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	return token, err
+}
+
 func (m TokenModel) Insert(token *Token) error {
 	query := `
 		INSERT INTO tokens (hash, user_id, expiry, scope)
@@ -101,3 +175,149 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err
 }
+
+// NewOTP issues a one-time password, subject to the cooldown and the hourly limit. Issued
+// codes are counted in otp_requests, which verifying a code does not clear, and the user
+// row is locked meanwhile so concurrent requests cannot both pass the check.
+func (m TokenModel) NewOTP(userID int64, phoneNumber string, scope string) (*Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `SELECT id FROM users WHERE id = $1 FOR UPDATE`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT COUNT(*), COALESCE(MAX(created_at), to_timestamp(0))
+		FROM otp_requests
+		WHERE scope = $1 AND user_id = $2 AND created_at > $3`
+
+	var count int
+	var latest time.Time
+
+	err = tx.QueryRowContext(ctx, query, scope, userID, time.Now().Add(-time.Hour)).Scan(&count, &latest)
+	if err != nil {
+		return nil, err
+	}
+
+	if count >= OTPHourlyLimit || time.Since(latest) < OTPCooldown {
+		return nil, ErrOTPTooManyRequests
+	}
+
+	token, err := generateOTP(userID, phoneNumber, OTPTTL, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	// Requests older than the hourly window no longer count, so they are cleared here.
+	query = `
+		DELETE FROM otp_requests
+		WHERE user_id = $1 AND created_at <= $2`
+
+	_, err = tx.ExecContext(ctx, query, userID, time.Now().Add(-time.Hour))
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		INSERT INTO otp_requests (user_id, scope)
+		VALUES ($1, $2)`
+
+	_, err = tx.ExecContext(ctx, query, userID, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		INSERT INTO tokens (hash, user_id, expiry, scope)
+		VALUES ($1, $2, $3, $4)`
+
+	_, err = tx.ExecContext(ctx, query, token.Hash, token.UserID, token.Expiry, token.Scope)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, tx.Commit()
+}
+
+// VerifyOTP checks a code against the latest unexpired OTP for the user. Every failed
+// attempt is counted, and the code is locked once OTPMaxAttempts is reached.
+func (m TokenModel) VerifyOTP(userID int64, phoneNumber string, scope string, code string) error {
+	query := `
+		SELECT hash, attempts
+		FROM tokens
+		WHERE scope = $1 AND user_id = $2 AND expiry > $3
+		ORDER BY expiry DESC
+		LIMIT 1`
+
+	var hash []byte
+	var attempts int
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, scope, userID, time.Now()).Scan(&hash, &attempts)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if attempts >= OTPMaxAttempts {
+		return ErrOTPTooManyAttempts
+	}
+
+	if subtle.ConstantTimeCompare(hash, hashOTP(userID, phoneNumber, code)) == 1 {
+		return nil
+	}
+
+	query = `
+		UPDATE tokens
+		SET attempts = attempts + 1
+		WHERE hash = $1`
+
+	_, err = m.DB.ExecContext(ctx, query, hash)
+	if err != nil {
+		return err
+	}
+
+	return ErrOTPMismatch
+}
+
+// IncrementAttempts records a failed attempt against a token and returns the new count.
+func (m TokenModel) IncrementAttempts(scope string, tokenPlaintext string) (int, error) {
+	query := `
+		UPDATE tokens
+		SET attempts = attempts + 1
+		WHERE hash = $1 AND scope = $2
+		RETURNING attempts`
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	var attempts int
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], scope).Scan(&attempts)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return attempts, nil
+}
@@ -29,6 +29,16 @@ func ValidateUserVoucher(v *validator.Validator, userVoucher *UserVoucher) {
 	v.Check(userVoucher.Quantity != 0, "quantity", "must be provided")
 }
 
+var UserVoucherSortSafeList = SortSafeList("id", "quantity", "created_at")
+
+var UserVoucherFilterSafeList = map[string]FilterField{
+	"user_id":    {Column: "user_vouchers.user_id", Type: FilterInt, Operators: EqualityOperators},
+	"user_email": {Column: "users.email", Type: FilterString, Operators: TextOperators, Exists: "SELECT 1 FROM users WHERE users.id = user_vouchers.user_id"},
+	"voucher_id": {Column: "user_vouchers.voucher_id", Type: FilterInt, Operators: EqualityOperators},
+	"quantity":   {Column: "user_vouchers.quantity", Type: FilterInt, Operators: RangeOperators},
+	"created_at": {Column: "user_vouchers.created_at", Type: FilterTime, Operators: RangeOperators},
+}
+
 type UserVoucherModel struct {
 	DB *gorm.DB
 }
@@ -37,24 +47,24 @@ type UserVoucherModel struct {
 // Backoffice Functions
 // ====================================================================================
 
-func (m UserVoucherModel) GetAll(p Pagination) ([]*UserVoucher, Metadata, error) {
+func (m UserVoucherModel) GetAll(f Filters) ([]*UserVoucher, Metadata, error) {
 	var userVoucher []*UserVoucher
 	var count int64
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.WithContext(ctx).Scopes(Paginate(p)).Preload("GormUser").Preload("Voucher").Order("created_at DESC").Find(&userVoucher).Error
+	err := m.DB.WithContext(ctx).Scopes(Filter(f), OrderBy(f), Paginate(f.Pagination())).Preload("GormUser").Preload("Voucher").Find(&userVoucher).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
-	err = m.DB.Table("user_vouchers").Count(&count).Error
+	err = m.DB.Table("user_vouchers").Scopes(Filter(f)).Count(&count).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(int(count), p.Page, p.PageSize)
+	metadata := calculateMetadata(int(count), f.Page, f.PageSize)
 
 	return userVoucher, metadata, nil
 }
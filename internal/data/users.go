@@ -19,30 +19,38 @@ var (
 )
 
 type User struct {
-	ID          int64     `json:"id"`
-	CreatedAt   time.Time `json:"created_at"`
-	Name        string    `json:"name"`
-	Email       string    `json:"email"`
-	Password    password  `json:"-"`
-	Activated   bool      `json:"activated"`
-	Version     int       `json:"version"`
-	Role        string    `json:"role,omitempty"`
-	Gender      string    `json:"gender"`
-	DateOfBirth time.Time `json:"date_of_birth"`
-	PhoneNumber string    `json:"phone_number"`
+	ID            int64     `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	Name          string    `json:"name"`
+	Email         string    `json:"email"`
+	PendingEmail  string    `json:"pending_email,omitempty"`
+	Password      password  `json:"-"`
+	Activated     bool      `json:"activated"`
+	Version       int       `json:"version"`
+	Role          string    `json:"role,omitempty"`
+	Gender        string    `json:"gender"`
+	DateOfBirth   time.Time `json:"date_of_birth"`
+	PhoneNumber   string    `json:"phone_number"`
+	PhoneVerified bool      `json:"phone_verified"`
+	TOTPSecret    string    `json:"-"`
+	TOTPEnabled   bool      `json:"two_factor_enabled"`
+	// DeletionScheduledAt is set while an account deletion request is within its grace
+	// period. The account is anonymised once this time has passed.
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
 }
 
 type GormUser struct {
-	ID          int64     `json:"id"`
-	CreatedAt   time.Time `json:"created_at"`
-	Name        string    `json:"name"`
-	Email       string    `json:"email"`
-	Activated   bool      `json:"activated"`
-	Version     int       `json:"version"`
-	Role        string    `json:"role,omitempty"`
-	Gender      string    `json:"gender"`
-	DateOfBirth time.Time `json:"date_of_birth"`
-	PhoneNumber string    `json:"phone_number"`
+	ID            int64     `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	Name          string    `json:"name"`
+	Email         string    `json:"email"`
+	Activated     bool      `json:"activated"`
+	Version       int       `json:"version"`
+	Role          string    `json:"role,omitempty"`
+	Gender        string    `json:"gender"`
+	DateOfBirth   time.Time `json:"date_of_birth"`
+	PhoneNumber   string    `json:"phone_number"`
+	PhoneVerified bool      `json:"phone_verified"`
 }
 
 type GormUserModel struct {
@@ -53,6 +61,22 @@ func (GormUser) TableName() string {
 	return "users"
 }
 
+// GetAdmins returns the activated admins, who receive operational notifications such as
+// low stock alerts.
+func (m GormUserModel) GetAdmins() ([]*GormUser, error) {
+	var users []*GormUser
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Where("role = ? AND activated = ?", "admin", true).Order("id").Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
 func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
@@ -163,7 +187,7 @@ func (m UserModel) Insert(user *User, role string) error {
 
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-		SELECT id, created_at, name, email, password_hash, activated, version
+		SELECT id, created_at, name, email, password_hash, activated, version, role, COALESCE(totp_secret, '') totp_secret, totp_enabled
 		FROM users
 		WHERE email = $1`
 
@@ -180,6 +204,48 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.Password.hash,
 		&user.Activated,
 		&user.Version,
+		&user.Role,
+		&user.TOTPSecret,
+		&user.TOTPEnabled,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+func (m UserModel) GetByOAuthIdentity(provider string, subject string) (*User, error) {
+	query := `
+		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version, users.role, COALESCE(users.totp_secret, '') totp_secret, users.totp_enabled
+		FROM users
+		INNER JOIN oauth_identities
+		ON users.id = oauth_identities.user_id
+		WHERE oauth_identities.provider = $1
+		AND oauth_identities.subject = $2`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, provider, subject).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+		&user.Role,
+		&user.TOTPSecret,
+		&user.TOTPEnabled,
 	)
 
 	if err != nil {
@@ -232,7 +298,7 @@ func (m UserModel) GetForToken(tokenScope string, tokenPlaintext string) (*User,
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 
 	query := `
-		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version, users.role, COALESCE(users.gender, '') gender, COALESCE(users.date_of_birth, '0001-01-01 00:00:00 +0000') date_of_birth, COALESCE(users.phone_number, '') phone_number FROM users
+		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version, users.role, COALESCE(users.gender, '') gender, COALESCE(users.date_of_birth, '0001-01-01 00:00:00 +0000') date_of_birth, COALESCE(users.phone_number, '') phone_number, users.phone_verified, COALESCE(users.totp_secret, '') totp_secret, users.totp_enabled, users.deletion_scheduled_at, COALESCE(users.pending_email, '') pending_email FROM users
 		INNER JOIN tokens
 		ON users.id = tokens.user_id
 		WHERE tokens.hash = $1
@@ -259,6 +325,11 @@ func (m UserModel) GetForToken(tokenScope string, tokenPlaintext string) (*User,
 		&user.Gender,
 		&user.DateOfBirth,
 		&user.PhoneNumber,
+		&user.PhoneVerified,
+		&user.TOTPSecret,
+		&user.TOTPEnabled,
+		&user.DeletionScheduledAt,
+		&user.PendingEmail,
 	)
 	if err != nil {
 		switch {
@@ -364,7 +435,7 @@ func (m UserModel) UpdateDateOfBirth(user *User) error {
 func (m UserModel) UpdatePhoneNumber(user *User) error {
 	query := `
 		UPDATE users
-		SET phone_number = $1, version = version + 1
+		SET phone_number = $1, phone_verified = FALSE, version = version + 1
 		WHERE id = $2 AND version = $3
 		RETURNING version`
 
@@ -387,5 +458,356 @@ func (m UserModel) UpdatePhoneNumber(user *User) error {
 		}
 	}
 
+	user.PhoneVerified = false
+
+	return nil
+}
+
+func (m UserModel) UpdatePhoneVerified(user *User) error {
+	query := `
+		UPDATE users
+		SET phone_verified = $1, version = version + 1
+		WHERE id = $2 AND version = $3 AND phone_number = $4
+		RETURNING version`
+
+	args := []interface{}{
+		user.PhoneVerified,
+		user.ID,
+		user.Version,
+		user.PhoneNumber,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UseTOTPStep records the time step of an accepted TOTP code. It reports false when a
+// code of that step or a later one was accepted before, so every code works only once.
+// The version is left alone, as this is not an edit of the profile.
+func (m UserModel) UseTOTPStep(userID int64, step int64) (bool, error) {
+	query := `
+		UPDATE users
+		SET totp_last_step = $1
+		WHERE id = $2 AND totp_last_step < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, step, userID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+func (m UserModel) UpdateTOTP(user *User) error {
+	query := `
+		UPDATE users
+		SET totp_secret = NULLIF($1, ''), totp_enabled = $2, version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING version`
+
+	args := []interface{}{
+		user.TOTPSecret,
+		user.TOTPEnabled,
+		user.ID,
+		user.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m UserModel) UpdateDeletionSchedule(user *User) error {
+	query := `
+		UPDATE users
+		SET deletion_scheduled_at = $1, version = version + 1
+		WHERE id = $2 AND version = $3
+		RETURNING version`
+
+	args := []interface{}{
+		user.DeletionScheduledAt,
+		user.ID,
+		user.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m UserModel) GetAllDueForDeletion() ([]int64, error) {
+	query := `
+		SELECT id
+		FROM users
+		WHERE deletion_scheduled_at <= NOW() AND anonymised_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+
+	for rows.Next() {
+		var id int64
+
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// Anonymise strips the personal data of a user while keeping the users row, so orders,
+// invoices and refunds stay intact for accounting. Data that only exists for the user's
+// own convenience, such as addresses, carts and favorites, is deleted outright.
+func (m UserModel) Anonymise(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE users
+		SET name = 'Deleted User', email = $1, pending_email = NULL, activated = FALSE, gender = NULL, date_of_birth = NULL,
+			phone_number = NULL, phone_verified = FALSE, totp_secret = NULL, totp_enabled = FALSE, referral_code = NULL,
+			deletion_scheduled_at = NULL, anonymised_at = NOW(), version = version + 1
+		WHERE id = $2 AND anonymised_at IS NULL`
+
+	// The email column is unique and not nullable, so it is replaced with an address on a
+	// domain we own that can never receive mail.
+	email := fmt.Sprintf("deleted-%d@deleted.kinofficial.co", userID)
+
+	result, err := tx.ExecContext(ctx, query, email, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	query = `
+		UPDATE orders
+		SET receiver = 'Deleted User', phone_number = '', address = ''
+		WHERE user_id = $1`
+
+	_, err = tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	// Ratings stay on the products they were given to, but what the user wrote and the
+	// photos they shared do not.
+	_, err = tx.ExecContext(ctx, `UPDATE product_reviews SET content = '', photos = '{}' WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	// Referrals are kept for the rewards they paid out, but not the device they were made on.
+	_, err = tx.ExecContext(ctx, `UPDATE referrals SET device_id = '' WHERE referrer_id = $1 OR referee_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	// Search analytics are kept, but can no longer be traced back to the user.
+	_, err = tx.ExecContext(ctx, `UPDATE search_events SET user_id = NULL, device_id = NULL WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	// Product views are kept for trending products, but neither they nor what was viewed on
+	// the devices of the user before they signed in can be traced back to them.
+	query = `
+		DELETE FROM recently_viewed_products
+		WHERE user_id IS NULL AND device_id IN (SELECT device_id FROM product_views WHERE user_id = $1 AND device_id <> '')`
+
+	_, err = tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		UPDATE product_views
+		SET user_id = NULL, device_id = ''
+		WHERE user_id = $1 OR device_id IN (SELECT device_id FROM product_views WHERE user_id = $1 AND device_id <> '')`
+
+	_, err = tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	tables := []string{"tokens", "oauth_identities", "recovery_codes", "user_addresses", "carts", "favorites", "wishlist_collections", "inbox_users", "inbox", "back_in_stock_subscriptions", "product_alert_deliveries", "user_recommendations", "recently_viewed_products"}
+
+	for _, table := range tables {
+		_, err = tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE user_id = $1", table), userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (m UserModel) UpdatePendingEmail(user *User) error {
+	query := `
+		UPDATE users
+		SET pending_email = NULLIF($1, ''), version = version + 1
+		WHERE id = $2 AND version = $3
+		RETURNING version`
+
+	args := []interface{}{
+		user.PendingEmail,
+		user.ID,
+		user.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ConfirmPendingEmail replaces the user's email with their pending email. The unique
+// constraint on email is checked again here, since the address may have been registered
+// after the change was requested. Only the pending email is matched, so other edits made
+// to the profile since then do not void the link.
+func (m UserModel) ConfirmPendingEmail(user *User) error {
+	query := `
+		UPDATE users
+		SET email = pending_email, pending_email = NULL, version = version + 1
+		WHERE id = $1 AND pending_email = $2
+		RETURNING email, version`
+
+	args := []interface{}{
+		user.ID,
+		user.PendingEmail,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Email, &user.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return ErrDuplicateEmail
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	user.PendingEmail = ""
+
 	return nil
 }
+
+// RevertEmail gives the account of an email-revert token back to the address the token
+// was sent to, and drops any pending change. It works whether or not the change was
+// confirmed meanwhile.
+func (m UserModel) RevertEmail(tokenPlaintext string) (*User, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		UPDATE users
+		SET email = tokens.email, pending_email = NULL, version = users.version + 1
+		FROM tokens
+		WHERE tokens.hash = $1 AND tokens.scope = $2 AND tokens.expiry > $3
+		AND tokens.email IS NOT NULL AND users.id = tokens.user_id
+		RETURNING users.id, users.email, users.version`
+
+	args := []interface{}{tokenHash[:], ScopeEmailRevert, time.Now()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var user User
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.Email, &user.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return nil, ErrDuplicateEmail
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
@@ -29,6 +29,8 @@ type Voucher struct {
 	IsActive          bool          `json:"is_active"`
 	EffectiveAt       time.Time     `json:"effective_at"`
 	ExpiredAt         time.Time     `json:"expired_at"`
+	PublishAt         *time.Time    `json:"publish_at"`
+	UnpublishAt       *time.Time    `json:"unpublish_at"`
 	CreatedAt         time.Time     `json:"-"`
 	UpdatedAt         time.Time     `json:"-"`
 	CreatedBy         int64         `json:"created_by"`
@@ -39,6 +41,25 @@ func ValidateVoucher(v *validator.Validator, voucher *Voucher) {
 	v.Check(voucher.Type != "", "type", "must be provided")
 	v.Check(voucher.Code != "", "code", "must be provided")
 	v.Check(voucher.Value != 0, "value", "must be not be zero")
+
+	ValidatePublishingWindow(v, voucher.PublishAt, voucher.UnpublishAt)
+}
+
+var VoucherSortSafeList = SortSafeList("id", "name", "value", "stock", "effective_at", "expired_at", "created_at")
+
+var VoucherFilterSafeList = map[string]FilterField{
+	"name":         {Column: "vouchers.name", Type: FilterString, Operators: TextOperators},
+	"code":         {Column: "vouchers.code", Type: FilterString, Operators: TextOperators},
+	"type":         {Column: "vouchers.type", Type: FilterString, Operators: EqualityOperators, Values: []string{"brand", "ship", "total"}},
+	"brand_id":     {Column: "vouchers.brand_id", Type: FilterInt, Operators: EqualityOperators},
+	"logistic_id":  {Column: "vouchers.logistic_id", Type: FilterInt, Operators: EqualityOperators},
+	"is_active":    {Column: "vouchers.is_active", Type: FilterBool, Operators: BoolOperators},
+	"is_percent":   {Column: "vouchers.is_percent", Type: FilterBool, Operators: BoolOperators},
+	"effective_at": {Column: "vouchers.effective_at", Type: FilterTime, Operators: RangeOperators},
+	"expired_at":   {Column: "vouchers.expired_at", Type: FilterTime, Operators: RangeOperators},
+	"publish_at":   {Column: "vouchers.publish_at", Type: FilterTime, Operators: RangeOperators},
+	"unpublish_at": {Column: "vouchers.unpublish_at", Type: FilterTime, Operators: RangeOperators},
+	"created_at":   {Column: "vouchers.created_at", Type: FilterTime, Operators: RangeOperators},
 }
 
 type VoucherModel struct {
@@ -49,24 +70,24 @@ type VoucherModel struct {
 // Backoffice Functions
 // ====================================================================================
 
-func (m VoucherModel) GetAll(p Pagination) ([]*Voucher, Metadata, error) {
+func (m VoucherModel) GetAll(f Filters) ([]*Voucher, Metadata, error) {
 	var voucher []*Voucher
 	var count int64
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.WithContext(ctx).Scopes(Paginate(p)).Preload("Brand").Preload("Logistic").Order("created_at DESC").Find(&voucher).Error
+	err := m.DB.WithContext(ctx).Scopes(Filter(f), OrderBy(f), Paginate(f.Pagination())).Preload("Brand").Preload("Logistic").Find(&voucher).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
-	err = m.DB.Table("vouchers").Count(&count).Error
+	err = m.DB.Table("vouchers").Scopes(Filter(f)).Count(&count).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(int(count), p.Page, p.PageSize)
+	metadata := calculateMetadata(int(count), f.Page, f.PageSize)
 
 	return voucher, metadata, nil
 }
@@ -133,6 +154,8 @@ func (m VoucherModel) Update(v *Voucher) error {
 	voucher.Slug = v.Slug
 	voucher.EffectiveAt = v.EffectiveAt
 	voucher.ExpiredAt = v.ExpiredAt
+	voucher.PublishAt = v.PublishAt
+	voucher.UnpublishAt = v.UnpublishAt
 	voucher.CreatedBy = v.CreatedBy
 	voucher.UpdatedBy = v.UpdatedBy
 
@@ -176,12 +199,12 @@ func (m VoucherModel) GetByBrands(p Pagination, brandID []int) ([]*Voucher, Meta
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.WithContext(ctx).Scopes(Paginate(p)).Preload("Brand").Preload("Logistic").Where("brand_id = ?", brandID).Order("brand_id ASC").Find(&voucher).Error
+	err := m.DB.WithContext(ctx).Scopes(Paginate(p), Published("vouchers")).Preload("Brand").Preload("Logistic").Where("brand_id = ?", brandID).Order("brand_id ASC").Find(&voucher).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
-	err = m.DB.Table("vouchers").Where("brand_id = ?", brandID).Count(&count).Error
+	err = m.DB.Table("vouchers").Scopes(Published("vouchers")).Where("brand_id = ?", brandID).Count(&count).Error
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -236,7 +259,7 @@ func (m VoucherModel) GetByID(id int64) (*Voucher, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.WithContext(ctx).Where("id = ?", id).Where("is_active = ?", true).First(&voucher).Error
+	err := m.DB.WithContext(ctx).Where("id = ?", id).Where("is_active = ?", true).Scopes(Published("vouchers")).First(&voucher).Error
 	if err != nil {
 		switch {
 		case errors.Is(err, gorm.ErrRecordNotFound):
@@ -0,0 +1,381 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/kervinch/internal/validator"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	WalletCredit = "credit"
+	WalletDebit  = "debit"
+	WalletExpiry = "expiry"
+)
+
+// WalletEntry is an entry of the store credit ledger. Amount is signed, so the store
+// credit of a user is the sum of the entries, and BalanceAfter records it right after the
+// entry. Debits spend the credits that expire first, Remaining is the part of a credit
+// not spent yet, which is what expires at ExpiresAt.
+type WalletEntry struct {
+	ID            int64         `json:"id"`
+	UserID        int64         `json:"user_id"`
+	Type          string        `json:"type"`
+	Amount        int64         `json:"amount"`
+	BalanceAfter  int64         `json:"balance_after"`
+	Remaining     int64         `json:"remaining"`
+	ExpiresAt     *time.Time    `json:"expires_at"`
+	Reason        string        `json:"reason"`
+	OrderID       sql.NullInt64 `json:"order_id"`
+	OrderRefundID sql.NullInt64 `json:"order_refund_id"`
+	GiftCardID    sql.NullInt64 `json:"gift_card_id"`
+	CreatedBy     sql.NullInt64 `json:"created_by"`
+	CreatedAt     time.Time     `json:"created_at"`
+}
+
+// WalletSpend is the part of a credit a debit was taken from.
+type WalletSpend struct {
+	EntryID  int64
+	SourceID int64
+	Amount   int64
+}
+
+// WalletAdjustment is a credit or debit made by hand from the CMS.
+type WalletAdjustment struct {
+	UserID    int64      `json:"user_id"`
+	Amount    int64      `json:"amount"`
+	Reason    string     `json:"reason"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func ValidateWalletAdjustment(v *validator.Validator, adjustment *WalletAdjustment) {
+	v.Check(adjustment.UserID > 0, "user_id", "must be a positive integer")
+	v.Check(adjustment.Amount != 0, "amount", "must not be zero")
+	v.Check(adjustment.Reason != "", "reason", "must be provided")
+	v.Check(adjustment.ExpiresAt == nil || adjustment.Amount > 0, "expires_at", "must only be set on a credit")
+	v.Check(adjustment.ExpiresAt == nil || adjustment.ExpiresAt.After(time.Now()), "expires_at", "must be in the future")
+}
+
+var WalletEntrySortSafeList = SortSafeList("id", "amount", "created_at")
+
+var WalletEntryFilterSafeList = map[string]FilterField{
+	"user_id":    {Column: "wallet_entries.user_id", Type: FilterInt, Operators: EqualityOperators},
+	"type":       {Column: "wallet_entries.type", Type: FilterString, Operators: EqualityOperators, Values: []string{WalletCredit, WalletDebit, WalletExpiry}},
+	"order_id":   {Column: "wallet_entries.order_id", Type: FilterInt, Operators: EqualityOperators},
+	"created_at": {Column: "wallet_entries.created_at", Type: FilterTime, Operators: RangeOperators},
+}
+
+type WalletModel struct {
+	DB *gorm.DB
+}
+
+// ====================================================================================
+// Backoffice Functions
+// ====================================================================================
+
+func (m WalletModel) GetAll(f Filters) ([]*WalletEntry, Metadata, error) {
+	var entries []*WalletEntry
+	var count int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Scopes(Filter(f), OrderBy(f), Paginate(f.Pagination())).Find(&entries).Error
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	err = m.DB.Table("wallet_entries").Scopes(Filter(f)).Count(&count).Error
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(int(count), f.Page, f.PageSize)
+
+	return entries, metadata, nil
+}
+
+// Adjust credits or debits the store credit of a user by hand.
+func (m WalletModel) Adjust(adjustment *WalletAdjustment, adminID int64) (*WalletEntry, error) {
+	entry := &WalletEntry{
+		UserID:    adjustment.UserID,
+		Type:      WalletCredit,
+		Amount:    adjustment.Amount,
+		ExpiresAt: adjustment.ExpiresAt,
+		Reason:    adjustment.Reason,
+		CreatedBy: sql.NullInt64{Int64: adminID, Valid: true},
+	}
+
+	if adjustment.Amount < 0 {
+		entry.Type = WalletDebit
+	}
+
+	err := m.DB.Transaction(func(tx *gorm.DB) error {
+		return m.ApplyWithTx(entry, tx)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// CreditRefundWithTx pays an approved refund into the store credit of the user instead of
+// through the payment gateway, in the transaction that approves the refund. A refund is
+// only ever credited once.
+func (m WalletModel) CreditRefundWithTx(orderRefund *OrderRefund, adminID int64, tx *gorm.DB) error {
+	var credited int64
+
+	err := tx.Model(&WalletEntry{}).Where("order_refund_id = ? AND type = ?", orderRefund.ID, WalletCredit).Count(&credited).Error
+	if err != nil || credited > 0 {
+		return err
+	}
+
+	err = tx.Model(&OrderRefund{}).Where("id = ?", orderRefund.ID).Update("refund_to", "store_credit").Error
+	if err != nil {
+		return err
+	}
+
+	return m.ApplyWithTx(&WalletEntry{
+		UserID:        orderRefund.UserID,
+		Type:          WalletCredit,
+		Amount:        orderRefund.RefundValue,
+		Reason:        "order refund",
+		OrderRefundID: sql.NullInt64{Int64: orderRefund.ID, Valid: true},
+		CreatedBy:     sql.NullInt64{Int64: adminID, Valid: true},
+	}, tx)
+}
+
+// ====================================================================================
+// Business Functions
+// ====================================================================================
+
+func (m WalletModel) GetAllForUser(user *User, p Pagination) ([]*WalletEntry, Metadata, error) {
+	var entries []*WalletEntry
+	var count int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Scopes(Paginate(p)).Where("user_id = ?", user.ID).Order("id DESC").Find(&entries).Error
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	err = m.DB.Table("wallet_entries").Where("user_id = ?", user.ID).Count(&count).Error
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(int(count), p.Page, p.PageSize)
+
+	return entries, metadata, nil
+}
+
+// GetBalance returns the store credit a user can spend now. Credits past their expiry are
+// left out even before the expiry job has written them off.
+func (m WalletModel) GetBalance(userID int64) (int64, error) {
+	var balance int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Model(&WalletEntry{}).
+		Select("COALESCE(SUM(remaining), 0)").
+		Where("user_id = ? AND remaining > 0 AND (expires_at IS NULL OR expires_at > ?)", userID, time.Now()).
+		Scan(&balance).Error
+	if err != nil {
+		return 0, err
+	}
+
+	return balance, nil
+}
+
+// ApplyWithTx writes an entry to the ledger and updates the store credit of the user. The
+// user is locked, so concurrent entries of the same user are applied in turn. A debit
+// spends the credits that expire first and fails with ErrInsufficientCredit when the
+// credits not expired yet do not cover it.
+func (m WalletModel) ApplyWithTx(entry *WalletEntry, tx *gorm.DB) error {
+	var balance int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := tx.WithContext(ctx).Table("users").
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select("store_credit").
+		Where("id = ?", entry.UserID).
+		Scan(&balance).Error
+	if err != nil {
+		return err
+	}
+
+	var spends []*WalletSpend
+
+	switch entry.Type {
+	case WalletCredit:
+		entry.Remaining = entry.Amount
+	case WalletDebit:
+		spends, err = m.spendWithTx(entry.UserID, -entry.Amount, tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	balance += entry.Amount
+	if balance < 0 {
+		return ErrInsufficientCredit
+	}
+
+	err = tx.WithContext(ctx).Table("users").Where("id = ?", entry.UserID).Update("store_credit", balance).Error
+	if err != nil {
+		return err
+	}
+
+	entry.BalanceAfter = balance
+
+	err = tx.WithContext(ctx).Create(entry).Error
+	if err != nil || len(spends) == 0 {
+		return err
+	}
+
+	for _, spend := range spends {
+		spend.EntryID = entry.ID
+	}
+
+	return tx.WithContext(ctx).Create(&spends).Error
+}
+
+// spendWithTx takes amount off the remaining part of the credits of a user, the ones
+// expiring first before the ones that never expire, and returns how much was taken from
+// each.
+func (m WalletModel) spendWithTx(userID int64, amount int64, tx *gorm.DB) ([]*WalletSpend, error) {
+	var credits []*WalletEntry
+	var spends []*WalletSpend
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := tx.WithContext(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND remaining > 0 AND (expires_at IS NULL OR expires_at > ?)", userID, time.Now()).
+		Order("expires_at NULLS LAST, id").
+		Find(&credits).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, credit := range credits {
+		if amount == 0 {
+			break
+		}
+
+		spent := credit.Remaining
+		if spent > amount {
+			spent = amount
+		}
+
+		err = tx.WithContext(ctx).Model(&WalletEntry{}).Where("id = ?", credit.ID).Update("remaining", credit.Remaining-spent).Error
+		if err != nil {
+			return nil, err
+		}
+
+		spends = append(spends, &WalletSpend{SourceID: credit.ID, Amount: spent})
+		amount -= spent
+	}
+
+	if amount > 0 {
+		return nil, ErrInsufficientCredit
+	}
+
+	return spends, nil
+}
+
+// PayOrderWithTx spends amount of store credit on an order at checkout.
+func (m WalletModel) PayOrderWithTx(userID int64, orderID int64, amount int64, tx *gorm.DB) error {
+	return m.ApplyWithTx(&WalletEntry{
+		UserID:  userID,
+		Type:    WalletDebit,
+		Amount:  -amount,
+		Reason:  "checkout",
+		OrderID: sql.NullInt64{Int64: orderID, Valid: true},
+	}, tx)
+}
+
+// Expire writes off the part of the credits that reached their expiry without being
+// spent, and returns the number of credits written off.
+func (m WalletModel) Expire() (int, error) {
+	var credits []*WalletEntry
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Where("remaining > 0 AND expires_at <= ?", time.Now()).Order("id").Limit(1000).Find(&credits).Error
+	if err != nil {
+		return 0, err
+	}
+
+	for i, credit := range credits {
+		err = m.DB.Transaction(func(tx *gorm.DB) error {
+			// The credit may have been spent since it was read.
+			result := tx.Model(&WalletEntry{}).Where("id = ? AND remaining = ?", credit.ID, credit.Remaining).Update("remaining", 0)
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+
+			return m.ApplyWithTx(&WalletEntry{
+				UserID: credit.UserID,
+				Type:   WalletExpiry,
+				Amount: -credit.Remaining,
+				Reason: "store credit expired",
+			}, tx)
+		})
+		if err != nil {
+			return i, err
+		}
+	}
+
+	return len(credits), nil
+}
+
+// restoreStoreCredit gives back the store credit spent on an order whose invoice expired.
+// Each part is credited with the expiry of the credit it was spent from, so spending credit
+// on an invoice left to expire does not extend its validity.
+func restoreStoreCredit(orderID int64, tx *gorm.DB) error {
+	var sources []struct {
+		UserID    int64
+		OrderID   sql.NullInt64
+		Amount    int64
+		ExpiresAt *time.Time
+	}
+
+	err := tx.Table("wallet_entries AS debits").
+		Select("debits.user_id, debits.order_id, wallet_spends.amount, credits.expires_at").
+		Joins("JOIN wallet_spends ON wallet_spends.entry_id = debits.id").
+		Joins("JOIN wallet_entries AS credits ON credits.id = wallet_spends.source_id").
+		Where("debits.order_id = ? AND debits.type = ?", orderID, WalletDebit).
+		Order("debits.id, wallet_spends.source_id").
+		Scan(&sources).Error
+	if err != nil {
+		return err
+	}
+
+	for _, source := range sources {
+		err = WalletModel{DB: tx}.ApplyWithTx(&WalletEntry{
+			UserID:    source.UserID,
+			Type:      WalletCredit,
+			Amount:    source.Amount,
+			ExpiresAt: source.ExpiresAt,
+			Reason:    "invoice expired",
+			OrderID:   source.OrderID,
+		}, tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,192 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/kervinch/internal/validator"
+	"gorm.io/gorm"
+)
+
+// WishlistCollection is a named list of favorites, which its owner can share publicly by
+// its slug. Favorites outside of any collection make up the default one.
+type WishlistCollection struct {
+	ID        int64          `json:"id"`
+	UserID    int64          `json:"user_id"`
+	Name      string         `json:"name"`
+	Slug      sql.NullString `json:"slug"`
+	IsPublic  bool           `json:"is_public"`
+	ItemCount int64          `json:"item_count" gorm:"->"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"-"`
+}
+
+func ValidateWishlistCollection(v *validator.Validator, collection *WishlistCollection) {
+	v.Check(strings.TrimSpace(collection.Name) != "", "name", "must be provided")
+	v.Check(len(collection.Name) <= 100, "name", "must not be more than 100 bytes long")
+}
+
+// generateWishlistSlug returns a random slug that cannot be guessed from another one.
+func generateWishlistSlug() (string, error) {
+	randomBytes := make([]byte, 10)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)), nil
+}
+
+// withItemCount selects the number of favorites in each collection.
+func withItemCount(db *gorm.DB) *gorm.DB {
+	return db.Select("wishlist_collections.*, (SELECT COUNT(*) FROM favorites WHERE favorites.collection_id = wishlist_collections.id) AS item_count")
+}
+
+type WishlistModel struct {
+	DB *gorm.DB
+}
+
+// ====================================================================================
+// Business Functions
+// ====================================================================================
+
+func (m WishlistModel) GetAll(user *User) ([]*WishlistCollection, error) {
+	var collections []*WishlistCollection
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Scopes(withItemCount).Where("user_id = ?", user.ID).Order("id").Find(&collections).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return collections, nil
+}
+
+func (m WishlistModel) Get(id int64, user *User) (*WishlistCollection, error) {
+	var collection *WishlistCollection
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Scopes(withItemCount).Where("id = ? AND user_id = ?", id, user.ID).First(&collection).Error
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return collection, nil
+}
+
+// GetBySlug returns a collection shared by its owner. A collection no longer public is not
+// found, even by its slug.
+func (m WishlistModel) GetBySlug(slug string) (*WishlistCollection, error) {
+	var collection *WishlistCollection
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.WithContext(ctx).Scopes(withItemCount).Where("slug = ? AND is_public", slug).First(&collection).Error
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return collection, nil
+}
+
+func (m WishlistModel) Insert(collection *WishlistCollection) error {
+	err := collection.share()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.WithContext(ctx).Create(collection).Error
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "idx_wishlist_collections"`:
+			return ErrDuplicateKeyValue
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m WishlistModel) Update(collection *WishlistCollection) error {
+	err := collection.share()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.WithContext(ctx).Model(&WishlistCollection{}).Where("id = ?", collection.ID).Updates(map[string]interface{}{
+		"name":      collection.Name,
+		"slug":      collection.Slug,
+		"is_public": collection.IsPublic,
+	}).Error
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "idx_wishlist_collections"`:
+			return ErrDuplicateKeyValue
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes a collection along with its favorites.
+func (m WishlistModel) Delete(id int64, user *User) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	ra := m.DB.WithContext(ctx).Where("id = ? AND user_id = ?", id, user.ID).Delete(&WishlistCollection{}).RowsAffected
+	if ra < 1 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// share gives a public collection its slug the first time it is made public.
+func (c *WishlistCollection) share() error {
+	if !c.IsPublic || c.Slug.Valid {
+		return nil
+	}
+
+	slug, err := generateWishlistSlug()
+	if err != nil {
+		return err
+	}
+
+	c.Slug = sql.NullString{String: slug, Valid: true}
+
+	return nil
+}
@@ -0,0 +1,272 @@
+package oauth
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	GOOGLE = "google"
+	APPLE  = "apple"
+)
+
+var (
+	ErrUnknownProvider = errors.New("unknown oauth provider")
+	ErrInvalidToken    = errors.New("invalid id token")
+)
+
+// Provider holds the settings needed to verify ID tokens issued by a single identity
+// provider. JWKSURL can point to a local stand-in server during development and tests.
+type Provider struct {
+	Name     string
+	JWKSURL  string
+	Issuers  []string
+	ClientID string
+}
+
+type Claims struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"-"`
+	Name          string `json:"name"`
+}
+
+type OAuth struct {
+	providers map[string]*provider
+	client    *http.Client
+}
+
+// keyRefreshInterval is the least time between two fetches of a key set, so tokens with
+// made up key IDs cannot make us hammer the provider.
+const keyRefreshInterval = time.Minute
+
+type provider struct {
+	Provider
+	mu          sync.Mutex
+	keys        map[string]*rsa.PublicKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+}
+
+func New(providers ...Provider) OAuth {
+	o := OAuth{
+		providers: make(map[string]*provider),
+		client:    &http.Client{Timeout: 5 * time.Second},
+	}
+
+	for _, p := range providers {
+		if p.JWKSURL == "" || p.ClientID == "" {
+			continue
+		}
+
+		o.providers[p.Name] = &provider{Provider: p}
+	}
+
+	return o
+}
+
+// Verify checks the signature, issuer, audience and expiry of an RS256 ID token and
+// returns its claims.
+func (o OAuth) Verify(providerName, idToken string) (*Claims, error) {
+	p, ok := o.providers[providerName]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+
+	err := decodeSegment(parts[0], &header)
+	if err != nil || header.Alg != "RS256" {
+		return nil, ErrInvalidToken
+	}
+
+	key, err := o.key(p, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var payload struct {
+		Claims
+		Issuer        string          `json:"iss"`
+		Audience      json.RawMessage `json:"aud"`
+		Expiry        int64           `json:"exp"`
+		EmailVerified json.RawMessage `json:"email_verified"`
+	}
+
+	err = decodeSegment(parts[1], &payload)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if !contains(p.Issuers, payload.Issuer) {
+		return nil, ErrInvalidToken
+	}
+
+	if !audienceMatches(payload.Audience, p.ClientID) {
+		return nil, ErrInvalidToken
+	}
+
+	if time.Now().Unix() >= payload.Expiry {
+		return nil, ErrInvalidToken
+	}
+
+	if payload.Subject == "" {
+		return nil, ErrInvalidToken
+	}
+
+	// Google sends email_verified as a boolean while Apple sends it as a string.
+	verified := strings.Trim(string(payload.EmailVerified), `"`)
+	payload.Claims.EmailVerified = verified == "true"
+
+	return &payload.Claims, nil
+}
+
+func (o OAuth) key(p *provider, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key, ok := p.keys[kid]
+	if ok && time.Since(p.fetchedAt) < time.Hour {
+		return key, nil
+	}
+
+	// Refresh the key set when the kid is unknown, since providers rotate their keys, but
+	// no more than once per interval, failed attempts included.
+	if time.Since(p.attemptedAt) < keyRefreshInterval {
+		if ok {
+			return key, nil
+		}
+		return nil, ErrInvalidToken
+	}
+
+	p.attemptedAt = time.Now()
+
+	keys, err := o.fetchKeys(p.JWKSURL)
+	if err != nil {
+		return nil, err
+	}
+
+	p.keys = keys
+	p.fetchedAt = time.Now()
+
+	key, ok = p.keys[kid]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	return key, nil
+}
+
+func (o OAuth) fetchKeys(url string) (map[string]*rsa.PublicKey, error) {
+	resp, err := o.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks endpoint returned %s", resp.Status)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&jwks)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	return keys, nil
+}
+
+func decodeSegment(segment string, dst interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, dst)
+}
+
+func audienceMatches(raw json.RawMessage, clientID string) bool {
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		return single == clientID
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(raw, &multiple); err == nil {
+		return contains(multiple, clientID)
+	}
+
+	return false
+}
+
+func contains(list []string, value string) bool {
+	for i := range list {
+		if list[i] == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -23,6 +23,7 @@ const (
 	PRODUCT          = "products/"
 	PRODUCT_CATEGORY = "product_categories/"
 	PRODUCT_REFUND   = "product_refunds/"
+	PRODUCT_REVIEW   = "product_reviews/"
 	STOREFRONT       = "storefronts/"
 	VOUCHER          = "vouchers/"
 )
@@ -0,0 +1,83 @@
+package sms
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kervinch/internal/jsonlog"
+)
+
+// Sender is implemented by anything that can deliver a text message to a phone number.
+type Sender interface {
+	Send(phoneNumber, message string) error
+}
+
+// LogSender writes messages to the application log instead of sending them. It is meant
+// for development, where no SMS gateway is configured.
+type LogSender struct {
+	logger *jsonlog.Logger
+}
+
+func NewLogSender(logger *jsonlog.Logger) LogSender {
+	return LogSender{
+		logger: logger,
+	}
+}
+
+func (s LogSender) Send(phoneNumber, message string) error {
+	s.logger.PrintInfo("sms sent", map[string]string{
+		"phone_number": phoneNumber,
+		"message":      message,
+	})
+
+	return nil
+}
+
+// HTTPSender delivers messages through an SMS gateway, posting each one as JSON to the URL
+// of the gateway with its API key as a bearer token.
+type HTTPSender struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+func NewHTTPSender(url, apiKey string) HTTPSender {
+	return HTTPSender{
+		url:    url,
+		apiKey: apiKey,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s HTTPSender) Send(phoneNumber, message string) error {
+	body, err := json.Marshal(map[string]string{
+		"to":      phoneNumber,
+		"message": message,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+s.apiKey)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("sms gateway responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
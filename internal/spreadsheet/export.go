@@ -0,0 +1,92 @@
+package spreadsheet
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FormatCSV  = "csv"
+	FormatXLSX = "xlsx"
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported spreadsheet format")
+
+// Writer writes a table one row at a time, so an export never has to hold more than a
+// single row in memory. Close must be called to flush the file.
+type Writer interface {
+	Write(row []interface{}) error
+	Close() error
+}
+
+// New returns a writer for the format and the content type to send it with.
+func New(w io.Writer, format string, sheet string) (Writer, string, error) {
+	switch format {
+	case FormatCSV:
+		return &csvWriter{w: csv.NewWriter(w)}, "text/csv; charset=utf-8", nil
+	case FormatXLSX:
+		xw, err := newXLSXWriter(w, sheet)
+		if err != nil {
+			return nil, "", err
+		}
+		return xw, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", nil
+	default:
+		return nil, "", ErrUnsupportedFormat
+	}
+}
+
+func format(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.Format(time.RFC3339)
+	default:
+		return ""
+	}
+}
+
+type csvWriter struct {
+	w      *csv.Writer
+	record []string
+}
+
+func (cw *csvWriter) Write(row []interface{}) error {
+	cw.record = cw.record[:0]
+
+	for _, value := range row {
+		s := format(value)
+
+		// Spreadsheet apps run cells starting with these characters as formulas, so text
+		// typed in by customers is quoted to keep it inert.
+		if _, ok := value.(string); ok && s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+			s = "'" + s
+		}
+
+		cw.record = append(cw.record, s)
+	}
+
+	return cw.w.Write(cw.record)
+}
+
+func (cw *csvWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
@@ -0,0 +1,324 @@
+package spreadsheet
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"io"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrTooManyRows  = errors.New("too many rows")
+	ErrInvalidSheet = errors.New("invalid spreadsheet")
+)
+
+// maxColumns is the widest sheet Excel itself can produce.
+const maxColumns = 16384
+
+// FormatFromFilename picks the format from the extension of an uploaded file.
+func FormatFromFilename(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		return FormatCSV
+	case ".xlsx":
+		return FormatXLSX
+	default:
+		return ""
+	}
+}
+
+// Read returns the cells of a CSV file, or of the first worksheet of an XLSX workbook, as
+// text. Rows keep their position in the sheet, so empty rows in the middle are returned
+// as nil and row i of the result is row i+1 of the sheet. Files with more than maxRows
+// rows are rejected before they are fully loaded.
+func Read(r io.ReaderAt, size int64, format string, maxRows int) ([][]string, error) {
+	switch format {
+	case FormatCSV:
+		return readCSV(io.NewSectionReader(r, 0, size), maxRows)
+	case FormatXLSX:
+		return readXLSX(r, size, maxRows)
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+}
+
+func readCSV(r io.Reader, maxRows int) ([][]string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+
+	var rows [][]string
+
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(rows) == maxRows {
+			return nil, ErrTooManyRows
+		}
+
+		// Excel saves CSV files as UTF-8 with a byte order mark.
+		if len(rows) == 0 && len(record) > 0 {
+			record[0] = strings.TrimPrefix(record[0], "\ufeff")
+		}
+
+		rows = append(rows, record)
+	}
+
+	return rows, nil
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxWorkbook struct {
+	Sheets []struct {
+		ID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+// xlsxText is a shared or inline string, which is either plain text or a list of
+// formatted runs.
+type xlsxText struct {
+	T string `xml:"t"`
+	R []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxText) String() string {
+	if len(t.R) == 0 {
+		return t.T
+	}
+
+	var sb strings.Builder
+	for _, r := range t.R {
+		sb.WriteString(r.T)
+	}
+
+	return sb.String()
+}
+
+type xlsxRow struct {
+	R     int `xml:"r,attr"`
+	Cells []struct {
+		R  string   `xml:"r,attr"`
+		T  string   `xml:"t,attr"`
+		V  string   `xml:"v"`
+		Is xlsxText `xml:"is"`
+	} `xml:"c"`
+}
+
+func readXLSX(r io.ReaderAt, size int64, maxRows int) ([][]string, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, ErrInvalidSheet
+	}
+
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	sheet, err := firstSheet(files)
+	if err != nil {
+		return nil, err
+	}
+
+	var sharedStrings []string
+
+	if f, ok := files["xl/sharedStrings.xml"]; ok {
+		var sst struct {
+			SI []xlsxText `xml:"si"`
+		}
+
+		err = decodePart(f, &sst)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, si := range sst.SI {
+			sharedStrings = append(sharedStrings, si.String())
+		}
+	}
+
+	rc, err := sheet.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var rows [][]string
+
+	d := xml.NewDecoder(rc)
+
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, ErrInvalidSheet
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "row" {
+			continue
+		}
+
+		var row xlsxRow
+
+		err = d.DecodeElement(&row, &start)
+		if err != nil {
+			return nil, ErrInvalidSheet
+		}
+
+		// The row number is optional, rows without one follow the previous row.
+		if row.R == 0 {
+			row.R = len(rows) + 1
+		}
+		if row.R < len(rows)+1 {
+			return nil, ErrInvalidSheet
+		}
+		if row.R > maxRows {
+			return nil, ErrTooManyRows
+		}
+
+		for len(rows) < row.R-1 {
+			rows = append(rows, nil)
+		}
+
+		var record []string
+
+		for _, c := range row.Cells {
+			column := len(record)
+			if c.R != "" {
+				column = columnIndex(c.R)
+			}
+			if column < len(record) || column >= maxColumns {
+				return nil, ErrInvalidSheet
+			}
+
+			for len(record) < column {
+				record = append(record, "")
+			}
+
+			value := c.V
+
+			switch c.T {
+			case "s":
+				i, err := strconv.Atoi(c.V)
+				if err != nil || i < 0 || i >= len(sharedStrings) {
+					return nil, ErrInvalidSheet
+				}
+				value = sharedStrings[i]
+			case "inlineStr":
+				value = c.Is.String()
+			case "b":
+				value = strconv.FormatBool(c.V == "1")
+			case "", "n":
+				// Whole numbers may be stored in exponent notation, e.g. 1.5E5.
+				if f, err := strconv.ParseFloat(c.V, 64); err == nil && f == float64(int64(f)) {
+					value = strconv.FormatInt(int64(f), 10)
+				}
+			}
+
+			record = append(record, value)
+		}
+
+		rows = append(rows, record)
+	}
+
+	return rows, nil
+}
+
+// firstSheet follows the workbook relationships to the worksheet shown first.
+func firstSheet(files map[string]*zip.File) (*zip.File, error) {
+	var workbook xlsxWorkbook
+	var rels xlsxRelationships
+
+	f, ok := files["xl/workbook.xml"]
+	if !ok {
+		return nil, ErrInvalidSheet
+	}
+
+	err := decodePart(f, &workbook)
+	if err != nil {
+		return nil, err
+	}
+
+	f, ok = files["xl/_rels/workbook.xml.rels"]
+	if !ok || len(workbook.Sheets) == 0 {
+		return nil, ErrInvalidSheet
+	}
+
+	err = decodePart(f, &rels)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rel := range rels.Relationships {
+		if rel.ID != workbook.Sheets[0].ID {
+			continue
+		}
+
+		// Targets are relative to xl/ unless they start at the package root.
+		name := path.Join("xl", rel.Target)
+		if strings.HasPrefix(rel.Target, "/") {
+			name = strings.TrimPrefix(rel.Target, "/")
+		}
+
+		if sheet, ok := files[name]; ok {
+			return sheet, nil
+		}
+	}
+
+	return nil, ErrInvalidSheet
+}
+
+func decodePart(f *zip.File, v interface{}) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	err = xml.NewDecoder(rc).Decode(v)
+	if err != nil {
+		return ErrInvalidSheet
+	}
+
+	return nil
+}
+
+// columnIndex converts a cell reference to its zero based column, e.g. "AB12" to 27. It
+// is the inverse of columnName.
+func columnIndex(ref string) int {
+	i := 0
+
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		i = i*26 + int(r-'A') + 1
+		if i > maxColumns {
+			break
+		}
+	}
+
+	return i - 1
+}
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS oauth_identities;
\ No newline at end of file
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS oauth_identities (
+  id bigserial PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  provider text NOT NULL,
+  subject text NOT NULL,
+  email citext,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX idx_oauth_identities
+ON oauth_identities(provider, subject);
+
+CREATE TRIGGER update_oauth_identities_updated_at BEFORE UPDATE
+    ON oauth_identities FOR EACH ROW EXECUTE PROCEDURE 
+    update_updated_at_column();
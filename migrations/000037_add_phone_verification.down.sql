@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS otp_requests;
+ALTER TABLE users DROP COLUMN IF EXISTS phone_verified;
+ALTER TABLE tokens DROP COLUMN IF EXISTS attempts;
@@ -0,0 +1,14 @@
+ALTER TABLE users ADD COLUMN phone_verified bool NOT NULL DEFAULT FALSE;
+ALTER TABLE tokens ADD COLUMN attempts integer NOT NULL DEFAULT 0;
+
+-- Every one-time password sent, for the cooldown and the hourly limit. Kept apart from
+-- tokens, which are deleted once a code is verified.
+CREATE TABLE IF NOT EXISTS otp_requests (
+  id bigserial PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  scope text NOT NULL,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_otp_requests_user_id
+ON otp_requests(user_id, scope, created_at);
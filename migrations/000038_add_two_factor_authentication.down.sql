@@ -0,0 +1,5 @@
+DELETE FROM permissions WHERE code IN ('admins:write', 'order-refunds:write', 'products:write');
+DROP TABLE IF EXISTS recovery_codes;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_secret;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_enabled;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_last_step;
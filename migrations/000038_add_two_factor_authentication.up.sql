@@ -0,0 +1,24 @@
+ALTER TABLE users ADD COLUMN totp_secret text;
+ALTER TABLE users ADD COLUMN totp_enabled bool NOT NULL DEFAULT FALSE;
+-- The time step of the last code accepted, so a code cannot be replayed.
+ALTER TABLE users ADD COLUMN totp_last_step bigint NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+  id bigserial PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  hash bytea UNIQUE NOT NULL,
+  used_at timestamp(0) with time zone,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO permissions (code)
+VALUES
+    ('admins:write'),
+    ('order-refunds:write'),
+    ('products:write');
+
+-- Existing admins could already register admins, write products and handle refunds, so
+-- they keep doing so once they enable two-factor authentication.
+INSERT INTO users_permissions
+SELECT users.id, permissions.id FROM users, permissions
+WHERE users.role = 'admin' AND permissions.code IN ('admins:write', 'order-refunds:write', 'products:write');
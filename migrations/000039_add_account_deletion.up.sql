@@ -0,0 +1,5 @@
+ALTER TABLE users ADD COLUMN deletion_scheduled_at timestamp(0) with time zone;
+ALTER TABLE users ADD COLUMN anonymised_at timestamp(0) with time zone;
+
+CREATE INDEX idx_users_deletion_scheduled_at
+ON users(deletion_scheduled_at) WHERE deletion_scheduled_at IS NOT NULL;
@@ -0,0 +1,5 @@
+ALTER TABLE users ADD COLUMN pending_email citext;
+
+-- The address an email-revert token gives the account back to, since a change may have
+-- been confirmed by the time the old address uses it.
+ALTER TABLE tokens ADD COLUMN email citext;
@@ -0,0 +1,12 @@
+DROP INDEX IF EXISTS idx_product_categories_name_trgm;
+DROP INDEX IF EXISTS idx_brands_name_trgm;
+DROP INDEX IF EXISTS idx_products_name_trgm;
+DROP INDEX IF EXISTS idx_products_search_vector;
+DROP TRIGGER IF EXISTS update_product_categories_products_search_vector ON product_categories;
+DROP TRIGGER IF EXISTS update_brands_products_search_vector ON brands;
+DROP TRIGGER IF EXISTS update_products_search_vector ON products;
+DROP FUNCTION IF EXISTS update_product_categories_products_search_vector();
+DROP FUNCTION IF EXISTS update_brands_products_search_vector();
+DROP FUNCTION IF EXISTS update_products_search_vector();
+DROP FUNCTION IF EXISTS build_product_search_vector(text, text, bigint, bigint);
+ALTER TABLE products DROP COLUMN IF EXISTS search_vector;
@@ -0,0 +1,61 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE products ADD COLUMN search_vector tsvector;
+
+-- Product names and descriptions are written in a mix of Indonesian and English, so both
+-- stemmers are applied. Brand and category names are proper nouns and are not stemmed.
+CREATE OR REPLACE FUNCTION build_product_search_vector(name text, description text, brand_id bigint, product_category_id bigint)
+RETURNS tsvector AS $$
+    SELECT
+        setweight(to_tsvector('indonesian', coalesce(name, '')), 'A') ||
+        setweight(to_tsvector('english', coalesce(name, '')), 'A') ||
+        setweight(to_tsvector('simple', coalesce((SELECT brands.name FROM brands WHERE brands.id = brand_id), '')), 'B') ||
+        setweight(to_tsvector('simple', coalesce((SELECT product_categories.name FROM product_categories WHERE product_categories.id = product_category_id), '')), 'B') ||
+        setweight(to_tsvector('indonesian', coalesce(description, '')), 'C') ||
+        setweight(to_tsvector('english', coalesce(description, '')), 'C');
+$$ LANGUAGE sql STABLE;
+
+CREATE OR REPLACE FUNCTION update_products_search_vector()
+RETURNS TRIGGER AS $$
+BEGIN
+    NEW.search_vector = build_product_search_vector(NEW.name, NEW.description, NEW.brand_id, NEW.product_category_id);
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER update_products_search_vector BEFORE INSERT OR UPDATE OF name, description, brand_id, product_category_id
+    ON products FOR EACH ROW EXECUTE PROCEDURE
+    update_products_search_vector();
+
+CREATE OR REPLACE FUNCTION update_brands_products_search_vector()
+RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE products SET search_vector = build_product_search_vector(name, description, brand_id, product_category_id)
+    WHERE brand_id = NEW.id;
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER update_brands_products_search_vector AFTER UPDATE OF name
+    ON brands FOR EACH ROW EXECUTE PROCEDURE
+    update_brands_products_search_vector();
+
+CREATE OR REPLACE FUNCTION update_product_categories_products_search_vector()
+RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE products SET search_vector = build_product_search_vector(name, description, brand_id, product_category_id)
+    WHERE product_category_id = NEW.id;
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER update_product_categories_products_search_vector AFTER UPDATE OF name
+    ON product_categories FOR EACH ROW EXECUTE PROCEDURE
+    update_product_categories_products_search_vector();
+
+UPDATE products SET search_vector = build_product_search_vector(name, description, brand_id, product_category_id);
+
+CREATE INDEX idx_products_search_vector ON products USING GIN (search_vector);
+CREATE INDEX idx_products_name_trgm ON products USING GIN (name gin_trgm_ops);
+CREATE INDEX idx_brands_name_trgm ON brands USING GIN (name gin_trgm_ops);
+CREATE INDEX idx_product_categories_name_trgm ON product_categories USING GIN (name gin_trgm_ops);
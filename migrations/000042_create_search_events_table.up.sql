@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS search_events (
+  id bigserial PRIMARY KEY,
+  search_id text UNIQUE NOT NULL,
+  query text NOT NULL,
+  filters jsonb NOT NULL DEFAULT '{}',
+  result_count integer NOT NULL,
+  user_id bigint REFERENCES users ON DELETE SET NULL,
+  device_id text,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_search_events_created_at
+ON search_events(created_at);
+
+CREATE TABLE IF NOT EXISTS search_clicks (
+  id bigserial PRIMARY KEY,
+  search_id text NOT NULL REFERENCES search_events(search_id) ON DELETE CASCADE,
+  product_id bigint NOT NULL REFERENCES products ON DELETE CASCADE,
+  position integer NOT NULL,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_search_clicks_search_id
+ON search_clicks(search_id);
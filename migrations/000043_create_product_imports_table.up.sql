@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS product_imports (
+  id bigserial PRIMARY KEY,
+  filename text NOT NULL,
+  status text NOT NULL DEFAULT 'pending',
+  total_products integer NOT NULL DEFAULT 0,
+  processed_products integer NOT NULL DEFAULT 0,
+  created_products integer NOT NULL DEFAULT 0,
+  created_variants integer NOT NULL DEFAULT 0,
+  errors jsonb NOT NULL DEFAULT '[]',
+  created_by bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  finished_at timestamp(0) with time zone,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE TRIGGER update_product_imports_updated_at BEFORE UPDATE
+    ON product_imports FOR EACH ROW EXECUTE PROCEDURE
+    update_updated_at_column();
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS inventory_movements;
+
+ALTER TABLE product_details DROP COLUMN IF EXISTS low_stock_threshold;
+
+DROP TYPE IF EXISTS inventory_movements_type_enum;
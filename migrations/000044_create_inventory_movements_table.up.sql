@@ -0,0 +1,27 @@
+CREATE TYPE inventory_movements_type_enum AS ENUM ('receipt', 'sale', 'return', 'adjustment', 'reservation');
+
+ALTER TABLE product_details ADD COLUMN low_stock_threshold integer NOT NULL DEFAULT 5;
+
+CREATE TABLE IF NOT EXISTS inventory_movements (
+  id bigserial PRIMARY KEY,
+  product_detail_id bigint NOT NULL REFERENCES product_details ON DELETE CASCADE,
+  sku text NOT NULL DEFAULT '',
+  type inventory_movements_type_enum NOT NULL,
+  quantity integer NOT NULL,
+  stock_after integer NOT NULL,
+  reason text NOT NULL DEFAULT '',
+  order_detail_id bigint REFERENCES order_details ON DELETE SET NULL,
+  created_by bigint REFERENCES users ON DELETE SET NULL,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_inventory_movements_product_detail_id
+ON inventory_movements(product_detail_id);
+
+CREATE INDEX idx_inventory_movements_order_detail_id
+ON inventory_movements(order_detail_id);
+
+-- The stock on hand becomes the opening balance, so the ledger adds up from the start.
+INSERT INTO inventory_movements (product_detail_id, sku, type, quantity, stock_after, reason)
+SELECT id, COALESCE(sku, ''), 'adjustment', stock, stock, 'opening balance'
+FROM product_details;
@@ -0,0 +1,7 @@
+DROP TRIGGER IF EXISTS queue_product_details_alert_events ON product_details;
+DROP FUNCTION IF EXISTS queue_product_alert_events;
+ALTER TABLE inbox DROP COLUMN IF EXISTS user_id;
+DROP TABLE IF EXISTS product_alert_deliveries;
+DROP TABLE IF EXISTS product_alert_events;
+DROP TABLE IF EXISTS back_in_stock_subscriptions;
+DROP TYPE IF EXISTS product_alert_events_type_enum;
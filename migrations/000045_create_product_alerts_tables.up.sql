@@ -0,0 +1,68 @@
+CREATE TYPE product_alert_events_type_enum AS ENUM ('back_in_stock', 'price_drop');
+
+CREATE TABLE IF NOT EXISTS back_in_stock_subscriptions (
+  id bigserial PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  product_detail_id bigint NOT NULL REFERENCES product_details ON DELETE CASCADE,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX idx_back_in_stock_subscriptions
+ON back_in_stock_subscriptions(user_id, product_detail_id);
+
+CREATE TABLE IF NOT EXISTS product_alert_events (
+  id bigserial PRIMARY KEY,
+  product_detail_id bigint NOT NULL REFERENCES product_details ON DELETE CASCADE,
+  type product_alert_events_type_enum NOT NULL,
+  old_price bigint NOT NULL,
+  new_price bigint NOT NULL,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  processed_at timestamp(0) with time zone,
+  deferred_until timestamp(0) with time zone
+);
+
+CREATE INDEX idx_product_alert_events_pending
+ON product_alert_events(id) WHERE processed_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS product_alert_deliveries (
+  id bigserial PRIMARY KEY,
+  event_id bigint NOT NULL REFERENCES product_alert_events ON DELETE CASCADE,
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX idx_product_alert_deliveries
+ON product_alert_deliveries(event_id, user_id);
+
+CREATE INDEX idx_product_alert_deliveries_user_id
+ON product_alert_deliveries(user_id, created_at);
+
+-- Personal messages, e.g. product alerts, belong to a single user. Messages without a
+-- user are sent to everyone.
+ALTER TABLE inbox ADD COLUMN user_id bigint REFERENCES users ON DELETE CASCADE;
+
+CREATE INDEX idx_inbox_user_id
+ON inbox(user_id);
+
+-- Every change that may interest a shopper is queued, whoever made it. The job sending
+-- the alerts checks the variant again, so a change undone in the meantime is dropped.
+CREATE FUNCTION queue_product_alert_events() RETURNS trigger
+    LANGUAGE plpgsql
+    AS $$
+  BEGIN
+    IF OLD.stock <= 0 AND NEW.stock > 0 THEN
+      INSERT INTO product_alert_events (product_detail_id, type, old_price, new_price)
+      VALUES (NEW.id, 'back_in_stock', OLD.price, NEW.price);
+    END IF;
+
+    IF NEW.price < OLD.price THEN
+      INSERT INTO product_alert_events (product_detail_id, type, old_price, new_price)
+      VALUES (NEW.id, 'price_drop', OLD.price, NEW.price);
+    END IF;
+
+    RETURN NEW;
+  END;
+$$;
+
+CREATE TRIGGER queue_product_details_alert_events AFTER UPDATE OF stock, price
+ON product_details FOR EACH ROW EXECUTE PROCEDURE queue_product_alert_events();
@@ -0,0 +1,4 @@
+ALTER TABLE products DROP COLUMN IF EXISTS review_count;
+ALTER TABLE products DROP COLUMN IF EXISTS rating;
+
+DROP TABLE IF EXISTS product_reviews;
@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS product_reviews (
+  id bigserial PRIMARY KEY,
+  invoice_detail_id bigint NOT NULL UNIQUE REFERENCES invoice_details ON DELETE CASCADE,
+  product_id bigint NOT NULL REFERENCES products ON DELETE CASCADE,
+  product_detail_id bigint NOT NULL REFERENCES product_details ON DELETE CASCADE,
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  rating smallint NOT NULL CHECK (rating BETWEEN 1 AND 5),
+  content text NOT NULL DEFAULT '',
+  photos text[] NOT NULL DEFAULT '{}',
+  is_hidden boolean NOT NULL DEFAULT FALSE,
+  is_flagged boolean NOT NULL DEFAULT FALSE,
+  flag_reason text NOT NULL DEFAULT '',
+  reply text NOT NULL DEFAULT '',
+  replied_at timestamp(0) with time zone,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_product_reviews_product_id
+ON product_reviews(product_id, created_at) WHERE NOT is_hidden;
+
+CREATE INDEX idx_product_reviews_user_id
+ON product_reviews(user_id);
+
+CREATE TRIGGER update_product_reviews_updated_at BEFORE UPDATE
+ON product_reviews FOR EACH ROW EXECUTE PROCEDURE
+update_updated_at_column();
+
+-- The aggregates of the visible reviews are kept on the product, so listings can show
+-- them without counting reviews on every request.
+ALTER TABLE products ADD COLUMN rating numeric(3, 2) NOT NULL DEFAULT 0;
+ALTER TABLE products ADD COLUMN review_count integer NOT NULL DEFAULT 0;
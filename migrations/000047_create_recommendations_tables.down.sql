@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS user_recommendations;
+DROP TABLE IF EXISTS product_associations;
+DROP TYPE IF EXISTS product_associations_kind_enum;
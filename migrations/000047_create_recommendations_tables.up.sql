@@ -0,0 +1,18 @@
+CREATE TYPE product_associations_kind_enum AS ENUM ('bought_together', 'similar');
+
+CREATE TABLE IF NOT EXISTS product_associations (
+  product_id bigint NOT NULL REFERENCES products ON DELETE CASCADE,
+  related_product_id bigint NOT NULL REFERENCES products ON DELETE CASCADE,
+  kind product_associations_kind_enum NOT NULL,
+  score double precision NOT NULL,
+  ranking integer NOT NULL,
+  PRIMARY KEY (product_id, kind, related_product_id)
+);
+
+CREATE TABLE IF NOT EXISTS user_recommendations (
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  product_id bigint NOT NULL REFERENCES products ON DELETE CASCADE,
+  score double precision NOT NULL,
+  ranking integer NOT NULL,
+  PRIMARY KEY (user_id, product_id)
+);
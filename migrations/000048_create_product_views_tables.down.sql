@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS recently_viewed_products;
+DROP TABLE IF EXISTS product_views;
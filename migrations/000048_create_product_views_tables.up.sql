@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS product_views (
+  id bigserial PRIMARY KEY,
+  product_id bigint NOT NULL REFERENCES products ON DELETE CASCADE,
+  user_id bigint REFERENCES users ON DELETE SET NULL,
+  device_id text NOT NULL DEFAULT '',
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_product_views_created_at
+ON product_views(created_at);
+
+CREATE TABLE IF NOT EXISTS recently_viewed_products (
+  id bigserial PRIMARY KEY,
+  user_id bigint REFERENCES users ON DELETE CASCADE,
+  device_id text NOT NULL DEFAULT '',
+  product_id bigint NOT NULL REFERENCES products ON DELETE CASCADE,
+  viewed_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+-- A row belongs to a user once they have signed in, and to the device they browsed on
+-- until then.
+CREATE UNIQUE INDEX idx_recently_viewed_products_user
+ON recently_viewed_products(user_id, product_id) WHERE user_id IS NOT NULL;
+
+CREATE UNIQUE INDEX idx_recently_viewed_products_device
+ON recently_viewed_products(device_id, product_id) WHERE user_id IS NULL;
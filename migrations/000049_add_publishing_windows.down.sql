@@ -0,0 +1,17 @@
+DROP INDEX IF EXISTS idx_products_unpublish_at;
+DROP INDEX IF EXISTS idx_products_publish_at;
+
+ALTER TABLE blogs DROP COLUMN IF EXISTS unpublish_at;
+ALTER TABLE blogs DROP COLUMN IF EXISTS publish_at;
+
+ALTER TABLE vouchers DROP COLUMN IF EXISTS unpublish_at;
+ALTER TABLE vouchers DROP COLUMN IF EXISTS publish_at;
+
+ALTER TABLE products DROP COLUMN IF EXISTS unpublish_at;
+ALTER TABLE products DROP COLUMN IF EXISTS publish_at;
+
+ALTER TABLE storefronts DROP COLUMN IF EXISTS unpublish_at;
+ALTER TABLE storefronts DROP COLUMN IF EXISTS publish_at;
+
+ALTER TABLE banners DROP COLUMN IF EXISTS unpublish_at;
+ALTER TABLE banners DROP COLUMN IF EXISTS publish_at;
@@ -0,0 +1,26 @@
+-- A row is public between publish_at and unpublish_at, on top of its is_active or status
+-- flag. A missing bound leaves that side of the window open.
+ALTER TABLE banners ADD COLUMN publish_at timestamp(0) with time zone;
+ALTER TABLE banners ADD COLUMN unpublish_at timestamp(0) with time zone;
+
+ALTER TABLE storefronts ADD COLUMN publish_at timestamp(0) with time zone;
+ALTER TABLE storefronts ADD COLUMN unpublish_at timestamp(0) with time zone;
+
+ALTER TABLE products ADD COLUMN publish_at timestamp(0) with time zone;
+ALTER TABLE products ADD COLUMN unpublish_at timestamp(0) with time zone;
+
+ALTER TABLE vouchers ADD COLUMN publish_at timestamp(0) with time zone;
+ALTER TABLE vouchers ADD COLUMN unpublish_at timestamp(0) with time zone;
+
+ALTER TABLE blogs ADD COLUMN publish_at timestamp(0) with time zone;
+ALTER TABLE blogs ADD COLUMN unpublish_at timestamp(0) with time zone;
+
+ALTER TABLE banners ADD CONSTRAINT banners_publishing_window_check CHECK (unpublish_at > publish_at);
+ALTER TABLE storefronts ADD CONSTRAINT storefronts_publishing_window_check CHECK (unpublish_at > publish_at);
+ALTER TABLE products ADD CONSTRAINT products_publishing_window_check CHECK (unpublish_at > publish_at);
+ALTER TABLE vouchers ADD CONSTRAINT vouchers_publishing_window_check CHECK (unpublish_at > publish_at);
+ALTER TABLE blogs ADD CONSTRAINT blogs_publishing_window_check CHECK (unpublish_at > publish_at);
+
+-- The publishing scheduler looks up the next upcoming bound of the cached catalogue.
+CREATE INDEX idx_products_publish_at ON products(publish_at) WHERE publish_at IS NOT NULL;
+CREATE INDEX idx_products_unpublish_at ON products(unpublish_at) WHERE unpublish_at IS NOT NULL;
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_invoice_details_campaign_item_id;
+
+ALTER TABLE invoice_details DROP COLUMN IF EXISTS campaign_item_id;
+ALTER TABLE invoice_details DROP COLUMN IF EXISTS campaign_id;
+
+DROP TABLE IF EXISTS campaign_items;
+DROP TABLE IF EXISTS campaigns;
@@ -0,0 +1,54 @@
+CREATE TABLE IF NOT EXISTS campaigns (
+  id bigserial PRIMARY KEY,
+  name text NOT NULL,
+  starts_at timestamp(0) with time zone NOT NULL,
+  ends_at timestamp(0) with time zone NOT NULL,
+  is_active boolean NOT NULL DEFAULT TRUE,
+  created_by bigint NOT NULL,
+  updated_by bigint NOT NULL,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  CHECK (ends_at > starts_at)
+);
+
+CREATE INDEX idx_campaigns_window
+ON campaigns(starts_at, ends_at) WHERE is_active;
+
+CREATE TRIGGER update_campaigns_updated_at BEFORE UPDATE
+ON campaigns FOR EACH ROW EXECUTE PROCEDURE
+update_updated_at_column();
+
+-- An item sells a variant either at a fixed sale price or at a percentage off its
+-- regular price, until the quota allocated to the campaign is sold.
+CREATE TABLE IF NOT EXISTS campaign_items (
+  id bigserial PRIMARY KEY,
+  campaign_id bigint NOT NULL REFERENCES campaigns ON DELETE CASCADE,
+  product_detail_id bigint NOT NULL REFERENCES product_details ON DELETE CASCADE,
+  sale_price bigint CHECK (sale_price > 0),
+  discount_percent integer CHECK (discount_percent BETWEEN 1 AND 99),
+  per_user_limit integer NOT NULL DEFAULT 0 CHECK (per_user_limit >= 0),
+  quota integer NOT NULL CHECK (quota > 0),
+  sold integer NOT NULL DEFAULT 0,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  CHECK ((sale_price IS NULL) <> (discount_percent IS NULL)),
+  CONSTRAINT campaign_items_sold_check CHECK (sold BETWEEN 0 AND quota)
+);
+
+CREATE UNIQUE INDEX idx_campaign_items
+ON campaign_items(campaign_id, product_detail_id);
+
+CREATE INDEX idx_campaign_items_product_detail_id
+ON campaign_items(product_detail_id);
+
+CREATE TRIGGER update_campaign_items_updated_at BEFORE UPDATE
+ON campaign_items FOR EACH ROW EXECUTE PROCEDURE
+update_updated_at_column();
+
+-- Invoice lines remember the campaign that set their price, which also counts the
+-- purchases of each user against the per-user limit.
+ALTER TABLE invoice_details ADD COLUMN campaign_id bigint REFERENCES campaigns ON DELETE SET NULL;
+ALTER TABLE invoice_details ADD COLUMN campaign_item_id bigint REFERENCES campaign_items ON DELETE SET NULL;
+
+CREATE INDEX idx_invoice_details_campaign_item_id
+ON invoice_details(campaign_item_id) WHERE campaign_item_id IS NOT NULL;
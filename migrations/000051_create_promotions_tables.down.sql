@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS order_discounts;
+DROP TABLE IF EXISTS promotions;
+DROP TYPE IF EXISTS promotions_type_enum;
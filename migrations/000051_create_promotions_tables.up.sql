@@ -0,0 +1,47 @@
+CREATE TYPE promotions_type_enum AS ENUM ('quantity_threshold', 'buy_x_get_y', 'bundle_price');
+
+-- A promotion applies to the cart lines matching every scope that is set: a brand, a
+-- product category and a list of SKUs. A bundle_price promotion sells one unit of each of
+-- its SKUs together at bundle_price.
+CREATE TABLE IF NOT EXISTS promotions (
+  id bigserial PRIMARY KEY,
+  name text NOT NULL,
+  type promotions_type_enum NOT NULL,
+  brand_id bigint REFERENCES brands ON DELETE CASCADE,
+  product_category_id bigint REFERENCES product_categories ON DELETE CASCADE,
+  skus text[] NOT NULL DEFAULT '{}',
+  min_quantity integer NOT NULL DEFAULT 0 CHECK (min_quantity >= 0),
+  free_quantity integer NOT NULL DEFAULT 0 CHECK (free_quantity >= 0),
+  discount_percent integer NOT NULL DEFAULT 0 CHECK (discount_percent BETWEEN 0 AND 100),
+  bundle_price bigint NOT NULL DEFAULT 0 CHECK (bundle_price >= 0),
+  priority integer NOT NULL DEFAULT 0,
+  starts_at timestamp(0) with time zone,
+  ends_at timestamp(0) with time zone,
+  is_active boolean NOT NULL DEFAULT TRUE,
+  created_by bigint NOT NULL,
+  updated_by bigint NOT NULL,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  CONSTRAINT promotions_window_check CHECK (ends_at > starts_at)
+);
+
+CREATE INDEX idx_promotions_active
+ON promotions(priority DESC, id) WHERE is_active;
+
+CREATE TRIGGER update_promotions_updated_at BEFORE UPDATE
+ON promotions FOR EACH ROW EXECUTE PROCEDURE
+update_updated_at_column();
+
+-- The discounts the promotions gave an order detail, one line per promotion, kept
+-- separate from the voucher so the invoice shows each of them.
+CREATE TABLE IF NOT EXISTS order_discounts (
+  id bigserial PRIMARY KEY,
+  order_detail_id bigint NOT NULL REFERENCES order_details ON DELETE CASCADE,
+  promotion_id bigint REFERENCES promotions ON DELETE SET NULL,
+  name text NOT NULL,
+  value bigint NOT NULL CHECK (value > 0),
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_order_discounts_order_detail_id
+ON order_discounts(order_detail_id);
@@ -0,0 +1,17 @@
+DELETE FROM permissions WHERE code IN ('gift-cards:write', 'wallet:write');
+
+DROP TABLE IF EXISTS wallet_spends;
+DROP TABLE IF EXISTS wallet_entries;
+DROP TABLE IF EXISTS gift_cards;
+
+ALTER TABLE order_refunds DROP CONSTRAINT IF EXISTS order_refunds_refund_to_check;
+ALTER TABLE order_refunds DROP COLUMN IF EXISTS refund_to;
+
+ALTER TABLE orders DROP COLUMN IF EXISTS store_credit;
+
+ALTER TABLE products DROP COLUMN IF EXISTS is_gift_card;
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_store_credit_check;
+ALTER TABLE users DROP COLUMN IF EXISTS store_credit;
+
+DROP TYPE IF EXISTS wallet_entries_type_enum;
@@ -0,0 +1,89 @@
+CREATE TYPE wallet_entries_type_enum AS ENUM ('credit', 'debit', 'expiry');
+
+ALTER TABLE users ADD COLUMN store_credit bigint NOT NULL DEFAULT 0;
+ALTER TABLE users ADD CONSTRAINT users_store_credit_check CHECK (store_credit >= 0);
+
+ALTER TABLE products ADD COLUMN is_gift_card boolean NOT NULL DEFAULT FALSE;
+
+ALTER TABLE orders ADD COLUMN store_credit bigint NOT NULL DEFAULT 0;
+
+ALTER TABLE order_refunds ADD COLUMN refund_to text NOT NULL DEFAULT 'gateway';
+ALTER TABLE order_refunds ADD CONSTRAINT order_refunds_refund_to_check CHECK (refund_to IN ('gateway', 'store_credit'));
+
+-- A gift card is issued for every unit of a gift card product paid for, or by hand from
+-- the CMS, and is worth its value in store credit once redeemed.
+CREATE TABLE IF NOT EXISTS gift_cards (
+  id bigserial PRIMARY KEY,
+  code text NOT NULL,
+  value bigint NOT NULL CHECK (value > 0),
+  invoice_detail_id bigint REFERENCES invoice_details ON DELETE SET NULL,
+  unit integer NOT NULL DEFAULT 1,
+  purchased_by bigint REFERENCES users ON DELETE SET NULL,
+  redeemed_by bigint REFERENCES users ON DELETE SET NULL,
+  redeemed_at timestamp(0) with time zone,
+  expires_at timestamp(0) with time zone,
+  created_by bigint REFERENCES users ON DELETE SET NULL,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX idx_gift_cards_code
+ON gift_cards(code);
+
+CREATE UNIQUE INDEX idx_gift_cards_invoice_detail_id_unit
+ON gift_cards(invoice_detail_id, unit);
+
+CREATE TRIGGER update_gift_cards_updated_at BEFORE UPDATE
+ON gift_cards FOR EACH ROW EXECUTE PROCEDURE
+update_updated_at_column();
+
+-- The store credit ledger. Amount is signed, so the store credit of a user is the sum of
+-- the entries, and balance_after records it right after the entry. Credits keep the part
+-- not spent yet in remaining, which is what expires at expires_at.
+CREATE TABLE IF NOT EXISTS wallet_entries (
+  id bigserial PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  type wallet_entries_type_enum NOT NULL,
+  amount bigint NOT NULL,
+  balance_after bigint NOT NULL,
+  remaining bigint NOT NULL DEFAULT 0,
+  expires_at timestamp(0) with time zone,
+  reason text NOT NULL DEFAULT '',
+  order_id bigint REFERENCES orders ON DELETE SET NULL,
+  order_refund_id bigint REFERENCES order_refunds ON DELETE SET NULL,
+  gift_card_id bigint REFERENCES gift_cards ON DELETE SET NULL,
+  created_by bigint REFERENCES users ON DELETE SET NULL,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  CHECK ((type = 'credit') = (amount > 0)),
+  CHECK (remaining BETWEEN 0 AND GREATEST(amount, 0))
+);
+
+CREATE INDEX idx_wallet_entries_user_id
+ON wallet_entries(user_id, id);
+
+CREATE INDEX idx_wallet_entries_expires_at
+ON wallet_entries(expires_at) WHERE remaining > 0;
+
+CREATE UNIQUE INDEX idx_wallet_entries_order_refund_id
+ON wallet_entries(order_refund_id) WHERE type = 'credit';
+
+-- The credits each debit was taken from, so store credit given back keeps the expiry of
+-- the credits it was spent from.
+CREATE TABLE IF NOT EXISTS wallet_spends (
+  entry_id bigint NOT NULL REFERENCES wallet_entries ON DELETE CASCADE,
+  source_id bigint NOT NULL REFERENCES wallet_entries ON DELETE CASCADE,
+  amount bigint NOT NULL CHECK (amount > 0),
+  PRIMARY KEY (entry_id, source_id)
+);
+
+INSERT INTO permissions (code)
+VALUES
+    ('gift-cards:write'),
+    ('wallet:write');
+
+-- Admins issued gift cards and adjusted wallets with order-refunds:write until now, so
+-- the ones holding it keep doing so.
+INSERT INTO users_permissions
+SELECT users_permissions.user_id, permissions.id FROM users_permissions, permissions
+WHERE users_permissions.permission_id = (SELECT id FROM permissions WHERE code = 'order-refunds:write')
+AND permissions.code IN ('gift-cards:write', 'wallet:write');
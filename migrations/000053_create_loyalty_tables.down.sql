@@ -0,0 +1,15 @@
+DELETE FROM permissions WHERE code IN ('loyalty:write', 'orders:write');
+
+DROP TABLE IF EXISTS loyalty_spends;
+DROP TABLE IF EXISTS loyalty_entries;
+
+ALTER TABLE orders DROP COLUMN IF EXISTS loyalty_discount;
+ALTER TABLE orders DROP COLUMN IF EXISTS loyalty_points;
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_loyalty_points_check;
+ALTER TABLE users DROP COLUMN IF EXISTS loyalty_points;
+
+DROP TABLE IF EXISTS loyalty_tiers;
+DROP TABLE IF EXISTS loyalty_settings;
+
+DROP TYPE IF EXISTS loyalty_entries_type_enum;
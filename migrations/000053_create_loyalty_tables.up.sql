@@ -0,0 +1,92 @@
+CREATE TYPE loyalty_entries_type_enum AS ENUM ('earn', 'reversal', 'redeem', 'restore', 'expiry');
+
+-- The programme has a single row of settings. Points are earned as earn_percent of the
+-- total of a completed order, times the multiplier of the tier of the user, and are worth
+-- point_value each at checkout.
+CREATE TABLE IF NOT EXISTS loyalty_settings (
+  id boolean PRIMARY KEY DEFAULT TRUE CHECK (id),
+  earn_percent numeric(5, 2) NOT NULL DEFAULT 1 CHECK (earn_percent BETWEEN 0 AND 100),
+  point_value bigint NOT NULL DEFAULT 1 CHECK (point_value > 0),
+  max_redeem_percent integer NOT NULL DEFAULT 100 CHECK (max_redeem_percent BETWEEN 0 AND 100),
+  validity_days integer NOT NULL DEFAULT 365 CHECK (validity_days > 0),
+  updated_by bigint REFERENCES users ON DELETE SET NULL,
+  updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO loyalty_settings DEFAULT VALUES;
+
+CREATE TRIGGER update_loyalty_settings_updated_at BEFORE UPDATE
+ON loyalty_settings FOR EACH ROW EXECUTE PROCEDURE
+update_updated_at_column();
+
+-- A user is in the highest tier whose min_spend their completed orders of the last 12
+-- months reach.
+CREATE TABLE IF NOT EXISTS loyalty_tiers (
+  id bigserial PRIMARY KEY,
+  name text NOT NULL,
+  min_spend bigint NOT NULL CHECK (min_spend >= 0),
+  earn_multiplier integer NOT NULL DEFAULT 100 CHECK (earn_multiplier > 0),
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX idx_loyalty_tiers_min_spend
+ON loyalty_tiers(min_spend);
+
+CREATE TRIGGER update_loyalty_tiers_updated_at BEFORE UPDATE
+ON loyalty_tiers FOR EACH ROW EXECUTE PROCEDURE
+update_updated_at_column();
+
+ALTER TABLE users ADD COLUMN loyalty_points bigint NOT NULL DEFAULT 0;
+ALTER TABLE users ADD CONSTRAINT users_loyalty_points_check CHECK (loyalty_points >= 0);
+
+ALTER TABLE orders ADD COLUMN loyalty_points bigint NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN loyalty_discount bigint NOT NULL DEFAULT 0;
+
+-- The points ledger, kept like the store credit ledger: points is signed, balance_after
+-- records the balance right after the entry, and remaining is the part of an earned entry
+-- not spent yet, which is what expires at expires_at.
+CREATE TABLE IF NOT EXISTS loyalty_entries (
+  id bigserial PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  type loyalty_entries_type_enum NOT NULL,
+  points bigint NOT NULL,
+  balance_after bigint NOT NULL,
+  remaining bigint NOT NULL DEFAULT 0,
+  expires_at timestamp(0) with time zone,
+  reason text NOT NULL DEFAULT '',
+  order_id bigint REFERENCES orders ON DELETE SET NULL,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  CHECK ((type IN ('earn', 'restore')) = (points > 0)),
+  CHECK (remaining BETWEEN 0 AND GREATEST(points, 0))
+);
+
+CREATE INDEX idx_loyalty_entries_user_id
+ON loyalty_entries(user_id, id);
+
+CREATE INDEX idx_loyalty_entries_expires_at
+ON loyalty_entries(expires_at) WHERE remaining > 0;
+
+CREATE UNIQUE INDEX idx_loyalty_entries_order_id_type
+ON loyalty_entries(order_id, type) WHERE type IN ('earn', 'reversal');
+
+-- The entries each redemption or reversal took points from, so points given back keep the
+-- expiry of the points they were spent from.
+CREATE TABLE IF NOT EXISTS loyalty_spends (
+  entry_id bigint NOT NULL REFERENCES loyalty_entries ON DELETE CASCADE,
+  source_id bigint NOT NULL REFERENCES loyalty_entries ON DELETE CASCADE,
+  points bigint NOT NULL CHECK (points > 0),
+  PRIMARY KEY (entry_id, source_id)
+);
+
+INSERT INTO permissions (code)
+VALUES
+    ('loyalty:write'),
+    ('orders:write');
+
+-- Admins changed the loyalty settings and order statuses with order-refunds:write until
+-- now, so the ones holding it keep doing so.
+INSERT INTO users_permissions
+SELECT users_permissions.user_id, permissions.id FROM users_permissions, permissions
+WHERE users_permissions.permission_id = (SELECT id FROM permissions WHERE code = 'order-refunds:write')
+AND permissions.code IN ('loyalty:write', 'orders:write');
@@ -0,0 +1,8 @@
+DELETE FROM permissions WHERE code = 'referrals:write';
+
+DROP TABLE IF EXISTS referrals;
+DROP TABLE IF EXISTS referral_settings;
+
+ALTER TABLE users DROP COLUMN IF EXISTS referral_code;
+
+DROP TYPE IF EXISTS referrals_status_enum;
@@ -0,0 +1,70 @@
+CREATE TYPE referrals_status_enum AS ENUM ('pending', 'rewarded', 'rejected', 'reversed');
+
+-- Codes are given out the first time a user asks for theirs.
+ALTER TABLE users ADD COLUMN referral_code text UNIQUE;
+
+-- The programme has a single row of settings. Each side of a converted referral gets the
+-- voucher set for it, or the store credit when no voucher is set.
+CREATE TABLE IF NOT EXISTS referral_settings (
+  id boolean PRIMARY KEY DEFAULT TRUE CHECK (id),
+  is_active bool NOT NULL DEFAULT FALSE,
+  referrer_voucher_id bigint REFERENCES vouchers ON DELETE SET NULL,
+  referrer_credit bigint NOT NULL DEFAULT 0 CHECK (referrer_credit >= 0),
+  referee_voucher_id bigint REFERENCES vouchers ON DELETE SET NULL,
+  referee_credit bigint NOT NULL DEFAULT 0 CHECK (referee_credit >= 0),
+  updated_by bigint REFERENCES users ON DELETE SET NULL,
+  updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO referral_settings DEFAULT VALUES;
+
+CREATE TRIGGER update_referral_settings_updated_at BEFORE UPDATE
+ON referral_settings FOR EACH ROW EXECUTE PROCEDURE
+update_updated_at_column();
+
+-- A user is referred at most once, when they register with the code of another user. The
+-- referral converts when the first order of the referee completes, and is rejected
+-- instead when the fraud checks find the two accounts belong to the same person.
+-- The rewards granted are kept, so they can be taken back when that order is refunded.
+CREATE TABLE IF NOT EXISTS referrals (
+  id bigserial PRIMARY KEY,
+  referrer_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  referee_id bigint NOT NULL UNIQUE REFERENCES users ON DELETE CASCADE,
+  code text NOT NULL,
+  status referrals_status_enum NOT NULL DEFAULT 'pending',
+  rejection_reason text NOT NULL DEFAULT '',
+  device_id text NOT NULL DEFAULT '',
+  order_id bigint REFERENCES orders ON DELETE SET NULL,
+  referrer_voucher_id bigint REFERENCES vouchers ON DELETE SET NULL,
+  referrer_credit bigint NOT NULL DEFAULT 0,
+  referee_voucher_id bigint REFERENCES vouchers ON DELETE SET NULL,
+  referee_credit bigint NOT NULL DEFAULT 0,
+  converted_at timestamp(0) with time zone,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  CHECK (referrer_id <> referee_id)
+);
+
+CREATE INDEX idx_referrals_referrer_id
+ON referrals(referrer_id);
+
+CREATE INDEX idx_referrals_order_id
+ON referrals(order_id);
+
+CREATE INDEX idx_referrals_device_id
+ON referrals(device_id) WHERE device_id <> '';
+
+CREATE TRIGGER update_referrals_updated_at BEFORE UPDATE
+ON referrals FOR EACH ROW EXECUTE PROCEDURE
+update_updated_at_column();
+
+INSERT INTO permissions (code)
+VALUES
+    ('referrals:write');
+
+-- Admins changed the referral settings with order-refunds:write until now, so the ones
+-- holding it keep doing so.
+INSERT INTO users_permissions
+SELECT users_permissions.user_id, permissions.id FROM users_permissions, permissions
+WHERE users_permissions.permission_id = (SELECT id FROM permissions WHERE code = 'order-refunds:write')
+AND permissions.code = 'referrals:write';
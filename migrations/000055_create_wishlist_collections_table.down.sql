@@ -0,0 +1,11 @@
+DELETE FROM favorites WHERE collection_id IS NOT NULL;
+
+DROP INDEX IF EXISTS idx_favorites_collection_id;
+DROP INDEX IF EXISTS idx_favorites;
+
+ALTER TABLE favorites DROP COLUMN IF EXISTS collection_id;
+
+CREATE UNIQUE INDEX idx_favorites
+ON favorites(user_id, product_detail_id);
+
+DROP TABLE IF EXISTS wishlist_collections;
@@ -0,0 +1,30 @@
+-- Favorites without a collection make up the default collection of a user, the one served
+-- by /api/favorites. A collection gets a slug the first time it is made public, and keeps
+-- it so links shared before keep working when it is made public again.
+CREATE TABLE IF NOT EXISTS wishlist_collections (
+  id bigserial PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  name text NOT NULL,
+  slug text UNIQUE,
+  is_public bool NOT NULL DEFAULT FALSE,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX idx_wishlist_collections
+ON wishlist_collections(user_id, LOWER(name));
+
+CREATE TRIGGER update_wishlist_collections_updated_at BEFORE UPDATE
+ON wishlist_collections FOR EACH ROW EXECUTE PROCEDURE
+update_updated_at_column();
+
+ALTER TABLE favorites ADD COLUMN collection_id bigint REFERENCES wishlist_collections ON DELETE CASCADE;
+
+-- A product can be in several collections, but only once in each.
+DROP INDEX IF EXISTS idx_favorites;
+
+CREATE UNIQUE INDEX idx_favorites
+ON favorites(user_id, product_detail_id, COALESCE(collection_id, 0));
+
+CREATE INDEX idx_favorites_collection_id
+ON favorites(collection_id) WHERE collection_id IS NOT NULL;
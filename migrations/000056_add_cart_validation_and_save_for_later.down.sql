@@ -0,0 +1,2 @@
+ALTER TABLE carts DROP COLUMN IF EXISTS saved_for_later;
+ALTER TABLE carts DROP COLUMN IF EXISTS added_price;
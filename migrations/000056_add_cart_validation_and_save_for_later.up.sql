@@ -0,0 +1,25 @@
+-- added_price is the price a line was put in the cart at, so a later change of price can
+-- be shown to the shopper. It is taken again whenever the shopper edits the line.
+ALTER TABLE carts ADD COLUMN added_price bigint;
+
+-- Existing lines take the price the cart charges now: the lowest running campaign price of
+-- the variant, found the way salePriceQuery does, or else its regular price.
+UPDATE carts SET added_price = COALESCE(sale.sale_price, product_details.price)
+FROM product_details
+LEFT JOIN LATERAL (
+  SELECT COALESCE(campaign_items.sale_price, product_details.price - product_details.price * campaign_items.discount_percent / 100) AS sale_price
+  FROM campaign_items
+  JOIN campaigns ON campaigns.id = campaign_items.campaign_id
+  WHERE campaign_items.product_detail_id = product_details.id
+  AND campaigns.is_active AND campaigns.starts_at <= NOW() AND campaigns.ends_at > NOW()
+  AND campaign_items.sold < campaign_items.quota
+  AND COALESCE(campaign_items.sale_price, product_details.price - product_details.price * campaign_items.discount_percent / 100) < product_details.price
+  ORDER BY sale_price, campaign_items.id
+  LIMIT 1
+) sale ON TRUE
+WHERE product_details.id = carts.product_detail_id;
+
+ALTER TABLE carts ALTER COLUMN added_price SET NOT NULL;
+
+-- A line saved for later stays in the cart but is left out of checkout.
+ALTER TABLE carts ADD COLUMN saved_for_later bool NOT NULL DEFAULT FALSE;
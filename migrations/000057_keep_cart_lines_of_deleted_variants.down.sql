@@ -0,0 +1,8 @@
+DELETE FROM carts WHERE product_detail_id IS NULL;
+
+ALTER TABLE carts DROP CONSTRAINT IF EXISTS carts_product_detail_id_fkey;
+
+ALTER TABLE carts ADD CONSTRAINT carts_product_detail_id_fkey
+FOREIGN KEY (product_detail_id) REFERENCES product_details ON DELETE CASCADE;
+
+ALTER TABLE carts ALTER COLUMN product_detail_id SET NOT NULL;
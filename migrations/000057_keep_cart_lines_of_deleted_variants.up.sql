@@ -0,0 +1,8 @@
+-- A line whose variant is deleted stays in the cart without it, so the shopper can be told
+-- it is no longer available instead of finding it gone.
+ALTER TABLE carts ALTER COLUMN product_detail_id DROP NOT NULL;
+
+ALTER TABLE carts DROP CONSTRAINT IF EXISTS carts_product_detail_id_fkey;
+
+ALTER TABLE carts ADD CONSTRAINT carts_product_detail_id_fkey
+FOREIGN KEY (product_detail_id) REFERENCES product_details ON DELETE SET NULL;